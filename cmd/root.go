@@ -110,6 +110,12 @@ func init() {
 	rootCmd.Flags().BoolVar(&config.AllowInternalHTTPRequests, "allow-internal-http-requests", config.AllowInternalHTTPRequests, "Allow link checker, HTML checker & screenshots to access internal IP addresses")
 	rootCmd.Flags().BoolVar(&config.DisableLinkCheckRateLimit, "disable-link-check-rate-limit", config.DisableLinkCheckRateLimit, "Disable the per-domain rate limiter and result cache used by the link checker")
 	rootCmd.Flags().StringVar(&config.EnableSpamAssassin, "enable-spamassassin", config.EnableSpamAssassin, "Enable integration with SpamAssassin")
+	rootCmd.Flags().StringVar(&config.DNSZoneFile, "dns-zone-file", config.DNSZoneFile, "A DNS zone file for offline DKIM, SPF, DMARC & ARC checks")
+	rootCmd.Flags().StringVar(&config.DKIMKeysFile, "dkim-keys", config.DKIMKeysFile, "A YAML map of DKIM public keys (offline verification)")
+	rootCmd.Flags().StringVar(&config.SMIMECAFile, "smime-ca", config.SMIMECAFile, "CA certificate bundle (PEM) to verify S/MIME signatures (default system roots)")
+	rootCmd.Flags().StringVar(&config.SMIMEKeysFile, "smime-keys", config.SMIMEKeysFile, "PEM file of private keys & certificates to decrypt S/MIME messages")
+	rootCmd.Flags().StringVar(&config.PGPKeyringFile, "pgp-keyring", config.PGPKeyringFile, "OpenPGP public keyring to verify PGP/MIME signatures")
+	rootCmd.Flags().StringVar(&config.PGPKeysFile, "pgp-keys", config.PGPKeysFile, "OpenPGP private keys (without passphrase) to decrypt PGP/MIME messages")
 	rootCmd.Flags().BoolVar(&config.AllowUntrustedTLS, "allow-untrusted-tls", config.AllowUntrustedTLS, "Do not verify HTTPS certificates (link checker & screenshots)")
 	rootCmd.Flags().BoolVar(&config.DisableHTTPCompression, "disable-http-compression", config.DisableHTTPCompression, "Disable HTTP compression support (web UI & API)")
 	rootCmd.Flags().BoolVar(&config.HideDeleteAllButton, "hide-delete-all-button", config.HideDeleteAllButton, "Hide the \"Delete all\" button in the web UI")
@@ -122,8 +128,13 @@ func init() {
 	rootCmd.Flags().StringVarP(&config.SMTPListen, "smtp", "s", config.SMTPListen, "SMTP bind interface and port")
 	rootCmd.Flags().StringVar(&config.SMTPAuthFile, "smtp-auth-file", config.SMTPAuthFile, "A password file for SMTP authentication")
 	rootCmd.Flags().BoolVar(&config.SMTPAuthAcceptAny, "smtp-auth-accept-any", config.SMTPAuthAcceptAny, "Accept any SMTP username and password, including none")
+	rootCmd.Flags().StringVar(&config.SMTPAuthOAuthTokensFile, "smtp-auth-oauth-tokens", config.SMTPAuthOAuthTokensFile, "A file of bearer tokens for SMTP XOAUTH2 & OAUTHBEARER authentication")
+	rootCmd.Flags().StringVar(&config.SMTPAuthOAuthJWTKey, "smtp-auth-oauth-jwt-key", config.SMTPAuthOAuthJWTKey, "A PEM public key to validate JWT bearer tokens for SMTP XOAUTH2 & OAUTHBEARER authentication")
+	rootCmd.Flags().BoolVar(&config.SMTPAuthOAuthAcceptAny, "smtp-auth-oauth-accept-any", config.SMTPAuthOAuthAcceptAny, "Accept any bearer token for SMTP XOAUTH2 & OAUTHBEARER authentication")
 	rootCmd.Flags().StringVar(&config.SMTPTLSCert, "smtp-tls-cert", config.SMTPTLSCert, "TLS certificate for SMTP (STARTTLS) - requires smtp-tls-key")
 	rootCmd.Flags().StringVar(&config.SMTPTLSKey, "smtp-tls-key", config.SMTPTLSKey, "TLS key for SMTP (STARTTLS) - requires smtp-tls-cert")
+	rootCmd.Flags().StringVar(&config.SMTPTLSClientCA, "smtp-tls-client-ca", config.SMTPTLSClientCA, "CA bundle to verify SMTP TLS client certificates (enables AUTH EXTERNAL)")
+	rootCmd.Flags().BoolVar(&config.SMTPRequireClientCert, "smtp-require-client-cert", config.SMTPRequireClientCert, "Require SMTP clients to present a valid TLS client certificate")
 	rootCmd.Flags().BoolVar(&config.SMTPRequireSTARTTLS, "smtp-require-starttls", config.SMTPRequireSTARTTLS, "Require SMTP client use STARTTLS")
 	rootCmd.Flags().BoolVar(&config.SMTPRequireTLS, "smtp-require-tls", config.SMTPRequireTLS, "Require client use SSL/TLS")
 	rootCmd.Flags().BoolVar(&config.SMTPAuthAllowInsecure, "smtp-auth-allow-insecure", config.SMTPAuthAllowInsecure, "Allow insecure PLAIN & LOGIN SMTP authentication")
@@ -131,19 +142,31 @@ func init() {
 	rootCmd.Flags().IntVar(&config.SMTPMaxRecipients, "smtp-max-recipients", config.SMTPMaxRecipients, "Maximum SMTP recipients allowed")
 	rootCmd.Flags().StringVar(&config.SMTPAllowedRecipients, "smtp-allowed-recipients", config.SMTPAllowedRecipients, "Only allow SMTP recipients matching a regular expression (default allow all)")
 	rootCmd.Flags().BoolVar(&config.SMTPIgnoreRejectedRecipients, "smtp-ignore-rejected-recipients", config.SMTPIgnoreRejectedRecipients, "Ignore rejected SMTP recipients with 2xx response")
+	rootCmd.Flags().IntVar(&config.SMTPLimitConnections, "smtp-limit-connections", config.SMTPLimitConnections, "Maximum concurrent SMTP connections per client IP (0 = unlimited)")
+	rootCmd.Flags().IntVar(&config.SMTPLimitMessages, "smtp-limit-messages", config.SMTPLimitMessages, "Maximum SMTP messages per minute per client IP (0 = unlimited)")
+	rootCmd.Flags().IntVar(&config.SMTPLimitSize, "smtp-limit-size", config.SMTPLimitSize, "Maximum SMTP message data in MB per hour per client IP (0 = unlimited)")
+	rootCmd.Flags().IntVar(&config.SMTPLimitUserConnections, "smtp-limit-user-connections", config.SMTPLimitUserConnections, "Maximum concurrent SMTP connections per authenticated user (0 = unlimited)")
+	rootCmd.Flags().IntVar(&config.SMTPLimitUserMessages, "smtp-limit-user-messages", config.SMTPLimitUserMessages, "Maximum SMTP messages per minute per authenticated user (0 = unlimited)")
+	rootCmd.Flags().IntVar(&config.SMTPLimitUserSize, "smtp-limit-user-size", config.SMTPLimitUserSize, "Maximum SMTP message data in MB per hour per authenticated user (0 = unlimited)")
+	rootCmd.Flags().StringVar(&config.SMTPGreylist, "smtp-greylist", config.SMTPGreylist, "Greylist new SMTP client IP, sender & recipient triplets for a delay (eg: 5m)")
 	rootCmd.Flags().BoolVar(&smtpd.DisableReverseDNS, "smtp-disable-rdns", smtpd.DisableReverseDNS, "Disable SMTP reverse DNS lookups")
 
 	// SMTP relay
 	rootCmd.Flags().StringVar(&config.SMTPRelayConfigFile, "smtp-relay-config", config.SMTPRelayConfigFile, "SMTP relay configuration file to allow releasing messages")
 	rootCmd.Flags().BoolVar(&config.SMTPRelayAll, "smtp-relay-all", config.SMTPRelayAll, "Auto-relay all new messages via external SMTP server (caution!)")
 	rootCmd.Flags().StringVar(&config.SMTPRelayMatching, "smtp-relay-matching", config.SMTPRelayMatching, "Auto-relay new messages to only matching recipients (regular expression)")
+	rootCmd.Flags().StringVar(&config.SMTPRelayQueueMaxAge, "smtp-relay-queue-max-age", config.SMTPRelayQueueMaxAge, "Maximum age of queued relay & forward deliveries before failing (0 disables retries)")
 
 	// SMTP forwarding
 	rootCmd.Flags().StringVar(&config.SMTPForwardConfigFile, "smtp-forward-config", config.SMTPForwardConfigFile, "SMTP forwarding configuration file for all messages")
 
+	// Bounces & auto-replies
+	rootCmd.Flags().StringVar(&config.BounceRulesFile, "bounce-rules", config.BounceRulesFile, "Load bounce (DSN) & auto-reply rules from a YAML file")
+
 	// Chaos
 	rootCmd.Flags().BoolVar(&chaos.Enabled, "enable-chaos", chaos.Enabled, "Enable Chaos functionality (API / web UI)")
 	rootCmd.Flags().StringVar(&config.ChaosTriggers, "chaos-triggers", config.ChaosTriggers, "Enable Chaos & set the triggers for SMTP server")
+	rootCmd.Flags().StringVar(&config.ChaosRulesFile, "chaos-rules", config.ChaosRulesFile, "Enable Chaos & load SMTP response rules from a YAML file")
 
 	// POP3 server
 	rootCmd.Flags().StringVar(&config.POP3Listen, "pop3", config.POP3Listen, "POP3 server bind interface and port")
@@ -268,6 +291,12 @@ func initConfigFromEnv() {
 	if len(os.Getenv("MP_ENABLE_SPAMASSASSIN")) > 0 {
 		config.EnableSpamAssassin = os.Getenv("MP_ENABLE_SPAMASSASSIN")
 	}
+	config.DNSZoneFile = os.Getenv("MP_DNS_ZONE_FILE")
+	config.DKIMKeysFile = os.Getenv("MP_DKIM_KEYS")
+	config.SMIMECAFile = os.Getenv("MP_SMIME_CA")
+	config.SMIMEKeysFile = os.Getenv("MP_SMIME_KEYS")
+	config.PGPKeyringFile = os.Getenv("MP_PGP_KEYRING")
+	config.PGPKeysFile = os.Getenv("MP_PGP_KEYS")
 	if getEnabledFromEnv("MP_ALLOW_UNTRUSTED_TLS") {
 		config.AllowUntrustedTLS = true
 	}
@@ -298,8 +327,20 @@ func initConfigFromEnv() {
 	if getEnabledFromEnv("MP_SMTP_AUTH_ACCEPT_ANY") {
 		config.SMTPAuthAcceptAny = true
 	}
+	config.SMTPAuthOAuthTokensFile = os.Getenv("MP_SMTP_AUTH_OAUTH_TOKENS_FILE")
+	if err := auth.SetSMTPOAuthTokens(os.Getenv("MP_SMTP_AUTH_OAUTH_TOKENS")); err != nil {
+		logger.Log().Error(err.Error())
+	}
+	config.SMTPAuthOAuthJWTKey = os.Getenv("MP_SMTP_AUTH_OAUTH_JWT_KEY")
+	if getEnabledFromEnv("MP_SMTP_AUTH_OAUTH_ACCEPT_ANY") {
+		config.SMTPAuthOAuthAcceptAny = true
+	}
 	config.SMTPTLSCert = os.Getenv("MP_SMTP_TLS_CERT")
 	config.SMTPTLSKey = os.Getenv("MP_SMTP_TLS_KEY")
+	config.SMTPTLSClientCA = os.Getenv("MP_SMTP_TLS_CLIENT_CA")
+	if getEnabledFromEnv("MP_SMTP_REQUIRE_CLIENT_CERT") {
+		config.SMTPRequireClientCert = true
+	}
 	if getEnabledFromEnv("MP_SMTP_REQUIRE_STARTTLS") {
 		config.SMTPRequireSTARTTLS = true
 	}
@@ -321,6 +362,27 @@ func initConfigFromEnv() {
 	if getEnabledFromEnv("MP_SMTP_IGNORE_REJECTED_RECIPIENTS") {
 		config.SMTPIgnoreRejectedRecipients = true
 	}
+	if len(os.Getenv("MP_SMTP_LIMIT_CONNECTIONS")) > 0 {
+		config.SMTPLimitConnections, _ = strconv.Atoi(os.Getenv("MP_SMTP_LIMIT_CONNECTIONS"))
+	}
+	if len(os.Getenv("MP_SMTP_LIMIT_MESSAGES")) > 0 {
+		config.SMTPLimitMessages, _ = strconv.Atoi(os.Getenv("MP_SMTP_LIMIT_MESSAGES"))
+	}
+	if len(os.Getenv("MP_SMTP_LIMIT_SIZE")) > 0 {
+		config.SMTPLimitSize, _ = strconv.Atoi(os.Getenv("MP_SMTP_LIMIT_SIZE"))
+	}
+	if len(os.Getenv("MP_SMTP_LIMIT_USER_CONNECTIONS")) > 0 {
+		config.SMTPLimitUserConnections, _ = strconv.Atoi(os.Getenv("MP_SMTP_LIMIT_USER_CONNECTIONS"))
+	}
+	if len(os.Getenv("MP_SMTP_LIMIT_USER_MESSAGES")) > 0 {
+		config.SMTPLimitUserMessages, _ = strconv.Atoi(os.Getenv("MP_SMTP_LIMIT_USER_MESSAGES"))
+	}
+	if len(os.Getenv("MP_SMTP_LIMIT_USER_SIZE")) > 0 {
+		config.SMTPLimitUserSize, _ = strconv.Atoi(os.Getenv("MP_SMTP_LIMIT_USER_SIZE"))
+	}
+	if len(os.Getenv("MP_SMTP_GREYLIST")) > 0 {
+		config.SMTPGreylist = os.Getenv("MP_SMTP_GREYLIST")
+	}
 	if getEnabledFromEnv("MP_SMTP_DISABLE_RDNS") {
 		smtpd.DisableReverseDNS = true
 	}
@@ -331,6 +393,9 @@ func initConfigFromEnv() {
 		config.SMTPRelayAll = true
 	}
 	config.SMTPRelayMatching = os.Getenv("MP_SMTP_RELAY_MATCHING")
+	if len(os.Getenv("MP_SMTP_RELAY_QUEUE_MAX_AGE")) > 0 {
+		config.SMTPRelayQueueMaxAge = os.Getenv("MP_SMTP_RELAY_QUEUE_MAX_AGE")
+	}
 	config.SMTPRelayConfig = config.SMTPRelayConfigStruct{}
 	config.SMTPRelayConfig.Host = os.Getenv("MP_SMTP_RELAY_HOST")
 	if len(os.Getenv("MP_SMTP_RELAY_PORT")) > 0 {
@@ -349,6 +414,9 @@ func initConfigFromEnv() {
 	config.SMTPRelayConfig.BlockedRecipients = os.Getenv("MP_SMTP_RELAY_BLOCKED_RECIPIENTS")
 	config.SMTPRelayConfig.PreserveMessageIDs = getEnabledFromEnv("MP_SMTP_RELAY_PRESERVE_MESSAGE_IDS")
 	config.SMTPRelayConfig.ForwardSMTPErrors = getEnabledFromEnv("MP_SMTP_RELAY_FWD_SMTP_ERRORS")
+	config.SMTPRelayConfig.Provider = os.Getenv("MP_SMTP_RELAY_PROVIDER")
+	config.SMTPRelayConfig.ProviderEndpoint = os.Getenv("MP_SMTP_RELAY_PROVIDER_ENDPOINT")
+	config.SMTPRelayConfig.ProviderAPIKey = os.Getenv("MP_SMTP_RELAY_PROVIDER_API_KEY")
 
 	// SMTP forwarding
 	config.SMTPForwardConfigFile = os.Getenv("MP_SMTP_FORWARD_CONFIG")
@@ -369,9 +437,13 @@ func initConfigFromEnv() {
 	config.SMTPForwardConfig.To = os.Getenv("MP_SMTP_FORWARD_TO")
 	config.SMTPForwardConfig.ForwardSMTPErrors = getEnabledFromEnv("MP_SMTP_FORWARD_FWD_SMTP_ERRORS")
 
+	// Bounces & auto-replies
+	config.BounceRulesFile = os.Getenv("MP_BOUNCE_RULES")
+
 	// Chaos
 	chaos.Enabled = getEnabledFromEnv("MP_ENABLE_CHAOS")
 	config.ChaosTriggers = os.Getenv("MP_CHAOS_TRIGGERS")
+	config.ChaosRulesFile = os.Getenv("MP_CHAOS_RULES")
 
 	// POP3 server
 	if len(os.Getenv("MP_POP3_BIND_ADDR")) > 0 {
@@ -10,11 +10,21 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/axllent/ghru/v2"
 	"github.com/axllent/mailpit/internal/auth"
+	"github.com/axllent/mailpit/internal/deliver"
+	"github.com/axllent/mailpit/internal/dkim"
 	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/pgp"
+	"github.com/axllent/mailpit/internal/provider"
+	"github.com/axllent/mailpit/internal/resolver"
+	"github.com/axllent/mailpit/internal/rewrite"
+	"github.com/axllent/mailpit/internal/smime"
 	"github.com/axllent/mailpit/internal/smtpd/chaos"
+	"github.com/axllent/mailpit/internal/smtpd/greylist"
+	"github.com/axllent/mailpit/internal/smtpd/ratelimit"
 	"github.com/axllent/mailpit/internal/snakeoil"
 	"github.com/axllent/mailpit/internal/spamassassin"
 	"github.com/axllent/mailpit/internal/tools"
@@ -102,6 +112,12 @@ var (
 	// SMTPTLSKey file
 	SMTPTLSKey string
 
+	// SMTPTLSClientCA is a PEM CA bundle used to verify SMTP TLS client certificates
+	SMTPTLSClientCA string
+
+	// SMTPRequireClientCert requires SMTP clients to present a TLS client certificate signed by SMTPTLSClientCA
+	SMTPRequireClientCert bool
+
 	// SMTPRequireSTARTTLS to enforce the use of STARTTLS
 	// The only allowed commands are NOOP, EHLO, STARTTLS and QUIT (as specified in RFC 3207) until
 	// the connection is upgraded to TLS i.e. until STARTTLS is issued.
@@ -120,6 +136,15 @@ var (
 	// SMTPAuthAcceptAny accepts any username/password including none
 	SMTPAuthAcceptAny bool
 
+	// SMTPAuthOAuthTokensFile is a file containing static bearer tokens for SMTP XOAUTH2 & OAUTHBEARER authentication
+	SMTPAuthOAuthTokensFile string
+
+	// SMTPAuthOAuthJWTKey is a PEM public key file used to validate JWT bearer tokens for SMTP XOAUTH2 & OAUTHBEARER authentication
+	SMTPAuthOAuthJWTKey string
+
+	// SMTPAuthOAuthAcceptAny accepts any bearer token for SMTP XOAUTH2 & OAUTHBEARER authentication
+	SMTPAuthOAuthAcceptAny bool
+
 	// SMTPMaxRecipients is the maximum number of recipients a message may have.
 	// The SMTP RFC states that an server must handle a minimum of 100 recipients
 	// however some servers accept more.
@@ -183,6 +208,12 @@ var (
 	// SMTPRelayMatchingRegexp is the compiled version of SMTPRelayMatching
 	SMTPRelayMatchingRegexp *regexp.Regexp
 
+	// SMTPRelayQueueMaxAge is the maximum age of queued relay & forward deliveries before they are failed (eg: 24h)
+	SMTPRelayQueueMaxAge = "24h"
+
+	// SMTPRelayQueueMaxAgeDuration is the parsed version of SMTPRelayQueueMaxAge
+	SMTPRelayQueueMaxAgeDuration time.Duration
+
 	// SMTPForwardConfigFile to parse a yaml file and store config of the forwarding SMTP server
 	SMTPForwardConfigFile string
 
@@ -202,6 +233,27 @@ var (
 	// SMTPIgnoreRejectedRecipients if true, will accept emails to rejected recipients with 2xx response but silently drop them
 	SMTPIgnoreRejectedRecipients bool
 
+	// SMTPLimitConnections is the maximum number of concurrent SMTP connections per client IP
+	SMTPLimitConnections int
+
+	// SMTPLimitMessages is the maximum number of SMTP messages per minute per client IP
+	SMTPLimitMessages int
+
+	// SMTPLimitSize is the maximum SMTP message data in MB per hour per client IP
+	SMTPLimitSize int
+
+	// SMTPLimitUserConnections is the maximum number of concurrent SMTP connections per authenticated user
+	SMTPLimitUserConnections int
+
+	// SMTPLimitUserMessages is the maximum number of SMTP messages per minute per authenticated user
+	SMTPLimitUserMessages int
+
+	// SMTPLimitUserSize is the maximum SMTP message data in MB per hour per authenticated user
+	SMTPLimitUserSize int
+
+	// SMTPGreylist is the delay before a greylisted client IP, sender & recipient triplet is accepted (eg: 5m)
+	SMTPGreylist string
+
 	// POP3Listen address - if set then Mailpit will start the POP3 server and listen on this address
 	POP3Listen = "[::]:1110"
 
@@ -217,6 +269,24 @@ var (
 	// EnableSpamAssassin must be either <host>:<port> or "postmark"
 	EnableSpamAssassin string
 
+	// DNSZoneFile is a DNS zone file used for message authentication checks (DKIM, SPF, DMARC & ARC)
+	DNSZoneFile string
+
+	// DKIMKeysFile is a YAML map of DKIM public keys (<selector>._domainkey.<domain>: <record or PEM key>)
+	DKIMKeysFile string
+
+	// SMIMECAFile is a PEM CA certificate bundle used to verify S/MIME signatures (default system roots)
+	SMIMECAFile string
+
+	// SMIMEKeysFile is a PEM file of private keys (& certificates) used to decrypt S/MIME messages
+	SMIMEKeysFile string
+
+	// PGPKeyringFile is an OpenPGP public keyring used to verify PGP/MIME signatures
+	PGPKeyringFile string
+
+	// PGPKeysFile is a file of OpenPGP private keys (without passphrase) used to decrypt PGP/MIME messages
+	PGPKeysFile string
+
 	// HideDeleteAllButton hides the delete all button in the web UI
 	HideDeleteAllButton bool
 
@@ -236,6 +306,12 @@ var (
 	// ChaosTriggers are parsed and set in the chaos module
 	ChaosTriggers string
 
+	// ChaosRulesFile is a yaml file of deterministic SMTP response rules for the chaos module
+	ChaosRulesFile string
+
+	// BounceRulesFile is a yaml file of bounce (DSN) & auto-reply rules
+	BounceRulesFile string
+
 	// DisableHTMLCheck DEPRECATED 2024/04/13 - kept here to display console warning only
 	DisableHTMLCheck = false
 
@@ -254,23 +330,37 @@ type autoTag struct {
 
 // SMTPRelayConfigStruct struct for parsing yaml & storing variables
 type SMTPRelayConfigStruct struct {
-	Host                    string         `yaml:"host"`               // SMTP host
-	Port                    int            `yaml:"port"`               // SMTP port
-	STARTTLS                bool           `yaml:"starttls"`           // whether to use STARTTLS
-	TLS                     bool           `yaml:"tls"`                // whether to use TLS
-	AllowInsecure           bool           `yaml:"allow-insecure"`     // allow insecure authentication, ignore TLS validation
-	Auth                    string         `yaml:"auth"`               // none, plain, login, cram-md5
-	Username                string         `yaml:"username"`           // plain & cram-md5
-	Password                string         `yaml:"password"`           // plain
-	Secret                  string         `yaml:"secret"`             // cram-md5
-	ReturnPath              string         `yaml:"return-path"`        // allow overriding the bounce address
-	OverrideFrom            string         `yaml:"override-from"`      // allow overriding of the from address
-	AllowedRecipients       string         `yaml:"allowed-recipients"` // regex, if set needs to match for mails to be relayed
-	AllowedRecipientsRegexp *regexp.Regexp // compiled regexp using AllowedRecipients
-	BlockedRecipients       string         `yaml:"blocked-recipients"` // regex, if set prevents relating to these addresses
-	BlockedRecipientsRegexp *regexp.Regexp // compiled regexp using BlockedRecipients
-	PreserveMessageIDs      bool           `yaml:"preserve-message-ids"` // preserve the original Message-ID when relaying
-	ForwardSMTPErrors       bool           `yaml:"forward-smtp-errors"`  // whether to log smtp-errors or forward them to upstream-client
+	Host                    string                  `yaml:"host"`               // SMTP host
+	Port                    int                     `yaml:"port"`               // SMTP port
+	STARTTLS                bool                    `yaml:"starttls"`           // whether to use STARTTLS
+	TLS                     bool                    `yaml:"tls"`                // whether to use TLS
+	AllowInsecure           bool                    `yaml:"allow-insecure"`     // allow insecure authentication, ignore TLS validation
+	Auth                    string                  `yaml:"auth"`               // none, plain, login, cram-md5
+	Username                string                  `yaml:"username"`           // plain & cram-md5
+	Password                string                  `yaml:"password"`           // plain
+	Secret                  string                  `yaml:"secret"`             // cram-md5
+	ReturnPath              string                  `yaml:"return-path"`        // allow overriding the bounce address
+	OverrideFrom            string                  `yaml:"override-from"`      // allow overriding of the from address
+	AllowedRecipients       string                  `yaml:"allowed-recipients"` // regex, if set needs to match for mails to be relayed
+	AllowedRecipientsRegexp *regexp.Regexp          // compiled regexp using AllowedRecipients
+	BlockedRecipients       string                  `yaml:"blocked-recipients"` // regex, if set prevents relating to these addresses
+	BlockedRecipientsRegexp *regexp.Regexp          // compiled regexp using BlockedRecipients
+	PreserveMessageIDs      bool                    `yaml:"preserve-message-ids"` // preserve the original Message-ID when relaying
+	ForwardSMTPErrors       bool                    `yaml:"forward-smtp-errors"`  // whether to log smtp-errors or forward them to upstream-client
+	DKIM                    DKIMSigningConfigStruct `yaml:"dkim"`                 // DKIM signing of relayed messages
+	Rewrite                 RewriteConfigStruct     `yaml:"rewrite"`              // header, subject & URL rewriting of relayed messages
+	Backend                 *deliver.Backend        // local delivery backend, set if the host is a maildir://, mbox:// or exec:// URL
+
+	// named relay profiles, selectable per release & auto-relay matching
+	Profiles       map[string]*SMTPRelayConfigStruct `yaml:"profiles"` // named relay profiles (default relay config only)
+	Matching       string                            `yaml:"matching"` // regex, auto-relay new messages to matching recipients via the profile (named profiles only)
+	MatchingRegexp *regexp.Regexp                    // compiled regexp using Matching
+
+	// HTTP email provider API, relaying messages via the provider instead of an SMTP server
+	Provider         string           `yaml:"provider"`          // sendgrid, mailgun or postmark
+	ProviderEndpoint string           `yaml:"provider-endpoint"` // provider API endpoint, defaults to the provider API
+	ProviderAPIKey   string           `yaml:"provider-api-key"`  // provider API key
+	ProviderClient   *provider.Client // provider API client, set if a provider is configured
 
 	// DEPRECATED 2024/03/12
 	RecipientAllowlist string `yaml:"recipient-allowlist"`
@@ -278,19 +368,52 @@ type SMTPRelayConfigStruct struct {
 
 // SMTPForwardConfigStruct struct for parsing yaml & storing variables
 type SMTPForwardConfigStruct struct {
-	To                string `yaml:"to"`                  // comma-separated list of email addresses
-	Host              string `yaml:"host"`                // SMTP host
-	Port              int    `yaml:"port"`                // SMTP port
-	STARTTLS          bool   `yaml:"starttls"`            // whether to use STARTTLS
-	TLS               bool   `yaml:"tls"`                 // whether to use TLS
-	AllowInsecure     bool   `yaml:"allow-insecure"`      // allow insecure authentication, ignore TLS validation
-	Auth              string `yaml:"auth"`                // none, plain, login, cram-md5
-	Username          string `yaml:"username"`            // plain & cram-md5
-	Password          string `yaml:"password"`            // plain
-	Secret            string `yaml:"secret"`              // cram-md5
-	ReturnPath        string `yaml:"return-path"`         // allow overriding the bounce address
-	OverrideFrom      string `yaml:"override-from"`       // allow overriding of the from address
-	ForwardSMTPErrors bool   `yaml:"forward-smtp-errors"` // whether to log smtp-errors or forward them to upstream-client
+	To                string                  `yaml:"to"`                  // comma-separated list of email addresses
+	Host              string                  `yaml:"host"`                // SMTP host
+	Port              int                     `yaml:"port"`                // SMTP port
+	STARTTLS          bool                    `yaml:"starttls"`            // whether to use STARTTLS
+	TLS               bool                    `yaml:"tls"`                 // whether to use TLS
+	AllowInsecure     bool                    `yaml:"allow-insecure"`      // allow insecure authentication, ignore TLS validation
+	Auth              string                  `yaml:"auth"`                // none, plain, login, cram-md5
+	Username          string                  `yaml:"username"`            // plain & cram-md5
+	Password          string                  `yaml:"password"`            // plain
+	Secret            string                  `yaml:"secret"`              // cram-md5
+	ReturnPath        string                  `yaml:"return-path"`         // allow overriding the bounce address
+	OverrideFrom      string                  `yaml:"override-from"`       // allow overriding of the from address
+	ForwardSMTPErrors bool                    `yaml:"forward-smtp-errors"` // whether to log smtp-errors or forward them to upstream-client
+	DKIM              DKIMSigningConfigStruct `yaml:"dkim"`                // DKIM signing of forwarded messages
+	Rewrite           RewriteConfigStruct     `yaml:"rewrite"`             // header, subject & URL rewriting of forwarded messages
+	Backend           *deliver.Backend        // local delivery backend, set if the host is a maildir://, mbox:// or exec:// URL
+
+	// forward rules, forwarding messages matching a search via their own SMTP server
+	Rules []*SMTPForwardConfigStruct `yaml:"rules"` // forward rules (default forwarding config only)
+	Name  string                     `yaml:"name"`  // unique rule name, defaults to rule-<n> (forward rules only)
+	Match string                     `yaml:"match"` // search expression, eg: tag:billing (forward rules only)
+}
+
+// RewriteConfigStruct struct for parsing yaml rewriting rules of relayed & forwarded messages
+type RewriteConfigStruct struct {
+	RemoveHeaders []string           `yaml:"remove-headers"` // headers to remove, a trailing * matches a prefix (eg: X-Internal-*)
+	SetHeaders    map[string]string  `yaml:"set-headers"`    // headers to add or replace, values are templates (eg: {{ .To }})
+	Subject       string             `yaml:"subject"`        // subject template (eg: [STAGING] {{ .Subject }})
+	URLs          []URLRewriteStruct `yaml:"urls"`           // URL rewriting in text & HTML parts
+	Rules         *rewrite.Rules     // compiled rules, set if configured
+}
+
+// URLRewriteStruct struct for parsing a yaml URL rewriting rule
+type URLRewriteStruct struct {
+	Match   string `yaml:"match"`   // regex matched against each URL
+	Replace string `yaml:"replace"` // replacement, may contain regexp group references (eg: $1)
+}
+
+// DKIMSigningConfigStruct struct for parsing yaml DKIM signing options of relayed & forwarded messages
+type DKIMSigningConfigStruct struct {
+	Domain           string       `yaml:"domain"`           // signing domain (d=)
+	Selector         string       `yaml:"selector"`         // selector (s=)
+	PrivateKey       string       `yaml:"private-key"`      // path to a PEM-encoded RSA or Ed25519 private key
+	Headers          string       `yaml:"headers"`          // comma-separated list of headers to sign
+	Canonicalization string       `yaml:"canonicalization"` // header/body canonicalization, default relaxed/relaxed
+	Signer           *dkim.Signer // DKIM signer, set if configured
 }
 
 // VerifyConfig wil do some basic checking
@@ -459,6 +582,21 @@ func VerifyConfig() error {
 	} else if SMTPRequireSTARTTLS {
 		return errors.New("[smtp] STARTTLS cannot be required without an SMTP TLS certificate and key")
 	}
+
+	if SMTPTLSClientCA != "" {
+		if SMTPTLSCert == "" {
+			return errors.New("[smtp] client certificate authentication requires an SMTP TLS certificate and key")
+		}
+
+		SMTPTLSClientCA = filepath.Clean(SMTPTLSClientCA)
+
+		if !isFile(SMTPTLSClientCA) {
+			return fmt.Errorf("[smtp] TLS client CA not found or readable: %s", SMTPTLSClientCA)
+		}
+	} else if SMTPRequireClientCert {
+		return errors.New("[smtp] client certificates cannot be required without a TLS client CA")
+	}
+
 	if SMTPRequireSTARTTLS && SMTPAuthAllowInsecure || SMTPRequireTLS && SMTPAuthAllowInsecure {
 		return errors.New("[smtp] TLS cannot be required with --smtp-auth-allow-insecure")
 	}
@@ -499,7 +637,55 @@ func VerifyConfig() error {
 		return errors.New("[smtp] authentication cannot use both credentials and --smtp-auth-accept-any")
 	}
 
-	if SMTPTLSCert == "" && (auth.SMTPCredentials != nil || SMTPAuthAcceptAny) && !SMTPAuthAllowInsecure {
+	if SMTPAuthOAuthTokensFile != "" {
+		SMTPAuthOAuthTokensFile = filepath.Clean(SMTPAuthOAuthTokensFile)
+
+		if !isFile(SMTPAuthOAuthTokensFile) {
+			return fmt.Errorf("[smtp] OAuth token file not found or readable: %s", SMTPAuthOAuthTokensFile)
+		}
+
+		b, err := os.ReadFile(SMTPAuthOAuthTokensFile)
+		if err != nil {
+			return err
+		}
+
+		if err := auth.SetSMTPOAuthTokens(string(b)); err != nil {
+			return fmt.Errorf("[smtp] %s", err.Error())
+		}
+	}
+
+	if SMTPAuthOAuthJWTKey != "" {
+		SMTPAuthOAuthJWTKey = filepath.Clean(SMTPAuthOAuthJWTKey)
+
+		if !isFile(SMTPAuthOAuthJWTKey) {
+			return fmt.Errorf("[smtp] OAuth JWT public key not found or readable: %s", SMTPAuthOAuthJWTKey)
+		}
+
+		b, err := os.ReadFile(SMTPAuthOAuthJWTKey)
+		if err != nil {
+			return err
+		}
+
+		if err := auth.SetSMTPOAuthJWTKey(b); err != nil {
+			return fmt.Errorf("[smtp] %s", err.Error())
+		}
+	}
+
+	if SMTPAuthOAuthAcceptAny && (auth.SMTPOAuthTokens != nil || auth.SMTPOAuthJWTKey != nil) {
+		return errors.New("[smtp] OAuth authentication cannot use both tokens and --smtp-auth-oauth-accept-any")
+	}
+
+	auth.SMTPOAuthAcceptAny = SMTPAuthOAuthAcceptAny
+
+	if (auth.SMTPOAuthTokens != nil || auth.SMTPOAuthJWTKey != nil) && !SMTPAuthAllowInsecure && SMTPTLSCert != "" && !SMTPRequireTLS {
+		// bearer tokens are credentials, so must not be sent unencrypted (RFC 7628 section 5)
+		if !SMTPRequireSTARTTLS {
+			logger.Log().Warn("[smtp] STARTTLS is required for OAuth authentication, run with `--smtp-auth-allow-insecure` to allow insecure authentication")
+		}
+		SMTPRequireSTARTTLS = true
+	}
+
+	if SMTPTLSCert == "" && (auth.SMTPCredentials != nil || SMTPAuthAcceptAny || auth.SMTPOAuthEnabled()) && !SMTPAuthAllowInsecure {
 		return errors.New("[smtp] authentication requires STARTTLS or TLS encryption, run with `--smtp-auth-allow-insecure` to allow insecure authentication")
 	}
 
@@ -507,6 +693,10 @@ func VerifyConfig() error {
 		return fmt.Errorf("[chaos] %s", err.Error())
 	}
 
+	if err := parseChaosRules(); err != nil {
+		return fmt.Errorf("[chaos] %s", err.Error())
+	}
+
 	if chaos.Enabled {
 		logger.Log().Info("[chaos] is enabled")
 	}
@@ -587,6 +777,72 @@ func VerifyConfig() error {
 		}
 	}
 
+	if DNSZoneFile != "" {
+		DNSZoneFile = filepath.Clean(DNSZoneFile)
+		if !isFile(DNSZoneFile) {
+			return fmt.Errorf("[dns] zone file not found or readable: %s", DNSZoneFile)
+		}
+	}
+
+	if DKIMKeysFile != "" {
+		DKIMKeysFile = filepath.Clean(DKIMKeysFile)
+		if !isFile(DKIMKeysFile) {
+			return fmt.Errorf("[dkim] keys file not found or readable: %s", DKIMKeysFile)
+		}
+	}
+
+	if err := resolver.SetLocal(DNSZoneFile, DKIMKeysFile); err != nil {
+		return fmt.Errorf("[dns] %s", err.Error())
+	}
+
+	if resolver.Local() != nil {
+		logger.Log().Info("[dns] authenticating messages using local DNS records")
+	}
+
+	if SMIMECAFile != "" {
+		SMIMECAFile = filepath.Clean(SMIMECAFile)
+		if !isFile(SMIMECAFile) {
+			return fmt.Errorf("[smime] CA bundle not found or readable: %s", SMIMECAFile)
+		}
+	}
+
+	if SMIMEKeysFile != "" {
+		SMIMEKeysFile = filepath.Clean(SMIMEKeysFile)
+		if !isFile(SMIMEKeysFile) {
+			return fmt.Errorf("[smime] keys file not found or readable: %s", SMIMEKeysFile)
+		}
+	}
+
+	if err := smime.Load(SMIMECAFile, SMIMEKeysFile); err != nil {
+		return fmt.Errorf("[smime] %s", err.Error())
+	}
+
+	if SMIMEKeysFile != "" {
+		logger.Log().Infof("[smime] decrypting messages using keys from %s", SMIMEKeysFile)
+	}
+
+	if PGPKeyringFile != "" {
+		PGPKeyringFile = filepath.Clean(PGPKeyringFile)
+		if !isFile(PGPKeyringFile) {
+			return fmt.Errorf("[pgp] keyring not found or readable: %s", PGPKeyringFile)
+		}
+	}
+
+	if PGPKeysFile != "" {
+		PGPKeysFile = filepath.Clean(PGPKeysFile)
+		if !isFile(PGPKeysFile) {
+			return fmt.Errorf("[pgp] keys file not found or readable: %s", PGPKeysFile)
+		}
+	}
+
+	if err := pgp.Load(PGPKeyringFile, PGPKeysFile); err != nil {
+		return fmt.Errorf("[pgp] %s", err.Error())
+	}
+
+	if PGPKeysFile != "" {
+		logger.Log().Infof("[pgp] decrypting messages using keys from %s", PGPKeysFile)
+	}
+
 	// load tag filters & options
 	TagFilters = []autoTag{}
 	if err := loadTagsFromArgs(CLITagsArg); err != nil {
@@ -617,6 +873,42 @@ func VerifyConfig() error {
 		}
 	}
 
+	if SMTPLimitConnections < 0 || SMTPLimitMessages < 0 || SMTPLimitSize < 0 ||
+		SMTPLimitUserConnections < 0 || SMTPLimitUserMessages < 0 || SMTPLimitUserSize < 0 {
+		return errors.New("[smtp] rate limits cannot be negative")
+	}
+
+	ratelimit.MaxConnections = SMTPLimitConnections
+	ratelimit.MaxMessages = SMTPLimitMessages
+	ratelimit.MaxSize = SMTPLimitSize * 1024 * 1024
+	ratelimit.MaxUserConnections = SMTPLimitUserConnections
+	ratelimit.MaxUserMessages = SMTPLimitUserMessages
+	ratelimit.MaxUserSize = SMTPLimitUserSize * 1024 * 1024
+
+	if SMTPLimitConnections > 0 || SMTPLimitMessages > 0 || SMTPLimitSize > 0 {
+		logger.Log().Infof(
+			"[smtp] rate limiting client IPs to %d connections, %d messages per minute & %dMB per hour (0 = unlimited)",
+			SMTPLimitConnections, SMTPLimitMessages, SMTPLimitSize,
+		)
+	}
+
+	if SMTPLimitUserConnections > 0 || SMTPLimitUserMessages > 0 || SMTPLimitUserSize > 0 {
+		logger.Log().Infof(
+			"[smtp] rate limiting authenticated users to %d connections, %d messages per minute & %dMB per hour (0 = unlimited)",
+			SMTPLimitUserConnections, SMTPLimitUserMessages, SMTPLimitUserSize,
+		)
+	}
+
+	if SMTPGreylist != "" {
+		delay, err := time.ParseDuration(SMTPGreylist)
+		if err != nil || delay <= 0 {
+			return fmt.Errorf("[smtp] invalid greylist delay: %s", SMTPGreylist)
+		}
+
+		greylist.Delay = delay
+		logger.Log().Infof("[smtp] greylisting new delivery attempts for %s", delay)
+	}
+
 	if err := parseRelayConfig(SMTPRelayConfigFile); err != nil {
 		return err
 	}
@@ -641,15 +933,24 @@ func VerifyConfig() error {
 
 			SMTPRelayMatchingRegexp = re
 			logger.Log().Infof(
-				"[relay] auto-relaying new messages to recipients matching \"%s\" via %s:%d",
-				SMTPRelayMatching, SMTPRelayConfig.Host, SMTPRelayConfig.Port,
+				"[relay] auto-relaying new messages to recipients matching \"%s\" via %s",
+				SMTPRelayMatching, relayAddr(&SMTPRelayConfig),
 			)
 		}
 	}
 
+	if SMTPRelayQueueMaxAge != "" {
+		d, err := time.ParseDuration(SMTPRelayQueueMaxAge)
+		if err != nil || d < 0 {
+			return fmt.Errorf("[relay] invalid queue max age: %s", SMTPRelayQueueMaxAge)
+		}
+
+		SMTPRelayQueueMaxAgeDuration = d
+	}
+
 	if SMTPRelayAll {
 		// this deserves a warning
-		logger.Log().Warnf("[relay] auto-relaying all new messages via %s:%d", SMTPRelayConfig.Host, SMTPRelayConfig.Port)
+		logger.Log().Warnf("[relay] auto-relaying all new messages via %s", relayAddr(&SMTPRelayConfig))
 	}
 
 	if err := parseForwardConfig(SMTPForwardConfigFile); err != nil {
@@ -661,6 +962,10 @@ func VerifyConfig() error {
 		return err
 	}
 
+	if err := parseBounceRules(); err != nil {
+		return fmt.Errorf("[bounce] %s", err.Error())
+	}
+
 	if DemoMode {
 		MaxMessages = 1000
 		// this deserves a warning
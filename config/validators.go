@@ -7,10 +7,16 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/axllent/mailpit/internal/deliver"
+	"github.com/axllent/mailpit/internal/dkim"
 	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/provider"
+	"github.com/axllent/mailpit/internal/rewrite"
+	"github.com/axllent/mailpit/internal/smtpd/bounce"
 	"github.com/axllent/mailpit/internal/smtpd/chaos"
 	"github.com/goccy/go-yaml"
 )
@@ -69,8 +75,8 @@ func parseRelayConfig(c string) error {
 		return err
 	}
 
-	if SMTPRelayConfig.Host == "" {
-		return errors.New("[relay] host not set")
+	if SMTPRelayConfig.Host == "" && SMTPRelayConfig.Provider == "" {
+		return errors.New("[relay] host or provider not set")
 	}
 
 	// DEPRECATED 2024/03/12
@@ -84,76 +90,200 @@ func parseRelayConfig(c string) error {
 	return nil
 }
 
-// Validate the SMTPRelayConfig (if Host is set)
+// Validate the SMTPRelayConfig & named relay profiles (if Host or Provider is set)
 func validateRelayConfig() error {
-	if SMTPRelayConfig.Host == "" {
+	if SMTPRelayConfig.Host == "" && SMTPRelayConfig.Provider == "" {
 		return nil
 	}
 
-	if SMTPRelayConfig.Port == 0 {
-		SMTPRelayConfig.Port = 25 // default
+	if err := validateRelayProfile("relay", &SMTPRelayConfig); err != nil {
+		return err
+	}
+
+	if SMTPRelayConfig.Matching != "" {
+		return errors.New("[relay] matching is only supported by named profiles, use smtp-relay-matching instead")
 	}
 
-	SMTPRelayConfig.Auth = strings.ToLower(SMTPRelayConfig.Auth)
+	for _, name := range RelayProfileNames() {
+		p := SMTPRelayConfig.Profiles[name]
+		prefix := "relay:" + name
 
-	if SMTPRelayConfig.Auth == "" || SMTPRelayConfig.Auth == "none" || SMTPRelayConfig.Auth == "false" {
-		SMTPRelayConfig.Auth = "none"
-	} else if SMTPRelayConfig.Auth == "plain" {
-		if SMTPRelayConfig.Username == "" || SMTPRelayConfig.Password == "" {
-			return fmt.Errorf("[relay] host username or password not set for PLAIN authentication")
+		if p == nil || p.Host == "" && p.Provider == "" {
+			return fmt.Errorf("[%s] host or provider not set", prefix)
 		}
-	} else if SMTPRelayConfig.Auth == "login" {
-		SMTPRelayConfig.Auth = "login"
-		if SMTPRelayConfig.Username == "" || SMTPRelayConfig.Password == "" {
-			return fmt.Errorf("[relay] host username or password not set for LOGIN authentication")
+
+		if len(p.Profiles) > 0 {
+			return fmt.Errorf("[%s] profiles cannot be nested", prefix)
 		}
-	} else if strings.HasPrefix(SMTPRelayConfig.Auth, "cram") {
-		SMTPRelayConfig.Auth = "cram-md5"
-		if SMTPRelayConfig.Username == "" || SMTPRelayConfig.Secret == "" {
-			return fmt.Errorf("[relay] host username or secret not set for CRAM-MD5 authentication")
+
+		if err := validateRelayProfile(prefix, p); err != nil {
+			return err
+		}
+
+		if p.Matching != "" {
+			re, err := regexp.Compile(p.Matching)
+			if err != nil {
+				return fmt.Errorf("[%s] failed to compile matching regexp: %s", prefix, err.Error())
+			}
+
+			p.MatchingRegexp = re
+			logger.Log().Infof("[%s] auto-relaying new messages to recipients matching \"%s\" via %s", prefix, p.Matching, relayAddr(p))
+		}
+	}
+
+	ReleaseEnabled = true
+
+	logger.Log().Infof("[relay] enabling message relaying via %s", relayAddr(&SMTPRelayConfig))
+
+	return nil
+}
+
+// validateRelayProfile validates & sets the defaults of a relay configuration
+func validateRelayProfile(prefix string, c *SMTPRelayConfigStruct) error {
+	if c.Provider != "" {
+		if c.Host != "" {
+			return fmt.Errorf("[%s] host & provider cannot be set together", prefix)
+		}
+
+		client, err := provider.New(c.Provider, c.ProviderEndpoint, c.ProviderAPIKey, c.AllowInsecure)
+		if err != nil {
+			return fmt.Errorf("[%s] %s", prefix, err.Error())
+		}
+
+		client.UserAgent = "Mailpit/" + Version
+		c.ProviderClient = client
+	}
+
+	b, err := deliver.Parse(c.Host)
+	if err != nil {
+		return fmt.Errorf("[%s] %s", prefix, err.Error())
+	}
+
+	c.Backend = b
+
+	if c.Port == 0 && c.Backend == nil && c.ProviderClient == nil {
+		c.Port = 25 // default
+	}
+
+	c.Auth = strings.ToLower(c.Auth)
+
+	if c.Auth == "" || c.Auth == "none" || c.Auth == "false" {
+		c.Auth = "none"
+	} else if c.Auth == "plain" {
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("[%s] host username or password not set for PLAIN authentication", prefix)
+		}
+	} else if c.Auth == "login" {
+		c.Auth = "login"
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("[%s] host username or password not set for LOGIN authentication", prefix)
+		}
+	} else if strings.HasPrefix(c.Auth, "cram") {
+		c.Auth = "cram-md5"
+		if c.Username == "" || c.Secret == "" {
+			return fmt.Errorf("[%s] host username or secret not set for CRAM-MD5 authentication", prefix)
 		}
 	} else {
-		return fmt.Errorf("[relay] authentication method not supported: %s", SMTPRelayConfig.Auth)
+		return fmt.Errorf("[%s] authentication method not supported: %s", prefix, c.Auth)
 	}
 
-	if SMTPRelayConfig.AllowedRecipients != "" {
-		re, err := regexp.Compile(SMTPRelayConfig.AllowedRecipients)
+	if c.AllowedRecipients != "" {
+		re, err := regexp.Compile(c.AllowedRecipients)
 		if err != nil {
-			return fmt.Errorf("[relay] failed to compile recipient allowlist regexp: %s", err.Error())
+			return fmt.Errorf("[%s] failed to compile recipient allowlist regexp: %s", prefix, err.Error())
 		}
 
-		SMTPRelayConfig.AllowedRecipientsRegexp = re
-		logger.Log().Infof("[relay] recipient allowlist is active with the following regexp: %s", SMTPRelayConfig.AllowedRecipients)
+		c.AllowedRecipientsRegexp = re
+		logger.Log().Infof("[%s] recipient allowlist is active with the following regexp: %s", prefix, c.AllowedRecipients)
 	}
 
-	if SMTPRelayConfig.BlockedRecipients != "" {
-		re, err := regexp.Compile(SMTPRelayConfig.BlockedRecipients)
+	if c.BlockedRecipients != "" {
+		re, err := regexp.Compile(c.BlockedRecipients)
 		if err != nil {
-			return fmt.Errorf("[relay] failed to compile recipient blocklist regexp: %s", err.Error())
+			return fmt.Errorf("[%s] failed to compile recipient blocklist regexp: %s", prefix, err.Error())
 		}
 
-		SMTPRelayConfig.BlockedRecipientsRegexp = re
-		logger.Log().Infof("[relay] recipient blocklist is active with the following regexp: %s", SMTPRelayConfig.BlockedRecipients)
+		c.BlockedRecipientsRegexp = re
+		logger.Log().Infof("[%s] recipient blocklist is active with the following regexp: %s", prefix, c.BlockedRecipients)
 	}
 
-	if SMTPRelayConfig.OverrideFrom != "" {
-		m, err := mail.ParseAddress(SMTPRelayConfig.OverrideFrom)
+	if c.OverrideFrom != "" {
+		m, err := mail.ParseAddress(c.OverrideFrom)
 		if err != nil {
-			return fmt.Errorf("[relay] override-from is not a valid email address: %s", SMTPRelayConfig.OverrideFrom)
+			return fmt.Errorf("[%s] override-from is not a valid email address: %s", prefix, c.OverrideFrom)
 		}
 
-		SMTPRelayConfig.OverrideFrom = m.Address
+		c.OverrideFrom = m.Address
 	}
 
-	if SMTPRelayConfig.STARTTLS && SMTPRelayConfig.TLS {
-		return fmt.Errorf("[relay] TLS & STARTTLS cannot be required together")
+	if c.STARTTLS && c.TLS {
+		return fmt.Errorf("[%s] TLS & STARTTLS cannot be required together", prefix)
 	}
 
-	ReleaseEnabled = true
+	if err := validateDKIMSigningConfig(prefix, &c.DKIM); err != nil {
+		return err
+	}
 
-	logger.Log().Infof("[relay] enabling message relaying via %s:%d", SMTPRelayConfig.Host, SMTPRelayConfig.Port)
+	return validateRewriteConfig(prefix, &c.Rewrite)
+}
 
-	return nil
+// RelayProfile returns the named relay profile, or the default relay configuration if the name is empty
+func RelayProfile(name string) (*SMTPRelayConfigStruct, error) {
+	if name == "" {
+		return &SMTPRelayConfig, nil
+	}
+
+	p, ok := SMTPRelayConfig.Profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("relay profile not found: %s", name)
+	}
+
+	return p, nil
+}
+
+// deliveryAddr returns the SMTP host & port, or the local delivery URL if the host is one
+func deliveryAddr(host string, port int) string {
+	if strings.Contains(host, "://") {
+		return host
+	}
+
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// relayAddr returns the provider API, SMTP host & port, or local delivery URL of a relay configuration
+func relayAddr(c *SMTPRelayConfigStruct) string {
+	if c.ProviderClient != nil {
+		return c.ProviderClient.String()
+	}
+
+	return deliveryAddr(c.Host, c.Port)
+}
+
+// ForwardRule returns the named forward rule, or the default forwarding configuration if the name is empty
+func ForwardRule(name string) (*SMTPForwardConfigStruct, error) {
+	if name == "" {
+		return &SMTPForwardConfig, nil
+	}
+
+	for _, rule := range SMTPForwardConfig.Rules {
+		if rule != nil && rule.Name == name {
+			return rule, nil
+		}
+	}
+
+	return nil, fmt.Errorf("forward rule not found: %s", name)
+}
+
+// RelayProfileNames returns the names of the named relay profiles, sorted alphabetically
+func RelayProfileNames() []string {
+	names := []string{}
+	for name := range SMTPRelayConfig.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
 }
 
 // Parse the SMTPForwardConfigFile (if set)
@@ -177,81 +307,194 @@ func parseForwardConfig(c string) error {
 		return err
 	}
 
-	if SMTPForwardConfig.Host == "" {
+	if SMTPForwardConfig.Host == "" && len(SMTPForwardConfig.Rules) == 0 {
 		return errors.New("[forward] host not set")
 	}
 
 	return nil
 }
 
-// Validate the SMTPForwardConfig (if Host is set)
+// Validate the SMTPForwardConfig (if Host is set) & forward rules
 func validateForwardConfig() error {
-	if SMTPForwardConfig.Host == "" {
-		return nil
+	if SMTPForwardConfig.Host != "" {
+		if err := validateForwardTarget("forward", &SMTPForwardConfig); err != nil {
+			return err
+		}
+
+		logger.Log().Infof("[forward] enabling message forwarding to %s via %s", SMTPForwardConfig.To, deliveryAddr(SMTPForwardConfig.Host, SMTPForwardConfig.Port))
+	}
+
+	names := map[string]bool{}
+	for i, rule := range SMTPForwardConfig.Rules {
+		if rule == nil {
+			return fmt.Errorf("[forward] rule %d is empty", i+1)
+		}
+
+		rule.Name = strings.TrimSpace(rule.Name)
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		prefix := "forward:" + rule.Name
+
+		if names[rule.Name] {
+			return fmt.Errorf("[%s] duplicate rule name", prefix)
+		}
+		names[rule.Name] = true
+
+		if strings.TrimSpace(rule.Match) == "" {
+			return fmt.Errorf("[%s] match not set", prefix)
+		}
+
+		if rule.Host == "" {
+			return fmt.Errorf("[%s] host not set", prefix)
+		}
+
+		if len(rule.Rules) > 0 {
+			return fmt.Errorf("[%s] rules cannot be nested", prefix)
+		}
+
+		// rules match stored messages, so are always queued once the message has been accepted
+		if rule.ForwardSMTPErrors {
+			return fmt.Errorf("[%s] forward-smtp-errors is not supported by forward rules", prefix)
+		}
+
+		if err := validateForwardTarget(prefix, rule); err != nil {
+			return err
+		}
+
+		logger.Log().Infof("[%s] forwarding messages matching \"%s\" to %s via %s", prefix, rule.Match, rule.To, deliveryAddr(rule.Host, rule.Port))
 	}
 
-	if SMTPForwardConfig.Port == 0 {
-		SMTPForwardConfig.Port = 25 // default
+	return nil
+}
+
+// validateForwardTarget validates & sets the defaults of a forwarding configuration
+func validateForwardTarget(prefix string, c *SMTPForwardConfigStruct) error {
+	b, err := deliver.Parse(c.Host)
+	if err != nil {
+		return fmt.Errorf("[%s] %s", prefix, err.Error())
 	}
 
-	SMTPForwardConfig.Auth = strings.ToLower(SMTPForwardConfig.Auth)
+	c.Backend = b
+
+	if c.Port == 0 && c.Backend == nil {
+		c.Port = 25 // default
+	}
 
-	if SMTPForwardConfig.Auth == "" || SMTPForwardConfig.Auth == "none" || SMTPForwardConfig.Auth == "false" {
-		SMTPForwardConfig.Auth = "none"
-	} else if SMTPForwardConfig.Auth == "plain" {
-		if SMTPForwardConfig.Username == "" || SMTPForwardConfig.Password == "" {
-			return fmt.Errorf("[forward] host username or password not set for PLAIN authentication")
+	c.Auth = strings.ToLower(c.Auth)
+
+	if c.Auth == "" || c.Auth == "none" || c.Auth == "false" {
+		c.Auth = "none"
+	} else if c.Auth == "plain" {
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("[%s] host username or password not set for PLAIN authentication", prefix)
 		}
-	} else if SMTPForwardConfig.Auth == "login" {
-		SMTPForwardConfig.Auth = "login"
-		if SMTPForwardConfig.Username == "" || SMTPForwardConfig.Password == "" {
-			return fmt.Errorf("[forward] host username or password not set for LOGIN authentication")
+	} else if c.Auth == "login" {
+		c.Auth = "login"
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("[%s] host username or password not set for LOGIN authentication", prefix)
 		}
-	} else if strings.HasPrefix(SMTPForwardConfig.Auth, "cram") {
-		SMTPForwardConfig.Auth = "cram-md5"
-		if SMTPForwardConfig.Username == "" || SMTPForwardConfig.Secret == "" {
-			return fmt.Errorf("[forward] host username or secret not set for CRAM-MD5 authentication")
+	} else if strings.HasPrefix(c.Auth, "cram") {
+		c.Auth = "cram-md5"
+		if c.Username == "" || c.Secret == "" {
+			return fmt.Errorf("[%s] host username or secret not set for CRAM-MD5 authentication", prefix)
 		}
 	} else {
-		return fmt.Errorf("[forward] authentication method not supported: %s", SMTPForwardConfig.Auth)
+		return fmt.Errorf("[%s] authentication method not supported: %s", prefix, c.Auth)
 	}
 
-	if SMTPForwardConfig.To == "" {
-		return errors.New("[forward] To addresses missing")
+	if c.To == "" {
+		return fmt.Errorf("[%s] To addresses missing", prefix)
 	}
 
 	to := []string{}
-	addresses := strings.SplitSeq(SMTPForwardConfig.To, ",")
+	addresses := strings.SplitSeq(c.To, ",")
 	for a := range addresses {
 		a = strings.TrimSpace(a)
 		m, err := mail.ParseAddress(a)
 		if err != nil {
-			return fmt.Errorf("[forward] To address is not a valid email address: %s", a)
+			return fmt.Errorf("[%s] To address is not a valid email address: %s", prefix, a)
 		}
 		to = append(to, m.Address)
 	}
 
 	if len(to) == 0 {
-		return errors.New("[forward] no valid To addresses found")
+		return fmt.Errorf("[%s] no valid To addresses found", prefix)
 	}
 
 	// overwrite the To field with the cleaned up list
-	SMTPForwardConfig.To = strings.Join(to, ",")
+	c.To = strings.Join(to, ",")
 
-	if SMTPForwardConfig.OverrideFrom != "" {
-		m, err := mail.ParseAddress(SMTPForwardConfig.OverrideFrom)
+	if c.OverrideFrom != "" {
+		m, err := mail.ParseAddress(c.OverrideFrom)
 		if err != nil {
-			return fmt.Errorf("[forward] override-from is not a valid email address: %s", SMTPForwardConfig.OverrideFrom)
+			return fmt.Errorf("[%s] override-from is not a valid email address: %s", prefix, c.OverrideFrom)
 		}
 
-		SMTPForwardConfig.OverrideFrom = m.Address
+		c.OverrideFrom = m.Address
+	}
+
+	if c.STARTTLS && c.TLS {
+		return fmt.Errorf("[%s] TLS & STARTTLS cannot be required together", prefix)
+	}
+
+	if err := validateDKIMSigningConfig(prefix, &c.DKIM); err != nil {
+		return err
 	}
 
-	if SMTPForwardConfig.STARTTLS && SMTPForwardConfig.TLS {
-		return fmt.Errorf("[forward] TLS & STARTTLS cannot be required together")
+	return validateRewriteConfig(prefix, &c.Rewrite)
+}
+
+// Validate & compile the rewriting rules of relayed or forwarded messages (if set)
+func validateRewriteConfig(prefix string, c *RewriteConfigStruct) error {
+	urls := []rewrite.URLRule{}
+	for _, u := range c.URLs {
+		urls = append(urls, rewrite.URLRule{Match: u.Match, Replace: u.Replace})
 	}
 
-	logger.Log().Infof("[forward] enabling message forwarding to %s via %s:%d", SMTPForwardConfig.To, SMTPForwardConfig.Host, SMTPForwardConfig.Port)
+	rules, err := rewrite.New(c.RemoveHeaders, c.SetHeaders, c.Subject, urls)
+	if err != nil {
+		return fmt.Errorf("[%s] rewrite: %s", prefix, err.Error())
+	}
+
+	c.Rules = rules
+	if rules != nil {
+		logger.Log().Infof("[%s] rewriting message headers & content", prefix)
+	}
+
+	return nil
+}
+
+// Validate the DKIM signing config of relayed or forwarded messages (if set)
+func validateDKIMSigningConfig(prefix string, c *DKIMSigningConfigStruct) error {
+	if c.Domain == "" && c.Selector == "" && c.PrivateKey == "" {
+		return nil
+	}
+
+	if c.Domain == "" || c.Selector == "" || c.PrivateKey == "" {
+		return fmt.Errorf("[%s] dkim domain, selector & private-key are required for DKIM signing", prefix)
+	}
+
+	if !isFile(c.PrivateKey) {
+		return fmt.Errorf("[%s] dkim private-key not found or readable: %s", prefix, c.PrivateKey)
+	}
+
+	headers := []string{}
+	for h := range strings.SplitSeq(c.Headers, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, h)
+		}
+	}
+
+	signer, err := dkim.NewSigner(c.Domain, c.Selector, c.PrivateKey, headers, c.Canonicalization)
+	if err != nil {
+		return fmt.Errorf("[%s] dkim: %s", prefix, err.Error())
+	}
+
+	c.Signer = signer
+
+	logger.Log().Infof("[%s] signing messages with DKIM for %s (selector %s)", prefix, signer.Domain(), c.Selector)
 
 	return nil
 }
@@ -262,11 +505,26 @@ func parseChaosTriggers() error {
 	}
 
 	re := regexp.MustCompile(`^([a-zA-Z0-0]+):(\d\d\d):(\d+(\.\d)?)$`)
+	// delay-<stage>:<min>[-<max>]:<probability>, drop-<stage>:<probability>, bandwidth:<bytes>:<probability> & truncate:<lines>:<probability>
+	faultRe := regexp.MustCompile(`^([a-zA-Z]+(-[a-zA-Z]+)?):((\d+(-\d+)?):)?(\d+)$`)
 
 	parts := strings.SplitSeq(ChaosTriggers, ",")
 	for p := range parts {
 		p = strings.TrimSpace(p)
 		if !re.MatchString(p) {
+			if faultRe.MatchString(p) {
+				matches := faultRe.FindStringSubmatch(p)
+				probability, err := strconv.Atoi(matches[6])
+				if err != nil {
+					return err
+				}
+
+				if err := chaos.SetFault(matches[1], matches[4], probability); err != nil {
+					return err
+				}
+				continue
+			}
+
 			return fmt.Errorf("invalid argument: %s", p)
 		}
 
@@ -288,3 +546,70 @@ func parseChaosTriggers() error {
 
 	return nil
 }
+
+// yamlChaosRules is the chaos rules configuration file structure
+type yamlChaosRules struct {
+	Rules []chaos.Rule `yaml:"rules"`
+}
+
+func parseChaosRules() error {
+	if ChaosRulesFile == "" {
+		return nil
+	}
+
+	ChaosRulesFile = filepath.Clean(ChaosRulesFile)
+
+	if !isFile(ChaosRulesFile) {
+		return fmt.Errorf("rules file not found or readable: %s", ChaosRulesFile)
+	}
+
+	data, err := os.ReadFile(ChaosRulesFile)
+	if err != nil {
+		return err
+	}
+
+	conf := yamlChaosRules{}
+
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return err
+	}
+
+	if conf.Rules == nil {
+		return fmt.Errorf("missing rules: array in %s", ChaosRulesFile)
+	}
+
+	return chaos.SetRules(conf.Rules)
+}
+
+func parseBounceRules() error {
+	if BounceRulesFile == "" {
+		return nil
+	}
+
+	BounceRulesFile = filepath.Clean(BounceRulesFile)
+
+	if !isFile(BounceRulesFile) {
+		return fmt.Errorf("rules file not found or readable: %s", BounceRulesFile)
+	}
+
+	data, err := os.ReadFile(BounceRulesFile)
+	if err != nil {
+		return err
+	}
+
+	conf := bounce.Config{}
+
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return err
+	}
+
+	if conf.Rules == nil && !conf.ChaosRejections {
+		return fmt.Errorf("missing rules: array in %s", BounceRulesFile)
+	}
+
+	if strings.EqualFold(conf.Delivery, bounce.DeliveryRelay) && !ReleaseEnabled {
+		return errors.New("a relay configuration must be set to relay generated messages")
+	}
+
+	return bounce.SetConfig(conf)
+}
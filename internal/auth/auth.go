@@ -30,7 +30,7 @@ func SetUIAuth(s string) error {
 
 	r := strings.NewReader(strings.Join(credentials, "\n"))
 
-	UICredentials, err = htpasswd.NewFromReader(r, htpasswd.DefaultSystems, nil)
+	UICredentials, err = htpasswd.NewFromReader(r, passwdSystems, nil)
 	if err != nil {
 		return err
 	}
@@ -49,7 +49,7 @@ func SetSendAPIAuth(s string) error {
 
 	r := strings.NewReader(strings.Join(credentials, "\n"))
 
-	SendAPICredentials, err = htpasswd.NewFromReader(r, htpasswd.DefaultSystems, nil)
+	SendAPICredentials, err = htpasswd.NewFromReader(r, passwdSystems, nil)
 	if err != nil {
 		return err
 	}
@@ -68,12 +68,14 @@ func SetSMTPAuth(s string) error {
 
 	r := strings.NewReader(strings.Join(credentials, "\n"))
 
-	SMTPCredentials, err = htpasswd.NewFromReader(r, htpasswd.DefaultSystems, nil)
+	SMTPCredentials, err = htpasswd.NewFromReader(r, passwdSystems, nil)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	SMTPSCRAMCredentials, err = scramCredentialsFromString(s)
+
+	return err
 }
 
 // SetPOP3Auth will set POP3 server credentials
@@ -87,12 +89,14 @@ func SetPOP3Auth(s string) error {
 
 	r := strings.NewReader(strings.Join(credentials, "\n"))
 
-	POP3Credentials, err = htpasswd.NewFromReader(r, htpasswd.DefaultSystems, nil)
+	POP3Credentials, err = htpasswd.NewFromReader(r, passwdSystems, nil)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	POP3SCRAMCredentials, err = scramCredentialsFromString(s)
+
+	return err
 }
 
 func credentialsFromString(s string) []string {
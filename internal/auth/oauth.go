@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// SMTPOAuthTokens are static bearer tokens accepted for SMTP XOAUTH2 & OAUTHBEARER authentication.
+	// The map key is the token, the value is the username the token is bound to (empty for any user).
+	SMTPOAuthTokens map[string]string
+
+	// SMTPOAuthJWTKey is the public key used to validate JWT bearer tokens for SMTP authentication
+	SMTPOAuthJWTKey crypto.PublicKey
+
+	// SMTPOAuthAcceptAny accepts any bearer token for SMTP XOAUTH2 & OAUTHBEARER authentication
+	SMTPOAuthAcceptAny bool
+)
+
+// SMTPOAuthEnabled returns whether SMTP XOAUTH2 & OAUTHBEARER authentication is configured
+func SMTPOAuthEnabled() bool {
+	return SMTPOAuthTokens != nil || SMTPOAuthJWTKey != nil || SMTPOAuthAcceptAny
+}
+
+// SetSMTPOAuthTokens will set the static SMTP bearer tokens.
+// Tokens are whitespace-separated, and may optionally be bound to a username using `<username>:<token>`.
+// Anything following a `#` is treated as a comment.
+func SetSMTPOAuthTokens(s string) error {
+	tokens := []string{}
+	for line := range strings.Lines(s) {
+		// ignore comments
+		line, _, _ = strings.Cut(line, "#")
+		tokens = append(tokens, credentialsFromString(line)...)
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	SMTPOAuthTokens = make(map[string]string)
+
+	for _, t := range tokens {
+		username := ""
+		if u, token, found := strings.Cut(t, ":"); found {
+			username = u
+			t = token
+		}
+
+		if t == "" {
+			return fmt.Errorf("invalid OAuth token for %q", username)
+		}
+
+		SMTPOAuthTokens[t] = username
+	}
+
+	return nil
+}
+
+// SetSMTPOAuthJWTKey will set the public key used to validate SMTP JWT bearer tokens.
+// The key must be PEM-encoded, either as a PKIX public key, a PKCS #1 RSA public key, or an X.509 certificate.
+func SetSMTPOAuthJWTKey(b []byte) error {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return errors.New("no PEM data found in JWT public key")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		SMTPOAuthJWTKey = key
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		SMTPOAuthJWTKey = key
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		SMTPOAuthJWTKey = cert.PublicKey
+	default:
+		return fmt.Errorf("unsupported PEM type in JWT public key: %s", block.Type)
+	}
+
+	switch SMTPOAuthJWTKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return nil
+	default:
+		SMTPOAuthJWTKey = nil
+		return errors.New("unsupported JWT public key algorithm")
+	}
+}
+
+// ValidateSMTPOAuthToken returns whether the bearer token is valid for the given username.
+// Static tokens are checked first, followed by JWT signature validation.
+func ValidateSMTPOAuthToken(username, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	if SMTPOAuthAcceptAny {
+		return true
+	}
+
+	for t, u := range SMTPOAuthTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return u == "" || u == username
+		}
+	}
+
+	if SMTPOAuthJWTKey != nil {
+		return validateJWT(token, SMTPOAuthJWTKey, username, time.Now()) == nil
+	}
+
+	return false
+}
+
+// jwtClaims are the JWT claims that are validated
+type jwtClaims struct {
+	ExpiresAt *json.Number `json:"exp"`
+	NotBefore *json.Number `json:"nbf"`
+	Subject   string       `json:"sub"`
+	Email     string       `json:"email"`
+}
+
+// validateJWT validates a compact-serialized JWT signature, the exp & nbf claims, and that
+// the sub or email claim matches the username
+func validateJWT(token string, key crypto.PublicKey, username string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("token is not a JWT")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid JWT header: %s", err.Error())
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("invalid JWT header: %s", err.Error())
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("invalid JWT signature: %s", err.Error())
+	}
+
+	if err := verifyJWTSignature(header.Alg, []byte(parts[0]+"."+parts[1]), signature, key); err != nil {
+		return err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid JWT payload: %s", err.Error())
+	}
+
+	var claims jwtClaims
+	d := json.NewDecoder(strings.NewReader(string(payload)))
+	d.UseNumber()
+	if err := d.Decode(&claims); err != nil {
+		return fmt.Errorf("invalid JWT payload: %s", err.Error())
+	}
+
+	if claims.ExpiresAt != nil {
+		exp, err := claims.ExpiresAt.Float64()
+		if err != nil {
+			return errors.New("invalid JWT exp claim")
+		}
+		if now.Unix() >= int64(exp) {
+			return errors.New("JWT has expired")
+		}
+	}
+
+	if claims.NotBefore != nil {
+		nbf, err := claims.NotBefore.Float64()
+		if err != nil {
+			return errors.New("invalid JWT nbf claim")
+		}
+		if now.Unix() < int64(nbf) {
+			return errors.New("JWT is not valid yet")
+		}
+	}
+
+	if username == "" || (claims.Subject != username && !strings.EqualFold(claims.Email, username)) {
+		return fmt.Errorf("JWT is not valid for user %q", username)
+	}
+
+	return nil
+}
+
+// verifyJWTSignature verifies the signature of the signing input for the given JWS algorithm
+func verifyJWTSignature(alg string, signed, signature []byte, key crypto.PublicKey) error {
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, signed, signature) {
+			return errors.New("invalid JWT signature")
+		}
+		return nil
+	}
+
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported JWT algorithm: %q", alg)
+	}
+
+	var h hash.Hash
+	var hashType crypto.Hash
+
+	switch alg[2:] {
+	case "256":
+		h, hashType = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashType = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashType = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported JWT algorithm: %q", alg)
+	}
+
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(k, hashType, digest, signature) != nil {
+			return errors.New("invalid JWT signature")
+		}
+	case "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPSS(k, hashType, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) != nil {
+			return errors.New("invalid JWT signature")
+		}
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return errors.New("invalid JWT signature")
+		}
+		// JWS ECDSA signatures are the concatenation of R & S, not ASN.1
+		r := new(big.Int).SetBytes(signature[:len(signature)/2])
+		s := new(big.Int).SetBytes(signature[len(signature)/2:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid JWT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT algorithm: %q", alg)
+	}
+
+	return nil
+}
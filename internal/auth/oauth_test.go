@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMTPOAuthTokens(t *testing.T) {
+	t.Cleanup(func() { SMTPOAuthTokens = nil })
+
+	if err := SetSMTPOAuthTokens("# comment\nanytoken\nalice:alicetoken"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username string
+		token    string
+		valid    bool
+	}{
+		{"bob", "anytoken", true},
+		{"", "anytoken", true},
+		{"alice", "alicetoken", true},
+		{"bob", "alicetoken", false},
+		{"alice", "wrong", false},
+		{"alice", "", false},
+		{"alice", "comment", false},
+	}
+
+	for _, tt := range tests {
+		if v := ValidateSMTPOAuthToken(tt.username, tt.token); v != tt.valid {
+			t.Errorf("ValidateSMTPOAuthToken(%q, %q) returned %v, want %v", tt.username, tt.token, v, tt.valid)
+		}
+	}
+}
+
+func TestSMTPOAuthJWT(t *testing.T) {
+	t.Cleanup(func() { SMTPOAuthJWTKey = nil })
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetSMTPOAuthJWTKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(payload string) string {
+		signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","typ":"JWT"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(payload))
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	now := time.Now().Unix()
+
+	valid := sign(fmt.Sprintf(`{"sub":"alice","exp":%d}`, now+3600))
+	if !ValidateSMTPOAuthToken("alice", valid) {
+		t.Error("valid JWT was rejected")
+	}
+
+	if ValidateSMTPOAuthToken("bob", valid) {
+		t.Error("JWT was accepted for another user")
+	}
+
+	email := sign(fmt.Sprintf(`{"sub":"1234","email":"alice@example.com","exp":%d}`, now+3600))
+	if !ValidateSMTPOAuthToken("alice@example.com", email) {
+		t.Error("valid JWT email claim was rejected")
+	}
+
+	noUser := sign(fmt.Sprintf(`{"exp":%d}`, now+3600))
+	if ValidateSMTPOAuthToken("alice", noUser) || ValidateSMTPOAuthToken("", noUser) {
+		t.Error("JWT without a sub or email claim was accepted")
+	}
+
+	expired := sign(fmt.Sprintf(`{"sub":"alice","exp":%d}`, now-60))
+	if ValidateSMTPOAuthToken("alice", expired) {
+		t.Error("expired JWT was accepted")
+	}
+
+	notYetValid := sign(fmt.Sprintf(`{"sub":"alice","nbf":%d}`, now+3600))
+	if ValidateSMTPOAuthToken("alice", notYetValid) {
+		t.Error("not-yet-valid JWT was accepted")
+	}
+
+	// replace the payload, keeping the original signature
+	parts := strings.Split(valid, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`))
+	tampered := strings.Join(parts, ".")
+	if ValidateSMTPOAuthToken("alice", tampered) {
+		t.Error("tampered JWT was accepted")
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+	if ValidateSMTPOAuthToken("alice", unsigned) {
+		t.Error("unsigned JWT was accepted")
+	}
+}
@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1" // #nosec - required for SCRAM-SHA-1
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/tg123/go-htpasswd"
+)
+
+const (
+	// scramIterations is the PBKDF2 iteration count used when deriving keys from plaintext passwords
+	scramIterations = 4096
+	// scramSaltLength is the salt length used when deriving keys from plaintext passwords
+	scramSaltLength = 16
+)
+
+var (
+	// SMTPSCRAMCredentials are the salted SCRAM credentials for SMTP authentication
+	SMTPSCRAMCredentials SCRAMCredentials
+	// POP3SCRAMCredentials are the salted SCRAM credentials for POP3 authentication
+	POP3SCRAMCredentials SCRAMCredentials
+
+	// SCRAM hash functions by name
+	scramHashes = map[string]func() hash.Hash{
+		"SHA-1":   sha1.New,
+		"SHA-256": sha256.New,
+	}
+
+	// password parsers for credential files, supporting RFC 5803 SCRAM entries
+	passwdSystems = append([]htpasswd.PasswdParser{acceptSCRAM}, htpasswd.DefaultSystems...)
+
+	// password parsers for hashed (non-plaintext) passwords
+	hashedSystems = []htpasswd.PasswdParser{htpasswd.AcceptMd5, htpasswd.AcceptSha, htpasswd.AcceptBcrypt, htpasswd.AcceptSsha, htpasswd.AcceptCryptSha}
+)
+
+// SCRAMKeys are the salted SCRAM credentials of a user for a single hash function
+type SCRAMKeys struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// SCRAMCredentials are the SCRAM keys per username & hash name (SHA-1 or SHA-256)
+type SCRAMCredentials map[string]map[string]SCRAMKeys
+
+// Lookup returns the SCRAM keys for a username & hash name
+func (c SCRAMCredentials) Lookup(username, hashName string) (SCRAMKeys, bool) {
+	keys, ok := c[username][hashName]
+	return keys, ok
+}
+
+// SCRAMMechanisms returns the SCRAM SASL mechanisms, optionally including the channel-binding (-PLUS) variants
+func SCRAMMechanisms(plus bool) []string {
+	mechs := []string{"SCRAM-SHA-1", "SCRAM-SHA-256"}
+	if plus {
+		mechs = append(mechs, "SCRAM-SHA-1-PLUS", "SCRAM-SHA-256-PLUS")
+	}
+
+	return mechs
+}
+
+// scramCredentialsFromString returns the SCRAM credentials found in a credentials string.
+// RFC 5803 entries (eg: `user:SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>`) are used as-is,
+// SCRAM-SHA-1 & SCRAM-SHA-256 keys are derived from plaintext passwords, and hashed passwords are ignored.
+func scramCredentialsFromString(s string) (SCRAMCredentials, error) {
+	creds := SCRAMCredentials{}
+
+	for _, c := range credentialsFromString(s) {
+		username, secret, found := strings.Cut(c, ":")
+		if !found {
+			continue
+		}
+
+		if strings.HasPrefix(secret, "SCRAM-") {
+			hashName, keys, err := parseSCRAMEntry(secret)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SCRAM credentials for %s: %s", username, err.Error())
+			}
+			if creds[username] == nil {
+				creds[username] = map[string]SCRAMKeys{}
+			}
+			creds[username][hashName] = keys
+			continue
+		}
+
+		if isHashedPassword(secret) {
+			// SCRAM keys cannot be derived from a hashed password
+			continue
+		}
+
+		password := strings.TrimPrefix(secret, "{PLAIN}")
+
+		creds[username] = map[string]SCRAMKeys{}
+		for hashName := range scramHashes {
+			salt := make([]byte, scramSaltLength)
+			if _, err := rand.Read(salt); err != nil {
+				return nil, err
+			}
+
+			keys, err := deriveSCRAMKeys(hashName, password, salt, scramIterations)
+			if err != nil {
+				return nil, err
+			}
+			creds[username][hashName] = keys
+		}
+	}
+
+	if len(creds) == 0 {
+		return nil, nil
+	}
+
+	return creds, nil
+}
+
+// isHashedPassword returns whether the password is in a recognized (non-plaintext) htpasswd hash format
+func isHashedPassword(pw string) bool {
+	for _, p := range hashedSystems {
+		if m, err := p(pw); m != nil || err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseSCRAMEntry parses an RFC 5803 SCRAM credential entry, returning the hash name & keys
+func parseSCRAMEntry(s string) (string, SCRAMKeys, error) {
+	keys := SCRAMKeys{}
+
+	// SCRAM-<hash>$<iterations>:<salt>$<StoredKey>:<ServerKey>
+	parts := strings.Split(s, "$")
+	if len(parts) != 3 {
+		return "", keys, errors.New("expected format SCRAM-<hash>$<iterations>:<salt>$<StoredKey>:<ServerKey>")
+	}
+
+	hashName := strings.TrimPrefix(parts[0], "SCRAM-")
+	h, ok := scramHashes[hashName]
+	if !ok {
+		return "", keys, fmt.Errorf("unsupported hash %s", hashName)
+	}
+
+	iterations, salt, found := strings.Cut(parts[1], ":")
+	if !found {
+		return "", keys, errors.New("missing salt")
+	}
+
+	storedKey, serverKey, found := strings.Cut(parts[2], ":")
+	if !found {
+		return "", keys, errors.New("missing ServerKey")
+	}
+
+	var err error
+	if keys.Iterations, err = strconv.Atoi(iterations); err != nil || keys.Iterations < 1 {
+		return "", keys, errors.New("invalid iteration count")
+	}
+	if keys.Salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return "", keys, errors.New("invalid salt")
+	}
+	if keys.StoredKey, err = base64.StdEncoding.DecodeString(storedKey); err != nil || len(keys.StoredKey) != h().Size() {
+		return "", keys, errors.New("invalid StoredKey")
+	}
+	if keys.ServerKey, err = base64.StdEncoding.DecodeString(serverKey); err != nil || len(keys.ServerKey) != h().Size() {
+		return "", keys, errors.New("invalid ServerKey")
+	}
+
+	return hashName, keys, nil
+}
+
+// deriveSCRAMKeys derives the StoredKey & ServerKey from a plaintext password (RFC 5802 section 3)
+func deriveSCRAMKeys(hashName, password string, salt []byte, iterations int) (SCRAMKeys, error) {
+	h := scramHashes[hashName]
+
+	saltedPassword, err := pbkdf2.Key(h, password, salt, iterations, h().Size())
+	if err != nil {
+		return SCRAMKeys{}, err
+	}
+
+	clientKey := scramHMAC(h, saltedPassword, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+
+	return SCRAMKeys{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  scramHMAC(h, saltedPassword, []byte("Server Key")),
+	}, nil
+}
+
+func scramHMAC(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// scramPasswd is an htpasswd.EncodedPasswd for RFC 5803 SCRAM entries, allowing
+// their use with plaintext authentication mechanisms
+type scramPasswd struct {
+	hashName string
+	keys     SCRAMKeys
+}
+
+func acceptSCRAM(pw string) (htpasswd.EncodedPasswd, error) {
+	if !strings.HasPrefix(pw, "SCRAM-") {
+		return nil, nil
+	}
+
+	hashName, keys, err := parseSCRAMEntry(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scramPasswd{hashName, keys}, nil
+}
+
+// MatchesPassword derives the StoredKey from the password & compares it
+func (p *scramPasswd) MatchesPassword(pw string) bool {
+	keys, err := deriveSCRAMKeys(p.hashName, pw, p.keys.Salt, p.keys.Iterations)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(keys.StoredKey, p.keys.StoredKey) == 1
+}
+
+// SCRAMServer is the server side of a SCRAM (RFC 5802) SASL exchange
+type SCRAMServer struct {
+	hashName       string
+	plus           bool
+	credentials    SCRAMCredentials
+	channelBinding func(cbType string) ([]byte, error)
+
+	step            int
+	gs2Header       string
+	cbType          string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	username        string
+	keys            SCRAMKeys
+	found           bool
+}
+
+// NewSCRAMServer returns a SCRAM server for the given mechanism (eg: SCRAM-SHA-256-PLUS).
+// channelBinding returns the channel binding data for a channel binding type, and may be nil
+// if the connection does not support channel binding (ie: no TLS).
+func NewSCRAMServer(mechanism string, credentials SCRAMCredentials, channelBinding func(cbType string) ([]byte, error)) (*SCRAMServer, error) {
+	s := &SCRAMServer{credentials: credentials, channelBinding: channelBinding}
+
+	name := strings.TrimPrefix(strings.ToUpper(mechanism), "SCRAM-")
+	if n, ok := strings.CutSuffix(name, "-PLUS"); ok {
+		if channelBinding == nil {
+			return nil, errors.New("channel binding is not supported on this connection")
+		}
+		name = n
+		s.plus = true
+	}
+
+	if _, ok := scramHashes[name]; !ok {
+		return nil, fmt.Errorf("unsupported SCRAM mechanism: %s", mechanism)
+	}
+	s.hashName = name
+
+	return s, nil
+}
+
+// Username returns the authenticated username
+func (s *SCRAMServer) Username() string {
+	return s.username
+}
+
+// Next processes a client message and returns the server challenge. When done is true
+// the exchange is complete and successful; an error indicates a failed exchange.
+// If the returned error is an ErrSCRAMAuth, the challenge (server-error) should be sent to the client.
+func (s *SCRAMServer) Next(clientMessage []byte) (challenge []byte, done bool, err error) {
+	switch s.step {
+	case 0:
+		s.step++
+		challenge, err = s.clientFirst(string(clientMessage))
+		return challenge, false, err
+	case 1:
+		s.step++
+		challenge, err = s.clientFinal(string(clientMessage))
+		return challenge, err == nil, err
+	default:
+		return nil, false, errors.New("unexpected SCRAM message")
+	}
+}
+
+// ErrSCRAMAuth is returned when the client proof or channel binding is invalid
+var ErrSCRAMAuth = errors.New("SCRAM authentication failed")
+
+func (s *SCRAMServer) clientFirst(msg string) ([]byte, error) {
+	// gs2-header = gs2-cbind-flag "," [ authzid ] ","
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("invalid SCRAM client-first-message")
+	}
+
+	cbFlag := parts[0]
+	switch {
+	case cbFlag == "n":
+		if s.plus {
+			return nil, errors.New("channel binding is required for -PLUS mechanisms")
+		}
+	case cbFlag == "y":
+		// the client supports channel binding but believes the server does not,
+		// which is a downgrade attack if the server is offering -PLUS (RFC 5802 section 6)
+		if s.channelBinding != nil {
+			return nil, ErrSCRAMAuth
+		}
+	case strings.HasPrefix(cbFlag, "p="):
+		if !s.plus {
+			return nil, errors.New("channel binding is only supported with -PLUS mechanisms")
+		}
+		s.cbType = strings.TrimPrefix(cbFlag, "p=")
+	default:
+		return nil, errors.New("invalid SCRAM channel binding flag")
+	}
+
+	if parts[1] != "" && !strings.HasPrefix(parts[1], "a=") {
+		return nil, errors.New("invalid SCRAM authzid")
+	}
+
+	s.gs2Header = parts[0] + "," + parts[1] + ","
+	s.clientFirstBare = parts[2]
+
+	attrs := strings.Split(s.clientFirstBare, ",")
+	if len(attrs) < 2 || !strings.HasPrefix(attrs[0], "n=") || !strings.HasPrefix(attrs[1], "r=") {
+		return nil, errors.New("invalid SCRAM client-first-message")
+	}
+
+	s.username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(strings.TrimPrefix(attrs[0], "n="))
+	clientNonce := strings.TrimPrefix(attrs[1], "r=")
+	if clientNonce == "" || s.username == "" {
+		return nil, errors.New("invalid SCRAM client-first-message")
+	}
+
+	s.keys, s.found = s.credentials.Lookup(s.username, s.hashName)
+	if !s.found {
+		// continue with a random salt to prevent username enumeration
+		s.keys.Salt = make([]byte, scramSaltLength)
+		_, _ = rand.Read(s.keys.Salt)
+		s.keys.Iterations = scramIterations
+	}
+
+	serverNonce := make([]byte, 18)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+
+	s.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", s.nonce, base64.StdEncoding.EncodeToString(s.keys.Salt), s.keys.Iterations)
+
+	return []byte(s.serverFirst), nil
+}
+
+func (s *SCRAMServer) clientFinal(msg string) ([]byte, error) {
+	withoutProof, proofAttr, found := cutLast(msg, ",p=")
+	if !found {
+		return nil, errors.New("invalid SCRAM client-final-message")
+	}
+
+	attrs := strings.Split(withoutProof, ",")
+	if len(attrs) < 2 || !strings.HasPrefix(attrs[0], "c=") || !strings.HasPrefix(attrs[1], "r=") {
+		return nil, errors.New("invalid SCRAM client-final-message")
+	}
+
+	if strings.TrimPrefix(attrs[1], "r=") != s.nonce {
+		return []byte("e=other-error"), ErrSCRAMAuth
+	}
+
+	cbind, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(attrs[0], "c="))
+	if err != nil {
+		return nil, errors.New("invalid SCRAM channel binding")
+	}
+
+	expected := []byte(s.gs2Header)
+	if s.cbType != "" {
+		data, err := s.channelBinding(s.cbType)
+		if err != nil {
+			return []byte("e=unsupported-channel-binding-type"), ErrSCRAMAuth
+		}
+		expected = append(expected, data...)
+	}
+
+	if !bytes.Equal(cbind, expected) {
+		return []byte("e=channel-bindings-dont-match"), ErrSCRAMAuth
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(proofAttr)
+	if err != nil {
+		return nil, errors.New("invalid SCRAM proof")
+	}
+
+	h := scramHashes[s.hashName]
+	authMessage := []byte(s.clientFirstBare + "," + s.serverFirst + "," + withoutProof)
+
+	// ClientKey = ClientProof XOR HMAC(StoredKey, AuthMessage)
+	clientSignature := scramHMAC(h, s.keys.StoredKey, authMessage)
+	if !s.found || len(proof) != len(clientSignature) {
+		return []byte("e=invalid-proof"), ErrSCRAMAuth
+	}
+
+	clientKey := make([]byte, len(proof))
+	subtle.XORBytes(clientKey, proof, clientSignature)
+
+	storedKey := h()
+	storedKey.Write(clientKey)
+	if subtle.ConstantTimeCompare(storedKey.Sum(nil), s.keys.StoredKey) != 1 {
+		return []byte("e=invalid-proof"), ErrSCRAMAuth
+	}
+
+	serverSignature := scramHMAC(h, s.keys.ServerKey, authMessage)
+
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// TLSChannelBinding returns the channel binding data for a TLS connection.
+// Supported types are tls-exporter (RFC 9266) and tls-unique (RFC 5929).
+func TLSChannelBinding(cs tls.ConnectionState, cbType string) ([]byte, error) {
+	switch cbType {
+	case "tls-exporter":
+		if cs.Version < tls.VersionTLS13 {
+			return nil, errors.New("tls-exporter requires TLS 1.3")
+		}
+		return cs.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+	case "tls-unique":
+		if len(cs.TLSUnique) == 0 {
+			return nil, errors.New("tls-unique is not available")
+		}
+		return cs.TLSUnique, nil
+	default:
+		return nil, fmt.Errorf("unsupported channel binding type: %s", cbType)
+	}
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
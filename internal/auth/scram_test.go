@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scramClientFinal returns the client-final-message for a server-first-message
+func scramClientFinal(t *testing.T, hashName, password, gs2Header, clientFirstBare, serverFirst string, cbData []byte) string {
+	t.Helper()
+
+	attrs := map[string]string{}
+	for a := range strings.SplitSeq(serverFirst, ",") {
+		attrs[a[:1]] = a[2:]
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := scramHashes[hashName]
+	saltedPassword, err := pbkdf2.Key(h, password, salt, scramIterations, h().Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey := scramHMAC(h, saltedPassword, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString(append([]byte(gs2Header), cbData...)) + ",r=" + attrs["r"]
+	clientSignature := scramHMAC(h, storedKey.Sum(nil), []byte(clientFirstBare+","+serverFirst+","+withoutProof))
+
+	proof := make([]byte, len(clientKey))
+	subtle.XORBytes(proof, clientKey, clientSignature)
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+}
+
+// Test vector from RFC 7677 section 3
+func TestSCRAMKeyDerivation(t *testing.T) {
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	keys, err := deriveSCRAMKeys("SHA-256", "pencil", salt, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authMessage := "n=user,r=rOprNGfwEbeRWgbNEkqO," +
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096," +
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+
+	serverSignature := base64.StdEncoding.EncodeToString(scramHMAC(scramHashes["SHA-256"], keys.ServerKey, []byte(authMessage)))
+	if serverSignature != "6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=" {
+		t.Errorf("server signature is %s, want 6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", serverSignature)
+	}
+
+	entry := "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$" +
+		base64.StdEncoding.EncodeToString(keys.StoredKey) + ":" + base64.StdEncoding.EncodeToString(keys.ServerKey)
+
+	m, err := acceptSCRAM(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.MatchesPassword("pencil") {
+		t.Error("SCRAM entry does not match the correct password")
+	}
+	if m.MatchesPassword("crayon") {
+		t.Error("SCRAM entry matches an incorrect password")
+	}
+}
+
+func TestSCRAMServer(t *testing.T) {
+	creds, err := scramCredentialsFromString("user:pencil hashed:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := creds.Lookup("hashed", "SHA-256"); ok {
+		t.Error("SCRAM keys were derived from a hashed password")
+	}
+
+	cbData := []byte("channel-binding-data")
+	channelBinding := func(cbType string) ([]byte, error) {
+		if cbType != "tls-exporter" {
+			return nil, errors.New("unsupported")
+		}
+		return cbData, nil
+	}
+
+	tests := []struct {
+		mechanism string
+		gs2Header string
+		password  string
+		cbData    []byte
+		cb        func(string) ([]byte, error)
+		success   bool
+	}{
+		{"SCRAM-SHA-256", "n,,", "pencil", nil, nil, true},
+		{"SCRAM-SHA-1", "n,,", "pencil", nil, nil, true},
+		{"SCRAM-SHA-256", "n,,", "crayon", nil, nil, false},
+		{"SCRAM-SHA-256-PLUS", "p=tls-exporter,,", "pencil", cbData, channelBinding, true},
+		{"SCRAM-SHA-256-PLUS", "p=tls-exporter,,", "pencil", []byte("wrong"), channelBinding, false},
+		// downgrade: client supports channel binding, server offers it
+		{"SCRAM-SHA-256", "y,,", "pencil", nil, channelBinding, false},
+	}
+
+	for _, tt := range tests {
+		server, err := NewSCRAMServer(tt.mechanism, creds, tt.cb)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.mechanism, err)
+		}
+
+		clientFirstBare := "n=user,r=fyko+d2lbbFgONRv9qkxdawL"
+		serverFirst, _, err := server.Next([]byte(tt.gs2Header + clientFirstBare))
+		if err != nil {
+			if tt.success {
+				t.Errorf("%s %s: unexpected error %s", tt.mechanism, tt.gs2Header, err)
+			}
+			continue
+		}
+
+		clientFinal := scramClientFinal(t, strings.TrimSuffix(strings.TrimPrefix(tt.mechanism, "SCRAM-"), "-PLUS"), tt.password, tt.gs2Header, clientFirstBare, string(serverFirst), tt.cbData)
+		serverFinal, done, err := server.Next([]byte(clientFinal))
+		if tt.success {
+			if err != nil || !done || !strings.HasPrefix(string(serverFinal), "v=") {
+				t.Errorf("%s %s: expected success, got %q %v", tt.mechanism, tt.password, serverFinal, err)
+			}
+			if server.Username() != "user" {
+				t.Errorf("username is %q, want user", server.Username())
+			}
+		} else if !errors.Is(err, ErrSCRAMAuth) || done {
+			t.Errorf("%s %s: expected authentication failure, got %q %v", tt.mechanism, tt.password, serverFinal, err)
+		}
+	}
+
+	// -PLUS mechanisms require channel binding support
+	if _, err := NewSCRAMServer("SCRAM-SHA-256-PLUS", creds, nil); err == nil {
+		t.Error("SCRAM-SHA-256-PLUS was allowed without channel binding")
+	}
+}
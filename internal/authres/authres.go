@@ -0,0 +1,122 @@
+// Package authres evaluates message authentication (SPF, DKIM, DMARC & ARC), returning
+// Authentication-Results (RFC 8601) style reports
+package authres
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+
+	"github.com/axllent/mailpit/internal/dkim"
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+// AuthServID is the authentication service identifier of the Authentication-Results header
+const AuthServID = "mailpit"
+
+// Envelope is the SMTP session of a received message, used to evaluate SPF
+type Envelope struct {
+	// SMTP client IP address
+	ClientIP string `json:"ClientIP,omitempty"`
+	// SMTP HELO / EHLO hostname
+	HELO string `json:"HELO,omitempty"`
+	// SMTP MAIL FROM address
+	MailFrom string `json:"MailFrom,omitempty"`
+}
+
+// Report is the authentication results report of a message
+//
+// swagger:model AuthenticationResultsResponse
+type Report struct {
+	// Authentication-Results header value
+	// example: mailpit; spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com header.s=default; dmarc=pass (p=reject) header.from=example.com; arc=none
+	Header string
+	// SMTP client IP address
+	ClientIP string
+	// SMTP HELO / EHLO hostname
+	HELO string
+	// SMTP MAIL FROM address
+	MailFrom string
+	// SPF result
+	SPF SPFResult
+	// DKIM result
+	DKIM dkim.Report
+	// DMARC result
+	DMARC DMARCResult
+	// ARC result
+	ARC dkim.ARCResult
+}
+
+// Check evaluates the authentication of a raw message & its SMTP envelope using the default resolver
+func Check(msg []byte, e *Envelope) Report {
+	return Evaluate(msg, e, resolver.Default())
+}
+
+// Evaluate the authentication of a raw message, looking up DNS records with the resolver.
+// SPF is evaluated using the SMTP client IP address, HELO hostname & MAIL FROM address of the
+// envelope, and is skipped if the message was not received via SMTP (nil envelope).
+func Evaluate(msg []byte, e *Envelope, r resolver.Resolver) Report {
+	report := Report{}
+
+	var fromDomain string
+	if m, err := mail.ReadMessage(bytes.NewReader(msg)); err == nil {
+		if from, err := mail.ParseAddress(m.Header.Get("From")); err == nil {
+			_, fromDomain, _ = strings.Cut(from.Address, "@")
+		}
+	}
+
+	if e != nil {
+		report.ClientIP = e.ClientIP
+		report.HELO = e.HELO
+		report.MailFrom = e.MailFrom
+		report.SPF = CheckSPF(net.ParseIP(report.ClientIP), report.MailFrom, report.HELO, r)
+	} else {
+		report.SPF = SPFResult{Identity: "mailfrom", Result: "none", Error: "not received via SMTP"}
+	}
+
+	report.DKIM = dkim.Verify(msg, r)
+	report.DMARC = CheckDMARC(fromDomain, report.SPF, report.DKIM, r)
+	report.ARC = dkim.VerifyARC(msg, r)
+	report.Header = report.header()
+
+	return report
+}
+
+// header returns the Authentication-Results header value of the report
+func (report Report) header() string {
+	results := []string{AuthServID}
+
+	spf := "spf=" + report.SPF.Result
+	if report.SPF.Identity == "helo" {
+		spf += " smtp.helo=" + report.HELO
+	} else if report.MailFrom != "" {
+		spf += " smtp.mailfrom=" + report.SPF.Domain
+	}
+	results = append(results, spf)
+
+	if len(report.DKIM.Signatures) == 0 {
+		results = append(results, "dkim=none")
+	}
+	for _, s := range report.DKIM.Signatures {
+		results = append(results, fmt.Sprintf("dkim=%s header.d=%s header.s=%s", s.Result, s.Domain, s.Selector))
+	}
+
+	dmarc := "dmarc=" + report.DMARC.Result
+	if report.DMARC.Policy != "" {
+		dmarc += " (p=" + report.DMARC.Policy + ")"
+	}
+	if report.DMARC.Domain != "" {
+		dmarc += " header.from=" + report.DMARC.Domain
+	}
+	results = append(results, dmarc)
+
+	arc := "arc=" + report.ARC.Result
+	if report.ARC.Instances > 0 {
+		arc += fmt.Sprintf(" (i=%d)", report.ARC.Instances)
+	}
+	results = append(results, arc)
+
+	return strings.Join(results, "; ")
+}
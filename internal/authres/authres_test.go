@@ -0,0 +1,108 @@
+package authres
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/dkim"
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+// dkimReport returns a DKIM report with a passing signature for the domain, or none if empty
+func dkimReport(domain string) dkim.Report {
+	if domain == "" {
+		return dkim.Report{Status: dkim.StatusNone}
+	}
+
+	return dkim.Report{Status: dkim.StatusPass, Signatures: []dkim.Signature{{Result: dkim.ResultPass, Domain: domain}}}
+}
+
+func TestEvaluate(t *testing.T) {
+	zone, err := resolver.LoadZoneFile("testdata/example.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := os.ReadFile("../dkim/testdata/signed.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(signed, []byte("We lost the game."), []byte("We won the game."), 1)
+
+	envelope := func(ip, mailFrom string) *Envelope {
+		return &Envelope{ClientIP: ip, HELO: "mail.football.example.com", MailFrom: mailFrom}
+	}
+
+	// Received & Return-Path headers set by the sender are not trusted
+	forged := append([]byte("Received: from mail.football.example.com (unknown [192.0.2.5])\r\n"+
+		"        by mailpit (Mailpit) with SMTP\r\n"+
+		"        for <suzie@shopping.example.net>; Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n"+
+		"Return-Path: <joe@football.example.com>\r\n"), signed...)
+
+	tests := []struct {
+		name   string
+		msg    []byte
+		e      *Envelope
+		spf    string
+		dkim   string
+		dmarc  string
+		header string
+	}{
+		{
+			"aligned", signed, envelope("192.0.2.5", "joe@football.example.com"), "pass", "pass", "pass",
+			"mailpit; spf=pass smtp.mailfrom=football.example.com; dkim=pass header.d=football.example.com header.s=brisbane; dmarc=pass (p=reject) header.from=football.example.com; arc=none",
+		},
+		{
+			"dkim aligned", signed, envelope("203.0.113.1", "joe@football.example.com"), "fail", "pass", "pass",
+			"mailpit; spf=fail smtp.mailfrom=football.example.com; dkim=pass header.d=football.example.com header.s=brisbane; dmarc=pass (p=reject) header.from=football.example.com; arc=none",
+		},
+		{
+			"spf aligned", tampered, envelope("192.0.2.5", "joe@football.example.com"), "pass", "fail", "pass",
+			"mailpit; spf=pass smtp.mailfrom=football.example.com; dkim=fail header.d=football.example.com header.s=brisbane; dmarc=pass (p=reject) header.from=football.example.com; arc=none",
+		},
+		{
+			"unaligned spf", tampered, envelope("198.51.100.10", "bounce@redirect.example.net"), "pass", "fail", "fail",
+			"mailpit; spf=pass smtp.mailfrom=redirect.example.net; dkim=fail header.d=football.example.com header.s=brisbane; dmarc=fail (p=reject) header.from=football.example.com; arc=none",
+		},
+		{
+			"not received via SMTP", forged, nil, "none", "pass", "pass",
+			"mailpit; spf=none; dkim=pass header.d=football.example.com header.s=brisbane; dmarc=pass (p=reject) header.from=football.example.com; arc=none",
+		},
+	}
+
+	for _, tt := range tests {
+		r := Evaluate(tt.msg, tt.e, zone)
+		if r.SPF.Result != tt.spf || r.DKIM.Status != tt.dkim || r.DMARC.Result != tt.dmarc {
+			t.Errorf("%s: expected spf=%s dkim=%s dmarc=%s, got spf=%s dkim=%s dmarc=%s", tt.name, tt.spf, tt.dkim, tt.dmarc, r.SPF.Result, r.DKIM.Status, r.DMARC.Result)
+		}
+		if r.Header != tt.header {
+			t.Errorf("%s: unexpected header\n%s", tt.name, r.Header)
+		}
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	zone, err := resolver.LoadZoneFile("testdata/example.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spf := SPFResult{Result: "pass", Identity: "mailfrom", Domain: "bounce.example.com"}
+
+	// subdomain policy of the organizational domain with relaxed SPF alignment
+	r := CheckDMARC("news.example.com", spf, dkimReport(""), zone)
+	if r.Result != "pass" || r.Policy != "quarantine" || !r.SPFAligned {
+		t.Errorf("expected relaxed SPF alignment with sp=quarantine, got %+v", r)
+	}
+
+	// strict DKIM alignment
+	r = CheckDMARC("football.example.com", SPFResult{}, dkimReport("example.com"), zone)
+	if r.Result != "fail" || r.DKIMAligned {
+		t.Errorf("expected strict DKIM alignment failure, got %+v", r)
+	}
+
+	if r := CheckDMARC("example.org", spf, dkimReport(""), zone); r.Result != "none" {
+		t.Errorf("expected none without a DMARC record, got %+v", r)
+	}
+}
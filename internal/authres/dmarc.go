@@ -0,0 +1,177 @@
+package authres
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/axllent/mailpit/internal/dkim"
+	"github.com/axllent/mailpit/internal/resolver"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DMARCResult is the DMARC evaluation result of a message
+//
+// swagger:model DMARCResult
+type DMARCResult struct {
+	// DMARC result: none (no policy), pass, fail, temperror or permerror
+	// example: pass
+	Result string
+	// Error or reason for the result
+	Error string
+	// From header domain
+	Domain string
+	// Policy to apply to the message (p= or sp=): none, quarantine or reject
+	// example: reject
+	Policy string
+	// Whether an SPF pass is aligned with the From domain
+	SPFAligned bool
+	// Whether a DKIM pass is aligned with the From domain
+	DKIMAligned bool
+}
+
+// dmarcRecord is a parsed DMARC policy record
+type dmarcRecord struct {
+	policy          string
+	subdomainPolicy string
+	strictSPF       bool
+	strictDKIM      bool
+}
+
+// CheckDMARC evaluates DMARC alignment of the SPF & DKIM results against the From header domain
+func CheckDMARC(fromDomain string, spf SPFResult, d dkim.Report, r resolver.Resolver) DMARCResult {
+	result := DMARCResult{Domain: strings.ToLower(fromDomain)}
+
+	if !validDomain(result.Domain) {
+		result.Result, result.Error = "permerror", "no valid From header domain"
+		return result
+	}
+
+	orgDomain := organizationalDomain(result.Domain)
+
+	record, err := lookupDMARC(result.Domain, r)
+	if errors.Is(err, resolver.ErrNotFound) && orgDomain != result.Domain {
+		record, err = lookupDMARC(orgDomain, r)
+		if err == nil && record.subdomainPolicy != "" {
+			record.policy = record.subdomainPolicy
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, resolver.ErrNotFound) {
+			result.Result, result.Error = "none", "no DMARC record found"
+		} else {
+			var malformed dmarcError
+			if errors.As(err, &malformed) {
+				result.Result = "permerror"
+			} else {
+				result.Result = "temperror"
+			}
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Policy = record.policy
+
+	if spf.Result == "pass" && spf.Identity == "mailfrom" {
+		result.SPFAligned = aligned(spf.Domain, result.Domain, record.strictSPF)
+	}
+
+	for _, s := range d.Signatures {
+		if s.Result == dkim.ResultPass && aligned(s.Domain, result.Domain, record.strictDKIM) {
+			result.DKIMAligned = true
+			break
+		}
+	}
+
+	if result.SPFAligned || result.DKIMAligned {
+		result.Result = "pass"
+	} else {
+		result.Result, result.Error = "fail", "neither SPF nor DKIM are aligned with the From domain"
+	}
+
+	return result
+}
+
+// dmarcError is a malformed DMARC record error
+type dmarcError string
+
+func (e dmarcError) Error() string {
+	return string(e)
+}
+
+// lookupDMARC returns the DMARC record of a domain
+func lookupDMARC(domain string, r resolver.Resolver) (dmarcRecord, error) {
+	record := dmarcRecord{}
+
+	records, err := r.LookupTXT("_dmarc." + domain)
+	if err != nil {
+		return record, err
+	}
+
+	found := []string{}
+	for _, txt := range records {
+		version, _, _ := strings.Cut(txt, ";")
+		if strings.EqualFold(strings.ReplaceAll(strings.TrimSpace(version), " ", ""), "v=DMARC1") {
+			found = append(found, txt)
+		}
+	}
+
+	if len(found) == 0 {
+		return record, resolver.ErrNotFound
+	}
+	if len(found) > 1 {
+		return record, dmarcError("multiple DMARC records found for " + domain)
+	}
+
+	for part := range strings.SplitSeq(found[0], ";") {
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		switch name {
+		case "p":
+			record.policy = value
+		case "sp":
+			record.subdomainPolicy = value
+		case "adkim":
+			record.strictDKIM = value == "s"
+		case "aspf":
+			record.strictSPF = value == "s"
+		}
+	}
+
+	for _, p := range []string{record.policy, record.subdomainPolicy} {
+		if p != "" && p != "none" && p != "quarantine" && p != "reject" {
+			return record, dmarcError(fmt.Sprintf("invalid DMARC policy %q for %s", p, domain))
+		}
+	}
+
+	if record.policy == "" {
+		return record, dmarcError("missing DMARC policy (p=) for " + domain)
+	}
+
+	return record, nil
+}
+
+// aligned returns whether a domain is aligned with the From domain, either exactly (strict) or by
+// organizational domain (relaxed)
+func aligned(domain, fromDomain string, strict bool) bool {
+	domain = strings.ToLower(domain)
+	if domain == fromDomain {
+		return true
+	}
+
+	return !strict && organizationalDomain(domain) == organizationalDomain(fromDomain)
+}
+
+// organizationalDomain returns the organizational domain (public suffix + 1) of a domain
+func organizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+
+	return org
+}
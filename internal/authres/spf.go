@@ -0,0 +1,517 @@
+package authres
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+const (
+	// SPF lookup limit (RFC 7208 section 4.6.4)
+	spfLookupLimit = 10
+	// SPF void lookup limit (RFC 7208 section 4.6.4)
+	spfVoidLookupLimit = 2
+)
+
+// SPFResult is the SPF evaluation result of a message
+//
+// swagger:model SPFResult
+type SPFResult struct {
+	// SPF result: none, neutral, pass, fail, softfail, temperror or permerror
+	// example: pass
+	Result string
+	// Error or reason for the result
+	Error string
+	// Identity checked: mailfrom or helo
+	// example: mailfrom
+	Identity string
+	// Domain checked
+	Domain string
+	// SMTP client IP address
+	ClientIP string
+}
+
+// spfError is an SPF evaluation error with its result (temperror or permerror)
+type spfError struct {
+	result string
+	msg    string
+}
+
+func (e spfError) Error() string {
+	return e.msg
+}
+
+// spfCheck holds the state of an SPF evaluation
+type spfCheck struct {
+	r           resolver.Resolver
+	ip          net.IP
+	sender      string
+	helo        string
+	lookups     int
+	voidLookups int
+}
+
+// CheckSPF evaluates SPF for the client IP, MAIL FROM & HELO. The MAIL FROM identity is used unless
+// it is empty (null reverse-path), in which case the HELO identity is checked.
+func CheckSPF(ip net.IP, mailFrom, helo string, r resolver.Resolver) SPFResult {
+	result := SPFResult{Identity: "mailfrom"}
+
+	if ip == nil {
+		result.Result, result.Error = "none", "no SMTP client IP address"
+		return result
+	}
+	result.ClientIP = ip.String()
+
+	sender := mailFrom
+	if sender == "" {
+		result.Identity = "helo"
+		sender = "postmaster@" + helo
+	} else if !strings.Contains(sender, "@") {
+		sender = "postmaster@" + sender
+	}
+
+	_, domain, _ := strings.Cut(sender, "@")
+	result.Domain = strings.ToLower(domain)
+
+	if !validDomain(result.Domain) {
+		result.Result, result.Error = "none", "no valid domain to check"
+		return result
+	}
+
+	c := &spfCheck{r: r, ip: ip, sender: sender, helo: helo}
+	result.Result, result.Error = c.checkHost(result.Domain)
+
+	return result
+}
+
+// checkHost is the check_host() function (RFC 7208 section 4), returning the result & reason
+func (c *spfCheck) checkHost(domain string) (string, string) {
+	record, err := c.record(domain)
+	if err != nil {
+		return errorResult(err, "none"), err.Error()
+	}
+
+	terms := strings.Fields(record)[1:]
+	redirect := ""
+
+	for _, term := range terms {
+		lower := strings.ToLower(term)
+
+		// modifiers
+		if name, value, found := strings.Cut(lower, "="); found && !strings.ContainsAny(name, ":/") {
+			if name == "redirect" {
+				if redirect != "" {
+					return "permerror", "multiple redirect modifiers"
+				}
+				redirect = term[len(name)+1:]
+			} else if value == "" && name != "exp" {
+				return "permerror", "invalid modifier " + term
+			}
+			continue
+		}
+
+		qualifier := "pass"
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = "fail", term[1:]
+		case '~':
+			qualifier, term = "softfail", term[1:]
+		case '?':
+			qualifier, term = "neutral", term[1:]
+		}
+
+		match, err := c.mechanism(term, domain)
+		if err != nil {
+			return errorResult(err, "permerror"), err.Error()
+		}
+
+		if match {
+			return qualifier, "matched " + term
+		}
+	}
+
+	if redirect != "" {
+		if err := c.countLookup(); err != nil {
+			return "permerror", err.Error()
+		}
+
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return "permerror", err.Error()
+		}
+
+		res, reason := c.checkHost(target)
+		if res == "none" {
+			return "permerror", "redirect domain " + target + " has no SPF record"
+		}
+
+		return res, reason
+	}
+
+	return "neutral", "no mechanism matched"
+}
+
+// errorResult returns the result of an SPF error, or the default result for other errors
+func errorResult(err error, defaultResult string) string {
+	var e spfError
+	if errors.As(err, &e) {
+		return e.result
+	}
+
+	return defaultResult
+}
+
+// record returns the SPF record of a domain
+func (c *spfCheck) record(domain string) (string, error) {
+	records, err := c.r.LookupTXT(domain)
+	if err != nil {
+		if errors.Is(err, resolver.ErrNotFound) {
+			return "", fmt.Errorf("no SPF record found for %s", domain)
+		}
+		return "", spfError{"temperror", fmt.Sprintf("DNS lookup for %s failed: %s", domain, err.Error())}
+	}
+
+	found := []string{}
+	for _, r := range records {
+		l := strings.ToLower(r)
+		if l == "v=spf1" || strings.HasPrefix(l, "v=spf1 ") {
+			found = append(found, r)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no SPF record found for %s", domain)
+	case 1:
+		return found[0], nil
+	default:
+		return "", spfError{"permerror", fmt.Sprintf("multiple SPF records found for %s", domain)}
+	}
+}
+
+// countLookup counts a DNS lookup towards the lookup limit
+func (c *spfCheck) countLookup() error {
+	c.lookups++
+	if c.lookups > spfLookupLimit {
+		return spfError{"permerror", "too many DNS lookups"}
+	}
+
+	return nil
+}
+
+// countVoidLookup counts a lookup returning no records towards the void lookup limit
+func (c *spfCheck) countVoidLookup() error {
+	c.voidLookups++
+	if c.voidLookups > spfVoidLookupLimit {
+		return spfError{"permerror", "too many void DNS lookups"}
+	}
+
+	return nil
+}
+
+// mechanism returns whether a mechanism (without qualifier) matches the client IP
+func (c *spfCheck) mechanism(term, domain string) (bool, error) {
+	name, arg, hasArg := strings.Cut(term, ":")
+	name = strings.ToLower(name)
+
+	// a & mx may have a CIDR length without a domain, eg: a/24
+	cidr := ""
+	if !hasArg {
+		if n, l, found := strings.Cut(name, "/"); found {
+			name, cidr = n, "/"+l
+		}
+	} else if i := strings.Index(arg, "/"); i >= 0 && (name == "a" || name == "mx") {
+		arg, cidr = arg[:i], arg[i:]
+	}
+
+	switch name {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		if !hasArg {
+			return false, spfError{"permerror", name + " requires an address"}
+		}
+		if !strings.Contains(arg, "/") {
+			if name == "ip4" {
+				arg += "/32"
+			} else {
+				arg += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(arg)
+		if err != nil || (name == "ip4") != (network.IP.To4() != nil) {
+			return false, spfError{"permerror", "invalid " + term}
+		}
+		return network.Contains(c.ip), nil
+
+	case "a", "mx", "include", "exists", "ptr":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+
+		target := domain
+		if hasArg {
+			var err error
+			if target, err = c.expand(arg, domain); err != nil {
+				return false, err
+			}
+		} else if name == "include" || name == "exists" {
+			return false, spfError{"permerror", name + " requires a domain"}
+		}
+
+		switch name {
+		case "include":
+			res, reason := c.checkHost(target)
+			switch res {
+			case "pass":
+				return true, nil
+			case "temperror":
+				return false, spfError{"temperror", reason}
+			case "permerror", "none":
+				return false, spfError{"permerror", fmt.Sprintf("include:%s: %s", target, reason)}
+			}
+			return false, nil
+
+		case "exists":
+			ips, err := c.lookupIP(target)
+			return len(ips) > 0, err
+
+		case "ptr":
+			// the ptr mechanism is deprecated (RFC 7208 section 5.5) & not supported
+			return false, nil
+
+		case "a":
+			ips, err := c.lookupIP(target)
+			if err != nil {
+				return false, err
+			}
+			return c.matchIPs(ips, cidr)
+
+		default:
+			hosts, err := c.r.LookupMX(target)
+			if err != nil {
+				if !errors.Is(err, resolver.ErrNotFound) {
+					return false, spfError{"temperror", err.Error()}
+				}
+				return false, c.countVoidLookup()
+			}
+			if len(hosts) > spfLookupLimit {
+				return false, spfError{"permerror", "too many MX records"}
+			}
+			for _, host := range hosts {
+				ips, err := c.lookupIP(host)
+				if err != nil {
+					return false, err
+				}
+				if match, err := c.matchIPs(ips, cidr); match || err != nil {
+					return match, err
+				}
+			}
+			return false, nil
+		}
+	}
+
+	return false, spfError{"permerror", "unknown mechanism " + term}
+}
+
+// lookupIP returns the IP addresses of a domain, counting void lookups
+func (c *spfCheck) lookupIP(domain string) ([]net.IP, error) {
+	ips, err := c.r.LookupIP(domain)
+	if err != nil {
+		if !errors.Is(err, resolver.ErrNotFound) {
+			return nil, spfError{"temperror", err.Error()}
+		}
+		return nil, c.countVoidLookup()
+	}
+
+	return ips, nil
+}
+
+// matchIPs returns whether the client IP is within any of the IP addresses, using the
+// optional dual CIDR length (/<ip4-cidr>[//<ip6-cidr>])
+func (c *spfCheck) matchIPs(ips []net.IP, cidr string) (bool, error) {
+	ip4Len, ip6Len := 32, 128
+	if cidr != "" {
+		v4, v6, _ := strings.Cut(strings.TrimPrefix(cidr, "/"), "//")
+		var err error
+		if strings.HasPrefix(cidr, "//") {
+			v4, v6 = "", strings.TrimPrefix(cidr, "//")
+		}
+		if v4 != "" {
+			if ip4Len, err = strconv.Atoi(v4); err != nil || ip4Len > 32 || ip4Len < 0 {
+				return false, spfError{"permerror", "invalid CIDR length " + cidr}
+			}
+		}
+		if v6 != "" {
+			if ip6Len, err = strconv.Atoi(v6); err != nil || ip6Len > 128 || ip6Len < 0 {
+				return false, spfError{"permerror", "invalid CIDR length " + cidr}
+			}
+		}
+	}
+
+	for _, ip := range ips {
+		var mask net.IPMask
+		if ip.To4() != nil {
+			if c.ip.To4() == nil {
+				continue
+			}
+			mask = net.CIDRMask(ip4Len, 32)
+			if ip.To4().Mask(mask).Equal(c.ip.To4().Mask(mask)) {
+				return true, nil
+			}
+		} else {
+			if c.ip.To4() != nil {
+				continue
+			}
+			mask = net.CIDRMask(ip6Len, 128)
+			if ip.Mask(mask).Equal(c.ip.Mask(mask)) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// expand expands the macros of a domain-spec (RFC 7208 section 7)
+func (c *spfCheck) expand(spec, domain string) (string, error) {
+	var sb strings.Builder
+
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			sb.WriteByte(spec[i])
+			continue
+		}
+
+		if i+1 >= len(spec) {
+			return "", spfError{"permerror", "invalid macro in " + spec}
+		}
+		i++
+
+		switch spec[i] {
+		case '%':
+			sb.WriteByte('%')
+			continue
+		case '_':
+			sb.WriteByte(' ')
+			continue
+		case '-':
+			sb.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", spfError{"permerror", "invalid macro in " + spec}
+		}
+
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 2 {
+			return "", spfError{"permerror", "invalid macro in " + spec}
+		}
+		macro := spec[i+1 : i+end]
+		i += end
+
+		value, err := c.macroValue(macro[0], domain)
+		if err != nil {
+			return "", err
+		}
+
+		// transformers: [digits][r] followed by delimiters
+		rest := macro[1:]
+		digits := ""
+		for len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' {
+			digits += rest[:1]
+			rest = rest[1:]
+		}
+		reverse := false
+		if len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R') {
+			reverse = true
+			rest = rest[1:]
+		}
+		delimiters := "."
+		if rest != "" {
+			if strings.Trim(rest, ".-+,/_=") != "" {
+				return "", spfError{"permerror", "invalid macro delimiter in " + spec}
+			}
+			delimiters = rest
+		}
+
+		parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+		if reverse {
+			for a, b := 0, len(parts)-1; a < b; a, b = a+1, b-1 {
+				parts[a], parts[b] = parts[b], parts[a]
+			}
+		}
+		if digits != "" {
+			n, err := strconv.Atoi(digits)
+			if err != nil || n == 0 {
+				return "", spfError{"permerror", "invalid macro transformer in " + spec}
+			}
+			if n < len(parts) {
+				parts = parts[len(parts)-n:]
+			}
+		}
+
+		sb.WriteString(strings.Join(parts, "."))
+	}
+
+	return strings.TrimSuffix(sb.String(), "."), nil
+}
+
+// macroValue returns the value of a macro letter
+func (c *spfCheck) macroValue(letter byte, domain string) (string, error) {
+	local, senderDomain, _ := strings.Cut(c.sender, "@")
+
+	switch letter | 0x20 {
+	case 's':
+		return c.sender, nil
+	case 'l':
+		return local, nil
+	case 'o':
+		return senderDomain, nil
+	case 'd':
+		return domain, nil
+	case 'i':
+		if ip4 := c.ip.To4(); ip4 != nil {
+			return ip4.String(), nil
+		}
+		// IPv6 addresses are expanded as dot-separated nibbles
+		nibbles := []string{}
+		for _, b := range c.ip.To16() {
+			nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0xf), 16))
+		}
+		return strings.Join(nibbles, "."), nil
+	case 'p':
+		return "unknown", nil
+	case 'v':
+		if c.ip.To4() != nil {
+			return "in-addr", nil
+		}
+		return "ip6", nil
+	case 'h':
+		return c.helo, nil
+	}
+
+	return "", spfError{"permerror", fmt.Sprintf("invalid macro letter %c", letter)}
+}
+
+// validDomain returns whether a domain is a (multi-label) domain name
+func validDomain(domain string) bool {
+	if len(domain) > 253 || !strings.Contains(domain, ".") {
+		return false
+	}
+
+	for label := range strings.SplitSeq(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,50 @@
+package authres
+
+import (
+	"net"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+func TestCheckSPF(t *testing.T) {
+	zone, err := resolver.LoadZoneFile("testdata/example.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip       string
+		mailFrom string
+		helo     string
+		expected string
+	}{
+		{"192.0.2.5", "joe@football.example.com", "", "pass"},
+		{"198.51.100.10", "joe@football.example.com", "", "pass"},
+		{"198.51.100.20", "joe@football.example.com", "", "pass"},
+		{"2001:db8::25", "joe@football.example.com", "", "pass"},
+		{"203.0.113.1", "joe@football.example.com", "", "fail"},
+		{"203.0.113.1", "joe@redirect.example.net", "", "softfail"},
+		{"198.51.100.10", "joe@redirect.example.net", "", "pass"},
+		{"192.0.2.5", "joe@macro.example.net", "", "pass"},
+		{"192.0.2.6", "joe@macro.example.net", "", "fail"},
+		{"198.51.100.99", "joe@cidr.example.net", "", "pass"},
+		{"2001:db8::1", "joe@cidr.example.net", "", "pass"},
+		{"203.0.113.1", "joe@cidr.example.net", "", "fail"},
+		{"192.0.2.5", "joe@loop.example.net", "", "permerror"},
+		{"192.0.2.5", "joe@void.example.net", "", "permerror"},
+		{"192.0.2.5", "joe@multiple.example.net", "", "permerror"},
+		{"192.0.2.5", "joe@nospf.example.org", "", "none"},
+		// null reverse-path uses the HELO identity
+		{"192.0.2.5", "", "football.example.com", "pass"},
+		{"203.0.113.1", "", "football.example.com", "fail"},
+		{"", "joe@football.example.com", "", "none"},
+	}
+
+	for _, tt := range tests {
+		r := CheckSPF(net.ParseIP(tt.ip), tt.mailFrom, tt.helo, zone)
+		if r.Result != tt.expected {
+			t.Errorf("%s from %s: expected %s, got %s (%s)", tt.mailFrom, tt.ip, tt.expected, r.Result, r.Error)
+		}
+	}
+}
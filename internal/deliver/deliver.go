@@ -0,0 +1,194 @@
+// Package deliver handles the local delivery of relayed & forwarded messages to a Maildir, mbox file or command
+package deliver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axllent/mailpit/internal/shortuuid"
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+const (
+	// Maildir delivers each message as a new file in a Maildir
+	Maildir = "maildir"
+	// Mbox appends each message to an mbox file
+	Mbox = "mbox"
+	// Exec pipes each message to a command
+	Exec = "exec"
+)
+
+var (
+	// mboxFromRegexp matches lines requiring quoting in an mbox file (mboxrd)
+	mboxFromRegexp = regexp.MustCompile(`(?m)^(>*From )`)
+
+	// mboxLock prevents concurrent writes to mbox files
+	mboxLock sync.Mutex
+
+	// execTimeout is the maximum duration of a command, after which it is killed
+	execTimeout = 5 * time.Minute
+)
+
+// Backend is a local delivery backend
+type Backend struct {
+	// Type is the backend type: maildir, mbox or exec
+	Type string
+	// Path is the Maildir directory or mbox file
+	Path string
+	// Command is the command & arguments to execute
+	Command []string
+
+	url string
+}
+
+// Parse returns the local delivery backend of a maildir://path, mbox://file or exec://command URL,
+// or nil if the host is not a local delivery URL
+func Parse(host string) (*Backend, error) {
+	scheme, target, ok := strings.Cut(host, "://")
+	if !ok {
+		return nil, nil
+	}
+
+	target = strings.TrimSpace(target)
+	b := &Backend{Type: strings.ToLower(scheme), url: host}
+
+	switch b.Type {
+	case Maildir, Mbox:
+		if target == "" {
+			return nil, fmt.Errorf("%s path not set", b.Type)
+		}
+		b.Path = filepath.Clean(target)
+	case Exec:
+		b.Command = tools.ArgsParser(target)
+		if len(b.Command) == 0 {
+			return nil, fmt.Errorf("%s command not set", b.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported delivery backend: %s", scheme)
+	}
+
+	return b, nil
+}
+
+// String returns the backend URL
+func (b *Backend) String() string {
+	return b.url
+}
+
+// Deliver delivers a message to the backend
+func (b *Backend) Deliver(from string, to []string, msg []byte) error {
+	switch b.Type {
+	case Maildir:
+		return b.maildir(msg)
+	case Mbox:
+		return b.mbox(from, msg)
+	default:
+		return b.exec(from, to, msg)
+	}
+}
+
+// maildir writes the message to the tmp directory of the Maildir, before moving it to new
+func (b *Backend) maildir(msg []byte) error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(b.Path, dir), 0700); err != nil {
+			return fmt.Errorf("error creating Maildir: %s", err.Error())
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	// hostnames may not contain "/" or ":" in Maildir filenames
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+
+	name := fmt.Sprintf("%d.%s.%s", time.Now().Unix(), shortuuid.New(), hostname)
+	tmp := filepath.Join(b.Path, "tmp", name)
+
+	if err := os.WriteFile(tmp, msg, 0600); err != nil {
+		return fmt.Errorf("error writing Maildir message: %s", err.Error())
+	}
+
+	if err := os.Rename(tmp, filepath.Join(b.Path, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error delivering Maildir message: %s", err.Error())
+	}
+
+	return nil
+}
+
+// mbox appends the message to the mbox file, quoting any lines starting with "From " (mboxrd)
+func (b *Backend) mbox(from string, msg []byte) error {
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("From " + from + " " + time.Now().UTC().Format(time.ANSIC) + "\n")
+
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	buf.Write(mboxFromRegexp.ReplaceAll(msg, []byte(">$1")))
+
+	if !bytes.HasSuffix(msg, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	mboxLock.Lock()
+	defer mboxLock.Unlock()
+
+	f, err := os.OpenFile(b.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening mbox file: %s", err.Error())
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing mbox file: %s", err.Error())
+	}
+
+	return f.Close()
+}
+
+// exec pipes the raw message to the command on stdin, with the envelope sender & recipients
+// in the MAILPIT_FROM & MAILPIT_TO (comma-separated) environment variables.
+// The command is killed if it does not complete within the execTimeout.
+func (b *Backend) exec(from string, to []string, msg []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, b.Command[0], b.Command[1:]...) // #nosec
+	// do not wait indefinitely for child processes holding the output open once the command is killed
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Env = append(os.Environ(),
+		"MAILPIT_FROM="+from,
+		"MAILPIT_TO="+strings.Join(to, ","),
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("error executing %s: timed out after %s", b.Command[0], execTimeout)
+		}
+
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return fmt.Errorf("error executing %s: %s: %s", b.Command[0], err.Error(), s)
+		}
+
+		return fmt.Errorf("error executing %s: %s", b.Command[0], err.Error())
+	}
+
+	return nil
+}
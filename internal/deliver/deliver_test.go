@@ -0,0 +1,182 @@
+package deliver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = []byte("From: sender@example.com\r\nTo: recipient@example.com\r\nSubject: Test\r\n\r\nHello\r\nFrom the test\r\n")
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		"smtp.example.com":                   "",
+		"maildir:///var/mail/test":           Maildir,
+		"mbox://relative/mbox":               Mbox,
+		"exec:///usr/bin/script \"a b\" --c": Exec,
+	}
+
+	for host, expected := range tests {
+		b, err := Parse(host)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", host, err)
+			continue
+		}
+
+		if expected == "" {
+			if b != nil {
+				t.Errorf("%s: expected no backend", host)
+			}
+			continue
+		}
+
+		if b == nil || b.Type != expected {
+			t.Errorf("%s: expected %s backend", host, expected)
+			continue
+		}
+
+		if b.String() != host {
+			t.Errorf("%s: unexpected string %s", host, b.String())
+		}
+	}
+
+	b, _ := Parse("exec:///usr/bin/script \"a b\" --c")
+	if strings.Join(b.Command, "|") != "/usr/bin/script|a b|--c" {
+		t.Errorf("unexpected command: %v", b.Command)
+	}
+
+	for _, host := range []string{"maildir://", "exec:// ", "http://example.com"} {
+		if _, err := Parse(host); err == nil {
+			t.Errorf("%s: expected error", host)
+		}
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+
+	b, err := Parse("maildir://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := b.Deliver("sender@example.com", []string{"recipient@example.com"}, testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(files))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != string(testMessage) {
+		t.Errorf("unexpected message: %q", data)
+	}
+
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("expected empty tmp directory, got %d files", len(tmp))
+	}
+}
+
+func TestMbox(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mbox")
+
+	b, err := Parse("mbox://" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := b.Deliver("sender@example.com", []string{"recipient@example.com"}, testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mbox := string(data)
+
+	if n := strings.Count(mbox, "\nFrom sender@example.com "); n != 1 || !strings.HasPrefix(mbox, "From sender@example.com ") {
+		t.Errorf("expected 2 mbox separators: %q", mbox)
+	}
+
+	if strings.Count(mbox, "\n>From the test\n") != 2 {
+		t.Errorf("expected quoted From lines: %q", mbox)
+	}
+
+	if strings.Contains(mbox, "\r") {
+		t.Errorf("expected LF line endings: %q", mbox)
+	}
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+
+	b, err := Parse(`exec://sh -c "cat > ` + out + `; echo $MAILPIT_FROM $MAILPIT_TO >> ` + out + `"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Deliver("sender@example.com", []string{"a@example.com", "b@example.com"}, testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := string(testMessage) + "sender@example.com a@example.com,b@example.com\n"
+	if string(data) != expected {
+		t.Errorf("unexpected output: %q", data)
+	}
+
+	b, err = Parse(`exec://sh -c "echo failed >&2; exit 1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Deliver("", nil, testMessage)
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("expected command error, got %v", err)
+	}
+
+	defaultTimeout := execTimeout
+	execTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { execTimeout = defaultTimeout })
+
+	b, err = Parse(`exec://sh -c "sleep 5"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = b.Deliver("", nil, testMessage)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("expected the command to be killed, took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,261 @@
+package dkim
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+// maxARCInstances is the maximum number of ARC sets (RFC 8617 section 4.2.1)
+const maxARCInstances = 50
+
+// ARCResult is the ARC (Authenticated Received Chain) validation result of a message
+//
+// swagger:model ARCResult
+type ARCResult struct {
+	// Chain validation result: none (no ARC sets), pass or fail
+	// example: pass
+	Result string
+	// Error or reason for a failed result
+	Error string
+	// Number of ARC sets
+	Instances int
+	// Verification result of the latest ARC-Message-Signature
+	MessageSignature *Signature `json:",omitempty"`
+	// ARC-Seal results, latest first
+	Seals []ARCSeal
+}
+
+// ARCSeal is the verification result of a single ARC-Seal header
+//
+// swagger:model ARCSeal
+type ARCSeal struct {
+	// ARC set instance (i=)
+	Instance int
+	// Sealing domain (d=)
+	Domain string
+	// Selector (s=)
+	Selector string
+	// Chain validation status of the sealer (cv=)
+	// example: pass
+	ChainValidation string
+	// Seal result: pass, fail, permerror or temperror
+	// example: pass
+	Result string
+	// Error or reason for a failed result
+	Error string
+}
+
+// arcSet is the header indexes of an ARC set
+type arcSet struct {
+	aar, ams, as int
+}
+
+// VerifyARC validates the ARC chain of a raw message, looking up public keys with the resolver
+func VerifyARC(msg []byte, r resolver.Resolver) ARCResult {
+	result := ARCResult{Result: StatusNone, Seals: []ARCSeal{}}
+
+	headers, body := splitMessage(normalizeLineEndings(msg))
+
+	sets, err := arcSets(headers)
+	if err != nil {
+		result.Result, result.Error = StatusFail, err.Error()
+		return result
+	}
+
+	result.Instances = len(sets)
+	if len(sets) == 0 {
+		return result
+	}
+
+	n := len(sets)
+
+	// chain validation status of each ARC-Seal
+	seals := map[int]map[string]string{}
+	for i := n; i >= 1; i-- {
+		_, value, _ := strings.Cut(headers[sets[i].as].raw, ":")
+		tags, err := parseTags(value)
+		if err != nil {
+			result.Result, result.Error = StatusFail, fmt.Sprintf("ARC-Seal i=%d: %s", i, err.Error())
+			return result
+		}
+		seals[i] = tags
+
+		cv := strings.ToLower(tags["cv"])
+		switch {
+		case i == n && cv == "fail":
+			result.Result, result.Error = StatusFail, fmt.Sprintf("ARC-Seal i=%d: chain marked as failed (cv=fail)", i)
+			return result
+		case i == 1 && cv != "none":
+			result.Result, result.Error = StatusFail, "ARC-Seal i=1: chain validation status must be none"
+			return result
+		case i > 1 && cv != "pass":
+			result.Result, result.Error = StatusFail, fmt.Sprintf("ARC-Seal i=%d: chain validation status must be pass", i)
+			return result
+		}
+	}
+
+	// only the latest ARC-Message-Signature is validated
+	ams := verifyARCMessageSignature(headers, sets[n].ams, body, r)
+	result.MessageSignature = &ams
+	if ams.Result != ResultPass {
+		result.Result, result.Error = StatusFail, fmt.Sprintf("ARC-Message-Signature i=%d: %s", n, ams.Error)
+		return result
+	}
+
+	result.Result = StatusPass
+
+	for i := n; i >= 1; i-- {
+		seal := verifyARCSeal(headers, sets, i, seals[i], r)
+		result.Seals = append(result.Seals, seal)
+
+		if seal.Result != ResultPass && result.Result == StatusPass {
+			result.Result, result.Error = StatusFail, fmt.Sprintf("ARC-Seal i=%d: %s", i, seal.Error)
+		}
+	}
+
+	return result
+}
+
+// arcSets returns the ARC sets of the headers by instance, validating that each set is complete
+func arcSets(headers []header) (map[int]arcSet, error) {
+	sets := map[int]*arcSet{}
+
+	for idx, h := range headers {
+		if !slices.Contains([]string{"arc-authentication-results", "arc-message-signature", "arc-seal"}, h.name) {
+			continue
+		}
+
+		_, value, _ := strings.Cut(h.raw, ":")
+		instance := ""
+		if h.name == "arc-authentication-results" {
+			// i=<n>; <authserv-id>; <results>
+			tag, _, _ := strings.Cut(value, ";")
+			name, v, _ := strings.Cut(tag, "=")
+			if strings.TrimSpace(name) == "i" {
+				instance = strings.TrimSpace(v)
+			}
+		} else {
+			tags, err := parseTags(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", h.name, err.Error())
+			}
+			instance = tags["i"]
+		}
+
+		i, err := strconv.Atoi(instance)
+		if err != nil || i < 1 || i > maxARCInstances {
+			return nil, fmt.Errorf("%s: invalid instance (i=%s)", h.name, instance)
+		}
+
+		if _, ok := sets[i]; !ok {
+			sets[i] = &arcSet{-1, -1, -1}
+		}
+
+		var ptr *int
+		switch h.name {
+		case "arc-authentication-results":
+			ptr = &sets[i].aar
+		case "arc-message-signature":
+			ptr = &sets[i].ams
+		default:
+			ptr = &sets[i].as
+		}
+
+		if *ptr != -1 {
+			return nil, fmt.Errorf("duplicate %s header for i=%d", h.name, i)
+		}
+		*ptr = idx
+	}
+
+	result := map[int]arcSet{}
+	for i := 1; i <= len(sets); i++ {
+		set, ok := sets[i]
+		if !ok {
+			return nil, fmt.Errorf("missing ARC set i=%d", i)
+		}
+		if set.aar == -1 || set.ams == -1 || set.as == -1 {
+			return nil, fmt.Errorf("incomplete ARC set i=%d", i)
+		}
+		result[i] = *set
+	}
+
+	return result, nil
+}
+
+// verifyARCMessageSignature verifies the ARC-Message-Signature header at index i of the headers
+func verifyARCMessageSignature(headers []header, i int, body []byte, r resolver.Resolver) Signature {
+	tags, s, err := parseSignature(headers[i].raw)
+	// the i= tag is the instance, not the identity
+	s.Identity = ""
+	if err != nil {
+		s.Result, s.Error = ResultPermError, err.Error()
+		return s
+	}
+
+	for _, t := range []string{"a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[t]; !ok {
+			s.Result, s.Error = ResultPermError, fmt.Sprintf("missing required tag %s=", t)
+			return s
+		}
+	}
+
+	if !slices.Contains([]string{"rsa-sha256", "rsa-sha1", "ed25519-sha256"}, s.Algorithm) {
+		s.Result, s.Error = ResultPermError, fmt.Sprintf("unsupported algorithm %s", s.Algorithm)
+		return s
+	}
+
+	if slices.ContainsFunc(s.Headers, func(h string) bool { return strings.EqualFold(h, "arc-seal") }) {
+		s.Result, s.Error = ResultPermError, "ARC-Seal header must not be signed"
+		return s
+	}
+
+	verifyMessage(headers, i, body, tags, &s, r)
+
+	return s
+}
+
+// verifyARCSeal verifies the ARC-Seal of instance i, which signs all ARC sets up to & including itself
+func verifyARCSeal(headers []header, sets map[int]arcSet, i int, tags map[string]string, r resolver.Resolver) ARCSeal {
+	seal := ARCSeal{
+		Instance:        i,
+		Domain:          strings.ToLower(tags["d"]),
+		Selector:        tags["s"],
+		ChainValidation: strings.ToLower(tags["cv"]),
+	}
+
+	for _, t := range []string{"a", "b", "d", "s"} {
+		if _, ok := tags[t]; !ok {
+			seal.Result, seal.Error = ResultPermError, fmt.Sprintf("missing required tag %s=", t)
+			return seal
+		}
+	}
+
+	s := Signature{Domain: seal.Domain, Selector: seal.Selector, Algorithm: strings.ToLower(tags["a"])}
+	if !slices.Contains([]string{"rsa-sha256", "rsa-sha1", "ed25519-sha256"}, s.Algorithm) {
+		seal.Result, seal.Error = ResultPermError, fmt.Sprintf("unsupported algorithm %s", s.Algorithm)
+		return seal
+	}
+
+	_, newHash := hashFunc(s.Algorithm)
+	h := newHash()
+	for j := 1; j <= i; j++ {
+		h.Write([]byte(canonicalHeader(headers[sets[j].aar].raw, Relaxed)))
+		h.Write([]byte(canonicalHeader(headers[sets[j].ams].raw, Relaxed)))
+		if j < i {
+			h.Write([]byte(canonicalHeader(headers[sets[j].as].raw, Relaxed)))
+		}
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(removeSignature(headers[sets[i].as].raw), Relaxed), "\r\n")))
+
+	if checkSignature(r, &s, h.Sum(nil), tags["b"]) {
+		seal.Result = ResultPass
+	} else {
+		seal.Result, seal.Error = s.Result, s.Error
+	}
+
+	return seal
+}
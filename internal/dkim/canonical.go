@@ -0,0 +1,151 @@
+package dkim
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Simple canonicalization
+	Simple = "simple"
+	// Relaxed canonicalization
+	Relaxed = "relaxed"
+)
+
+var wspRe = regexp.MustCompile(`[ \t]+`)
+
+// header is a single (unfolded) header field
+type header struct {
+	// lowercase header name
+	name string
+	// raw header including the name & folding, excluding the trailing CRLF
+	raw string
+}
+
+// normalizeLineEndings converts all line endings to CRLF
+func normalizeLineEndings(b []byte) []byte {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+
+	return bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+}
+
+// splitMessage returns the header fields & body of a message with CRLF line endings
+func splitMessage(msg []byte) ([]header, []byte) {
+	var headerBytes, body []byte
+
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		body = msg[2:]
+	} else if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		headerBytes = msg[:i+2]
+		body = msg[i+4:]
+	} else {
+		headerBytes = msg
+	}
+
+	headers := []header{}
+	for line := range strings.Lines(string(headerBytes)) {
+		line = strings.TrimSuffix(line, "\r\n")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1].raw += "\r\n" + line
+			continue
+		}
+
+		name, _, _ := strings.Cut(line, ":")
+		headers = append(headers, header{name: strings.ToLower(strings.TrimRight(name, " \t")), raw: line})
+	}
+
+	return headers, body
+}
+
+// canonicalHeader returns the canonicalized header including the trailing CRLF
+func canonicalHeader(raw, method string) string {
+	if method == Simple {
+		return raw + "\r\n"
+	}
+
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(wspRe.ReplaceAllString(value, " "))
+
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// canonicalBody returns the canonicalized message body
+func canonicalBody(body []byte, method string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	// a trailing CRLF produces an empty last element
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if method == Relaxed {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(wspRe.ReplaceAllString(l, " "), " ")
+		}
+	}
+
+	// ignore all empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if method == Relaxed {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// parseTags parses a DKIM tag=value list
+func parseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+
+	for part := range strings.SplitSeq(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, errMalformed("invalid tag " + strings.TrimSpace(part))
+		}
+
+		name = strings.TrimSpace(name)
+		if _, exists := tags[name]; exists {
+			return nil, errMalformed("duplicate tag " + name)
+		}
+
+		tags[name] = strings.TrimSpace(value)
+	}
+
+	return tags, nil
+}
+
+// stripWhitespace removes all whitespace (including folding) from a value
+func stripWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// removeSignature returns the raw DKIM-Signature header with the b= tag value removed
+func removeSignature(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		tag, _, found := strings.Cut(part, "=")
+		if found && strings.TrimSpace(tag) == "b" {
+			parts[i] = part[:strings.Index(part, "=")+1]
+		}
+	}
+
+	return name + ":" + strings.Join(parts, ";")
+}
@@ -0,0 +1,423 @@
+// Package dkim verifies & signs the DKIM signatures of messages (RFC 6376 & RFC 8463)
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1" // #nosec - rsa-sha1 signatures are still verified
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+const (
+	// StatusPass is returned when at least one signature verifies
+	StatusPass = "pass"
+	// StatusFail is returned when no signatures verify
+	StatusFail = "fail"
+	// StatusNone is returned when a message is not signed
+	StatusNone = "none"
+
+	// ResultPass is a verified signature
+	ResultPass = "pass"
+	// ResultFail is a signature which failed verification
+	ResultFail = "fail"
+	// ResultPermError is a signature which cannot be verified (eg: malformed or no public key)
+	ResultPermError = "permerror"
+	// ResultTempError is a signature which could not be verified due to a temporary (DNS) error
+	ResultTempError = "temperror"
+)
+
+// maxSignatures is the maximum number of signatures verified per message
+const maxSignatures = 20
+
+// Report is the DKIM verification report of a message
+//
+// swagger:model DKIMResponse
+type Report struct {
+	// Overall DKIM status: pass (at least one valid signature), fail or none (not signed)
+	// example: pass
+	Status string
+	// Verification results of each DKIM-Signature header, in header order
+	Signatures []Signature
+}
+
+// Signature is the verification result of a single DKIM-Signature header
+//
+// swagger:model DKIMSignature
+type Signature struct {
+	// Signature result: pass, fail, permerror or temperror
+	// example: pass
+	Result string
+	// Error or reason for a failed result
+	Error string
+	// Signing domain (d=)
+	Domain string
+	// Selector (s=)
+	Selector string
+	// Agent or user identifier (i=)
+	Identity string
+	// Signing algorithm (a=)
+	// example: rsa-sha256
+	Algorithm string
+	// Header canonicalization: simple or relaxed
+	// example: relaxed
+	HeaderCanonicalization string
+	// Body canonicalization: simple or relaxed
+	// example: simple
+	BodyCanonicalization string
+	// Signed header fields (h=)
+	Headers []string
+	// Whether the body hash (bh=) matches the message body
+	BodyHashMatch bool
+	// Whether the public key is flagged as testing (t=y)
+	Testing bool
+}
+
+// malformed signature or key error
+type errMalformed string
+
+func (e errMalformed) Error() string {
+	return string(e)
+}
+
+// Check verifies the DKIM signatures of a raw message using the default resolver
+func Check(msg []byte) Report {
+	return Verify(msg, resolver.Default())
+}
+
+// Verify the DKIM signatures of a raw message, looking up public keys with the resolver
+func Verify(msg []byte, r resolver.Resolver) Report {
+	report := Report{Status: StatusNone, Signatures: []Signature{}}
+
+	headers, body := splitMessage(normalizeLineEndings(msg))
+
+	for i, h := range headers {
+		if h.name != "dkim-signature" {
+			continue
+		}
+
+		if len(report.Signatures) == maxSignatures {
+			break
+		}
+
+		s := verifySignature(headers, i, body, r)
+		report.Signatures = append(report.Signatures, s)
+
+		if s.Result == ResultPass {
+			report.Status = StatusPass
+		} else if report.Status == StatusNone {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+// verifySignature verifies the DKIM-Signature header at index i of the headers
+func verifySignature(headers []header, i int, body []byte, r resolver.Resolver) Signature {
+	tags, s, err := parseSignature(headers[i].raw)
+	if err != nil {
+		s.Result, s.Error = ResultPermError, err.Error()
+		return s
+	}
+
+	if err := validateTags(tags, s); err != nil {
+		s.Result, s.Error = ResultPermError, err.Error()
+		return s
+	}
+
+	verifyMessage(headers, i, body, tags, &s, r)
+
+	return s
+}
+
+// parseSignature parses the tags of a DKIM-Signature (or ARC-Message-Signature) header
+func parseSignature(raw string) (map[string]string, Signature, error) {
+	s := Signature{Headers: []string{}}
+
+	_, value, _ := strings.Cut(raw, ":")
+	tags, err := parseTags(value)
+	if err != nil {
+		return nil, s, err
+	}
+
+	s.Domain = strings.ToLower(tags["d"])
+	s.Selector = tags["s"]
+	s.Identity = tags["i"]
+	s.Algorithm = strings.ToLower(tags["a"])
+	for name := range strings.SplitSeq(tags["h"], ":") {
+		if name = strings.TrimSpace(stripWhitespace(name)); name != "" {
+			s.Headers = append(s.Headers, name)
+		}
+	}
+
+	s.HeaderCanonicalization, s.BodyCanonicalization = Simple, Simple
+	if c, ok := tags["c"]; ok {
+		hc, bc, found := strings.Cut(strings.ToLower(c), "/")
+		s.HeaderCanonicalization = hc
+		if found {
+			s.BodyCanonicalization = bc
+		}
+	}
+
+	return tags, s, nil
+}
+
+// verifyMessage verifies the body hash & signature of the (validated) signature header at index i,
+// setting the result of the signature
+func verifyMessage(headers []header, i int, body []byte, tags map[string]string, s *Signature, r resolver.Resolver) {
+	_, newHash := hashFunc(s.Algorithm)
+
+	// body hash
+	canonical := canonicalBody(body, s.BodyCanonicalization)
+	if l, ok := tags["l"]; ok {
+		length, err := strconv.ParseInt(stripWhitespace(l), 10, 64)
+		if err != nil || length < 0 {
+			s.Result, s.Error = ResultPermError, "invalid body length (l=)"
+			return
+		}
+		if length > int64(len(canonical)) {
+			s.Result, s.Error = ResultPermError, "body length (l=) exceeds the message body"
+			return
+		}
+		canonical = canonical[:length]
+	}
+
+	bh := newHash()
+	bh.Write(canonical)
+	expectedBH, err := base64.StdEncoding.DecodeString(stripWhitespace(tags["bh"]))
+	if err != nil {
+		s.Result, s.Error = ResultPermError, "invalid body hash (bh=) encoding"
+		return
+	}
+	s.BodyHashMatch = bytes.Equal(bh.Sum(nil), expectedBH)
+
+	// header hash
+	hh := newHash()
+	used := map[string]int{}
+	for _, name := range s.Headers {
+		name = strings.ToLower(name)
+		// select the last unused instance of the header, working upwards
+		skip := used[name]
+		for j := len(headers) - 1; j >= 0; j-- {
+			if headers[j].name != name {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			hh.Write([]byte(canonicalHeader(headers[j].raw, s.HeaderCanonicalization)))
+			break
+		}
+		used[name]++
+	}
+	hh.Write([]byte(strings.TrimSuffix(canonicalHeader(removeSignature(headers[i].raw), s.HeaderCanonicalization), "\r\n")))
+
+	if !checkSignature(r, s, hh.Sum(nil), tags["b"]) {
+		return
+	}
+
+	if !s.BodyHashMatch {
+		s.Result, s.Error = ResultFail, "body hash did not verify"
+		return
+	}
+
+	s.Result = ResultPass
+}
+
+// hashFunc returns the hash of a signing algorithm
+func hashFunc(algorithm string) (crypto.Hash, func() hash.Hash) {
+	if strings.HasSuffix(algorithm, "-sha1") {
+		return crypto.SHA1, sha1.New
+	}
+
+	return crypto.SHA256, sha256.New
+}
+
+// checkSignature verifies the signature (b=) of the hashed header data using the public key of the signature.
+// The signature result is set if verification fails.
+func checkSignature(r resolver.Resolver, s *Signature, hashed []byte, b string) bool {
+	sig, err := base64.StdEncoding.DecodeString(stripWhitespace(b))
+	if err != nil {
+		s.Result, s.Error = ResultPermError, "invalid signature (b=) encoding"
+		return false
+	}
+
+	keyType, hashName, _ := strings.Cut(s.Algorithm, "-")
+	key, testing, err := lookupKey(r, *s, keyType, hashName)
+	s.Testing = testing
+	if err != nil {
+		var malformed errMalformed
+		if errors.As(err, &malformed) || errors.Is(err, resolver.ErrNotFound) {
+			s.Result = ResultPermError
+		} else {
+			s.Result = ResultTempError
+		}
+		s.Error = err.Error()
+		return false
+	}
+
+	h, _ := hashFunc(s.Algorithm)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(k, h, hashed, sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, hashed, sig) {
+			err = errors.New("invalid signature")
+		}
+	}
+
+	if err != nil {
+		s.Result, s.Error = ResultFail, "signature did not verify"
+		return false
+	}
+
+	return true
+}
+
+// validateTags validates the required DKIM-Signature tags
+func validateTags(tags map[string]string, s Signature) error {
+	for _, t := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[t]; !ok {
+			return fmt.Errorf("missing required tag %s=", t)
+		}
+	}
+
+	if tags["v"] != "1" {
+		return fmt.Errorf("unsupported version %s", tags["v"])
+	}
+
+	if !slices.Contains([]string{"rsa-sha256", "rsa-sha1", "ed25519-sha256"}, s.Algorithm) {
+		return fmt.Errorf("unsupported algorithm %s", s.Algorithm)
+	}
+
+	for _, c := range []string{s.HeaderCanonicalization, s.BodyCanonicalization} {
+		if c != Simple && c != Relaxed {
+			return fmt.Errorf("unsupported canonicalization %s", tags["c"])
+		}
+	}
+
+	if !slices.ContainsFunc(s.Headers, func(h string) bool { return strings.EqualFold(h, "from") }) {
+		return errors.New("From header is not signed")
+	}
+
+	if q, ok := tags["q"]; ok && !slices.Contains(strings.Split(stripWhitespace(q), ":"), "dns/txt") {
+		return fmt.Errorf("unsupported query method %s", q)
+	}
+
+	if s.Identity != "" {
+		_, domain, _ := strings.Cut(s.Identity, "@")
+		domain = strings.ToLower(domain)
+		if domain != s.Domain && !strings.HasSuffix(domain, "."+s.Domain) {
+			return errors.New("identity (i=) is not within the signing domain")
+		}
+	}
+
+	if x, ok := tags["x"]; ok {
+		expires, err := strconv.ParseInt(stripWhitespace(x), 10, 64)
+		if err != nil {
+			return errors.New("invalid expiration (x=)")
+		}
+		if time.Now().Unix() > expires {
+			return errors.New("signature expired")
+		}
+	}
+
+	return nil
+}
+
+// lookupKey returns the public key of a signature, and whether the key is in testing mode
+func lookupKey(r resolver.Resolver, s Signature, keyType, hashName string) (crypto.PublicKey, bool, error) {
+	name := s.Selector + "._domainkey." + s.Domain
+
+	records, err := r.LookupTXT(name)
+	if err != nil {
+		if errors.Is(err, resolver.ErrNotFound) {
+			return nil, false, fmt.Errorf("no public key found for %s: %w", name, err)
+		}
+		return nil, false, fmt.Errorf("public key lookup for %s failed: %s", name, err.Error())
+	}
+
+	if len(records) == 0 {
+		return nil, false, fmt.Errorf("no public key found for %s: %w", name, resolver.ErrNotFound)
+	}
+
+	tags, err := parseTags(records[0])
+	if err != nil {
+		return nil, false, err
+	}
+
+	testing := false
+	for flag := range strings.SplitSeq(tags["t"], ":") {
+		switch strings.TrimSpace(flag) {
+		case "y":
+			testing = true
+		case "s":
+			if _, domain, _ := strings.Cut(s.Identity, "@"); s.Identity != "" && !strings.EqualFold(domain, s.Domain) {
+				return nil, testing, errMalformed("key does not permit identity (i=) subdomains")
+			}
+		}
+	}
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, testing, errMalformed("unsupported key version " + v)
+	}
+
+	if h, ok := tags["h"]; ok && !slices.Contains(strings.Split(stripWhitespace(h), ":"), hashName) {
+		return nil, testing, errMalformed("key does not permit hash algorithm " + hashName)
+	}
+
+	k := "rsa"
+	if v, ok := tags["k"]; ok {
+		k = strings.ToLower(v)
+	}
+	if k != keyType {
+		return nil, testing, errMalformed("key type " + k + " does not match the signature algorithm")
+	}
+
+	p := stripWhitespace(tags["p"])
+	if p == "" {
+		return nil, testing, errMalformed("public key has been revoked")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, testing, errMalformed("invalid public key encoding")
+	}
+
+	if keyType == "ed25519" {
+		if len(der) != ed25519.PublicKeySize {
+			return nil, testing, errMalformed("invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(der), testing, nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		// some keys are published as a PKCS#1 RSA public key
+		if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+			return rsaKey, testing, nil
+		}
+		return nil, testing, errMalformed("invalid public key")
+	}
+
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, testing, errMalformed("public key is not an RSA key")
+	}
+
+	return rsaKey, testing, nil
+}
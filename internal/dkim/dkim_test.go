@@ -0,0 +1,259 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+var testMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: recipient@example.net\r\n" +
+	"Subject:  Test   message \r\n" +
+	"\r\n" +
+	"Hello  world \r\n" +
+	"\r\n\r\n"
+
+// signRSA returns the message with an rsa-sha256 DKIM-Signature header prepended
+func signRSA(t *testing.T, key *rsa.PrivateKey, msg, c string) string {
+	t.Helper()
+
+	headerC, bodyC, _ := strings.Cut(c, "/")
+	headers, body := splitMessage([]byte(msg))
+
+	bh := sha256.Sum256(canonicalBody(body, bodyC))
+	sigHeader := "DKIM-Signature: v=1; a=rsa-sha256; c=" + c + "; d=example.com; s=test;\r\n" +
+		" h=from:subject; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+
+	h := sha256.New()
+	for _, name := range []string{"from", "subject"} {
+		for _, hdr := range headers {
+			if hdr.name == name {
+				h.Write([]byte(canonicalHeader(hdr.raw, headerC)))
+			}
+		}
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(sigHeader, headerC), "\r\n")))
+
+	return sigHeader + rsaSign(t, key, h.Sum(nil)) + "\r\n" + msg
+}
+
+// rsaSign returns the base64-encoded rsa-sha256 signature of the hashed data
+func rsaSign(t *testing.T, key *rsa.PrivateKey, hashed []byte) string {
+	t.Helper()
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// sealARC returns the message with a new ARC set prepended
+func sealARC(t *testing.T, key *rsa.PrivateKey, msg string, instance int, cv string) string {
+	t.Helper()
+
+	headers, body := splitMessage([]byte(msg))
+	i := strconv.Itoa(instance)
+
+	aar := "ARC-Authentication-Results: i=" + i + "; mx.example.org; spf=pass smtp.mailfrom=example.com"
+
+	bh := sha256.Sum256(canonicalBody(body, Relaxed))
+	ams := "ARC-Message-Signature: i=" + i + "; a=rsa-sha256; c=relaxed/relaxed; d=example.org;\r\n" +
+		" s=arc; h=from:subject; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+	h := sha256.New()
+	for _, name := range []string{"from", "subject"} {
+		for j := len(headers) - 1; j >= 0; j-- {
+			if headers[j].name == name {
+				h.Write([]byte(canonicalHeader(headers[j].raw, Relaxed)))
+				break
+			}
+		}
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(ams, Relaxed), "\r\n")))
+	ams += rsaSign(t, key, h.Sum(nil))
+
+	// the seal signs all previous ARC sets (oldest first) followed by the new set
+	sets, err := arcSets(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := "ARC-Seal: i=" + i + "; a=rsa-sha256; cv=" + cv + "; d=example.org; s=arc; b="
+	h = sha256.New()
+	for j := 1; j < instance; j++ {
+		for _, idx := range []int{sets[j].aar, sets[j].ams, sets[j].as} {
+			h.Write([]byte(canonicalHeader(headers[idx].raw, Relaxed)))
+		}
+	}
+	h.Write([]byte(canonicalHeader(aar, Relaxed)))
+	h.Write([]byte(canonicalHeader(ams, Relaxed)))
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(seal, Relaxed), "\r\n")))
+	seal += rsaSign(t, key, h.Sum(nil))
+
+	return seal + "\r\n" + ams + "\r\n" + aar + "\r\n" + msg
+}
+
+func TestVerifyRFC8463(t *testing.T) {
+	zone, err := resolver.LoadZoneFile("testdata/football.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := os.ReadFile("testdata/signed.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Verify(msg, zone)
+	if report.Status != StatusPass || len(report.Signatures) != 1 {
+		t.Fatalf("expected a single passing signature, got %+v", report)
+	}
+
+	s := report.Signatures[0]
+	if s.Domain != "football.example.com" || s.Selector != "brisbane" || s.Algorithm != "ed25519-sha256" ||
+		s.HeaderCanonicalization != Relaxed || s.BodyCanonicalization != Relaxed || !s.BodyHashMatch || len(s.Headers) != 8 {
+		t.Errorf("unexpected signature result %+v", s)
+	}
+
+	// LF line endings are verified the same as CRLF
+	if r := Verify(bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n")), zone); r.Status != StatusPass {
+		t.Errorf("expected pass with LF line endings, got %+v", r)
+	}
+
+	tampered := bytes.Replace(msg, []byte("We lost the game."), []byte("We won the game."), 1)
+	report = Verify(tampered, zone)
+	if report.Status != StatusFail || report.Signatures[0].BodyHashMatch || report.Signatures[0].Result != ResultFail {
+		t.Errorf("expected body hash failure, got %+v", report)
+	}
+
+	// no public key
+	report = Verify(msg, resolver.StaticKeys{})
+	if report.Status != StatusFail || report.Signatures[0].Result != ResultPermError {
+		t.Errorf("expected permerror without a public key, got %+v", report)
+	}
+}
+
+func TestVerifyRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := resolver.KeyRecord(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := resolver.StaticKeys{"test._domainkey.example.com": record}
+
+	for _, c := range []string{"simple/simple", "relaxed/relaxed", "relaxed/simple", "simple/relaxed"} {
+		signed := signRSA(t, key, testMessage, c)
+
+		if r := Verify([]byte(signed), keys); r.Status != StatusPass {
+			t.Errorf("%s: expected pass, got %+v", c, r)
+		}
+
+		// whitespace changes to signed headers
+		changed := strings.Replace(signed, "Subject:  Test   message", "Subject: Test message", 1)
+		r := Verify([]byte(changed), keys)
+		if strings.HasPrefix(c, Relaxed) && r.Status != StatusPass {
+			t.Errorf("%s: expected pass with header whitespace changes, got %+v", c, r)
+		} else if strings.HasPrefix(c, Simple) && (r.Status != StatusFail || !r.Signatures[0].BodyHashMatch) {
+			t.Errorf("%s: expected header signature failure, got %+v", c, r)
+		}
+
+		// trailing empty lines are ignored by both body canonicalizations
+		if r := Verify([]byte(signed+"\r\n\r\n"), keys); r.Status != StatusPass {
+			t.Errorf("%s: expected pass with trailing empty lines, got %+v", c, r)
+		}
+	}
+
+	// unsigned header changes do not affect the signature
+	signed := signRSA(t, key, testMessage, "relaxed/relaxed")
+	if r := Verify([]byte(strings.Replace(signed, "recipient@example.net", "other@example.net", 1)), keys); r.Status != StatusPass {
+		t.Errorf("expected pass with unsigned header changes, got %+v", r)
+	}
+
+	// a prepended (unsigned) From header is selected from the bottom up, so is not verified
+	if r := Verify([]byte("From: attacker@example.org\r\n"+signed), keys); r.Status != StatusPass {
+		t.Errorf("expected the last From header to be verified, got %+v", r)
+	}
+	if r := Verify([]byte(strings.Replace(signed, "\r\n\r\n", "\r\nFrom: attacker@example.org\r\n\r\n", 1)), keys); r.Status != StatusFail {
+		t.Errorf("expected an appended From header to fail, got %+v", r)
+	}
+}
+
+func TestVerifyNone(t *testing.T) {
+	r := Verify([]byte(testMessage), resolver.StaticKeys{})
+	if r.Status != StatusNone || len(r.Signatures) != 0 {
+		t.Errorf("expected none, got %+v", r)
+	}
+}
+
+func TestVerifyARC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := resolver.StaticKeys{"arc._domainkey.example.org": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+
+	if r := VerifyARC([]byte(testMessage), keys); r.Result != StatusNone || r.Instances != 0 {
+		t.Errorf("expected none, got %+v", r)
+	}
+
+	one := sealARC(t, key, testMessage, 1, "none")
+	if r := VerifyARC([]byte(one), keys); r.Result != StatusPass || r.Instances != 1 || len(r.Seals) != 1 {
+		t.Errorf("expected pass with 1 instance, got %+v", r)
+	}
+
+	two := sealARC(t, key, one, 2, "pass")
+	r := VerifyARC([]byte(two), keys)
+	if r.Result != StatusPass || r.Instances != 2 || len(r.Seals) != 2 || r.Seals[0].Instance != 2 {
+		t.Errorf("expected pass with 2 instances, got %+v", r)
+	}
+
+	// changes to the message after the latest set fail the message signature
+	if r := VerifyARC([]byte(strings.Replace(two, "Test   message", "Changed", 1)), keys); r.Result != StatusFail || r.MessageSignature.Result != ResultFail {
+		t.Errorf("expected fail with a modified message, got %+v", r)
+	}
+
+	// a modified earlier set breaks the seal
+	if r := VerifyARC([]byte(strings.Replace(two, "i=1; mx.example.org", "i=1; other.example.org", 1)), keys); r.Result != StatusFail || r.Seals[0].Result != ResultFail {
+		t.Errorf("expected fail with a modified ARC set, got %+v", r)
+	}
+
+	tests := map[string]string{
+		"invalid first cv":  sealARC(t, key, testMessage, 1, "pass"),
+		"failed chain":      sealARC(t, key, one, 2, "fail"),
+		"missing instance":  sealARC(t, key, testMessage, 2, "pass"),
+		"incomplete set":    strings.Replace(one, "ARC-Authentication-Results:", "X-Authentication-Results:", 1),
+		"no public key":     strings.ReplaceAll(two, "d=example.org", "d=example.net"),
+		"duplicate headers": "ARC-Seal: i=1; a=rsa-sha256; cv=none; d=example.org; s=arc; b=\r\n" + one,
+	}
+
+	for name, msg := range tests {
+		if r := VerifyARC([]byte(msg), keys); r.Result != StatusFail {
+			t.Errorf("%s: expected fail, got %+v", name, r)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSignedHeaders are the header fields signed when none are configured.
+// Only header fields present in the message are signed, with the exception of From which is always signed.
+var DefaultSignedHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// Signer adds DKIM signatures to messages
+type Signer struct {
+	domain      string
+	selector    string
+	headers     []string
+	headerCanon string
+	bodyCanon   string
+	algorithm   string
+	key         crypto.Signer
+	hash        crypto.Hash
+}
+
+// NewSigner returns a DKIM signer using the PEM-encoded (RSA or Ed25519) private key file.
+// Headers default to DefaultSignedHeaders, and canonicalization (header/body) to relaxed/relaxed.
+func NewSigner(domain, selector, keyFile string, headers []string, canonicalization string) (*Signer, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("domain & selector are required")
+	}
+
+	data, err := os.ReadFile(filepath.Clean(keyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Signer{
+		domain:   strings.ToLower(domain),
+		selector: selector,
+		key:      key,
+	}
+
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		// Ed25519 signs the SHA-256 hash using PureEdDSA (RFC 8463)
+		s.algorithm, s.hash = "ed25519-sha256", crypto.Hash(0)
+	} else {
+		s.algorithm, s.hash = "rsa-sha256", crypto.SHA256
+	}
+
+	if len(headers) == 0 {
+		headers = DefaultSignedHeaders
+	}
+	for _, h := range headers {
+		if h = strings.TrimSpace(h); h != "" {
+			s.headers = append(s.headers, h)
+		}
+	}
+	if !slices.ContainsFunc(s.headers, func(h string) bool { return strings.EqualFold(h, "from") }) {
+		s.headers = append([]string{"From"}, s.headers...)
+	}
+	if slices.ContainsFunc(s.headers, func(h string) bool { return strings.EqualFold(h, "dkim-signature") }) {
+		return nil, errors.New("the DKIM-Signature header cannot be signed")
+	}
+
+	if canonicalization == "" {
+		canonicalization = Relaxed + "/" + Relaxed
+	}
+	hc, bc, found := strings.Cut(strings.ToLower(canonicalization), "/")
+	if !found {
+		bc = Simple
+	}
+	for _, c := range []string{hc, bc} {
+		if c != Simple && c != Relaxed {
+			return nil, fmt.Errorf("invalid canonicalization %q, expected simple or relaxed", c)
+		}
+	}
+	s.headerCanon, s.bodyCanon = hc, bc
+
+	return s, nil
+}
+
+// parsePrivateKey parses a PEM-encoded PKCS#1 or PKCS#8 RSA, or PKCS#8 Ed25519 private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %s", err.Error())
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key type %T, expected RSA or Ed25519", key)
+}
+
+// Domain returns the signing domain (d=)
+func (s *Signer) Domain() string {
+	return s.domain
+}
+
+// Sign returns the message (with CRLF line endings) with a DKIM-Signature header prepended
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+	msg = normalizeLineEndings(msg)
+	headers, body := splitMessage(msg)
+
+	_, newHash := hashFunc(s.algorithm)
+	bh := newHash()
+	bh.Write(canonicalBody(body, s.bodyCanon))
+
+	// only sign header fields present in the message (From is always signed)
+	signed := []string{}
+	for _, name := range s.headers {
+		if strings.EqualFold(name, "from") || slices.ContainsFunc(headers, func(h header) bool {
+			return h.name == strings.ToLower(name)
+		}) {
+			signed = append(signed, name)
+		}
+	}
+
+	sigHeader := "DKIM-Signature: v=1; a=" + s.algorithm + "; c=" + s.headerCanon + "/" + s.bodyCanon +
+		"; d=" + s.domain + "; s=" + s.selector + ";\r\n" +
+		"\tt=" + strconv.FormatInt(time.Now().Unix(), 10) + "; h=" + foldList(signed, ":") + ";\r\n" +
+		"\tbh=" + base64.StdEncoding.EncodeToString(bh.Sum(nil)) + ";\r\n" +
+		"\tb="
+
+	// header hash, selecting the last unused instance of each header working upwards
+	hh := newHash()
+	used := map[string]int{}
+	for _, name := range signed {
+		name = strings.ToLower(name)
+		skip := used[name]
+		for j := len(headers) - 1; j >= 0; j-- {
+			if headers[j].name != name {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			hh.Write([]byte(canonicalHeader(headers[j].raw, s.headerCanon)))
+			break
+		}
+		used[name]++
+	}
+	hh.Write([]byte(strings.TrimSuffix(canonicalHeader(sigHeader, s.headerCanon), "\r\n")))
+
+	sig, err := s.key.Sign(rand.Reader, hh.Sum(nil), s.hash)
+	if err != nil {
+		return nil, fmt.Errorf("error signing message: %s", err.Error())
+	}
+
+	b := base64.StdEncoding.EncodeToString(sig)
+	folded := []string{}
+	for len(b) > 72 {
+		folded = append(folded, b[:72])
+		b = b[72:]
+	}
+	folded = append(folded, b)
+
+	return append([]byte(sigHeader+strings.Join(folded, "\r\n\t")+"\r\n"), msg...), nil
+}
+
+// foldList joins values with the separator, folding lines longer than 72 characters
+func foldList(values []string, sep string) string {
+	var sb strings.Builder
+	lineLength := 0
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteString(sep)
+			lineLength += len(sep)
+			if lineLength+len(v) > 72 {
+				sb.WriteString("\r\n\t")
+				lineLength = 0
+			}
+		}
+		sb.WriteString(v)
+		lineLength += len(v)
+	}
+
+	return sb.String()
+}
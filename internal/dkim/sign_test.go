@@ -0,0 +1,116 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/resolver"
+)
+
+// writeKey writes the PEM-encoded private key to a temporary file, returning the file path
+func writeKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "private.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestSignRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := resolver.StaticKeys{"relay._domainkey.example.com": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+
+	for _, c := range []string{"", "simple/simple", "relaxed/simple", "simple/relaxed", "relaxed"} {
+		signer, err := NewSigner("example.com", "relay", keyFile, nil, c)
+		if err != nil {
+			t.Fatalf("%q: %s", c, err)
+		}
+
+		signed, err := signer.Sign([]byte(strings.ReplaceAll(testMessage, "\r\n", "\n")))
+		if err != nil {
+			t.Fatalf("%q: %s", c, err)
+		}
+
+		r := Verify(signed, keys)
+		if r.Status != StatusPass || r.Signatures[0].Algorithm != "rsa-sha256" {
+			t.Errorf("%q: expected pass, got %+v", c, r)
+			continue
+		}
+
+		// only headers present in the message are signed
+		if got := strings.Join(r.Signatures[0].Headers, ":"); got != "From:Subject:To" {
+			t.Errorf("%q: expected signed headers From:Subject:To, got %s", c, got)
+		}
+
+		if r := Verify([]byte(strings.Replace(string(signed), "Hello", "Goodbye", 1)), keys); r.Status != StatusFail {
+			t.Errorf("%q: expected a modified body to fail, got %+v", c, r)
+		}
+	}
+
+	// From is always signed, even if not configured
+	signer, err := NewSigner("example.com", "relay", keyFile, []string{"Subject"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign([]byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := Verify(signed, keys); r.Status != StatusPass || strings.Join(r.Signatures[0].Headers, ":") != "From:Subject" {
+		t.Errorf("expected From:Subject to be signed, got %+v", r)
+	}
+
+	if _, err := NewSigner("example.com", "relay", keyFile, nil, "relaxed/strict"); err == nil {
+		t.Error("expected an invalid canonicalization error")
+	}
+	if _, err := NewSigner("example.com", "relay", keyFile, []string{"DKIM-Signature"}, ""); err == nil {
+		t.Error("expected an error signing the DKIM-Signature header")
+	}
+}
+
+func TestSignEd25519(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString("nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A=")
+	der, err := x509.MarshalPKCS8PrivateKey(ed25519.NewKeyFromSeed(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSigner("football.example.com", "brisbane", writeKey(t, "PRIVATE KEY", der), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signer.Sign([]byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zone, err := resolver.LoadZoneFile("testdata/football.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := Verify(signed, zone); r.Status != StatusPass || r.Signatures[0].Algorithm != "ed25519-sha256" {
+		t.Errorf("expected pass, got %+v", r)
+	}
+}
@@ -0,0 +1,364 @@
+// Package pgp verifies the signatures of, and decrypts OpenPGP/MIME messages (RFC 3156)
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgpErrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+const (
+	// StatusPass is returned when all signatures are valid
+	StatusPass = "pass"
+	// StatusFail is returned when a signature is invalid or the public key is unknown
+	StatusFail = "fail"
+	// StatusNone is returned when a message is not signed
+	StatusNone = "none"
+
+	// maxLayers is the maximum number of nested OpenPGP/MIME layers (eg: signed then encrypted) processed
+	maxLayers = 5
+)
+
+var (
+	// ErrNotPGP is returned when a message is not an OpenPGP/MIME encrypted message
+	ErrNotPGP = errors.New("not an OpenPGP/MIME encrypted message")
+
+	// keyring contains the public keys used to verify signatures, and the private keys used for decryption
+	keyring openpgp.EntityList
+
+	// hasPrivateKeys is whether the keyring contains private keys
+	hasPrivateKeys bool
+)
+
+// Report is the OpenPGP/MIME report of a message
+//
+// swagger:model PGPResponse
+type Report struct {
+	// Whether the message is encrypted (multipart/encrypted)
+	Encrypted bool
+	// Whether the encrypted message was decrypted using a configured private key
+	Decrypted bool
+	// Whether the message (or decrypted message) is signed
+	Signed bool
+	// Signature status: pass (all signatures valid), fail or none (not signed)
+	// example: pass
+	Status string
+	// Error processing the message, eg: decryption failure
+	Error string
+	// Verification results of each signature
+	Signers []Signer
+}
+
+// Signer is the verification result of a single signature
+//
+// swagger:model PGPSigner
+type Signer struct {
+	// Whether the signature is valid using a public key from the keyring
+	Valid bool
+	// Error or reason for an invalid signature
+	Error string
+	// Issuer key ID (hex)
+	// example: 8E9DD824780BF973
+	KeyID string
+	// Public key fingerprint (hex), if found in the keyring
+	Fingerprint string
+	// User IDs of the public key, if found in the keyring
+	UserIDs []string
+	// Signature creation time
+	SigningTime *time.Time `json:",omitempty"`
+}
+
+// Load the public keyring used to verify signatures, and the private keys used to decrypt messages.
+// Both files may be ASCII armored or binary, and private keys must not be passphrase protected.
+func Load(keyringFile, keysFile string) error {
+	keyring, hasPrivateKeys = nil, false
+
+	if keyringFile != "" {
+		entities, err := readKeyFile(keyringFile)
+		if err != nil {
+			return err
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	if keysFile != "" {
+		entities, err := readKeyFile(keysFile)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entities {
+			if e.PrivateKey == nil {
+				continue
+			}
+			if e.PrivateKey.Encrypted {
+				return fmt.Errorf("%s: private key %s is passphrase protected", keysFile, e.PrimaryKey.KeyIdString())
+			}
+			for _, s := range e.Subkeys {
+				if s.PrivateKey != nil && s.PrivateKey.Encrypted {
+					return fmt.Errorf("%s: private key %s is passphrase protected", keysFile, s.PublicKey.KeyIdString())
+				}
+			}
+			hasPrivateKeys = true
+		}
+
+		if !hasPrivateKeys {
+			return fmt.Errorf("%s: no private keys found", keysFile)
+		}
+
+		keyring = append(keyring, entities...)
+	}
+
+	return nil
+}
+
+// readKeyFile reads an ASCII armored or binary keyring
+func readKeyFile(file string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err.Error())
+	}
+
+	return entities, nil
+}
+
+// Check verifies the OpenPGP/MIME signatures of a raw message, decrypting it first if encrypted
+func Check(msg []byte) Report {
+	report := Report{Status: StatusNone, Signers: []Signer{}}
+
+	e, err := tools.ParseMIMEEntity(msg)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	for range maxLayers {
+		mediaType, params, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+
+		var content []byte
+		switch {
+		case mediaType == "multipart/signed" && strings.EqualFold(params["protocol"], "application/pgp-signature"):
+			report.Signed = true
+			content, err = report.verifyDetached(e.Body, params["boundary"])
+		case mediaType == "multipart/encrypted" && strings.EqualFold(params["protocol"], "application/pgp-encrypted"):
+			report.Encrypted = true
+			content, err = decrypt(e.Body, params["boundary"], &report)
+			report.Decrypted = err == nil
+		default:
+			return report
+		}
+
+		if err != nil {
+			report.Error = err.Error()
+			if report.Signed {
+				report.Status = StatusFail
+			}
+			return report
+		}
+
+		// the content may itself be an OpenPGP/MIME entity, eg: signed then encrypted
+		if e, err = tools.ParseMIMEEntity(content); err != nil {
+			return report
+		}
+	}
+
+	return report
+}
+
+// Unwrap returns the message with its OpenPGP/MIME encrypted content decrypted, so it can be parsed
+// as a regular message. Signatures (multipart/signed) are left intact.
+func Unwrap(msg []byte) ([]byte, error) {
+	outer, err := tools.ParseMIMEEntity(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := outer
+	unwrapped := false
+	for range maxLayers {
+		mediaType, params, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+		if mediaType != "multipart/encrypted" || !strings.EqualFold(params["protocol"], "application/pgp-encrypted") {
+			break
+		}
+
+		content, err := decrypt(e.Body, params["boundary"], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if e, err = tools.ParseMIMEEntity(content); err != nil {
+			return nil, err
+		}
+		unwrapped = true
+	}
+
+	if !unwrapped {
+		return nil, ErrNotPGP
+	}
+
+	return outer.ReplaceContent(e), nil
+}
+
+// decrypt returns the decrypted content of a multipart/encrypted body, adding the results of
+// combined signatures (RFC 3156 section 6.2) to the report (if set)
+func decrypt(body []byte, boundary string, report *Report) ([]byte, error) {
+	parts := tools.SplitMultipart(body, boundary)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("multipart/encrypted requires 2 parts, found %d", len(parts))
+	}
+
+	encrypted, err := decodePart(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasPrivateKeys {
+		return nil, errors.New("no PGP private keys configured")
+	}
+
+	md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), keyring, nil, nil)
+	if err != nil {
+		if errors.Is(err, pgpErrors.ErrKeyIncorrect) {
+			return nil, errors.New("no matching private key found to decrypt the message")
+		}
+		return nil, fmt.Errorf("error decrypting message: %s", err.Error())
+	}
+
+	// the signature (if any) is verified once the body has been read
+	content, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting message: %s", err.Error())
+	}
+
+	if md.IsSigned && report != nil {
+		report.Signed = true
+
+		signer := Signer{KeyID: fmt.Sprintf("%016X", md.SignedByKeyId)}
+		if md.Signature != nil {
+			signer.SigningTime = &md.Signature.CreationTime
+		}
+
+		switch {
+		case md.SignedBy == nil:
+			signer.Error = "public key not found in the keyring"
+		case md.SignatureError != nil:
+			signer.Error = "signature did not verify: " + md.SignatureError.Error()
+		default:
+			signer.Valid = true
+		}
+		if md.SignedBy != nil {
+			keyDetails(&signer, md.SignedBy.Entity)
+		}
+
+		report.addSigner(signer)
+	}
+
+	return content, nil
+}
+
+// verifyDetached verifies a multipart/signed entity body, returning the signed content
+func (report *Report) verifyDetached(body []byte, boundary string) ([]byte, error) {
+	parts := tools.SplitMultipart(body, boundary)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("multipart/signed requires 2 parts, found %d", len(parts))
+	}
+
+	sig, err := decodePart(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := packet.Read(bytes.NewReader(sig))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err.Error())
+	}
+
+	signer := Signer{}
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId != nil {
+			signer.KeyID = fmt.Sprintf("%016X", *s.IssuerKeyId)
+		}
+		signer.SigningTime = &s.CreationTime
+	default:
+		return nil, errors.New("invalid signature: not a signature packet")
+	}
+
+	entity, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(parts[0]), bytes.NewReader(sig), nil)
+	switch {
+	case errors.Is(err, pgpErrors.ErrUnknownIssuer):
+		signer.Error = "public key not found in the keyring"
+	case err != nil:
+		signer.Error = "signature did not verify: " + err.Error()
+	default:
+		signer.Valid = true
+	}
+	if entity != nil {
+		keyDetails(&signer, entity)
+	}
+
+	report.addSigner(signer)
+
+	return parts[0], nil
+}
+
+// addSigner adds the signer to the report, updating the status
+func (report *Report) addSigner(signer Signer) {
+	if signer.Valid && report.Status != StatusFail {
+		report.Status = StatusPass
+	} else if !signer.Valid {
+		report.Status = StatusFail
+	}
+
+	report.Signers = append(report.Signers, signer)
+}
+
+// keyDetails sets the public key details of the signer
+func keyDetails(signer *Signer, entity *openpgp.Entity) {
+	signer.Fingerprint = fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+	signer.UserIDs = []string{}
+	for name := range entity.Identities {
+		signer.UserIDs = append(signer.UserIDs, name)
+	}
+}
+
+// decodePart returns the binary OpenPGP data of a body part, removing the ASCII armor if present
+func decodePart(part []byte) ([]byte, error) {
+	e, err := tools.ParseMIMEEntity(part)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := e.DecodedBody()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		// binary data
+		return data, nil
+	}
+
+	return io.ReadAll(block.Body)
+}
@@ -0,0 +1,112 @@
+package pgp
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+func readFile(t *testing.T, file string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestCheckSigned(t *testing.T) {
+	msg := readFile(t, "testdata/signed.eml")
+
+	// unknown public key
+	r := Check(msg)
+	if r.Status != StatusFail || !r.Signed || len(r.Signers) != 1 || r.Signers[0].Valid || r.Signers[0].KeyID != "8E9DD824780BF973" {
+		t.Errorf("expected an unknown public key, got %+v", r)
+	}
+
+	if err := Load("testdata/keyring.asc", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Load("", "") }()
+
+	r = Check(msg)
+	if r.Status != StatusPass || len(r.Signers) != 1 {
+		t.Fatalf("expected a valid signature, got %+v", r)
+	}
+	s := r.Signers[0]
+	if !s.Valid || s.SigningTime == nil || s.Fingerprint == "" || strings.Join(s.UserIDs, ",") != "Example sender <sender@example.com>" {
+		t.Errorf("unexpected signer %+v", s)
+	}
+
+	// modified content
+	r = Check(bytes.Replace(msg, []byte("Your statement"), []byte("The statement"), 1))
+	if r.Status != StatusFail || r.Signers[0].Valid {
+		t.Errorf("expected a modified message to fail, got %+v", r)
+	}
+
+	r = Check([]byte("From: sender@example.com\r\nSubject: test\r\n\r\nHello\r\n"))
+	if r.Status != StatusNone || r.Signed || r.Encrypted {
+		t.Errorf("expected an unsigned message, got %+v", r)
+	}
+}
+
+func TestCheckEncrypted(t *testing.T) {
+	// no private keys
+	if err := Load("testdata/keyring.asc", ""); err != nil {
+		t.Fatal(err)
+	}
+	r := Check(readFile(t, "testdata/encrypted.eml"))
+	if !r.Encrypted || r.Decrypted || r.Error == "" {
+		t.Errorf("expected a decryption error, got %+v", r)
+	}
+
+	if err := Load("testdata/keyring.asc", "testdata/recipient.asc"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Load("", "") }()
+
+	// multipart/signed within multipart/encrypted (RFC 3156 section 6.1)
+	r = Check(readFile(t, "testdata/encrypted.eml"))
+	if !r.Encrypted || !r.Decrypted || !r.Signed || r.Status != StatusPass || len(r.Signers) != 1 {
+		t.Errorf("expected a decrypted & signed message, got %+v", r)
+	}
+
+	// combined signed & encrypted (RFC 3156 section 6.2)
+	r = Check(readFile(t, "testdata/combined.eml"))
+	if !r.Encrypted || !r.Decrypted || !r.Signed || r.Status != StatusPass || len(r.Signers) != 1 || !r.Signers[0].Valid {
+		t.Errorf("expected a decrypted & signed message, got %+v", r)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	if err := Load("", "testdata/recipient.asc"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Load("", "") }()
+
+	b, err := Unwrap(readFile(t, "testdata/combined.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := tools.ParseMIMEEntity(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Header.Get("Subject") != "Signed and encrypted statement" || !strings.HasPrefix(e.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("unexpected headers %v", e.Header)
+	}
+	if !strings.Contains(string(b), `<a href="https://example.com/statement">`) {
+		t.Errorf("expected the decrypted content, got %s", b)
+	}
+
+	// signatures are left intact
+	if _, err := Unwrap(readFile(t, "testdata/signed.eml")); err != ErrNotPGP {
+		t.Errorf("expected ErrNotPGP, got %v", err)
+	}
+}
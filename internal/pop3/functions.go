@@ -1,6 +1,9 @@
 package pop3
 
 import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
@@ -16,6 +19,89 @@ func authUser(username, password string) bool {
 	return auth.POP3Credentials.Match(username, password)
 }
 
+// Return the supported SASL mechanisms for the connection.
+// SCRAM channel binding (-PLUS) mechanisms are only available over TLS.
+func saslMechanisms(c net.Conn) []string {
+	if auth.POP3SCRAMCredentials == nil {
+		return []string{}
+	}
+
+	_, isTLS := c.(*tls.Conn)
+
+	return auth.SCRAMMechanisms(isTLS)
+}
+
+// Perform a SCRAM exchange with the client, returning the authenticated username
+func authSCRAM(c net.Conn, reader *bufio.Reader, mechanism, initialResponse string) (string, error) {
+	var channelBinding func(cbType string) ([]byte, error)
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		channelBinding = func(cbType string) ([]byte, error) {
+			return auth.TLSChannelBinding(tlsConn.ConnectionState(), cbType)
+		}
+	}
+
+	server, err := auth.NewSCRAMServer(mechanism, auth.POP3SCRAMCredentials, channelBinding)
+	if err != nil {
+		return "", err
+	}
+
+	response := initialResponse
+	if response == "" {
+		sendResponse(c, "+ ")
+		if response, err = readAuthLine(reader); err != nil {
+			return "", err
+		}
+	} else if response == "=" {
+		// RFC 5034: a zero-length initial response is sent as a single equals sign
+		response = ""
+	}
+
+	for {
+		if response == "*" {
+			return server.Username(), errors.New("authentication cancelled")
+		}
+
+		data, err := base64.StdEncoding.DecodeString(response)
+		if err != nil {
+			return server.Username(), errors.New("unable to decode")
+		}
+
+		challenge, done, err := server.Next(data)
+		if err != nil {
+			if errors.Is(err, auth.ErrSCRAMAuth) {
+				return server.Username(), errors.New("authentication failed")
+			}
+			return server.Username(), err
+		}
+
+		sendResponse(c, "+ "+base64.StdEncoding.EncodeToString(challenge))
+		if response, err = readAuthLine(reader); err != nil {
+			return server.Username(), err
+		}
+
+		if done {
+			if response == "*" {
+				return server.Username(), errors.New("authentication cancelled")
+			}
+
+			return server.Username(), nil
+		}
+	}
+}
+
+// Read a single SASL response line from the client
+func readAuthLine(reader *bufio.Reader) (string, error) {
+	line, isPrefix, err := reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if isPrefix {
+		return "", errors.New("line too long")
+	}
+
+	return strings.TrimSpace(string(line)), nil
+}
+
 // Send a response with debug logging
 func sendResponse(c net.Conn, m string) {
 	_, _ = fmt.Fprintf(c, "%s\r\n", m)
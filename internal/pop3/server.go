@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -164,6 +165,9 @@ func handleClient(conn net.Conn) {
 			sendResponse(conn, "TOP")
 			sendResponse(conn, "USER")
 			sendResponse(conn, "UIDL")
+			if mechs := saslMechanisms(conn); len(mechs) > 0 {
+				sendResponse(conn, "SASL "+strings.Join(mechs, " "))
+			}
 			sendResponse(conn, "IMPLEMENTATION Mailpit")
 			sendResponse(conn, ".")
 		case "USER":
@@ -204,6 +208,48 @@ func handleClient(conn net.Conn) {
 			} else {
 				sendResponse(conn, "-ERR user not specified")
 			}
+		case "AUTH":
+			// SASL authentication as per RFC 5034
+			if state != AUTHORIZATION {
+				sendResponse(conn, "-ERR already authenticated")
+				break
+			}
+
+			mechs := saslMechanisms(conn)
+			if len(args) == 0 {
+				sendResponse(conn, "+OK")
+				for _, m := range mechs {
+					sendResponse(conn, m)
+				}
+				sendResponse(conn, ".")
+				break
+			}
+
+			mechanism := strings.ToUpper(args[0])
+			if !slices.Contains(mechs, mechanism) {
+				sendResponse(conn, "-ERR unrecognized authentication type")
+				break
+			}
+
+			initialResponse := ""
+			if len(args) > 1 {
+				initialResponse = args[1]
+			}
+
+			username, err := authSCRAM(conn, reader, mechanism, initialResponse)
+			if err != nil {
+				sendResponse(conn, "-ERR "+err.Error())
+				logger.Log().Warnf("[pop3] failed %s login: %s", mechanism, username)
+				break
+			}
+
+			user = username
+			sendResponse(conn, "+OK signed in")
+			messages, err = getMessages()
+			if err != nil {
+				logger.Log().Errorf("[pop3] %s", err.Error())
+			}
+			state = TRANSACTION
 		case "STAT", "LIST", "UIDL", "RETR", "TOP", "NOOP", "DELE", "RSET":
 			if state == TRANSACTION {
 				handleTransactionCommand(conn, cmd, args, messages, &toDelete)
@@ -13,6 +13,8 @@ import (
 
 	"github.com/axllent/mailpit/config"
 	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/smtpd"
+	"github.com/axllent/mailpit/internal/smtpd/greylist"
 	"github.com/axllent/mailpit/internal/stats"
 )
 
@@ -75,6 +77,13 @@ var (
 	smtpRejected     = &gauge{}
 	smtpIgnored      = &gauge{}
 	smtpAcceptedSize = &gauge{}
+	greylistDeferred = &gauge{}
+	greylistPassed   = &gauge{}
+	greylistTriplets = &gauge{}
+	queueDepth       = &gauge{}
+	queueFailed      = &gauge{}
+	queueDelivered   = &gauge{}
+	queueFailures    = &gauge{}
 	uptime           = &gauge{}
 	memoryUsage      = &gauge{}
 	tagCounters      = newGaugeVec("tag")
@@ -94,6 +103,13 @@ func initMetrics() {
 	register("mailpit_messages_unread", "Number of unread messages in the database", "gauge", unreadMessages, nil)
 	register("mailpit_smtp_accepted_size_bytes_total", "Total size of accepted SMTP messages in bytes", "counter", smtpAcceptedSize, nil)
 	register("mailpit_smtp_accepted_total", "Total number of SMTP messages accepted", "counter", smtpAccepted, nil)
+	register("mailpit_queue_delivered_total", "Total number of queued relay & forward deliveries delivered", "counter", queueDelivered, nil)
+	register("mailpit_queue_depth", "Number of queued relay & forward deliveries awaiting delivery", "gauge", queueDepth, nil)
+	register("mailpit_queue_failed", "Number of failed relay & forward deliveries in the queue", "gauge", queueFailed, nil)
+	register("mailpit_queue_failed_attempts_total", "Total number of failed relay & forward delivery attempts", "counter", queueFailures, nil)
+	register("mailpit_smtp_greylist_deferred_total", "Total number of SMTP delivery attempts deferred by greylisting", "counter", greylistDeferred, nil)
+	register("mailpit_smtp_greylist_passed_total", "Total number of SMTP delivery attempts accepted after the greylisting delay", "counter", greylistPassed, nil)
+	register("mailpit_smtp_greylist_triplets", "Number of tracked greylisting triplets", "gauge", greylistTriplets, nil)
 	register("mailpit_smtp_ignored_total", "Total number of SMTP messages ignored (duplicates)", "counter", smtpIgnored, nil)
 	register("mailpit_smtp_rejected_total", "Total number of SMTP messages rejected", "counter", smtpRejected, nil)
 	register("mailpit_tag_messages", "Number of messages per tag", "gauge", nil, tagCounters)
@@ -111,6 +127,19 @@ func updateMetrics() {
 	smtpRejected.Set(float64(info.RuntimeStats.SMTPRejected))
 	smtpIgnored.Set(float64(info.RuntimeStats.SMTPIgnored))
 	smtpAcceptedSize.Set(float64(info.RuntimeStats.SMTPAcceptedSize))
+
+	greylistStatus := greylist.GetStatus()
+	greylistDeferred.Set(float64(greylistStatus.Deferred))
+	greylistPassed.Set(float64(greylistStatus.Passed))
+	greylistTriplets.Set(float64(len(greylistStatus.Triplets)))
+
+	if queueStatus, err := smtpd.GetQueueStatus(); err == nil {
+		queueDepth.Set(float64(queueStatus.Queued))
+		queueFailed.Set(float64(queueStatus.Failed))
+		queueDelivered.Set(float64(queueStatus.Delivered))
+		queueFailures.Set(float64(queueStatus.FailedAttempts))
+	}
+
 	uptime.Set(float64(info.RuntimeStats.Uptime))
 	memoryUsage.Set(float64(info.RuntimeStats.Memory))
 
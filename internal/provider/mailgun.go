@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// mailgun is the Mailgun MIME message API transport, sending the raw message as-is.
+// The endpoint must include the sending domain, eg: https://api.mailgun.net/v3/example.com/messages.mime
+type mailgun struct{}
+
+// DefaultEndpoint returns an empty string, as the Mailgun endpoint includes the sending domain
+func (mailgun) DefaultEndpoint() string {
+	return ""
+}
+
+// NewRequest returns the Mailgun multipart/form-data request, authenticated with the API key
+func (mailgun) NewRequest(endpoint, apiKey string, m *Message) (*http.Request, error) {
+	var b bytes.Buffer
+
+	w := multipart.NewWriter(&b)
+
+	for _, to := range m.Recipients {
+		if err := w.WriteField("to", to); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := w.CreateFormFile("message", "message.eml")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Write(m.Raw); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.SetBasicAuth("api", apiKey)
+
+	return req, nil
+}
+
+// ParseResponse returns the Mailgun message ID, or the error message
+func (mailgun) ParseResponse(body []byte) string {
+	var r struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &r); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+
+	if r.ID != "" {
+		return r.ID
+	}
+
+	return r.Message
+}
@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// postmark is the Postmark email API transport
+type postmark struct{}
+
+type postmarkHeader struct {
+	Name  string
+	Value string
+}
+
+type postmarkAttachment struct {
+	Name        string
+	Content     []byte // base64 encoded by encoding/json
+	ContentType string
+	ContentID   string `json:",omitempty"`
+}
+
+type postmarkRequest struct {
+	From        string
+	To          string
+	Cc          string `json:",omitempty"`
+	Bcc         string `json:",omitempty"`
+	ReplyTo     string `json:",omitempty"`
+	Subject     string
+	TextBody    string               `json:",omitempty"`
+	HtmlBody    string               `json:",omitempty"`
+	Headers     []postmarkHeader     `json:",omitempty"`
+	Attachments []postmarkAttachment `json:",omitempty"`
+}
+
+// DefaultEndpoint returns the Postmark email API endpoint
+func (postmark) DefaultEndpoint() string {
+	return "https://api.postmarkapp.com/email"
+}
+
+// NewRequest returns the Postmark JSON request, authenticated with the API key as the server token
+func (postmark) NewRequest(endpoint, apiKey string, m *Message) (*http.Request, error) {
+	r := postmarkRequest{
+		From:     m.From.String(),
+		To:       addressList(m.To),
+		Cc:       addressList(m.Cc),
+		Bcc:      addressList(m.Bcc),
+		ReplyTo:  addressList(m.ReplyTo),
+		Subject:  m.Subject,
+		TextBody: m.Text,
+		HtmlBody: m.HTML,
+	}
+
+	for _, h := range m.Headers {
+		r.Headers = append(r.Headers, postmarkHeader{Name: h.Name, Value: h.Value})
+	}
+
+	for _, a := range m.Attachments {
+		attachment := postmarkAttachment{Name: a.FileName, Content: a.Content, ContentType: a.ContentType}
+		if a.Inline && a.ContentID != "" {
+			attachment.ContentID = "cid:" + a.ContentID
+		}
+		r.Attachments = append(r.Attachments, attachment)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", apiKey)
+
+	return req, nil
+}
+
+// ParseResponse returns the Postmark message ID, or the error code & message
+func (postmark) ParseResponse(body []byte) string {
+	var r struct {
+		ErrorCode int
+		Message   string
+		MessageID string
+	}
+
+	if err := json.Unmarshal(body, &r); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+
+	if r.ErrorCode != 0 {
+		return fmt.Sprintf("%s (error code %d)", r.Message, r.ErrorCode)
+	}
+
+	if r.MessageID != "" {
+		return r.MessageID
+	}
+
+	return r.Message
+}
@@ -0,0 +1,309 @@
+// Package provider relays messages via the HTTP APIs of email providers, using pluggable transports
+// which convert messages into the request each provider API expects
+package provider
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axllent/mailpit/internal/tools"
+	"github.com/jhillyerd/enmime/v2"
+)
+
+// Transport converts messages into the HTTP API requests of an email provider
+type Transport interface {
+	// DefaultEndpoint returns the default API endpoint, or an empty string if the endpoint must be configured
+	DefaultEndpoint() string
+	// NewRequest returns the API request to send the message
+	NewRequest(endpoint, apiKey string, m *Message) (*http.Request, error)
+	// ParseResponse returns the provider message ID of a successful response body,
+	// or the provider error message of an unsuccessful response body
+	ParseResponse(body []byte) string
+}
+
+var (
+	transports = map[string]Transport{
+		"mailgun":  mailgun{},
+		"postmark": postmark{},
+		"sendgrid": sendgrid{},
+	}
+	transportsMu sync.RWMutex
+
+	// reservedHeaders are the headers which are set by provider APIs, and not passed as custom headers
+	reservedHeaders = []string{
+		"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-Id", "Return-Path", "Received",
+		"Mime-Version", "Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Id",
+		"Dkim-Signature",
+	}
+)
+
+// Register adds a transport, replacing any existing transport of the same name
+func Register(name string, t Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	transports[strings.ToLower(name)] = t
+}
+
+// Names returns the sorted names of the registered transports
+func Names() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	names := []string{}
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Client sends messages via a provider API
+type Client struct {
+	// UserAgent is the User-Agent header of API requests
+	UserAgent string
+
+	name      string
+	endpoint  string
+	apiKey    string
+	transport Transport
+	client    *http.Client
+}
+
+// New returns a client for the named transport. The endpoint is optional if the transport has a default endpoint.
+func New(name, endpoint, apiKey string, allowInsecure bool) (*Client, error) {
+	name = strings.ToLower(name)
+
+	transportsMu.RLock()
+	t, ok := transports[name]
+	transportsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("provider not supported: %s (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	if endpoint == "" {
+		endpoint = t.DefaultEndpoint()
+	}
+
+	if endpoint == "" {
+		return nil, fmt.Errorf("%s endpoint not set", name)
+	}
+
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("%s endpoint must be an HTTP(S) URL: %s", name, endpoint)
+	}
+
+	if apiKey == "" {
+		return nil, fmt.Errorf("%s API key not set", name)
+	}
+
+	c := &Client{
+		UserAgent: "Mailpit",
+		name:      name,
+		endpoint:  endpoint,
+		apiKey:    apiKey,
+		transport: t,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if allowInsecure {
+		c.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec
+		}
+	}
+
+	return c, nil
+}
+
+// String returns the provider name & endpoint
+func (c *Client) String() string {
+	return c.name + "+" + c.endpoint
+}
+
+// Send sends the message via the provider API, returning an SMTP-equivalent response code & text.
+// Unsuccessful responses are returned as SMTP errors (*textproto.Error): rate limiting (429), timeouts (408)
+// and server errors (5xx) as temporary 451 errors, and all other responses as permanent 554 errors.
+func (c *Client) Send(from string, to []string, msg []byte) (int, string, error) {
+	m, err := ParseMessage(from, to, msg)
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := c.transport.NewRequest(c.endpoint, c.apiKey, m)
+	if err != nil {
+		return 0, "", fmt.Errorf("error creating %s request: %s", c.name, err.Error())
+	}
+
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("error connecting to %s: %s", c.name, err.Error())
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return 0, "", fmt.Errorf("error reading %s response: %s", c.name, err.Error())
+	}
+
+	text := c.transport.ParseResponse(body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 250, strings.TrimSpace(fmt.Sprintf("%s %d %s", c.name, resp.StatusCode, text)), nil
+	}
+
+	return 0, "", mapError(c.name, resp.StatusCode, text)
+}
+
+// mapError returns the SMTP-equivalent error of an unsuccessful provider API response
+func mapError(name string, status int, text string) error {
+	if text == "" {
+		text = http.StatusText(status)
+	}
+
+	code := 554
+	if status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500 {
+		code = 451
+	}
+
+	return &textproto.Error{Code: code, Msg: fmt.Sprintf("%s API error (HTTP %d): %s", name, status, text)}
+}
+
+// Message is a message parsed for a provider API request
+type Message struct {
+	// Raw is the raw message
+	Raw []byte
+	// Recipients are the SMTP recipients
+	Recipients []string
+	// From is the From header address, else the SMTP sender
+	From *mail.Address
+	// To are the SMTP recipients found in the To header, or all SMTP recipients if none are found in the To & Cc headers
+	To []*mail.Address
+	// Cc are the SMTP recipients found in the Cc header
+	Cc []*mail.Address
+	// Bcc are the SMTP recipients not found in the To or Cc headers
+	Bcc []*mail.Address
+	// ReplyTo are the Reply-To header addresses
+	ReplyTo []*mail.Address
+	// Subject is the decoded subject
+	Subject string
+	// Text is the text body
+	Text string
+	// HTML is the HTML body
+	HTML string
+	// Headers are the custom headers, excluding headers set by provider APIs
+	Headers []Header
+	// Attachments are the attachments & inline parts
+	Attachments []Attachment
+}
+
+// Header is a message header
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Attachment is a message attachment or inline part
+type Attachment struct {
+	FileName    string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Content     []byte
+}
+
+// ParseMessage parses a raw message & its SMTP sender & recipients for a provider API request
+func ParseMessage(from string, to []string, msg []byte) (*Message, error) {
+	env, err := enmime.ReadEnvelope(bytes.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing message: %s", err.Error())
+	}
+
+	m := &Message{
+		Raw:        msg,
+		Recipients: to,
+		Subject:    env.GetHeader("Subject"),
+		Text:       env.Text,
+		HTML:       env.HTML,
+	}
+
+	m.From = &mail.Address{Address: from}
+	if addresses, err := env.AddressList("From"); err == nil && len(addresses) > 0 {
+		m.From = addresses[0]
+	}
+
+	if addresses, err := env.AddressList("Reply-To"); err == nil {
+		m.ReplyTo = addresses
+	}
+
+	headerTo, _ := env.AddressList("To")
+	headerCc, _ := env.AddressList("Cc")
+
+	// split the SMTP recipients by the header they are found in, keeping their names
+	for _, addr := range to {
+		if a := findAddress(headerTo, addr); a != nil {
+			m.To = append(m.To, a)
+		} else if a := findAddress(headerCc, addr); a != nil {
+			m.Cc = append(m.Cc, a)
+		} else {
+			m.Bcc = append(m.Bcc, &mail.Address{Address: addr})
+		}
+	}
+
+	if len(m.To) == 0 && len(m.Cc) == 0 {
+		m.To, m.Bcc = m.Bcc, nil
+	}
+
+	for _, k := range env.GetHeaderKeys() {
+		if tools.InArray(k, reservedHeaders) {
+			continue
+		}
+
+		for _, v := range env.GetHeaderValues(k) {
+			m.Headers = append(m.Headers, Header{Name: k, Value: v})
+		}
+	}
+
+	for _, p := range env.Attachments {
+		m.Attachments = append(m.Attachments, Attachment{FileName: p.FileName, ContentType: p.ContentType, Content: p.Content})
+	}
+
+	for _, p := range append(env.Inlines, env.OtherParts...) {
+		m.Attachments = append(m.Attachments, Attachment{FileName: p.FileName, ContentType: p.ContentType, ContentID: p.ContentID, Inline: true, Content: p.Content})
+	}
+
+	return m, nil
+}
+
+// findAddress returns the address matching an email address (case-insensitive), if any
+func findAddress(addresses []*mail.Address, address string) *mail.Address {
+	for _, a := range addresses {
+		if strings.EqualFold(a.Address, address) {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// addressList returns the addresses as a comma-separated list
+func addressList(addresses []*mail.Address) string {
+	s := []string{}
+	for _, a := range addresses {
+		s = append(s, a.String())
+	}
+
+	return strings.Join(s, ", ")
+}
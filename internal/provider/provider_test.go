@@ -0,0 +1,231 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+var testMessage = []byte("From: \"Sender\" <sender@example.com>\r\n" +
+	"To: \"Recipient\" <recipient@example.com>\r\n" +
+	"Cc: cc@example.com\r\n" +
+	"Subject: Test message\r\n" +
+	"X-Campaign: spring\r\n" +
+	"Message-ID: <test@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; name=\"file.txt\"\r\n" +
+	"Content-Disposition: attachment; filename=\"file.txt\"\r\n" +
+	"\r\n" +
+	"Attached\r\n" +
+	"--b1--\r\n")
+
+var testRecipients = []string{"recipient@example.com", "cc@example.com", "hidden@example.com"}
+
+// captured is a request received by the HTTP stand-in
+type captured struct {
+	header http.Header
+	body   []byte
+}
+
+// standIn returns a local HTTP stand-in for a provider API, responding with the status & body
+func standIn(t *testing.T, status int, response string, c *captured) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.header = r.Header
+		c.body = body
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestParseMessage(t *testing.T) {
+	m, err := ParseMessage("bounce@example.com", testRecipients, testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.From.String() != `"Sender" <sender@example.com>` {
+		t.Errorf("unexpected From: %s", m.From)
+	}
+
+	if addressList(m.To) != `"Recipient" <recipient@example.com>` || addressList(m.Cc) != "<cc@example.com>" || addressList(m.Bcc) != "<hidden@example.com>" {
+		t.Errorf("unexpected recipients: %s / %s / %s", addressList(m.To), addressList(m.Cc), addressList(m.Bcc))
+	}
+
+	if len(m.Headers) != 1 || m.Headers[0].Name != "X-Campaign" {
+		t.Errorf("unexpected headers: %v", m.Headers)
+	}
+
+	if len(m.Attachments) != 1 || m.Attachments[0].FileName != "file.txt" || string(m.Attachments[0].Content) != "Attached" {
+		t.Errorf("unexpected attachments: %v", m.Attachments)
+	}
+
+	// recipients are sent as To if none are found in the To & Cc headers
+	m, err = ParseMessage("", []string{"other@example.com"}, testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addressList(m.To) != "<other@example.com>" || len(m.Bcc) != 0 {
+		t.Errorf("unexpected recipients: %s / %s", addressList(m.To), addressList(m.Bcc))
+	}
+}
+
+func TestSendGrid(t *testing.T) {
+	c := &captured{}
+	ts := standIn(t, http.StatusAccepted, "", c)
+
+	client, err := New("sendgrid", ts.URL, "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, err := client.Send("sender@example.com", testRecipients, testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 250 {
+		t.Errorf("expected 250, got %d", code)
+	}
+
+	if c.header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected Authorization header: %s", c.header.Get("Authorization"))
+	}
+
+	var r sendgridRequest
+	if err := json.Unmarshal(c.body, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	p := r.Personalizations[0]
+	if len(p.To) != 1 || p.To[0].Email != "recipient@example.com" || len(p.Cc) != 1 || len(p.Bcc) != 1 {
+		t.Errorf("unexpected personalizations: %+v", p)
+	}
+
+	if r.Subject != "Test message" || r.From.Email != "sender@example.com" || r.Headers["X-Campaign"] != "spring" {
+		t.Errorf("unexpected request: %s", c.body)
+	}
+
+	if len(r.Content) != 1 || r.Content[0].Value != "Hello" || len(r.Attachments) != 1 || string(r.Attachments[0].Content) != "Attached" {
+		t.Errorf("unexpected content: %s", c.body)
+	}
+}
+
+func TestMailgun(t *testing.T) {
+	c := &captured{}
+	ts := standIn(t, http.StatusOK, `{"id": "<id@example.com>", "message": "Queued. Thank you."}`, c)
+
+	if _, err := New("mailgun", "", "secret", false); err == nil {
+		t.Error("expected an error for a missing Mailgun endpoint")
+	}
+
+	client, err := New("mailgun", ts.URL+"/v3/example.com/messages.mime", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, text, err := client.Send("sender@example.com", testRecipients, testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 250 || !strings.Contains(text, "<id@example.com>") {
+		t.Errorf("unexpected response: %d %s", code, text)
+	}
+
+	if !strings.HasPrefix(c.header.Get("Authorization"), "Basic ") {
+		t.Errorf("unexpected Authorization header: %s", c.header.Get("Authorization"))
+	}
+
+	body := string(c.body)
+	if strings.Count(body, `name="to"`) != 3 || !strings.Contains(body, string(testMessage)) {
+		t.Errorf("unexpected request: %s", body)
+	}
+}
+
+func TestPostmark(t *testing.T) {
+	c := &captured{}
+	ts := standIn(t, http.StatusUnprocessableEntity, `{"ErrorCode": 300, "Message": "Invalid 'From' address"}`, c)
+
+	client, err := New("postmark", ts.URL, "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = client.Send("sender@example.com", testRecipients, testMessage)
+
+	var e *textproto.Error
+	if !errors.As(err, &e) || e.Code != 554 || !strings.Contains(e.Msg, "Invalid 'From' address (error code 300)") {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+
+	if c.header.Get("X-Postmark-Server-Token") != "secret" {
+		t.Errorf("unexpected X-Postmark-Server-Token header: %s", c.header.Get("X-Postmark-Server-Token"))
+	}
+
+	var r postmarkRequest
+	if err := json.Unmarshal(c.body, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.To != `"Recipient" <recipient@example.com>` || r.Bcc != "<hidden@example.com>" || r.TextBody != "Hello" || len(r.Attachments) != 1 {
+		t.Errorf("unexpected request: %s", c.body)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := map[int]int{
+		http.StatusBadRequest:          554,
+		http.StatusUnauthorized:        554,
+		http.StatusRequestTimeout:      451,
+		http.StatusTooManyRequests:     451,
+		http.StatusInternalServerError: 451,
+		http.StatusServiceUnavailable:  451,
+	}
+
+	for status, expected := range tests {
+		c := &captured{}
+		ts := standIn(t, status, `{"errors": [{"message": "failed"}]}`, c)
+
+		client, err := New("sendgrid", ts.URL, "secret", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = client.Send("sender@example.com", testRecipients, testMessage)
+
+		var e *textproto.Error
+		if !errors.As(err, &e) || e.Code != expected {
+			t.Errorf("HTTP %d: expected SMTP %d error, got %v", status, expected, err)
+		}
+	}
+
+	if _, err := New("unknown", "", "secret", false); err == nil {
+		t.Error("expected an error for an unsupported provider")
+	}
+
+	if _, err := New("sendgrid", "", "", false); err == nil {
+		t.Error("expected an error for a missing API key")
+	}
+}
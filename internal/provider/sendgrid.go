@@ -0,0 +1,150 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// sendgrid is the SendGrid v3 mail send API transport
+type sendgrid struct{}
+
+type sendgridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendgridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendgridAttachment struct {
+	Content     []byte `json:"content"` // base64 encoded by encoding/json
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendgridPersonalization struct {
+	To  []sendgridAddress `json:"to"`
+	Cc  []sendgridAddress `json:"cc,omitempty"`
+	Bcc []sendgridAddress `json:"bcc,omitempty"`
+}
+
+type sendgridRequest struct {
+	Personalizations []sendgridPersonalization `json:"personalizations"`
+	From             sendgridAddress           `json:"from"`
+	ReplyToList      []sendgridAddress         `json:"reply_to_list,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendgridContent         `json:"content,omitempty"`
+	Attachments      []sendgridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// DefaultEndpoint returns the SendGrid mail send API endpoint
+func (sendgrid) DefaultEndpoint() string {
+	return "https://api.sendgrid.com/v3/mail/send"
+}
+
+// NewRequest returns the SendGrid JSON request, authenticated with the API key as a bearer token
+func (sendgrid) NewRequest(endpoint, apiKey string, m *Message) (*http.Request, error) {
+	r := sendgridRequest{
+		Personalizations: []sendgridPersonalization{{
+			To:  sendgridAddresses(m.To),
+			Cc:  sendgridAddresses(m.Cc),
+			Bcc: sendgridAddresses(m.Bcc),
+		}},
+		From:        sendgridAddress{Email: m.From.Address, Name: m.From.Name},
+		ReplyToList: sendgridAddresses(m.ReplyTo),
+		Subject:     m.Subject,
+	}
+
+	// text/plain must be first
+	if m.Text != "" {
+		r.Content = append(r.Content, sendgridContent{Type: "text/plain", Value: m.Text})
+	}
+	if m.HTML != "" {
+		r.Content = append(r.Content, sendgridContent{Type: "text/html", Value: m.HTML})
+	}
+
+	for _, a := range m.Attachments {
+		disposition := "attachment"
+		if a.Inline {
+			disposition = "inline"
+		}
+
+		r.Attachments = append(r.Attachments, sendgridAttachment{
+			Content:     a.Content,
+			Type:        a.ContentType,
+			Filename:    a.FileName,
+			Disposition: disposition,
+			ContentID:   a.ContentID,
+		})
+	}
+
+	if len(m.Headers) > 0 {
+		r.Headers = map[string]string{}
+		for _, h := range m.Headers {
+			// SendGrid only supports a single value per header
+			r.Headers[h.Name] = h.Value
+		}
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	return req, nil
+}
+
+// ParseResponse returns the SendGrid error messages, as successful responses have an empty body
+func (sendgrid) ParseResponse(body []byte) string {
+	var r struct {
+		Errors []struct {
+			Message string `json:"message"`
+			Field   string `json:"field"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(body, &r); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+
+	errs := []string{}
+	for _, e := range r.Errors {
+		if e.Field != "" {
+			errs = append(errs, e.Field+": "+e.Message)
+		} else {
+			errs = append(errs, e.Message)
+		}
+	}
+
+	return strings.Join(errs, "; ")
+}
+
+// sendgridAddresses returns the SendGrid addresses
+func sendgridAddresses(addresses []*mail.Address) []sendgridAddress {
+	s := []sendgridAddress{}
+	for _, a := range addresses {
+		s = append(s, sendgridAddress{Email: a.Address, Name: a.Name})
+	}
+
+	if len(s) == 0 {
+		return nil
+	}
+
+	return s
+}
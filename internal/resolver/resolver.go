@@ -0,0 +1,180 @@
+// Package resolver provides DNS lookups for message authentication checks (eg: DKIM & SPF),
+// resolving either from local fixtures (a zone file or a static key map) or the system DNS
+package resolver
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when a record does not exist
+	ErrNotFound = errors.New("record not found")
+
+	// local is the resolver using local fixtures, nil if not configured
+	local Resolver
+)
+
+// Resolver looks up DNS records
+type Resolver interface {
+	// LookupTXT returns the TXT records of a domain, each record being
+	// the concatenation of its character strings
+	LookupTXT(name string) ([]string, error)
+
+	// LookupIP returns the A & AAAA records of a domain
+	LookupIP(name string) ([]net.IP, error)
+
+	// LookupMX returns the MX hosts of a domain, sorted by preference
+	LookupMX(name string) ([]string, error)
+}
+
+// SetLocal sets the local resolver using a zone file and/or a YAML map of DKIM keys.
+// The local resolver is unset if neither are provided.
+func SetLocal(zoneFile, keyFile string) error {
+	chain := Chain{}
+
+	if keyFile != "" {
+		keys, err := LoadKeyFile(keyFile)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, keys)
+	}
+
+	if zoneFile != "" {
+		zone, err := LoadZoneFile(zoneFile)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, zone)
+	}
+
+	if len(chain) == 0 {
+		local = nil
+	} else {
+		local = chain
+	}
+
+	return nil
+}
+
+// Local returns the resolver using local fixtures, or nil if not configured
+func Local() Resolver {
+	return local
+}
+
+// Default returns the local resolver if configured, else the system DNS resolver
+func Default() Resolver {
+	if local != nil {
+		return local
+	}
+
+	return System{}
+}
+
+// Chain queries each resolver in order, returning the first records found
+type Chain []Resolver
+
+// LookupTXT returns the TXT records from the first resolver in the chain containing the name
+func (c Chain) LookupTXT(name string) ([]string, error) {
+	return chainLookup(c, func(r Resolver) ([]string, error) { return r.LookupTXT(name) })
+}
+
+// LookupIP returns the IP addresses from the first resolver in the chain containing the name
+func (c Chain) LookupIP(name string) ([]net.IP, error) {
+	return chainLookup(c, func(r Resolver) ([]net.IP, error) { return r.LookupIP(name) })
+}
+
+// LookupMX returns the MX hosts from the first resolver in the chain containing the name
+func (c Chain) LookupMX(name string) ([]string, error) {
+	return chainLookup(c, func(r Resolver) ([]string, error) { return r.LookupMX(name) })
+}
+
+// chainLookup returns the first lookup result which is not ErrNotFound
+func chainLookup[T any](c Chain, lookup func(Resolver) ([]T, error)) ([]T, error) {
+	for _, r := range c {
+		records, err := lookup(r)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		return records, err
+	}
+
+	return nil, ErrNotFound
+}
+
+// System uses the system DNS resolver
+type System struct{}
+
+// LookupTXT returns the TXT records of a domain via DNS
+func (System) LookupTXT(name string) ([]string, error) {
+	records, err := net.LookupTXT(name)
+
+	return records, dnsError(err)
+}
+
+// LookupIP returns the IP addresses of a domain via DNS
+func (System) LookupIP(name string) ([]net.IP, error) {
+	ips, err := net.LookupIP(name)
+
+	return ips, dnsError(err)
+}
+
+// LookupMX returns the MX hosts of a domain via DNS
+func (System) LookupMX(name string) ([]string, error) {
+	records, err := net.LookupMX(name)
+	if err != nil {
+		return nil, dnsError(err)
+	}
+
+	// net.LookupMX sorts by preference
+	hosts := []string{}
+	for _, mx := range records {
+		hosts = append(hosts, normalize(mx.Host))
+	}
+
+	return hosts, nil
+}
+
+// dnsError converts a DNS "not found" error to ErrNotFound
+func dnsError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// sortMX sorts "<preference> <host>" MX records by preference, returning the hosts
+func sortMX(records []string) []string {
+	type mx struct {
+		pref int
+		host string
+	}
+
+	list := []mx{}
+	for _, r := range records {
+		pref, host, _ := strings.Cut(r, " ")
+		n, _ := strconv.Atoi(pref)
+		list = append(list, mx{n, host})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].pref < list[j].pref })
+
+	hosts := []string{}
+	for _, m := range list {
+		hosts = append(hosts, m.host)
+	}
+
+	return hosts
+}
+
+// normalize returns a lowercase fully qualified domain name without the trailing dot
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,283 @@
+package resolver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Zone is an in-memory set of DNS records loaded from a zone file
+type Zone struct {
+	// records by lowercase domain name & record type
+	records map[string]map[string][]string
+}
+
+// zoneToken is a single token of a zone file entry
+type zoneToken struct {
+	value  string
+	quoted bool
+}
+
+// LoadZoneFile loads the records of a (BIND-style) zone file.
+// The $ORIGIN & $TTL directives, relative names, parentheses and comments are supported.
+func LoadZoneFile(file string) (*Zone, error) {
+	f, err := os.Open(file) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	z := &Zone{records: map[string]map[string][]string{}}
+	origin := ""
+	lastOwner := ""
+	lineNo := 0
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var tokens []zoneToken
+	startLine := 0
+	blankOwner := false
+	depth := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+
+		if depth == 0 {
+			startLine = lineNo
+			blankOwner = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+			tokens = []zoneToken{}
+		}
+
+		lineTokens, d, err := tokenize(line, depth)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", file, lineNo, err.Error())
+		}
+		depth = d
+		tokens = append(tokens, lineTokens...)
+
+		if depth > 0 || len(tokens) == 0 {
+			continue
+		}
+
+		if !tokens[0].quoted && strings.HasPrefix(tokens[0].value, "$") {
+			switch strings.ToUpper(tokens[0].value) {
+			case "$ORIGIN":
+				if len(tokens) != 2 {
+					return nil, fmt.Errorf("%s line %d: invalid $ORIGIN", file, startLine)
+				}
+				origin = normalize(z.absolute(tokens[1].value, origin))
+			case "$TTL":
+				// TTLs are irrelevant for local lookups
+			default:
+				return nil, fmt.Errorf("%s line %d: unsupported directive %s", file, startLine, tokens[0].value)
+			}
+			continue
+		}
+
+		if !blankOwner {
+			lastOwner = normalize(z.absolute(tokens[0].value, origin))
+			tokens = tokens[1:]
+		} else if lastOwner == "" {
+			return nil, fmt.Errorf("%s line %d: record without a name", file, startLine)
+		}
+
+		// skip the optional TTL & class (in either order)
+		for len(tokens) > 0 {
+			v := strings.ToUpper(tokens[0].value)
+			if _, err := strconv.Atoi(v); err == nil || v == "IN" || v == "CH" || v == "HS" {
+				tokens = tokens[1:]
+				continue
+			}
+			break
+		}
+
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("%s line %d: incomplete record", file, startLine)
+		}
+
+		rrType := strings.ToUpper(tokens[0].value)
+		data := tokens[1:]
+
+		var rdata string
+		switch rrType {
+		case "TXT":
+			for _, t := range data {
+				rdata += t.value
+			}
+		case "CNAME", "NS", "PTR":
+			rdata = normalize(z.absolute(data[0].value, origin))
+		case "A", "AAAA":
+			if ip := net.ParseIP(data[0].value); ip == nil || len(data) != 1 {
+				return nil, fmt.Errorf("%s line %d: invalid %s record", file, startLine, rrType)
+			}
+			rdata = data[0].value
+		case "MX":
+			if _, err := strconv.Atoi(data[0].value); err != nil || len(data) != 2 {
+				return nil, fmt.Errorf("%s line %d: invalid MX record", file, startLine)
+			}
+			rdata = data[0].value + " " + normalize(z.absolute(data[1].value, origin))
+		default:
+			values := []string{}
+			for _, t := range data {
+				values = append(values, t.value)
+			}
+			rdata = strings.Join(values, " ")
+		}
+
+		z.add(lastOwner, rrType, rdata)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if depth > 0 {
+		return nil, fmt.Errorf("%s line %d: unclosed parenthesis", file, startLine)
+	}
+
+	return z, nil
+}
+
+// tokenize splits a zone file line into tokens, stripping comments & parentheses.
+// The parenthesis depth is carried over multiple lines.
+func tokenize(line string, depth int) ([]zoneToken, int, error) {
+	tokens := []zoneToken{}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ';':
+			return tokens, depth, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return nil, depth, fmt.Errorf("unexpected )")
+			}
+			depth--
+		case c == '"':
+			var sb strings.Builder
+			closed := false
+			for i++; i < len(line); i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					sb.WriteByte(line[i])
+					continue
+				}
+				if line[i] == '"' {
+					closed = true
+					break
+				}
+				sb.WriteByte(line[i])
+			}
+			if !closed {
+				return nil, depth, fmt.Errorf("unterminated quoted string")
+			}
+			tokens = append(tokens, zoneToken{value: sb.String(), quoted: true})
+		default:
+			start := i
+			for i < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[i])) {
+				i++
+			}
+			tokens = append(tokens, zoneToken{value: line[start:i]})
+			i--
+		}
+	}
+
+	return tokens, depth, nil
+}
+
+// absolute returns the absolute name of a (possibly relative) zone file name
+func (z *Zone) absolute(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+
+	if strings.HasSuffix(name, ".") || origin == "" {
+		return name
+	}
+
+	return name + "." + origin
+}
+
+// add a record to the zone
+func (z *Zone) add(name, rrType, rdata string) {
+	if _, ok := z.records[name]; !ok {
+		z.records[name] = map[string][]string{}
+	}
+
+	z.records[name][rrType] = append(z.records[name][rrType], rdata)
+}
+
+// lookup returns the records of a type, following CNAME records within the zone
+func (z *Zone) lookup(name, rrType string) ([]string, error) {
+	name = normalize(name)
+
+	for range 10 {
+		types, ok := z.records[name]
+		if !ok {
+			return nil, ErrNotFound
+		}
+
+		if records, ok := types[rrType]; ok {
+			return append([]string{}, records...), nil
+		}
+
+		cname, ok := types["CNAME"]
+		if !ok {
+			return nil, ErrNotFound
+		}
+
+		name = normalize(cname[0])
+	}
+
+	return nil, fmt.Errorf("too many CNAME redirections for %s", name)
+}
+
+// LookupTXT returns the TXT records of a domain
+func (z *Zone) LookupTXT(name string) ([]string, error) {
+	return z.lookup(name, "TXT")
+}
+
+// LookupIP returns the A & AAAA records of a domain
+func (z *Zone) LookupIP(name string) ([]net.IP, error) {
+	records := []string{}
+	for _, rrType := range []string{"A", "AAAA"} {
+		r, err := z.lookup(name, rrType)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		records = append(records, r...)
+	}
+
+	if len(records) == 0 {
+		return nil, ErrNotFound
+	}
+
+	ips := []net.IP{}
+	for _, r := range records {
+		if ip := net.ParseIP(r); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
+
+// LookupMX returns the MX hosts of a domain, sorted by preference
+func (z *Zone) LookupMX(name string) ([]string, error) {
+	records, err := z.lookup(name, "MX")
+	if err != nil {
+		return nil, err
+	}
+
+	return sortMX(records), nil
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadZoneFile(t *testing.T) {
+	zone := `$ORIGIN example.com.
+$TTL 300
+@                   IN  TXT   "v=spf1 -all" ; comment
+                    IN  MX    10 mail
+sel._domainkey  60  IN  TXT   ( "v=DKIM1; "
+                                "p=abc" ) ; multi-line
+alias._domainkey    IN  CNAME sel._domainkey
+other.example.org.      TXT   "absolute \"quoted\""
+`
+
+	file := filepath.Join(t.TempDir(), "test.zone")
+	if err := os.WriteFile(file, []byte(zone), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := LoadZoneFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"example.com":                  "v=spf1 -all",
+		"SEL._domainkey.example.com.":  "v=DKIM1; p=abc",
+		"alias._domainkey.example.com": "v=DKIM1; p=abc",
+		"other.example.org":            `absolute "quoted"`,
+	}
+
+	for name, expected := range tests {
+		records, err := z.LookupTXT(name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if len(records) != 1 || records[0] != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, records)
+		}
+	}
+
+	if mx := z.records["example.com"]["MX"]; len(mx) != 1 || mx[0] != "10 mail.example.com" {
+		t.Errorf("expected MX record for blank owner, got %q", mx)
+	}
+
+	if _, err := z.LookupTXT("missing.example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("test IN TXT ( \"unclosed\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadZoneFile(file); err == nil {
+		t.Error("expected error for unclosed parenthesis")
+	}
+}
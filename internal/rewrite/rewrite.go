@@ -0,0 +1,329 @@
+// Package rewrite applies header, subject & URL rewriting rules to relayed & forwarded messages
+package rewrite
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+// urlRegexp matches URLs in text & HTML content
+var urlRegexp = regexp.MustCompile(`https?://[^\s"'<>()\[\]]+`)
+
+// Rules are compiled rewriting rules
+type Rules struct {
+	removeHeaders []string
+	setHeaders    []header
+	subject       *template.Template
+	urls          []urlRule
+}
+
+// header is a header to add or replace
+type header struct {
+	name  string
+	value *template.Template
+}
+
+// urlRule rewrites URLs matching a regular expression
+type urlRule struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// URLRule is a URL rewriting rule. Match is a regular expression applied to each URL in text & HTML parts,
+// and matches are replaced with Replace, which may contain regexp group references such as $1.
+type URLRule struct {
+	Match   string
+	Replace string
+}
+
+// Data is the data available to header & subject templates
+type Data struct {
+	// Subject is the decoded original subject
+	Subject string
+	// From is the SMTP sender address
+	From string
+	// To is the comma-separated SMTP recipient addresses
+	To string
+	// MessageID is the original Message-ID header without angle brackets
+	MessageID string
+}
+
+// New returns the compiled rewriting rules, or nil if no rules are set. Header names in removeHeaders
+// may end with * to match a prefix, and setHeaders & subject values are text/template templates using Data.
+func New(removeHeaders []string, setHeaders map[string]string, subject string, urls []URLRule) (*Rules, error) {
+	if len(removeHeaders) == 0 && len(setHeaders) == 0 && subject == "" && len(urls) == 0 {
+		return nil, nil
+	}
+
+	r := &Rules{}
+
+	for _, h := range removeHeaders {
+		if h = strings.TrimSpace(h); h != "" {
+			r.removeHeaders = append(r.removeHeaders, h)
+		}
+	}
+
+	names := []string{}
+	for name := range setHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t, err := template.New(name).Parse(setHeaders[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header template: %s", name, err.Error())
+		}
+
+		r.setHeaders = append(r.setHeaders, header{name: strings.TrimSpace(name), value: t})
+	}
+
+	if subject != "" {
+		t, err := template.New("subject").Parse(subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject template: %s", err.Error())
+		}
+
+		r.subject = t
+	}
+
+	for _, u := range urls {
+		re, err := regexp.Compile(u.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL rewrite regexp: %s", err.Error())
+		}
+
+		r.urls = append(r.urls, urlRule{match: re, replace: u.Replace})
+	}
+
+	return r, nil
+}
+
+// Apply returns the message with the rules applied. Headers are removed before headers are set,
+// and URLs are rewritten in all text/plain & text/html parts which are not attachments.
+func (r *Rules) Apply(msg []byte, from string, to []string) ([]byte, error) {
+	if r == nil {
+		return msg, nil
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+
+	data := Data{
+		Subject:   subject,
+		From:      from,
+		To:        strings.Join(to, ", "),
+		MessageID: strings.Trim(m.Header.Get("Message-ID"), "<>"),
+	}
+
+	for _, name := range r.removeHeaders {
+		msg, err = removeHeaders(msg, m.Header, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, h := range r.setHeaders {
+		msg, err = setHeader(msg, h.name, h.value, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.subject != nil {
+		msg, err = setHeader(msg, "Subject", r.subject, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(r.urls) > 0 {
+		if rewritten, ok, err := r.rewriteEntity(msg); err != nil {
+			return nil, err
+		} else if ok {
+			msg = rewritten
+		}
+	}
+
+	return msg, nil
+}
+
+// removeHeaders removes all instances of a header, or headers matching a prefix ending with *
+func removeHeaders(msg []byte, h mail.Header, name string) ([]byte, error) {
+	var err error
+
+	for key, values := range h {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			if !strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+				continue
+			}
+		} else if !strings.EqualFold(key, name) {
+			continue
+		}
+
+		// RemoveMessageHeaders removes a single instance of the header
+		for range values {
+			msg, err = tools.RemoveMessageHeaders(msg, []string{key})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return msg, nil
+}
+
+// setHeader adds or replaces a header using the template, encoding non-ASCII values
+func setHeader(msg []byte, name string, t *template.Template, data Data) ([]byte, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("error rewriting %s header: %s", name, err.Error())
+	}
+
+	value := strings.Join(strings.Fields(b.String()), " ")
+
+	return tools.SetMessageHeader(msg, name, mime.QEncoding.Encode("utf-8", value))
+}
+
+// rewriteEntity returns the raw MIME entity with the URLs rewritten in all text/plain & text/html parts,
+// and whether anything was rewritten
+func (r *Rules) rewriteEntity(b []byte) ([]byte, bool, error) {
+	e, err := tools.ParseMIMEEntity(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(e.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return r.rewriteMultipart(e, params["boundary"])
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return b, false, nil
+	}
+
+	if disposition, _, _ := mime.ParseMediaType(e.Header.Get("Content-Disposition")); disposition == "attachment" {
+		return b, false, nil
+	}
+
+	body, err := e.DecodedBody()
+	if err != nil {
+		return nil, false, err
+	}
+
+	rewritten := r.rewriteURLs(body)
+	if bytes.Equal(rewritten, body) {
+		return b, false, nil
+	}
+
+	encoded, err := encodeBody(rewritten, e.Header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return append(e.RawHeader, encoded...), true, nil
+}
+
+// rewriteMultipart rewrites the parts of a multipart entity, preserving the preamble & epilogue
+func (r *Rules) rewriteMultipart(e tools.MIMEEntity, boundary string) ([]byte, bool, error) {
+	if boundary == "" {
+		return append(e.RawHeader, e.Body...), false, nil
+	}
+
+	parts := tools.SplitMultipart(e.Body, boundary)
+	changed := false
+
+	for i, part := range parts {
+		rewritten, ok, err := r.rewriteEntity(part)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			parts[i] = rewritten
+			changed = true
+		}
+	}
+
+	if !changed {
+		return append(e.RawHeader, e.Body...), false, nil
+	}
+
+	var b bytes.Buffer
+	b.Write(e.RawHeader)
+	if i := bytes.Index(e.Body, []byte("--"+boundary)); i > 0 {
+		b.Write(e.Body[:i])
+	}
+	for _, part := range parts {
+		b.WriteString("--" + boundary + "\r\n")
+		b.Write(part)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--" + boundary + "--")
+	if epilogue, ok := tools.MultipartEpilogue(e.Body, boundary); ok {
+		b.Write(epilogue)
+	} else {
+		b.WriteString("\r\n")
+	}
+
+	return b.Bytes(), true, nil
+}
+
+// rewriteURLs applies the URL rules to each URL in the content
+func (r *Rules) rewriteURLs(content []byte) []byte {
+	return urlRegexp.ReplaceAllFunc(content, func(u []byte) []byte {
+		for _, rule := range r.urls {
+			u = rule.match.ReplaceAll(u, []byte(rule.replace))
+		}
+
+		return u
+	})
+}
+
+// encodeBody encodes the body using the Content-Transfer-Encoding
+func encodeBody(body []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		s := base64.StdEncoding.EncodeToString(body)
+		var b strings.Builder
+		for len(s) > 76 {
+			b.WriteString(s[:76] + "\r\n")
+			s = s[76:]
+		}
+		b.WriteString(s + "\r\n")
+		return []byte(b.String()), nil
+	case "quoted-printable":
+		var b bytes.Buffer
+		w := quotedprintable.NewWriter(&b)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	return body, nil
+}
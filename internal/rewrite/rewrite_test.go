@@ -0,0 +1,141 @@
+package rewrite
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+var testMessage = "From: sender@example.com\r\n" +
+	"To: user@example.com\r\n" +
+	"Subject: =?utf-8?q?Caf=C3=A9_news?=\r\n" +
+	"Message-ID: <abc@example.com>\r\n" +
+	"X-Internal-ID: 1234\r\n" +
+	"X-Internal-Trace: a\r\n" +
+	"X-Internal-Trace: b\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"This is a multi-part message.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Visit https://track.example.com/c/42 now\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<a href=3D\"https://track.example.com/c/42?u=3D1\">Visit</a>\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=\"links.txt\"\r\n" +
+	"\r\n" +
+	"https://track.example.com/c/1\r\n" +
+	"--b1--\r\n"
+
+func TestApply(t *testing.T) {
+	r, err := New(
+		[]string{"X-Internal-*"},
+		map[string]string{"X-Original-To": "{{ .To }}"},
+		"[STAGING] {{ .Subject }}",
+		[]URLRule{{Match: `^https://track\.example\.com/c/(\d+)`, Replace: "https://safe.example.net/$1"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.Apply([]byte(testMessage), "sender@example.com", []string{"user@example.com", "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Header["X-Internal-Id"]) > 0 || len(m.Header["X-Internal-Trace"]) > 0 {
+		t.Error("expected X-Internal-* headers to be removed")
+	}
+
+	if to := m.Header.Get("X-Original-To"); to != "user@example.com, other@example.com" {
+		t.Errorf("unexpected X-Original-To header %q", to)
+	}
+
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); subject != "[STAGING] Café news" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+
+	body := string(msg)
+	if !strings.Contains(body, "Visit https://safe.example.net/42 now") {
+		t.Error("expected text URL to be rewritten")
+	}
+
+	if !strings.Contains(body, "https://track.example.com/c/1") {
+		t.Error("expected attachment to be unchanged")
+	}
+
+	if !strings.Contains(body, "This is a multi-part message.") {
+		t.Error("expected preamble to be preserved")
+	}
+
+	i := strings.Index(body, "<a href")
+	html, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body[i : strings.Index(body[i:], "\r\n--b1")+i])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(html) != `<a href="https://safe.example.net/42?u=1">Visit</a>` {
+		t.Errorf("unexpected HTML part %q", html)
+	}
+}
+
+func TestApplyEpilogue(t *testing.T) {
+	r, err := New(nil, nil, "", []URLRule{{Match: `^https://track\.example\.com/c/(\d+)`, Replace: "https://safe.example.net/$1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := "From: sender@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Visit https://track.example.com/c/42 now\r\n" +
+		"--b1--  \r\n" +
+		"This is the epilogue.\r\n"
+
+	msg, err := r.Apply([]byte(message), "sender@example.com", []string{"user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Replace(message, "https://track.example.com/c/42", "https://safe.example.net/42", 1)
+	if string(msg) != expected {
+		t.Errorf("unexpected message %q, expected %q", msg, expected)
+	}
+}
+
+func TestNew(t *testing.T) {
+	r, err := New(nil, nil, "", nil)
+	if err != nil || r != nil {
+		t.Error("expected no rules")
+	}
+
+	msg, err := r.Apply([]byte(testMessage), "", nil)
+	if err != nil || string(msg) != testMessage {
+		t.Error("expected nil rules to return the message unchanged")
+	}
+
+	if _, err := New(nil, nil, "{{ .Subject", nil); err == nil {
+		t.Error("expected an error for an invalid subject template")
+	}
+
+	if _, err := New(nil, nil, "", []URLRule{{Match: "("}}); err == nil {
+		t.Error("expected an error for an invalid URL regexp")
+	}
+}
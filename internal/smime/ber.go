@@ -0,0 +1,145 @@
+package smime
+
+import (
+	"errors"
+)
+
+// errBER is returned for invalid BER data
+var errBER = errors.New("invalid BER encoding")
+
+// berToDER converts BER encoded data to DER as required by encoding/asn1. Many S/MIME clients use
+// indefinite lengths & constructed OCTET STRINGs, which are converted to definite lengths &
+// primitive OCTET STRINGs respectively.
+func berToDER(b []byte) ([]byte, error) {
+	ident, content, _, err := berElement(b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendTLV(nil, ident, content), nil
+}
+
+// berElement parses a single BER element, returning its identifier octets, DER content & the remaining data
+func berElement(b []byte, depth int) ([]byte, []byte, []byte, error) {
+	if depth > 32 || len(b) < 2 {
+		return nil, nil, nil, errBER
+	}
+
+	// identifier octets, including high tag numbers
+	pos := 1
+	if b[0]&0x1f == 0x1f {
+		for pos < len(b) && b[pos]&0x80 != 0 {
+			pos++
+		}
+		pos++
+	}
+	if pos >= len(b) {
+		return nil, nil, nil, errBER
+	}
+
+	ident := b[:pos]
+	constructed := b[0]&0x20 != 0
+
+	length := int(b[pos])
+	pos++
+
+	var children [][]byte
+	var rest []byte
+
+	switch {
+	case length == 0x80:
+		// indefinite length, terminated by end-of-contents octets
+		if !constructed {
+			return nil, nil, nil, errBER
+		}
+		rest = b[pos:]
+		for {
+			if len(rest) < 2 {
+				return nil, nil, nil, errBER
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			childIdent, childContent, r, err := berElement(rest, depth+1)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			children = append(children, appendTLV(nil, childIdent, childContent))
+			rest = r
+		}
+	default:
+		if length&0x80 != 0 {
+			n := length & 0x7f
+			if n > 4 || pos+n > len(b) {
+				return nil, nil, nil, errBER
+			}
+			length = 0
+			for _, c := range b[pos : pos+n] {
+				length = length<<8 | int(c)
+			}
+			pos += n
+		}
+		if length < 0 || pos+length > len(b) {
+			return nil, nil, nil, errBER
+		}
+
+		content := b[pos : pos+length]
+		rest = b[pos+length:]
+
+		if !constructed {
+			return ident, content, rest, nil
+		}
+
+		for len(content) > 0 {
+			childIdent, childContent, r, err := berElement(content, depth+1)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			children = append(children, appendTLV(nil, childIdent, childContent))
+			content = r
+		}
+	}
+
+	// constructed universal OCTET STRING, concatenate the primitive segments
+	if len(ident) == 1 && ident[0] == 0x24 {
+		octets := []byte{}
+		for _, c := range children {
+			_, segment, _, err := berElement(c, depth+1)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			octets = append(octets, segment...)
+		}
+		return []byte{0x04}, octets, rest, nil
+	}
+
+	content := []byte{}
+	for _, c := range children {
+		content = append(content, c...)
+	}
+
+	return ident, content, rest, nil
+}
+
+// appendTLV appends the DER encoded element to b
+func appendTLV(b, ident, content []byte) []byte {
+	b = append(b, ident...)
+
+	l := len(content)
+	switch {
+	case l < 0x80:
+		b = append(b, byte(l))
+	default:
+		n := 0
+		for v := l; v > 0; v >>= 8 {
+			n++
+		}
+		b = append(b, 0x80|byte(n))
+		for i := n - 1; i >= 0; i-- {
+			b = append(b, byte(l>>(8*i)))
+		}
+	}
+
+	return append(b, content...)
+}
@@ -0,0 +1,433 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" // #nosec - 3DES encrypted messages are still decrypted
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1" // #nosec - SHA-1 signatures are still verified
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAESOAEP     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidRSAPSS        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+
+	// digest algorithms, also used for the OAEP & PSS hash parameters
+	digestAlgorithms = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+)
+
+// contentInfo is the outer CMS structure (RFC 5652 section 3)
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"` // [0] EXPLICIT, see explicitContent()
+}
+
+// signedData is the CMS SignedData structure (RFC 5652 section 5.1)
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional,tag:0"` // [0] EXPLICIT, see explicitContent()
+}
+
+// signerInfo is a CMS SignerInfo structure (RFC 5652 section 5.3)
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// envelopedData is the CMS EnvelopedData structure (RFC 5652 section 6.1)
+type envelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// keyTransRecipientInfo is a CMS KeyTransRecipientInfo structure (RFC 5652 section 6.2.1)
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type oaepParameters struct {
+	Hash pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:0"`
+	MGF  pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:1"`
+}
+
+// parseContentInfo parses a BER or DER encoded CMS ContentInfo
+func parseContentInfo(b []byte) (contentInfo, error) {
+	ci := contentInfo{}
+
+	der, err := berToDER(b)
+	if err != nil {
+		return ci, err
+	}
+
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return ci, fmt.Errorf("invalid PKCS#7 content: %s", err.Error())
+	}
+
+	if ci.Content, err = explicitContent(ci.Content); err != nil {
+		return ci, fmt.Errorf("invalid PKCS#7 content: %s", err.Error())
+	}
+
+	return ci, nil
+}
+
+// explicitContent returns the element within an (optional) explicitly tagged value. encoding/asn1 does
+// not unwrap explicit tags for RawValue fields.
+func explicitContent(v asn1.RawValue) (asn1.RawValue, error) {
+	var inner asn1.RawValue
+	if len(v.FullBytes) == 0 {
+		return inner, nil
+	}
+
+	_, err := asn1.Unmarshal(v.Bytes, &inner)
+
+	return inner, err
+}
+
+// octetString returns the octets of a (possibly implicitly tagged or constructed) OCTET STRING
+func octetString(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.Bytes, nil
+	}
+
+	octets := []byte{}
+	rest := v.Bytes
+	for len(rest) > 0 {
+		var segment asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &segment); err != nil {
+			return nil, err
+		}
+		b, err := octetString(segment)
+		if err != nil {
+			return nil, err
+		}
+		octets = append(octets, b...)
+	}
+
+	return octets, nil
+}
+
+// certificates returns the certificates included in the SignedData
+func (sd signedData) certificates() []*x509.Certificate {
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil
+	}
+
+	certs := []*x509.Certificate{}
+	rest := sd.Certificates.Bytes
+	for len(rest) > 0 {
+		var raw asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+			break
+		}
+		// other certificate formats (eg: attribute certificates) are ignored
+		if cert, err := x509.ParseCertificate(raw.FullBytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+
+	return certs
+}
+
+// matchesIdentifier returns whether the certificate matches a SignerIdentifier or RecipientIdentifier,
+// either an IssuerAndSerialNumber or [0] SubjectKeyIdentifier
+func matchesIdentifier(cert *x509.Certificate, id asn1.RawValue) bool {
+	if id.Class == asn1.ClassContextSpecific && id.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, id.Bytes)
+	}
+
+	var ias issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(id.FullBytes, &ias); err != nil || ias.SerialNumber == nil {
+		return false
+	}
+
+	return bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) && cert.SerialNumber.Cmp(ias.SerialNumber) == 0
+}
+
+// verifySignerInfo verifies the signature of the content, returning the signer certificate & signing time (if set)
+func verifySignerInfo(si signerInfo, contentType asn1.ObjectIdentifier, content []byte, certs []*x509.Certificate) (*x509.Certificate, *time.Time, error) {
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if matchesIdentifier(c, si.SID) {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, nil, errors.New("signer certificate not found")
+	}
+
+	h, ok := digestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return cert, nil, fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+
+	digest := h.New()
+	digest.Write(content)
+	signed := content
+
+	var signingTime *time.Time
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		var messageDigest []byte
+		var attrContentType asn1.ObjectIdentifier
+
+		rest := si.SignedAttrs.Bytes
+		for len(rest) > 0 {
+			var attr attribute
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+				return cert, nil, fmt.Errorf("invalid signed attributes: %s", err.Error())
+			}
+
+			switch {
+			case attr.Type.Equal(oidAttributeMessageDigest):
+				_, err = asn1.Unmarshal(attr.Values.Bytes, &messageDigest)
+			case attr.Type.Equal(oidAttributeContentType):
+				_, err = asn1.Unmarshal(attr.Values.Bytes, &attrContentType)
+			case attr.Type.Equal(oidAttributeSigningTime):
+				var t time.Time
+				if _, err = asn1.Unmarshal(attr.Values.Bytes, &t); err == nil {
+					signingTime = &t
+				}
+			}
+			if err != nil {
+				return cert, nil, fmt.Errorf("invalid signed attribute %s: %s", attr.Type, err.Error())
+			}
+		}
+
+		if !bytes.Equal(messageDigest, digest.Sum(nil)) {
+			return cert, signingTime, errors.New("message digest mismatch, the message content has been modified")
+		}
+		if !attrContentType.Equal(contentType) {
+			return cert, signingTime, errors.New("content type attribute mismatch")
+		}
+
+		// the signature is over the DER encoded SET OF attributes, not the implicit [0] tag
+		signed = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+		digest = h.New()
+		digest.Write(signed)
+	}
+
+	hashed := digest.Sum(nil)
+
+	var err error
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if si.SignatureAlgorithm.Algorithm.Equal(oidRSAPSS) {
+			err = rsa.VerifyPSS(pub, h, hashed, si.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, h, hashed, si.Signature)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, hashed, si.Signature) {
+			err = errors.New("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, signed, si.Signature) {
+			err = errors.New("invalid signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	if err != nil {
+		return cert, signingTime, fmt.Errorf("signature did not verify: %s", err.Error())
+	}
+
+	return cert, signingTime, nil
+}
+
+// decryptEnvelopedData decrypts the EnvelopedData content using the first matching recipient key
+func decryptEnvelopedData(content asn1.RawValue, recipients []recipient) ([]byte, error) {
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(content.FullBytes, &ed); err != nil {
+		return nil, fmt.Errorf("invalid enveloped data: %s", err.Error())
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("no S/MIME private keys configured")
+	}
+
+	var key []byte
+	for _, ri := range ed.RecipientInfos {
+		// only key transport (RSA) recipients are supported
+		if ri.Class != asn1.ClassUniversal || ri.Tag != asn1.TagSequence {
+			continue
+		}
+
+		var ktri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+			continue
+		}
+
+		for _, r := range recipients {
+			if r.cert != nil && !matchesIdentifier(r.cert, ktri.RID) {
+				continue
+			}
+			if k, err := decryptKey(r.key, ktri); err == nil {
+				key = k
+				break
+			}
+		}
+
+		if key != nil {
+			break
+		}
+	}
+
+	if key == nil {
+		return nil, errors.New("no matching private key found to decrypt the message")
+	}
+
+	ciphertext, err := octetString(ed.EncryptedContentInfo.EncryptedContent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted content: %s", err.Error())
+	}
+
+	return decryptContent(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, key, ciphertext)
+}
+
+// decryptKey decrypts the content-encryption key of a KeyTransRecipientInfo
+func decryptKey(key crypto.Decrypter, ktri keyTransRecipientInfo) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("not an RSA key")
+	}
+
+	alg := ktri.KeyEncryptionAlgorithm
+	switch {
+	case alg.Algorithm.Equal(oidRSAEncryption):
+		return key.Decrypt(rand.Reader, ktri.EncryptedKey, nil)
+	case alg.Algorithm.Equal(oidRSAESOAEP):
+		opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+		if len(alg.Parameters.FullBytes) > 0 {
+			var params oaepParameters
+			if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+				return nil, err
+			}
+			if params.Hash.Algorithm != nil {
+				h, ok := digestAlgorithms[params.Hash.Algorithm.String()]
+				if !ok {
+					return nil, fmt.Errorf("unsupported OAEP hash %s", params.Hash.Algorithm)
+				}
+				opts.Hash, opts.MGFHash = h, h
+			}
+			var mgfHash pkix.AlgorithmIdentifier
+			if _, err := asn1.Unmarshal(params.MGF.Parameters.FullBytes, &mgfHash); err == nil {
+				if h, ok := digestAlgorithms[mgfHash.Algorithm.String()]; ok {
+					opts.MGFHash = h
+				}
+			}
+		}
+		return key.Decrypt(rand.Reader, ktri.EncryptedKey, opts)
+	}
+
+	return nil, fmt.Errorf("unsupported key encryption algorithm %s", alg.Algorithm)
+}
+
+// decryptContent decrypts CBC encrypted content, removing the padding
+func decryptContent(alg pkix.AlgorithmIdentifier, key, ciphertext []byte) ([]byte, error) {
+	var block cipher.Block
+	var err error
+
+	switch {
+	case alg.Algorithm.Equal(oidAES128CBC), alg.Algorithm.Equal(oidAES192CBC), alg.Algorithm.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(key)
+	case alg.Algorithm.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(key) // #nosec
+	default:
+		return nil, fmt.Errorf("unsupported content encryption algorithm %s", alg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, errors.New("invalid content encryption IV")
+	}
+
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted content length")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding, the message could not be decrypted")
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,447 @@
+// Package smime verifies the signatures of, and decrypts S/MIME messages (RFC 8551)
+package smime
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+const (
+	// StatusPass is returned when all signatures are valid & trusted
+	StatusPass = "pass"
+	// StatusFail is returned when a signature is invalid or not trusted
+	StatusFail = "fail"
+	// StatusNone is returned when a message is not signed
+	StatusNone = "none"
+
+	// maxLayers is the maximum number of nested S/MIME layers (eg: signed & encrypted) processed
+	maxLayers = 5
+)
+
+var (
+	// ErrNotSMIME is returned when a message does not contain an S/MIME (application/pkcs7-mime) entity
+	ErrNotSMIME = errors.New("not an S/MIME message")
+
+	// roots are the CA certificates used to verify signer certificates, nil uses the system roots
+	roots *x509.CertPool
+
+	// recipients are the private keys used to decrypt messages
+	recipients []recipient
+)
+
+// recipient is a private key & (optional) certificate used for decryption
+type recipient struct {
+	key  crypto.Decrypter
+	cert *x509.Certificate
+}
+
+// Report is the S/MIME report of a message
+//
+// swagger:model SMIMEResponse
+type Report struct {
+	// Whether the message is encrypted (enveloped-data)
+	Encrypted bool
+	// Whether the encrypted message was decrypted using a configured private key
+	Decrypted bool
+	// Whether the message (or decrypted message) is signed
+	Signed bool
+	// Signature status: pass (all signatures valid & trusted), fail or none (not signed)
+	// example: pass
+	Status string
+	// Error processing the message, eg: decryption failure
+	Error string
+	// Verification results of each signer
+	Signers []Signer
+}
+
+// Signer is the verification result of a single signer
+//
+// swagger:model SMIMESigner
+type Signer struct {
+	// Whether the signature is valid
+	Valid bool
+	// Whether the signer certificate is trusted by the CA bundle (or system roots)
+	Trusted bool
+	// Error or reason for an invalid or untrusted signature
+	Error string
+	// Signing time (if set)
+	SigningTime *time.Time `json:",omitempty"`
+	// Signer certificate
+	Certificate *Certificate `json:",omitempty"`
+}
+
+// Certificate contains the details of a signer certificate
+//
+// swagger:model SMIMECertificate
+type Certificate struct {
+	// Certificate subject
+	// example: CN=Finance,O=Example Ltd
+	Subject string
+	// Certificate issuer
+	Issuer string
+	// Serial number (hex)
+	SerialNumber string
+	// Email addresses
+	EmailAddresses []string
+	// Valid from
+	NotBefore time.Time
+	// Valid until
+	NotAfter time.Time
+	// SHA-256 fingerprint (hex)
+	Fingerprint string
+}
+
+// Load the CA bundle used to verify signer certificates (else the system roots are used), and the
+// PEM file of private keys (with optional matching certificates) used to decrypt messages
+func Load(caFile, keysFile string) error {
+	roots, recipients = nil, nil
+
+	if caFile != "" {
+		data, err := os.ReadFile(filepath.Clean(caFile))
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+		roots = pool
+	}
+
+	if keysFile != "" {
+		data, err := os.ReadFile(filepath.Clean(keysFile))
+		if err != nil {
+			return err
+		}
+
+		r, err := parseKeys(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", keysFile, err.Error())
+		}
+		recipients = r
+	}
+
+	return nil
+}
+
+// parseKeys parses the PEM encoded RSA private keys, pairing each with its certificate (if included)
+func parseKeys(data []byte) ([]recipient, error) {
+	keys := []recipient{}
+	certs := []*x509.Certificate{}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, recipient{key: key})
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("unsupported private key type %T, expected RSA", key)
+			}
+			keys = append(keys, recipient{key: rsaKey})
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no private keys found")
+	}
+
+	for i, k := range keys {
+		pub := k.key.Public().(*rsa.PublicKey)
+		for _, c := range certs {
+			if pub.Equal(c.PublicKey) {
+				keys[i].cert = c
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// Check verifies the S/MIME signatures of a raw message, decrypting it first if encrypted
+func Check(msg []byte) Report {
+	report := Report{Status: StatusNone, Signers: []Signer{}}
+
+	e, err := tools.ParseMIMEEntity(msg)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	for range maxLayers {
+		mediaType, params, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+
+		var content []byte
+		switch {
+		case mediaType == "multipart/signed" && isPKCS7Signature(params["protocol"]):
+			report.Signed = true
+			content, err = report.verifyDetached(e.Body, params["boundary"])
+		case isPKCS7Mime(mediaType):
+			content, err = openPKCS7(e, &report)
+		default:
+			return report
+		}
+
+		if err != nil {
+			report.Error = err.Error()
+			if report.Signed {
+				report.Status = StatusFail
+			}
+			return report
+		}
+
+		// the content may itself be an S/MIME entity, eg: signed then encrypted
+		if e, err = tools.ParseMIMEEntity(content); err != nil {
+			return report
+		}
+	}
+
+	return report
+}
+
+// Unwrap returns the message with its S/MIME (application/pkcs7-mime) content decrypted or
+// extracted, so it can be parsed as a regular message. Detached (multipart/signed) signatures are left intact.
+func Unwrap(msg []byte) ([]byte, error) {
+	outer, err := tools.ParseMIMEEntity(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := outer
+	unwrapped := false
+	for range maxLayers {
+		mediaType, _, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+		if !isPKCS7Mime(mediaType) {
+			break
+		}
+
+		content, err := openPKCS7(e, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if e, err = tools.ParseMIMEEntity(content); err != nil {
+			return nil, err
+		}
+		unwrapped = true
+	}
+
+	if !unwrapped {
+		return nil, ErrNotSMIME
+	}
+
+	return outer.ReplaceContent(e), nil
+}
+
+// openPKCS7 returns the decrypted or signed content of an application/pkcs7-mime entity,
+// adding the results to the report (if set)
+func openPKCS7(e tools.MIMEEntity, report *Report) ([]byte, error) {
+	body, err := e.DecodedBody()
+	if err != nil {
+		return nil, err
+	}
+
+	ci, err := parseContentInfo(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ci.ContentType.Equal(oidEnvelopedData):
+		if report != nil {
+			report.Encrypted = true
+		}
+		content, err := decryptEnvelopedData(ci.Content, recipients)
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			report.Decrypted = true
+		}
+		return content, nil
+	case ci.ContentType.Equal(oidSignedData):
+		if report != nil {
+			report.Signed = true
+		}
+		return report.verifySignedData(ci.Content, nil)
+	}
+
+	return nil, fmt.Errorf("unsupported PKCS#7 content type %s", ci.ContentType)
+}
+
+// verifyDetached verifies a multipart/signed entity body, returning the signed content
+func (report *Report) verifyDetached(body []byte, boundary string) ([]byte, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart/signed boundary not set")
+	}
+
+	parts := tools.SplitMultipart(body, boundary)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("multipart/signed requires 2 parts, found %d", len(parts))
+	}
+
+	sigEntity, err := tools.ParseMIMEEntity(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := sigEntity.DecodedBody()
+	if err != nil {
+		return nil, err
+	}
+
+	ci, err := parseContentInfo(sig)
+	if err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("signature is not PKCS#7 signed data")
+	}
+
+	return report.verifySignedData(ci.Content, parts[0])
+}
+
+// verifySignedData verifies the signatures of the SignedData, adding the results to the report (if set),
+// and returns the signed content (or detached content if set)
+func (report *Report) verifySignedData(content asn1.RawValue, detached []byte) ([]byte, error) {
+	var sd signedData
+	if _, err := asn1.Unmarshal(content.FullBytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid signed data: %s", err.Error())
+	}
+
+	signed := detached
+	if signed == nil {
+		eContent, err := explicitContent(sd.EncapContentInfo.EContent)
+		if err == nil {
+			signed, err = octetString(eContent)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid signed content: %s", err.Error())
+		}
+	}
+
+	if report == nil {
+		return signed, nil
+	}
+
+	certs := sd.certificates()
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+
+	if len(sd.SignerInfos) == 0 {
+		report.Status = StatusFail
+		report.Error = "no signers found"
+	}
+
+	for _, si := range sd.SignerInfos {
+		signer := Signer{}
+		cert, signingTime, err := verifySignerInfo(si, sd.EncapContentInfo.EContentType, signed, certs)
+		signer.SigningTime = signingTime
+		if cert != nil {
+			signer.Certificate = certificateDetails(cert)
+		}
+
+		if err != nil {
+			signer.Error = err.Error()
+		} else {
+			signer.Valid = true
+
+			// certificates are verified at the time of signing (if set)
+			verifyTime := time.Now()
+			if signingTime != nil {
+				verifyTime = *signingTime
+			}
+			if _, err := cert.Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				CurrentTime:   verifyTime,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+			}); err != nil {
+				signer.Error = "certificate not trusted: " + err.Error()
+			} else {
+				signer.Trusted = true
+			}
+		}
+
+		if signer.Valid && signer.Trusted && report.Status != StatusFail {
+			report.Status = StatusPass
+		} else if !signer.Valid || !signer.Trusted {
+			report.Status = StatusFail
+		}
+
+		report.Signers = append(report.Signers, signer)
+	}
+
+	return signed, nil
+}
+
+// certificateDetails returns the details of a certificate
+func certificateDetails(cert *x509.Certificate) *Certificate {
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	emails := append([]string{}, cert.EmailAddresses...)
+	// legacy certificates include the email address in the subject
+	for _, name := range cert.Subject.Names {
+		if email, ok := name.Value.(string); ok && name.Type.Equal(oidEmailAddress) && !slices.Contains(emails, email) {
+			emails = append(emails, email)
+		}
+	}
+
+	return &Certificate{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		SerialNumber:   hex.EncodeToString(cert.SerialNumber.Bytes()),
+		EmailAddresses: emails,
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		Fingerprint:    hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+func isPKCS7Mime(mediaType string) bool {
+	return mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime"
+}
+
+func isPKCS7Signature(protocol string) bool {
+	protocol = strings.ToLower(protocol)
+	return protocol == "application/pkcs7-signature" || protocol == "application/x-pkcs7-signature"
+}
@@ -0,0 +1,130 @@
+package smime
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+func readFile(t *testing.T, file string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestCheckSigned(t *testing.T) {
+	if err := Load("testdata/ca.pem", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Load("", "") }()
+
+	for _, file := range []string{"testdata/signed.eml", "testdata/opaque.eml"} {
+		r := Check(readFile(t, file))
+		if r.Status != StatusPass || !r.Signed || r.Encrypted || len(r.Signers) != 1 {
+			t.Errorf("%s: expected a single trusted signature, got %+v", file, r)
+			continue
+		}
+
+		s := r.Signers[0]
+		if !s.Valid || !s.Trusted || s.SigningTime == nil || s.Certificate == nil ||
+			strings.Join(s.Certificate.EmailAddresses, ",") != "signer@example.com" ||
+			!strings.Contains(s.Certificate.Subject, "CN=signer") {
+			t.Errorf("%s: unexpected signer %+v", file, s)
+		}
+	}
+
+	// modified content
+	tampered := bytes.Replace(readFile(t, "testdata/signed.eml"), []byte("Your statement"), []byte("The statement"), 1)
+	r := Check(tampered)
+	if r.Status != StatusFail || r.Signers[0].Valid {
+		t.Errorf("expected a modified message to fail, got %+v", r)
+	}
+
+	// not signed by a trusted CA
+	if err := Load("testdata/recipient.pem", ""); err != nil {
+		t.Fatal(err)
+	}
+	r = Check(readFile(t, "testdata/signed.eml"))
+	if r.Status != StatusFail || !r.Signers[0].Valid || r.Signers[0].Trusted {
+		t.Errorf("expected a valid but untrusted signature, got %+v", r)
+	}
+
+	r = Check([]byte("From: sender@example.com\r\nSubject: test\r\n\r\nHello\r\n"))
+	if r.Status != StatusNone || r.Signed || r.Encrypted {
+		t.Errorf("expected an unsigned message, got %+v", r)
+	}
+}
+
+func TestCheckEncrypted(t *testing.T) {
+	msg := readFile(t, "testdata/encrypted.eml")
+
+	// no private keys
+	if err := Load("testdata/ca.pem", ""); err != nil {
+		t.Fatal(err)
+	}
+	r := Check(msg)
+	if !r.Encrypted || r.Decrypted || r.Error == "" {
+		t.Errorf("expected a decryption error, got %+v", r)
+	}
+	if _, err := Unwrap(msg); err == nil {
+		t.Error("expected unwrap to fail without private keys")
+	}
+
+	if err := Load("testdata/ca.pem", "testdata/recipient.pem"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Load("", "") }()
+
+	// signed then encrypted
+	r = Check(msg)
+	if !r.Encrypted || !r.Decrypted || !r.Signed || r.Status != StatusPass || len(r.Signers) != 1 {
+		t.Errorf("expected a decrypted & signed message, got %+v", r)
+	}
+
+	// RSAES-OAEP (SHA-256) key transport
+	r = Check(readFile(t, "testdata/oaep.eml"))
+	if !r.Encrypted || !r.Decrypted || r.Signed || r.Status != StatusNone {
+		t.Errorf("expected a decrypted OAEP message, got %+v", r)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	if err := Load("", "testdata/recipient.pem"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Load("", "") }()
+
+	for _, file := range []string{"testdata/encrypted.eml", "testdata/opaque.eml", "testdata/oaep.eml"} {
+		b, err := Unwrap(readFile(t, file))
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+
+		e, err := tools.ParseMIMEEntity(b)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+
+		if e.Header.Get("From") != "finance@example.com" || strings.Contains(e.Header.Get("Content-Type"), "pkcs7-mime") {
+			t.Errorf("%s: unexpected headers %v", file, e.Header)
+		}
+		if !strings.Contains(string(b), "Your statement is attached.") {
+			t.Errorf("%s: expected the decrypted content, got %s", file, b)
+		}
+	}
+
+	// detached signatures are left intact
+	if _, err := Unwrap(readFile(t, "testdata/signed.eml")); err != ErrNotSMIME {
+		t.Errorf("expected ErrNotSMIME, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package smtpd
+
+import (
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/smtpd/bounce"
+	"github.com/axllent/mailpit/internal/storage"
+)
+
+// Deliver the bounce & auto-reply messages generated for a received message, if configured
+func autoBounceMessage(from string, to []string, data []byte) {
+	for _, m := range bounce.Process(from, to, data) {
+		deliverBounce(m)
+	}
+}
+
+// Deliver a bounce for recipients rejected by Chaos, if configured
+func chaosBounce(from string, to []string, data []byte, code int, text string) {
+	if m := bounce.ChaosRejection(from, to, data, code, text); m != nil {
+		deliverBounce(*m)
+	}
+}
+
+// deliverBounce stores or queues a generated message for relaying with a null sender
+func deliverBounce(m bounce.Message) {
+	if bounce.Delivery() == bounce.DeliveryRelay {
+		if err := relayBounce(m); err != nil {
+			logger.Log().Warnf("[bounce] not relaying message to %s: %s", m.To, err.Error())
+			return
+		}
+
+		logger.Log().Debugf("[bounce] queued message to %s", m.To)
+		return
+	}
+
+	data := append([]byte("Return-Path: <>\r\n"), m.Data...)
+	if _, err := storage.Store(&data, nil, false, nil); err != nil {
+		logger.Log().Errorf("[bounce] error storing message to %s: %s", m.To, err.Error())
+		return
+	}
+
+	logger.Log().Debugf("[bounce] stored message to %s", m.To)
+}
+
+// relayBounce queues a generated message for relaying, provided the recipient is allowed
+// by the relay allowlist & blocklist
+func relayBounce(m bounce.Message) error {
+	if err := checkReleaseRecipients(&config.SMTPRelayConfig, []string{m.To}); err != nil {
+		return err
+	}
+
+	return Enqueue(storage.QueueJob{Type: QueueTypeRelay, To: []string{m.To}}, m.Data)
+}
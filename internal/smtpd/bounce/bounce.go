@@ -0,0 +1,270 @@
+// Package bounce generates delivery status notifications (RFC 3464) and auto-replies (RFC 3834)
+// for received messages matching configured rules
+package bounce
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+const (
+	// TypeBounce generates a delivery status notification to the sender
+	TypeBounce = "bounce"
+	// TypeAutoReply generates an out-of-office style auto-reply to the sender
+	TypeAutoReply = "auto-reply"
+
+	// DeliveryStore stores generated messages in Mailpit
+	DeliveryStore = "store"
+	// DeliveryRelay relays generated messages via the SMTP relay configuration, subject to its allowed & blocked recipients
+	DeliveryRelay = "relay"
+)
+
+var (
+	conf   = Config{Delivery: DeliveryStore}
+	confMu sync.RWMutex
+
+	// statusRE matches an RFC 3463 enhanced status code of a failed or delayed delivery
+	statusRE = regexp.MustCompile(`^[45]\.\d{1,3}\.\d{1,3}$`)
+	// responseStatusRE matches an enhanced status code at the start of an SMTP response text
+	responseStatusRE = regexp.MustCompile(`^([45]\.\d{1,3}\.\d{1,3})(\s|$)`)
+)
+
+// Config is the bounce rules configuration
+type Config struct {
+	// Delivery of generated messages: store (default) or relay
+	Delivery string `yaml:"delivery"`
+
+	// Host name used in generated messages, defaults to the system host name
+	ReportingMTA string `yaml:"reporting-mta"`
+
+	// Generate a delivery status notification when a Chaos rule or trigger rejects a recipient or message
+	ChaosRejections bool `yaml:"chaos-rejections"`
+
+	// Rules are evaluated in order for each recipient, with the first match winning
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule generates a bounce or auto-reply for matching recipients
+type Rule struct {
+	// Rule type: bounce or auto-reply
+	Type string `yaml:"type"`
+
+	// Regular expression matching the sender address (case-insensitive)
+	Sender string `yaml:"sender"`
+
+	// Regular expression matching the recipient address (case-insensitive)
+	Recipient string `yaml:"recipient"`
+
+	// Enhanced status code of a bounce, a 4.x.x status reports a delayed delivery (default 5.1.1)
+	Status string `yaml:"status"`
+
+	// SMTP diagnostic of a bounce (default "550 5.1.1 Mailbox does not exist")
+	Diagnostic string `yaml:"diagnostic"`
+
+	// Subject of an auto-reply, defaults to "Auto: " followed by the original subject
+	Subject string `yaml:"subject"`
+
+	// Plain text body of an auto-reply
+	Text string `yaml:"text"`
+
+	senderRE    *regexp.Regexp
+	recipientRE *regexp.Regexp
+}
+
+// Message is a generated message, sent with a null envelope sender (RFC 3464 & RFC 3834)
+type Message struct {
+	// To is the envelope recipient, ie: the sender of the original message
+	To string
+	// Data is the raw message
+	Data []byte
+}
+
+// compile validates a rule & compiles the regular expressions
+func (r *Rule) compile() error {
+	var err error
+
+	r.Type = strings.ToLower(strings.TrimSpace(r.Type))
+	if r.Type != TypeBounce && r.Type != TypeAutoReply {
+		return fmt.Errorf("invalid type %q, must be one of bounce or auto-reply", r.Type)
+	}
+
+	if r.Recipient == "" {
+		return fmt.Errorf("recipient is required")
+	}
+
+	r.senderRE = nil
+	if r.Sender != "" {
+		if r.senderRE, err = regexp.Compile("(?i)" + r.Sender); err != nil {
+			return fmt.Errorf("invalid sender regular expression: %s", err.Error())
+		}
+	}
+
+	if r.recipientRE, err = regexp.Compile("(?i)" + r.Recipient); err != nil {
+		return fmt.Errorf("invalid recipient regular expression: %s", err.Error())
+	}
+
+	if r.Type == TypeBounce {
+		if r.Status == "" {
+			r.Status = "5.1.1"
+		}
+		if !statusRE.MatchString(r.Status) {
+			return fmt.Errorf("invalid status %q, must be an enhanced status code such as 5.1.1", r.Status)
+		}
+		if r.Diagnostic == "" {
+			r.Diagnostic = "550 5.1.1 Mailbox does not exist"
+		}
+	} else if r.Text == "" {
+		return fmt.Errorf("auto-reply text is required")
+	}
+
+	return nil
+}
+
+// SetConfig validates & sets the configuration (ie: YAML config)
+func SetConfig(c Config) error {
+	c.Delivery = strings.ToLower(strings.TrimSpace(c.Delivery))
+	if c.Delivery == "" {
+		c.Delivery = DeliveryStore
+	}
+	if c.Delivery != DeliveryStore && c.Delivery != DeliveryRelay {
+		return fmt.Errorf("invalid delivery %q, must be one of store or relay", c.Delivery)
+	}
+
+	if c.ReportingMTA == "" {
+		c.ReportingMTA, _ = os.Hostname()
+		if c.ReportingMTA == "" {
+			c.ReportingMTA = "localhost"
+		}
+	}
+
+	for i := range c.Rules {
+		if err := c.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %s", i+1, err.Error())
+		}
+	}
+
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	conf = c
+
+	logger.Log().Infof("[bounce] %d rule(s) set, delivery of generated messages: %s", len(c.Rules), c.Delivery)
+
+	return nil
+}
+
+// Delivery returns the configured delivery of generated messages
+func Delivery() string {
+	confMu.RLock()
+	defer confMu.RUnlock()
+
+	return conf.Delivery
+}
+
+// Process returns the bounce & auto-reply messages generated for a received message.
+// Nothing is generated for messages with a null sender, and auto-replies are not generated
+// for automated messages (RFC 3834 section 2).
+func Process(from string, to []string, data []byte) []Message {
+	confMu.RLock()
+	defer confMu.RUnlock()
+
+	if len(conf.Rules) == 0 || from == "" {
+		return nil
+	}
+
+	e, err := tools.ParseMIMEEntity(data)
+	if err != nil {
+		return nil
+	}
+
+	messages := []Message{}
+	failed := []recipientStatus{}
+	for _, rcpt := range to {
+		r := matchRule(from, rcpt)
+		if r == nil {
+			continue
+		}
+
+		switch r.Type {
+		case TypeBounce:
+			failed = append(failed, recipientStatus{Address: rcpt, Status: r.Status, Diagnostic: r.Diagnostic})
+		case TypeAutoReply:
+			if isAutomated(e.Header) {
+				logger.Log().Debugf("[bounce] not sending auto-reply from %s to automated message from %s", rcpt, from)
+				continue
+			}
+			messages = append(messages, Message{To: from, Data: autoReply(from, rcpt, *r, e.Header)})
+		}
+	}
+
+	if len(failed) > 0 {
+		messages = append(messages, Message{To: from, Data: deliveryStatus(from, failed, e.RawHeader)})
+	}
+
+	return messages
+}
+
+// ChaosRejection returns the delivery status notification of recipients rejected by Chaos, if enabled.
+// The data is nil if the recipients were rejected before the message was sent.
+func ChaosRejection(from string, to []string, data []byte, code int, text string) *Message {
+	confMu.RLock()
+	defer confMu.RUnlock()
+
+	if !conf.ChaosRejections || from == "" || len(to) == 0 {
+		return nil
+	}
+
+	status := fmt.Sprintf("%d.0.0", code/100)
+	if m := responseStatusRE.FindStringSubmatch(text); m != nil {
+		status = m[1]
+	}
+
+	var headers []byte
+	if data != nil {
+		if e, err := tools.ParseMIMEEntity(data); err == nil {
+			headers = e.RawHeader
+		}
+	}
+
+	failed := []recipientStatus{}
+	for _, rcpt := range to {
+		failed = append(failed, recipientStatus{Address: rcpt, Status: status, Diagnostic: fmt.Sprintf("%d %s", code, text)})
+	}
+
+	return &Message{To: from, Data: deliveryStatus(from, failed, headers)}
+}
+
+// matchRule returns the first rule matching the sender & recipient, confMu must be held
+func matchRule(from, rcpt string) *Rule {
+	for i, r := range conf.Rules {
+		if r.senderRE != nil && !r.senderRE.MatchString(from) {
+			continue
+		}
+		if r.recipientRE.MatchString(rcpt) {
+			return &conf.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+// isAutomated returns whether the message headers identify an automatically generated or mailing list message
+func isAutomated(h mail.Header) bool {
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "junk", "list":
+		return true
+	}
+
+	return h.Get("List-Id") != "" || h.Get("List-Unsubscribe") != ""
+}
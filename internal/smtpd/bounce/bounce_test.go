@@ -0,0 +1,150 @@
+package bounce
+
+import (
+	"bytes"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+var testMessage = []byte("From: sender@example.com\r\nTo: bounce+test@example.com, ooo@example.com\r\n" +
+	"Subject: Quarterly report\r\nMessage-ID: <original@example.com>\r\n\r\nHello\r\n")
+
+func setConfig(t *testing.T, c Config) {
+	t.Helper()
+
+	c.ReportingMTA = "mx.example.test"
+	if err := SetConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetConfig(Config{}) })
+}
+
+func TestProcess(t *testing.T) {
+	setConfig(t, Config{Rules: []Rule{
+		{Type: TypeBounce, Recipient: `^bounce\+`},
+		{Type: TypeAutoReply, Recipient: `^ooo@`, Text: "I am out of the office"},
+	}})
+
+	msgs := Process("sender@example.com", []string{"bounce+test@example.com", "ooo@example.com", "other@example.com"}, testMessage)
+	if len(msgs) != 2 {
+		t.Fatalf("expected an auto-reply & a bounce, got %d messages", len(msgs))
+	}
+
+	// auto-reply
+	e, err := tools.ParseMIMEEntity(msgs[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].To != "sender@example.com" || e.Header.Get("From") != "<ooo@example.com>" ||
+		e.Header.Get("Subject") != "Auto: Quarterly report" || e.Header.Get("Auto-Submitted") != "auto-replied" ||
+		e.Header.Get("In-Reply-To") != "<original@example.com>" || !bytes.Contains(e.Body, []byte("I am out of the office")) {
+		t.Errorf("unexpected auto-reply:\n%s", msgs[0].Data)
+	}
+
+	// delivery status notification
+	e, err = tools.ParseMIMEEntity(msgs[1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+	if mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		t.Fatalf("unexpected content type %s", e.Header.Get("Content-Type"))
+	}
+
+	parts := []string{}
+	status := ""
+	r := multipart.NewReader(bytes.NewReader(e.Body), params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		parts = append(parts, p.Header.Get("Content-Type"))
+		if p.Header.Get("Content-Type") == "message/delivery-status" {
+			b := new(bytes.Buffer)
+			_, _ = b.ReadFrom(p)
+			status = b.String()
+		}
+	}
+
+	if strings.Join(parts, ",") != "text/plain; charset=utf-8,message/delivery-status,text/rfc822-headers" {
+		t.Errorf("unexpected parts %v", parts)
+	}
+
+	for _, s := range []string{"Reporting-MTA: dns; mx.example.test", "Final-Recipient: rfc822; bounce+test@example.com",
+		"Action: failed", "Status: 5.1.1", "Diagnostic-Code: smtp; 550 5.1.1 Mailbox does not exist"} {
+		if !strings.Contains(status, s) {
+			t.Errorf("expected %q in delivery status:\n%s", s, status)
+		}
+	}
+
+	if strings.Contains(status, "ooo@example.com") || strings.Contains(status, "other@example.com") {
+		t.Errorf("unexpected recipients in delivery status:\n%s", status)
+	}
+}
+
+func TestProcessLoops(t *testing.T) {
+	setConfig(t, Config{Rules: []Rule{
+		{Type: TypeBounce, Recipient: `^bounce\+`},
+		{Type: TypeAutoReply, Recipient: `^ooo@`, Text: "I am out of the office"},
+	}})
+
+	to := []string{"bounce+test@example.com", "ooo@example.com"}
+
+	// null sender
+	if msgs := Process("", to, testMessage); len(msgs) != 0 {
+		t.Errorf("expected no messages for a null sender, got %d", len(msgs))
+	}
+
+	// automated messages only bounce
+	for _, h := range []string{"Auto-Submitted: auto-generated", "Precedence: bulk", "List-Id: <list.example.com>"} {
+		msgs := Process("sender@example.com", to, append([]byte(h+"\r\n"), testMessage...))
+		if len(msgs) != 1 || !bytes.Contains(msgs[0].Data, []byte("multipart/report")) {
+			t.Errorf("%s: expected a single bounce, got %d messages", h, len(msgs))
+		}
+	}
+}
+
+func TestChaosRejection(t *testing.T) {
+	if m := ChaosRejection("sender@example.com", []string{"rcpt@example.com"}, nil, 550, "5.1.1 Mailbox does not exist"); m != nil {
+		t.Error("expected no message when disabled")
+	}
+
+	setConfig(t, Config{ChaosRejections: true})
+
+	m := ChaosRejection("sender@example.com", []string{"rcpt@example.com"}, nil, 451, "Chaos recipient error")
+	if m == nil {
+		t.Fatal("expected a message")
+	}
+	if !bytes.Contains(m.Data, []byte("Status: 4.0.0")) || !bytes.Contains(m.Data, []byte("Action: delayed")) ||
+		bytes.Contains(m.Data, []byte("text/rfc822-headers")) {
+		t.Errorf("unexpected delivery status:\n%s", m.Data)
+	}
+
+	m = ChaosRejection("sender@example.com", []string{"rcpt@example.com"}, testMessage, 550, "5.7.1 Message rejected")
+	if m == nil {
+		t.Fatal("expected a message")
+	}
+	if !bytes.Contains(m.Data, []byte("Status: 5.7.1")) || !bytes.Contains(m.Data, []byte("Diagnostic-Code: smtp; 550 5.7.1 Message rejected")) ||
+		!bytes.Contains(m.Data, []byte("text/rfc822-headers")) {
+		t.Errorf("unexpected delivery status:\n%s", m.Data)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	for _, c := range []Config{
+		{Delivery: "queue"},
+		{Rules: []Rule{{Type: "reject", Recipient: "."}}},
+		{Rules: []Rule{{Type: TypeBounce}}},
+		{Rules: []Rule{{Type: TypeBounce, Recipient: "."}, {Type: TypeBounce, Recipient: ".", Status: "2.0.0"}}},
+		{Rules: []Rule{{Type: TypeAutoReply, Recipient: "."}}},
+	} {
+		if err := SetConfig(c); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
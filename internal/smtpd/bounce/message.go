@@ -0,0 +1,141 @@
+package bounce
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/internal/shortuuid"
+)
+
+// recipientStatus is the delivery status of a single recipient
+type recipientStatus struct {
+	Address    string
+	Status     string
+	Diagnostic string
+}
+
+// action returns the RFC 3464 action of the status
+func (r recipientStatus) action() string {
+	if strings.HasPrefix(r.Status, "4.") {
+		return "delayed"
+	}
+
+	return "failed"
+}
+
+// deliveryStatus returns a multipart/report delivery status notification (RFC 3464) addressed to the sender.
+// The original message headers are returned as a text/rfc822-headers part if set. confMu must be held.
+func deliveryStatus(from string, recipients []recipientStatus, headers []byte) []byte {
+	now := time.Now()
+
+	delayed := true
+	for _, r := range recipients {
+		if r.action() != "delayed" {
+			delayed = false
+			break
+		}
+	}
+
+	subject := "Undelivered Mail Returned to Sender"
+	summary := "Your message could not be delivered to one or more recipients."
+	if delayed {
+		subject = "Delayed Mail (still being retried)"
+		summary = "Your message could not be delivered yet to one or more recipients, delivery will be retried."
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	// human-readable explanation
+	var text strings.Builder
+	fmt.Fprintf(&text, "This is the mail system at host %s.\r\n\r\n%s\r\n\r\n", conf.ReportingMTA, summary)
+	for _, r := range recipients {
+		fmt.Fprintf(&text, "<%s>: %s\r\n", r.Address, r.Diagnostic)
+	}
+	writeTextPart(w, text.String())
+
+	// machine-readable delivery status
+	var status strings.Builder
+	fmt.Fprintf(&status, "Reporting-MTA: dns; %s\r\nArrival-Date: %s\r\n", conf.ReportingMTA, now.Format(time.RFC1123Z))
+	for _, r := range recipients {
+		fmt.Fprintf(&status, "\r\nFinal-Recipient: rfc822; %s\r\nAction: %s\r\nStatus: %s\r\nDiagnostic-Code: smtp; %s\r\n",
+			r.Address, r.action(), r.Status, r.Diagnostic)
+	}
+	part, _ := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/delivery-status"}})
+	_, _ = part.Write([]byte(status.String()))
+
+	if len(headers) > 0 {
+		part, _ := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/rfc822-headers"}})
+		_, _ = part.Write(headers)
+	}
+
+	_ = w.Close()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: Mail Delivery System <MAILER-DAEMON@%s>\r\n", conf.ReportingMTA)
+	fmt.Fprintf(&b, "To: <%s>\r\n", from)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	writeCommonHeaders(&b, now)
+	fmt.Fprintf(&b, "Content-Type: multipart/report; report-type=delivery-status; boundary=\"%s\"\r\n\r\n", w.Boundary())
+	b.Write(body.Bytes())
+
+	return b.Bytes()
+}
+
+// autoReply returns an auto-reply (RFC 3834) from the recipient to the sender of the original message
+func autoReply(from, rcpt string, r Rule, original mail.Header) []byte {
+	subject := r.Subject
+	if subject == "" {
+		dec := new(mime.WordDecoder)
+		origSubject, err := dec.DecodeHeader(original.Get("Subject"))
+		if err != nil {
+			origSubject = original.Get("Subject")
+		}
+		subject = strings.TrimSpace("Auto: " + origSubject)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: <%s>\r\n", rcpt)
+	fmt.Fprintf(&b, "To: <%s>\r\n", from)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+
+	if id := strings.TrimSpace(original.Get("Message-ID")); id != "" {
+		fmt.Fprintf(&b, "In-Reply-To: %s\r\n", id)
+		fmt.Fprintf(&b, "References: %s\r\n", strings.TrimSpace(original.Get("References")+" "+id))
+	}
+
+	writeCommonHeaders(&b, time.Now())
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	_, _ = qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(r.Text, "\r\n", "\n"), "\n", "\r\n")))
+	_ = qp.Close()
+
+	return b.Bytes()
+}
+
+// writeCommonHeaders writes the Date, Message-ID, Auto-Submitted & MIME-Version headers. confMu must be held.
+func writeCommonHeaders(b *bytes.Buffer, now time.Time) {
+	fmt.Fprintf(b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Message-ID: <%s@%s>\r\n", shortuuid.New(), conf.ReportingMTA)
+	b.WriteString("Auto-Submitted: auto-replied\r\nMIME-Version: 1.0\r\n")
+}
+
+// writeTextPart writes a quoted-printable text/plain part
+func writeTextPart(w *multipart.Writer, text string) {
+	part, _ := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+
+	qp := quotedprintable.NewWriter(part)
+	_, _ = qp.Write([]byte(text))
+	_ = qp.Close()
+}
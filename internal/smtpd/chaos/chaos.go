@@ -4,10 +4,9 @@
 package chaos
 
 import (
-	"crypto/rand"
 	"fmt"
-	"math/big"
 	"strings"
+	"sync"
 
 	"github.com/axllent/mailpit/internal/logger"
 )
@@ -16,11 +15,16 @@ var (
 	// Enabled is a flag to enable or disable support for chaos
 	Enabled = false
 
-	// Config is the global Chaos configuration
+	// configMu guards Config, which is updated via the API while SMTP sessions read it
+	configMu sync.RWMutex
+
+	// Config is the global Chaos configuration, see Current() for a copy safe for concurrent use
 	Config = Triggers{
 		Sender:         Trigger{ErrorCode: 451, Probability: 0},
 		Recipient:      Trigger{ErrorCode: 451, Probability: 0},
 		Authentication: Trigger{ErrorCode: 535, Probability: 0},
+		Delay:          map[string]DelayTrigger{},
+		Drop:           map[string]DropTrigger{},
 	}
 )
 
@@ -34,6 +38,14 @@ type Triggers struct {
 	Recipient Trigger
 	// Authentication trigger to fail while authenticating (auth must be configured)
 	Authentication Trigger
+	// Delay triggers keyed by SMTP stage: connect, ehlo, auth, mail, rcpt or data (end of DATA)
+	Delay map[string]DelayTrigger
+	// Drop triggers to close the connection instead of responding, keyed by SMTP stage: connect, ehlo, auth, mail, rcpt or data (end of DATA)
+	Drop map[string]DropTrigger
+	// Bandwidth trigger to limit the speed of receiving DATA
+	Bandwidth BandwidthTrigger
+	// Truncate trigger to send an incomplete multiline EHLO response and close the connection
+	Truncate TruncateTrigger
 }
 
 // Trigger for Chaos
@@ -75,7 +87,7 @@ func SetFromStruct(c Triggers) error {
 		return err
 	}
 
-	return nil
+	return setFaultsFromStruct(c)
 }
 
 // Set will set the chaos configuration for the given key (CLI & setMap())
@@ -91,6 +103,9 @@ func Set(key string, errorCode int, probability int) error {
 
 	key = strings.ToLower(key)
 
+	configMu.Lock()
+	defer configMu.Unlock()
+
 	switch key {
 	case "sender":
 		Config.Sender = Trigger{ErrorCode: errorCode, Probability: probability}
@@ -111,13 +126,9 @@ func Set(key string, errorCode int, probability int) error {
 // Trigger will return whether the Chaos rule is triggered based on the configuration
 // and a randomly-generated percentage value.
 func (c Trigger) Trigger() (bool, int) {
-	if !Enabled || c.Probability == 0 {
+	if !triggered(c.Probability) {
 		return false, 0
 	}
 
-	nBig, _ := rand.Int(rand.Reader, big.NewInt(100))
-
-	// rand.IntN(100) will return 0-99, whereas probability is 1-100,
-	// so value must be less than (not <=) to the probability to trigger
-	return int(nBig.Int64()) < c.Probability, c.ErrorCode
+	return true, c.ErrorCode
 }
@@ -0,0 +1,322 @@
+package chaos
+
+import (
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/internal/logger"
+)
+
+const (
+	// StageConnect is the connection banner stage
+	StageConnect = "connect"
+	// StageEHLO is the HELO / EHLO stage
+	StageEHLO = "ehlo"
+	// StageAuth is the AUTH stage
+	StageAuth = "auth"
+)
+
+// FaultStages are the SMTP stages supporting delays & connection drops
+var FaultStages = []string{StageConnect, StageEHLO, StageAuth, StageMail, StageRcpt, StageData}
+
+// DelayTrigger for Chaos, delaying the SMTP response by a random duration
+//
+// swagger:model ChaosDelayTrigger
+type DelayTrigger struct {
+	// Minimum delay in milliseconds
+	// required: true
+	// example: 1000
+	Min int
+
+	// Maximum delay in milliseconds. A random delay between Min & Max is applied. Defaults to Min if not set.
+	// example: 5000
+	Max int
+
+	// Probability (chance) of triggering the delay. The value must range from 0 to 100.
+	// required: true
+	// example: 50
+	Probability int
+}
+
+// DropTrigger for Chaos, abruptly closing the connection instead of responding
+//
+// swagger:model ChaosDropTrigger
+type DropTrigger struct {
+	// Probability (chance) of dropping the connection. The value must range from 0 to 100.
+	// required: true
+	// example: 5
+	Probability int
+}
+
+// BandwidthTrigger for Chaos, limiting the speed at which DATA is read
+//
+// swagger:model ChaosBandwidthTrigger
+type BandwidthTrigger struct {
+	// Maximum bytes per second to read while receiving DATA
+	// example: 10240
+	BytesPerSecond int
+
+	// Probability (chance) of limiting the bandwidth of a message. The value must range from 0 to 100.
+	// example: 100
+	Probability int
+}
+
+// TruncateTrigger for Chaos, sending an incomplete multiline EHLO response then closing the connection
+//
+// swagger:model ChaosTruncateTrigger
+type TruncateTrigger struct {
+	// Number of response lines to send before closing the connection
+	// example: 2
+	Lines int
+
+	// Probability (chance) of truncating the response. The value must range from 0 to 100.
+	// example: 10
+	Probability int
+}
+
+// SetFault will set a chaos fault trigger (CLI), where the key is one of
+// delay-<stage>, drop-<stage>, bandwidth or truncate.
+// The value is a delay in milliseconds (<min>[-<max>]) for delays, bytes per second for
+// bandwidth, and the number of lines for truncate. Drops do not accept a value.
+func SetFault(key, value string, probability int) error {
+	Enabled = true
+
+	if probability > 100 || probability < 0 {
+		return fmt.Errorf("probability must be between 0 and 100")
+	}
+
+	key = strings.ToLower(key)
+
+	if stage, found := strings.CutPrefix(key, "delay-"); found {
+		minDelay, maxDelay, err := parseDelay(value)
+		if err != nil {
+			return err
+		}
+
+		d := DelayTrigger{Min: minDelay, Max: maxDelay, Probability: probability}
+		if err := d.validate(stage); err != nil {
+			return err
+		}
+
+		configMu.Lock()
+		if Config.Delay == nil {
+			Config.Delay = map[string]DelayTrigger{}
+		}
+		Config.Delay[stage] = d
+		configMu.Unlock()
+		logger.Log().Infof("[chaos] %s stage to delay %d-%dms with %d%% probability", stage, d.Min, d.Max, probability)
+
+		return nil
+	}
+
+	if stage, found := strings.CutPrefix(key, "drop-"); found {
+		if value != "" {
+			return fmt.Errorf("%s does not accept a value", key)
+		}
+
+		if !slices.Contains(FaultStages, stage) {
+			return fmt.Errorf("invalid stage %q, must be one of %s", stage, strings.Join(FaultStages, ", "))
+		}
+
+		configMu.Lock()
+		if Config.Drop == nil {
+			Config.Drop = map[string]DropTrigger{}
+		}
+		Config.Drop[stage] = DropTrigger{Probability: probability}
+		configMu.Unlock()
+		logger.Log().Infof("[chaos] %s stage to drop connection with %d%% probability", stage, probability)
+
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("%s requires a positive value", key)
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	switch key {
+	case "bandwidth":
+		Config.Bandwidth = BandwidthTrigger{BytesPerSecond: n, Probability: probability}
+		logger.Log().Infof("[chaos] DATA bandwidth limited to %d bytes per second with %d%% probability", n, probability)
+	case "truncate":
+		Config.Truncate = TruncateTrigger{Lines: n, Probability: probability}
+		logger.Log().Infof("[chaos] EHLO response truncated to %d line(s) with %d%% probability", n, probability)
+	default:
+		return fmt.Errorf("unknown key %s", key)
+	}
+
+	return nil
+}
+
+// setFaultsFromStruct validates & sets the fault triggers (ie: API)
+func setFaultsFromStruct(c Triggers) error {
+	delay := map[string]DelayTrigger{}
+	for stage, d := range c.Delay {
+		stage = strings.ToLower(stage)
+		if d.Max == 0 {
+			d.Max = d.Min
+		}
+		if err := d.validate(stage); err != nil {
+			return err
+		}
+		delay[stage] = d
+	}
+
+	drop := map[string]DropTrigger{}
+	for stage, d := range c.Drop {
+		stage = strings.ToLower(stage)
+		if !slices.Contains(FaultStages, stage) {
+			return fmt.Errorf("invalid stage %q, must be one of %s", stage, strings.Join(FaultStages, ", "))
+		}
+		if d.Probability > 100 || d.Probability < 0 {
+			return fmt.Errorf("probability must be between 0 and 100")
+		}
+		drop[stage] = d
+	}
+
+	if c.Bandwidth.Probability > 100 || c.Bandwidth.Probability < 0 || c.Truncate.Probability > 100 || c.Truncate.Probability < 0 {
+		return fmt.Errorf("probability must be between 0 and 100")
+	}
+
+	if c.Bandwidth.Probability > 0 && c.Bandwidth.BytesPerSecond < 1 {
+		return fmt.Errorf("bandwidth bytes per second must be greater than 0")
+	}
+
+	if c.Truncate.Probability > 0 && c.Truncate.Lines < 1 {
+		return fmt.Errorf("truncate lines must be greater than 0")
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	Config.Delay = delay
+	Config.Drop = drop
+	Config.Bandwidth = c.Bandwidth
+	Config.Truncate = c.Truncate
+
+	return nil
+}
+
+// Current returns a copy of the Chaos configuration
+func Current() Triggers {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	c := Config
+	c.Delay = maps.Clone(Config.Delay)
+	c.Drop = maps.Clone(Config.Drop)
+
+	return c
+}
+
+// parseDelay parses a <min>[-<max>] millisecond delay
+func parseDelay(value string) (int, int, error) {
+	minStr, maxStr, isRange := strings.Cut(value, "-")
+
+	minDelay, err := strconv.Atoi(minStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid delay %q", value)
+	}
+
+	maxDelay := minDelay
+	if isRange {
+		if maxDelay, err = strconv.Atoi(maxStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid delay %q", value)
+		}
+	}
+
+	return minDelay, maxDelay, nil
+}
+
+// validate a delay trigger for a stage
+func (d DelayTrigger) validate(stage string) error {
+	if !slices.Contains(FaultStages, stage) {
+		return fmt.Errorf("invalid stage %q, must be one of %s", stage, strings.Join(FaultStages, ", "))
+	}
+
+	if d.Min < 0 || d.Max < d.Min {
+		return fmt.Errorf("invalid %s delay %d-%dms", stage, d.Min, d.Max)
+	}
+
+	if d.Probability > 100 || d.Probability < 0 {
+		return fmt.Errorf("probability must be between 0 and 100")
+	}
+
+	return nil
+}
+
+// triggered returns whether a probability (0-100) is triggered
+func triggered(probability int) bool {
+	if !Enabled || probability == 0 {
+		return false
+	}
+
+	nBig, _ := rand.Int(rand.Reader, big.NewInt(100))
+
+	// rand.Int() will return 0-99, whereas probability is 1-100,
+	// so value must be less than (not <=) to the probability to trigger
+	return int(nBig.Int64()) < probability
+}
+
+// Delay returns the delay to apply before responding at the SMTP stage, if triggered
+func Delay(stage string) time.Duration {
+	configMu.RLock()
+	d, ok := Config.Delay[stage]
+	configMu.RUnlock()
+
+	if !ok || !triggered(d.Probability) {
+		return 0
+	}
+
+	ms := d.Min
+	if d.Max > d.Min {
+		nBig, _ := rand.Int(rand.Reader, big.NewInt(int64(d.Max-d.Min+1)))
+		ms += int(nBig.Int64())
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Drop returns whether the connection should be closed instead of responding at the SMTP stage
+func Drop(stage string) bool {
+	configMu.RLock()
+	d, ok := Config.Drop[stage]
+	configMu.RUnlock()
+
+	return ok && triggered(d.Probability)
+}
+
+// Bandwidth returns the maximum DATA read speed in bytes per second if triggered, else 0
+func Bandwidth() int {
+	configMu.RLock()
+	b := Config.Bandwidth
+	configMu.RUnlock()
+
+	if !triggered(b.Probability) {
+		return 0
+	}
+
+	return b.BytesPerSecond
+}
+
+// Truncate returns the number of multiline response lines to send before closing the connection if triggered, else 0
+func Truncate() int {
+	configMu.RLock()
+	t := Config.Truncate
+	configMu.RUnlock()
+
+	if !triggered(t.Probability) {
+		return 0
+	}
+
+	return t.Lines
+}
@@ -0,0 +1,340 @@
+package chaos
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/shortuuid"
+)
+
+const (
+	// StageMail is the MAIL FROM stage
+	StageMail = "mail"
+	// StageRcpt is the RCPT TO stage
+	StageRcpt = "rcpt"
+	// StageData is the end-of-DATA stage
+	StageData = "data"
+)
+
+// attemptsExpiry is the duration after which unused attempt counters are expired
+const attemptsExpiry = time.Hour
+
+var (
+	rules      = []Rule{}
+	attempts   = map[string]attempt{}
+	attemptsGC time.Time
+	rulesMu    sync.Mutex
+)
+
+// attempt counts the matching attempts of a rule for a sender & recipient(s)
+type attempt struct {
+	count    int
+	lastSeen time.Time
+}
+
+// Rule is a deterministic SMTP response rule. All configured matches must apply for
+// the rule to be triggered, and rules are evaluated in order with the first match winning.
+//
+// swagger:model ChaosRule
+type Rule struct {
+	// Unique rule ID, generated if not set
+	ID string `yaml:"id"`
+
+	// SMTP stage the rule applies to: mail, rcpt or data
+	// required: true
+	// example: rcpt
+	Stage string `yaml:"stage"`
+
+	// Regular expression matching the sender address (case-insensitive)
+	// example: @example\.com$
+	Sender string `yaml:"sender"`
+
+	// Regular expression matching any recipient address (case-insensitive).
+	// For the rcpt stage this is matched against the recipient being added.
+	// example: ^bounce@example\.com$
+	Recipient string `yaml:"recipient"`
+
+	// Minimum message size in bytes. For the mail stage this is matched against the SIZE parameter, if provided.
+	// example: 1048576
+	MinSize int `yaml:"min-size"`
+
+	// Regular expression matching the raw message headers (data stage only)
+	// example: (?m)^X-Test: bounce
+	Header string `yaml:"header"`
+
+	// Only trigger on the first number of matching attempts, then accept. 0 triggers on every attempt.
+	// Attempts are counted per sender & recipient(s).
+	// example: 2
+	Attempts int `yaml:"attempts"`
+
+	// SMTP error code to return. The value must range from 400 to 599.
+	// required: true
+	// example: 550
+	ErrorCode int `yaml:"error-code"`
+
+	// Response text returned with the error code
+	// example: 5.1.1 Mailbox does not exist
+	Text string `yaml:"text"`
+
+	senderRE    *regexp.Regexp
+	recipientRE *regexp.Regexp
+	headerRE    *regexp.Regexp
+}
+
+// Envelope contains the SMTP transaction details matched against rules.
+// Size & Data are only available at the data stage, or Size via the MAIL FROM SIZE parameter.
+type Envelope struct {
+	Sender     string
+	Recipients []string
+	Size       int
+	Data       []byte
+}
+
+// compile validates a rule & compiles the regular expressions
+func (r *Rule) compile() error {
+	var err error
+
+	r.Stage = strings.ToLower(strings.TrimSpace(r.Stage))
+	if r.Stage != StageMail && r.Stage != StageRcpt && r.Stage != StageData {
+		return fmt.Errorf("invalid stage %q, must be one of mail, rcpt or data", r.Stage)
+	}
+
+	if r.ErrorCode < 400 || r.ErrorCode > 599 {
+		return fmt.Errorf("error code must be between 400 and 599")
+	}
+
+	if r.MinSize < 0 || r.Attempts < 0 {
+		return fmt.Errorf("min size and attempts cannot be negative")
+	}
+
+	if r.Header != "" && r.Stage != StageData {
+		return fmt.Errorf("header matching is only supported in the data stage")
+	}
+
+	r.senderRE, r.recipientRE, r.headerRE = nil, nil, nil
+
+	if r.Sender != "" {
+		if r.senderRE, err = regexp.Compile("(?i)" + r.Sender); err != nil {
+			return fmt.Errorf("invalid sender regular expression: %s", err.Error())
+		}
+	}
+
+	if r.Recipient != "" {
+		if r.recipientRE, err = regexp.Compile("(?i)" + r.Recipient); err != nil {
+			return fmt.Errorf("invalid recipient regular expression: %s", err.Error())
+		}
+	}
+
+	if r.Header != "" {
+		if r.headerRE, err = regexp.Compile(r.Header); err != nil {
+			return fmt.Errorf("invalid header regular expression: %s", err.Error())
+		}
+	}
+
+	if r.Text == "" {
+		r.Text = "Chaos rule error"
+	}
+
+	if r.ID == "" {
+		r.ID = shortuuid.New()
+	}
+
+	return nil
+}
+
+// matches returns whether the rule applies to the envelope, ignoring attempts
+func (r Rule) matches(stage string, e Envelope) bool {
+	if r.Stage != stage {
+		return false
+	}
+
+	if r.senderRE != nil && !r.senderRE.MatchString(e.Sender) {
+		return false
+	}
+
+	if r.recipientRE != nil {
+		found := false
+		for _, to := range e.Recipients {
+			if r.recipientRE.MatchString(to) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.MinSize > 0 && e.Size < r.MinSize {
+		return false
+	}
+
+	if r.headerRE != nil {
+		headers, _, _ := bytes.Cut(e.Data, []byte("\r\n\r\n"))
+		if !r.headerRE.Match(headers) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Rules returns a copy of the configured rules
+func Rules() []Rule {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	return append([]Rule{}, rules...)
+}
+
+// SetRules replaces all rules (ie: YAML config) and resets the attempt counters
+func SetRules(r []Rule) error {
+	ids := map[string]bool{}
+	for i := range r {
+		if err := r[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %s", i+1, err.Error())
+		}
+		if ids[r[i].ID] {
+			return fmt.Errorf("rule %d: duplicate ID %s", i+1, r[i].ID)
+		}
+		ids[r[i].ID] = true
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	if len(r) > 0 {
+		Enabled = true
+	}
+
+	rules = r
+	attempts = map[string]attempt{}
+
+	logger.Log().Infof("[chaos] %d SMTP response rule(s) set", len(r))
+
+	return nil
+}
+
+// AddRule appends a rule and returns the rule including its ID
+func AddRule(r Rule) (Rule, error) {
+	if err := r.compile(); err != nil {
+		return r, err
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	for _, existing := range rules {
+		if existing.ID == r.ID {
+			return r, fmt.Errorf("rule %s already exists", r.ID)
+		}
+	}
+
+	rules = append(rules, r)
+
+	return r, nil
+}
+
+// UpdateRule replaces an existing rule, resetting its attempt counters
+func UpdateRule(id string, r Rule) (Rule, error) {
+	r.ID = id
+	if err := r.compile(); err != nil {
+		return r, err
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	for i, existing := range rules {
+		if existing.ID == id {
+			rules[i] = r
+			resetAttempts(id)
+			return r, nil
+		}
+	}
+
+	return r, fmt.Errorf("rule %s not found", id)
+}
+
+// DeleteRule deletes a rule
+func DeleteRule(id string) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	for i, existing := range rules {
+		if existing.ID == id {
+			rules = append(rules[:i], rules[i+1:]...)
+			resetAttempts(id)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("rule %s not found", id)
+}
+
+// resetAttempts deletes the attempt counters of a rule, rulesMu must be held
+func resetAttempts(id string) {
+	for k := range attempts {
+		if strings.HasPrefix(k, id+"|") {
+			delete(attempts, k)
+		}
+	}
+}
+
+// expireAttempts deletes the attempt counters which have not been used within the expiry, rulesMu must be held
+func expireAttempts(now time.Time) {
+	if now.Sub(attemptsGC) < time.Minute {
+		return
+	}
+
+	attemptsGC = now
+
+	for k, a := range attempts {
+		if now.Sub(a.lastSeen) > attemptsExpiry {
+			delete(attempts, k)
+		}
+	}
+}
+
+// MatchRule returns the response of the first rule triggered for the SMTP stage.
+// Each matching rule with an attempts limit counts the attempt, and is skipped once the limit is exceeded.
+// Attempt counters expire after an hour without a matching attempt.
+func MatchRule(stage string, e Envelope) (bool, int, string) {
+	if !Enabled {
+		return false, 0, ""
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	now := time.Now()
+	expireAttempts(now)
+
+	for _, r := range rules {
+		if !r.matches(stage, e) {
+			continue
+		}
+
+		if r.Attempts > 0 {
+			key := r.ID + "|" + strings.ToLower(e.Sender) + "|" + strings.ToLower(strings.Join(e.Recipients, ","))
+			a := attempts[key]
+			a.count++
+			a.lastSeen = now
+			attempts[key] = a
+			if a.count > r.Attempts {
+				continue
+			}
+		}
+
+		logger.Log().Debugf("[chaos] rule %s triggered %d response at %s stage", r.ID, r.ErrorCode, stage)
+
+		return true, r.ErrorCode, r.Text
+	}
+
+	return false, 0, ""
+}
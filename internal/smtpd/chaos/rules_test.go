@@ -0,0 +1,122 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetRules(t *testing.T) {
+	t.Cleanup(func() {
+		_ = SetRules([]Rule{})
+		Enabled = false
+	})
+
+	invalid := [][]Rule{
+		{{Stage: "quit", ErrorCode: 550}},
+		{{Stage: StageRcpt, ErrorCode: 250}},
+		{{Stage: StageRcpt, ErrorCode: 550, Attempts: -1}},
+		{{Stage: StageRcpt, ErrorCode: 550, Header: "X-Test"}},
+		{{Stage: StageRcpt, ErrorCode: 550, Sender: "("}},
+		{{ID: "a", Stage: StageRcpt, ErrorCode: 550}, {ID: "a", Stage: StageMail, ErrorCode: 550}},
+	}
+
+	for i, r := range invalid {
+		if err := SetRules(r); err == nil {
+			t.Errorf("invalid rules %d: expected an error", i)
+		}
+	}
+
+	if err := SetRules([]Rule{{Stage: " RCPT ", ErrorCode: 550}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Rules()
+	if !Enabled || len(r) != 1 || r[0].Stage != StageRcpt || r[0].ID == "" || r[0].Text != "Chaos rule error" {
+		t.Errorf("unexpected rules: %+v", r)
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	t.Cleanup(func() {
+		_ = SetRules([]Rule{})
+		Enabled = false
+	})
+
+	if err := SetRules([]Rule{
+		{ID: "sender", Stage: StageMail, Sender: `@example\.com$`, ErrorCode: 451, Attempts: 2},
+		{ID: "recipient", Stage: StageRcpt, Recipient: `^bounce@`, ErrorCode: 550, Text: "5.1.1 Mailbox does not exist"},
+		{ID: "size", Stage: StageData, MinSize: 100, ErrorCode: 552},
+		{ID: "header", Stage: StageData, Header: `(?m)^X-Test: fail`, ErrorCode: 554},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		stage string
+		e     Envelope
+		code  int
+	}{
+		{StageMail, Envelope{Sender: "user@EXAMPLE.com"}, 451},
+		{StageMail, Envelope{Sender: "user@example.org"}, 0},
+		{StageRcpt, Envelope{Recipients: []string{"bounce@example.com"}}, 550},
+		{StageRcpt, Envelope{Recipients: []string{"user@example.com"}}, 0},
+		{StageData, Envelope{Size: 100}, 552},
+		{StageData, Envelope{Size: 10, Data: []byte("X-Test: fail\r\n\r\nbody")}, 554},
+		{StageData, Envelope{Size: 10, Data: []byte("Subject: test\r\n\r\nX-Test: fail")}, 0},
+	}
+
+	for i, tt := range tests {
+		matched, code, _ := MatchRule(tt.stage, tt.e)
+		if matched != (tt.code != 0) || code != tt.code {
+			t.Errorf("test %d: expected %d, got %v %d", i, tt.code, matched, code)
+		}
+	}
+
+	// the sender rule has already been triggered once, and is skipped after the second attempt
+	sender := Envelope{Sender: "user@example.com"}
+	if matched, _, _ := MatchRule(StageMail, sender); !matched {
+		t.Error("expected the second attempt to match")
+	}
+	if matched, _, _ := MatchRule(StageMail, sender); matched {
+		t.Error("expected the third attempt to be accepted")
+	}
+
+	// attempts are counted per sender
+	if matched, _, _ := MatchRule(StageMail, Envelope{Sender: "other@example.com"}); !matched {
+		t.Error("expected the first attempt of another sender to match")
+	}
+
+	// updating a rule resets its attempts
+	if _, err := UpdateRule("sender", Rule{Stage: StageMail, Sender: `@example\.com$`, ErrorCode: 451, Attempts: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 0 {
+		t.Errorf("expected the attempts to be reset, got %d", len(attempts))
+	}
+	if matched, _, _ := MatchRule(StageMail, sender); !matched {
+		t.Error("expected the first attempt after an update to match")
+	}
+
+	// unused attempt counters expire
+	for k, a := range attempts {
+		a.lastSeen = time.Now().Add(-attemptsExpiry - time.Minute)
+		attempts[k] = a
+	}
+	attemptsGC = time.Time{}
+	if matched, _, _ := MatchRule(StageRcpt, Envelope{Recipients: []string{"user@example.com"}}); matched {
+		t.Error("expected no match")
+	}
+	if len(attempts) != 0 {
+		t.Errorf("expected the attempts to expire, got %d", len(attempts))
+	}
+
+	if err := DeleteRule("recipient"); err != nil {
+		t.Fatal(err)
+	}
+	if matched, _, _ := MatchRule(StageRcpt, Envelope{Recipients: []string{"bounce@example.com"}}); matched {
+		t.Error("expected a deleted rule not to match")
+	}
+	if err := DeleteRule("recipient"); err == nil {
+		t.Error("expected an error deleting a missing rule")
+	}
+}
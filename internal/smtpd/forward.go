@@ -3,29 +3,38 @@ package smtpd
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/smtp"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/axllent/mailpit/config"
 	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/storage"
 	"github.com/axllent/mailpit/internal/tools"
 	"github.com/pkg/errors"
 )
 
 // Wrapper to forward messages if configured
-func autoForwardMessage(from string, data *[]byte) error {
+func autoForwardMessage(from string, data *[]byte, o *outbound) error {
 	if config.SMTPForwardConfig.Host == "" {
 		return nil
 	}
 
-	if err := forward(from, *data); err != nil {
-		return fmt.Errorf("[forward] error: %w", err)
+	if config.SMTPForwardConfig.ForwardSMTPErrors {
+		start := time.Now()
+		code, text, err := forward(&config.SMTPForwardConfig, from, *data)
+		o.attempt(newRelayLog(QueueTypeForward, forwardHost(&config.SMTPForwardConfig), forwardRecipients(&config.SMTPForwardConfig), start, code, text, err))
+		if err != nil {
+			return fmt.Errorf("[forward] error: %w", err)
+		}
+	} else {
+		o.enqueue(storage.QueueJob{Type: QueueTypeForward, From: from, To: forwardRecipients(&config.SMTPForwardConfig)}, *data)
 	}
 
 	logger.Log().Debugf(
-		"[forward] message from %s to %s via %s:%d",
-		from, config.SMTPForwardConfig.To, config.SMTPForwardConfig.Host, config.SMTPForwardConfig.Port,
+		"[forward] message from %s to %s via %s",
+		from, config.SMTPForwardConfig.To, forwardHost(&config.SMTPForwardConfig),
 	)
 
 	return nil
@@ -36,12 +45,12 @@ func createForwardingSMTPClient(config config.SMTPForwardConfigStruct, addr stri
 		tlsConf := &tls.Config{ServerName: config.Host} // #nosec
 		tlsConf.InsecureSkipVerify = config.AllowInsecure
 
-		conn, err := tls.Dial("tcp", addr, tlsConf)
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, tlsConf)
 		if err != nil {
 			return nil, fmt.Errorf("TLS dial error: %v", err)
 		}
 
-		client, err := smtp.NewClient(conn, tlsConf.ServerName)
+		client, err := newSMTPClient(conn, tlsConf.ServerName)
 		if err != nil {
 			_ = conn.Close()
 			return nil, fmt.Errorf("SMTP client error: %v", err)
@@ -51,11 +60,18 @@ func createForwardingSMTPClient(config config.SMTPForwardConfigStruct, addr stri
 		return client, nil
 	}
 
-	client, err := smtp.Dial(addr)
+	conn, err := net.DialTimeout("tcp", addr, smtpDialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to %s: %v", addr, err)
 	}
 
+	host, _, _ := net.SplitHostPort(addr)
+	client, err := newSMTPClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error connecting to %s: %v", addr, err)
+	}
+
 	// Set the hostname for HELO/EHLO
 	if hostname, err := os.Hostname(); err == nil {
 		if err := client.Hello(hostname); err != nil {
@@ -78,79 +94,123 @@ func createForwardingSMTPClient(config config.SMTPForwardConfigStruct, addr stri
 	return client, nil
 }
 
-// Forward will connect to a pre-configured SMTP server and send a message to one or more recipients.
-func forward(from string, msg []byte) error {
-	addr := fmt.Sprintf("%s:%d", config.SMTPForwardConfig.Host, config.SMTPForwardConfig.Port)
+// Forward will connect to a forwarding SMTP server and send a message to one or more recipients,
+// returning the final SMTP response code & text, or deliver it locally if a local delivery backend is configured.
+func forward(c *config.SMTPForwardConfigStruct, from string, msg []byte) (int, string, error) {
+	msg, err := c.Rewrite.Rules.Apply(msg, from, forwardRecipients(c))
+	if err != nil {
+		return 0, "", fmt.Errorf("error rewriting message: %s", err.Error())
+	}
+
+	if c.OverrideFrom != "" {
+		msg, err = tools.OverrideFromHeader(msg, c.OverrideFrom)
+		if err != nil {
+			return 0, "", fmt.Errorf("error overriding From header: %s", err.Error())
+		}
+
+		from = c.OverrideFrom
+	}
+
+	// sign after any header changes
+	if c.DKIM.Signer != nil {
+		msg, err = c.DKIM.Signer.Sign(msg)
+		if err != nil {
+			return 0, "", err
+		}
+	}
 
-	c, err := createForwardingSMTPClient(config.SMTPForwardConfig, addr)
+	if c.Backend != nil {
+		return 0, "", c.Backend.Deliver(from, forwardRecipients(c), msg)
+	}
+
+	client, err := createForwardingSMTPClient(*c, forwardHost(c))
 	if err != nil {
-		return err
+		return 0, "", err
 	}
-	defer func() { _ = c.Close() }()
+	defer func() { _ = client.Close() }()
 
-	auth := forwardAuthFromConfig()
+	auth := forwardAuthFromConfig(c)
 
 	if auth != nil {
-		if err = c.Auth(auth); err != nil {
-			return fmt.Errorf("error response to AUTH command: %s", err.Error())
+		if err = client.Auth(auth); err != nil {
+			return 0, "", fmt.Errorf("error response to AUTH command: %w", err)
 		}
 	}
 
-	if config.SMTPForwardConfig.OverrideFrom != "" {
-		msg, err = tools.OverrideFromHeader(msg, config.SMTPForwardConfig.OverrideFrom)
+	to := forwardRecipients(c)
+
+	// internationalised addresses must be downgraded if the forwarding server does not support SMTPUTF8
+	if ok, _ := client.Extension("SMTPUTF8"); !ok {
+		from, to, err = downgradeAddresses(c.ForwardSMTPErrors, from, to)
 		if err != nil {
-			return fmt.Errorf("error overriding From header: %s", err.Error())
+			return 0, "", err
 		}
-
-		from = config.SMTPForwardConfig.OverrideFrom
 	}
 
-	if err = c.Mail(from); err != nil {
-		return fmt.Errorf("error response to MAIL command: %s", err.Error())
+	if err = client.Mail(from); err != nil {
+		return 0, "", fmt.Errorf("error response to MAIL command: %w", err)
 	}
 
-	to := strings.SplitSeq(config.SMTPForwardConfig.To, ",")
-
-	for addr := range to {
-		if err = c.Rcpt(addr); err != nil {
+	for _, addr := range to {
+		if err = client.Rcpt(addr); err != nil {
 			logger.Log().Warnf("error response to RCPT command for %s: %s", addr, err.Error())
-			if config.SMTPForwardConfig.ForwardSMTPErrors {
-				return errors.WithMessagef(err, "error response to RCPT command for %s", addr)
+			if c.ForwardSMTPErrors {
+				return 0, "", errors.WithMessagef(err, "error response to RCPT command for %s", addr)
 			}
 		}
 	}
 
-	w, err := c.Data()
+	code, text, err := sendData(client, msg)
 	if err != nil {
-		return fmt.Errorf("error response to DATA command: %s", err.Error())
+		return 0, "", err
 	}
 
-	if _, err := w.Write(msg); err != nil {
-		return fmt.Errorf("error sending message: %s", err.Error())
-	}
+	return code, text, client.Quit()
+}
 
-	if err := w.Close(); err != nil {
-		return fmt.Errorf("error closing connection: %s", err.Error())
+// forwardHost returns the host & port of a forwarding SMTP server, or the local delivery URL
+func forwardHost(c *config.SMTPForwardConfigStruct) string {
+	if c.Backend != nil {
+		return c.Backend.String()
 	}
 
-	return c.Quit()
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
 }
 
 // Return the SMTP forwarding authentication based on config
-func forwardAuthFromConfig() smtp.Auth {
+func forwardAuthFromConfig(c *config.SMTPForwardConfigStruct) smtp.Auth {
 	var a smtp.Auth
 
-	if config.SMTPForwardConfig.Auth == "plain" {
-		a = smtp.PlainAuth("", config.SMTPForwardConfig.Username, config.SMTPForwardConfig.Password, config.SMTPForwardConfig.Host)
+	if c.Auth == "plain" {
+		a = smtp.PlainAuth("", c.Username, c.Password, c.Host)
 	}
 
-	if config.SMTPForwardConfig.Auth == "login" {
-		a = LoginAuth(config.SMTPForwardConfig.Username, config.SMTPForwardConfig.Password)
+	if c.Auth == "login" {
+		a = LoginAuth(c.Username, c.Password)
 	}
 
-	if config.SMTPForwardConfig.Auth == "cram-md5" {
-		a = smtp.CRAMMD5Auth(config.SMTPForwardConfig.Username, config.SMTPForwardConfig.Secret)
+	if c.Auth == "cram-md5" {
+		a = smtp.CRAMMD5Auth(c.Username, c.Secret)
 	}
 
 	return a
 }
+
+// autoForwardRules queues a copy of a stored message for each forward rule matching it
+func autoForwardRules(id, from string, data []byte, o *outbound) {
+	for _, rule := range config.SMTPForwardConfig.Rules {
+		match, err := storage.MessageMatchesSearch(id, rule.Match)
+		if err != nil {
+			logger.Log().Errorf("[forward:%s] %s", rule.Name, err.Error())
+			continue
+		}
+
+		if !match {
+			continue
+		}
+
+		o.enqueue(storage.QueueJob{Type: QueueTypeForward, Profile: rule.Name, From: from, To: forwardRecipients(rule)}, data)
+
+		logger.Log().Debugf("[forward:%s] message %s from %s to %s via %s", rule.Name, id, from, rule.To, forwardHost(rule))
+	}
+}
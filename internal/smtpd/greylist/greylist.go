@@ -0,0 +1,174 @@
+// Package greylist simulates SMTP greylisting, temporarily rejecting the first delivery attempt of
+// each client IP, sender & recipient triplet until the configured delay has passed
+package greylist
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Delay before a retried triplet is accepted, 0 disables greylisting
+	Delay time.Duration
+
+	// RetryWindow is the time after the delay within which a deferred triplet must be retried,
+	// after which it expires and is greylisted again
+	RetryWindow = 48 * time.Hour
+
+	// TTL is the time after the latest delivery attempt that an accepted triplet expires
+	TTL = 35 * 24 * time.Hour
+
+	triplets = map[string]*Triplet{}
+	expired  time.Time
+	deferred uint64
+	passed   uint64
+	mu       sync.Mutex
+)
+
+// Status is the greylisting status & counters
+//
+// swagger:model GreylistResponse
+type Status struct {
+	// Whether greylisting is enabled
+	Enabled bool
+	// Delay in seconds before a retried delivery attempt is accepted
+	// example: 300
+	Delay int
+	// Total number of deferred delivery attempts (451 responses)
+	Deferred uint64
+	// Total number of delivery attempts accepted after the delay
+	Passed uint64
+	// Tracked triplets, ordered by first attempt
+	Triplets []Triplet
+}
+
+// Triplet is a tracked client IP, sender & recipient
+//
+// swagger:model GreylistTriplet
+type Triplet struct {
+	// Client IP address
+	// example: 127.0.0.1
+	IP string
+	// Sender address
+	// example: sender@example.com
+	Sender string
+	// Recipient address
+	// example: recipient@example.com
+	Recipient string
+	// Time of the first delivery attempt
+	FirstSeen time.Time
+	// Time of the latest delivery attempt
+	LastSeen time.Time
+	// Number of delivery attempts
+	Attempts int
+	// Number of deferred delivery attempts
+	Deferred int
+	// Time the triplet was first accepted, if passed
+	PassedAt *time.Time `json:",omitempty"`
+}
+
+// Check returns whether a delivery attempt is accepted, or the remaining delay if deferred.
+// Triplets are case-insensitive, and accepted triplets are not greylisted again until they expire or are reset.
+func Check(remoteAddr net.Addr, from, to string) (bool, time.Duration) {
+	if Delay <= 0 {
+		return true, 0
+	}
+
+	ip := remoteAddr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	key := ip + "|" + strings.ToLower(from) + "|" + strings.ToLower(to)
+	now := time.Now()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if now.Sub(expired) > time.Minute {
+		expire(now)
+	}
+
+	t, ok := triplets[key]
+	if !ok || t.isExpired(now) {
+		t = &Triplet{IP: ip, Sender: from, Recipient: to, FirstSeen: now}
+		triplets[key] = t
+	}
+
+	t.Attempts++
+	t.LastSeen = now
+
+	if t.PassedAt != nil {
+		return true, 0
+	}
+
+	if remaining := t.FirstSeen.Add(Delay).Sub(now); remaining > 0 {
+		t.Deferred++
+		deferred++
+		return false, remaining
+	}
+
+	t.PassedAt = &now
+	passed++
+
+	return true, 0
+}
+
+// isExpired returns whether a deferred triplet was not retried within the retry window,
+// or an accepted triplet has not been used within the TTL
+func (t *Triplet) isExpired(now time.Time) bool {
+	if t.PassedAt != nil {
+		return now.Sub(t.LastSeen) > TTL
+	}
+
+	return now.Sub(t.FirstSeen) > Delay+RetryWindow
+}
+
+// expire deletes the expired triplets, mu must be held
+func expire(now time.Time) {
+	expired = now
+
+	for k, t := range triplets {
+		if t.isExpired(now) {
+			delete(triplets, k)
+		}
+	}
+}
+
+// GetStatus returns the greylisting status, counters & tracked (unexpired) triplets
+func GetStatus() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	expire(time.Now())
+
+	s := Status{
+		Enabled:  Delay > 0,
+		Delay:    int(Delay.Seconds()),
+		Deferred: deferred,
+		Passed:   passed,
+		Triplets: []Triplet{},
+	}
+
+	for _, t := range triplets {
+		s.Triplets = append(s.Triplets, *t)
+	}
+
+	sort.Slice(s.Triplets, func(i, j int) bool {
+		return s.Triplets[i].FirstSeen.Before(s.Triplets[j].FirstSeen)
+	})
+
+	return s
+}
+
+// Reset deletes all tracked triplets & resets the counters
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	triplets = map[string]*Triplet{}
+	deferred, passed = 0, 0
+}
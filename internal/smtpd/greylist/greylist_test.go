@@ -0,0 +1,81 @@
+package greylist
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+
+	// disabled
+	if ok, _ := Check(addr, "sender@example.com", "rcpt@example.com"); !ok {
+		t.Error("expected greylisting to be disabled")
+	}
+
+	Delay = 100 * time.Millisecond
+	defer func() {
+		Delay = 0
+		Reset()
+	}()
+
+	if ok, remaining := Check(addr, "sender@example.com", "rcpt@example.com"); ok || remaining <= 0 || remaining > Delay {
+		t.Errorf("expected the first attempt to be deferred, got %v %s", ok, remaining)
+	}
+
+	// early retry
+	if ok, _ := Check(addr, "Sender@example.com", "rcpt@example.com"); ok {
+		t.Error("expected an early retry to be deferred")
+	}
+
+	// a different triplet
+	if ok, _ := Check(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 12345}, "sender@example.com", "rcpt@example.com"); ok {
+		t.Error("expected a new client IP to be deferred")
+	}
+
+	time.Sleep(Delay)
+
+	for range 2 {
+		if ok, _ := Check(addr, "sender@example.com", "rcpt@example.com"); !ok {
+			t.Error("expected a retry after the delay to be accepted")
+		}
+	}
+
+	s := GetStatus()
+	if !s.Enabled || s.Deferred != 3 || s.Passed != 1 || len(s.Triplets) != 2 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	tr := s.Triplets[0]
+	if tr.IP != "192.0.2.1" || tr.Attempts != 4 || tr.Deferred != 2 || tr.PassedAt == nil || tr.PassedAt.Sub(tr.FirstSeen) < Delay {
+		t.Errorf("unexpected triplet %+v", tr)
+	}
+
+	// deferred triplets expire when not retried within the retry window, and accepted triplets after the TTL
+	RetryWindow, TTL = 100*time.Millisecond, 200*time.Millisecond
+	defer func() {
+		RetryWindow, TTL = 48*time.Hour, 35*24*time.Hour
+	}()
+
+	time.Sleep(110 * time.Millisecond)
+
+	if s := GetStatus(); len(s.Triplets) != 1 || s.Triplets[0].PassedAt == nil {
+		t.Fatalf("expected the deferred triplet to expire, got %+v", s.Triplets)
+	}
+
+	if ok, _ := Check(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 12345}, "sender@example.com", "rcpt@example.com"); ok {
+		t.Error("expected an expired triplet to be deferred again")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if s := GetStatus(); len(s.Triplets) != 1 || s.Triplets[0].IP != "192.0.2.2" {
+		t.Errorf("expected the accepted triplet to expire, got %+v", s.Triplets)
+	}
+
+	Reset()
+	if s := GetStatus(); s.Deferred != 0 || s.Passed != 0 || len(s.Triplets) != 0 {
+		t.Errorf("expected reset status, got %+v", s)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/axllent/mailpit/config"
 	"github.com/axllent/mailpit/internal/auth"
+	"github.com/axllent/mailpit/internal/authres"
 	"github.com/axllent/mailpit/internal/logger"
 	"github.com/axllent/mailpit/internal/shortuuid"
 	"github.com/axllent/mailpit/internal/stats"
@@ -29,12 +30,14 @@ var (
 )
 
 // MailHandler handles the incoming message to store in the database
-func mailHandler(origin net.Addr, from string, to []string, data []byte, smtpUser *string) (string, error) {
-	return SaveToDatabase(origin, from, to, data, smtpUser)
+func mailHandler(origin net.Addr, from string, to []string, data []byte, smtpUser *string, requireTLS bool, envelope *authres.Envelope) (string, error) {
+	return SaveToDatabase(origin, from, to, data, smtpUser, requireTLS, envelope)
 }
 
-// SaveToDatabase will attempt to save a message to the database
-func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtpUser *string) (string, error) {
+// SaveToDatabase will attempt to save a message to the database.
+// The requireTLS is whether the message was received with the SMTP REQUIRETLS parameter, and the
+// envelope is the SMTP session used to evaluate SPF (nil if the message was not received via SMTP).
+func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtpUser *string, requireTLS bool, envelope *authres.Envelope) (string, error) {
 	if !config.SMTPStrictRFCHeaders && bytes.Contains(data, []byte("\r\r\n")) {
 		// replace all <CR><CR><LF> (\r\r\n) with <CR><LF> (\r\n)
 		// @see https://github.com/axllent/mailpit/issues/87 & https://github.com/axllent/mailpit/issues/153
@@ -73,8 +76,11 @@ func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtp
 		}
 	}
 
+	// relay & forward deliveries are recorded against the message once stored
+	o := &outbound{}
+
 	// if enabled, this may conditionally relay the email through to the preconfigured smtp server
-	if relayErr := autoRelayMessage(from, to, &data); relayErr != nil {
+	if relayErr := autoRelayMessage(from, to, &data, o); relayErr != nil {
 		logger.Log().Error(relayErr.Error())
 
 		if config.SMTPRelayConfig.ForwardSMTPErrors {
@@ -85,12 +91,13 @@ func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtp
 				}
 				relayErr = unwrappedErr
 			}
+			o.commit("")
 			return "", relayErr
 		}
 	}
 
 	// if enabled, this will forward a copy to preconfigured addresses
-	if forwardErr := autoForwardMessage(from, &data); forwardErr != nil {
+	if forwardErr := autoForwardMessage(from, &data, o); forwardErr != nil {
 		logger.Log().Error(forwardErr.Error())
 
 		if config.SMTPForwardConfig.ForwardSMTPErrors {
@@ -101,6 +108,7 @@ func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtp
 				}
 				forwardErr = unwrappedErr
 			}
+			o.commit("")
 			return "", forwardErr
 		}
 	}
@@ -112,7 +120,8 @@ func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtp
 	for _, a := range to {
 		// loop through passed email addresses to check if they are in the headers
 		if _, err := mail.ParseAddress(a); err == nil {
-			_, ok := emails[strings.ToLower(a)]
+			u, _ := tools.NormalizeAddress(a)
+			_, ok := emails[strings.ToLower(u)]
 			if !ok {
 				missingAddresses = append(missingAddresses, a)
 			}
@@ -137,14 +146,23 @@ func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtp
 		logger.Log().Debugf("[smtpd] added missing addresses to Bcc header: %s", strings.Join(missingAddresses, ", "))
 	}
 
-	id, err := storage.Store(&data, smtpUser)
+	id, err := storage.Store(&data, smtpUser, requireTLS, envelope)
 	if err != nil {
 		logger.Log().Errorf("[db] error storing message: %s", err.Error())
+		o.commit("")
 		return "", err
 	}
 
+	// forward rules match on search expressions, so can only be applied once stored
+	autoForwardRules(id, from, data, o)
+
+	o.commit(id)
+
 	stats.LogSMTPAccepted(len(data))
 
+	// if enabled, this will generate bounces & auto-replies to the sender
+	autoBounceMessage(from, to, data)
+
 	data = nil // avoid memory leaks
 
 	subject := msg.Header.Get("Subject")
@@ -154,7 +172,19 @@ func SaveToDatabase(origin net.Addr, from string, to []string, data []byte, smtp
 }
 
 func authHandler(remoteAddr net.Addr, mechanism string, username []byte, password []byte, _ []byte) (bool, error) {
-	allow := auth.SMTPCredentials.Match(string(username), string(password))
+	allow := false
+	if mechanism == "EXTERNAL" {
+		// the client certificate has already been verified during the TLS handshake
+		allow = true
+	} else if strings.HasPrefix(mechanism, "SCRAM-") {
+		// the client proof has already been verified during the SCRAM exchange
+		allow = auth.SMTPSCRAMCredentials != nil
+	} else if mechanism == "XOAUTH2" || mechanism == "OAUTHBEARER" {
+		allow = auth.ValidateSMTPOAuthToken(string(username), string(password))
+	} else if auth.SMTPCredentials != nil {
+		allow = auth.SMTPCredentials.Match(string(username), string(password))
+	}
+
 	if allow {
 		logger.Log().Debugf("[smtpd] allow %s login:%q from:%s", mechanism, string(username), cleanIP(remoteAddr))
 	} else {
@@ -177,7 +207,14 @@ func handlerRcpt(remoteAddr net.Addr, from string, to string) bool {
 		return true
 	}
 
-	result := config.SMTPAllowedRecipientsRegexp.MatchString(to)
+	// match either the Unicode or ASCII (punycode) form of internationalised addresses
+	result := false
+	for _, a := range tools.AddressForms(to) {
+		if config.SMTPAllowedRecipientsRegexp.MatchString(a) {
+			result = true
+			break
+		}
+	}
 
 	if !result {
 		logger.Log().Warnf("[smtpd] rejected message to %s from %s (%s)", to, from, cleanIP(remoteAddr))
@@ -195,14 +232,31 @@ func Listen() error {
 		} else if config.SMTPAuthAcceptAny {
 			logger.Log().Info("[smtpd] enabling any authentication (insecure)")
 		}
+		if auth.SMTPOAuthEnabled() {
+			logger.Log().Info("[smtpd] enabling XOAUTH2 & OAUTHBEARER authentication (insecure)")
+		}
 	} else {
 		if auth.SMTPCredentials != nil {
 			logger.Log().Info("[smtpd] enabling login authentication")
 		} else if config.SMTPAuthAcceptAny {
 			logger.Log().Info("[smtpd] enabling any authentication")
 		}
+		if auth.SMTPOAuthEnabled() {
+			logger.Log().Info("[smtpd] enabling XOAUTH2 & OAUTHBEARER authentication")
+		}
+	}
+
+	if config.SMTPTLSClientCA != "" {
+		if config.SMTPRequireClientCert {
+			logger.Log().Info("[smtpd] enabling TLS client certificate authentication (required)")
+		} else {
+			logger.Log().Info("[smtpd] enabling TLS client certificate authentication")
+		}
 	}
 
+	// deliver queued relay & forward messages
+	go processQueue()
+
 	return listenAndServe(config.SMTPListen, mailHandler, authHandler)
 }
 
@@ -280,12 +334,66 @@ func listenAndServe(addr string, handler MsgIDHandler, authHandler AuthHandler)
 		srv.AuthHandler = authHandlerAny
 	}
 
+	if auth.SMTPCredentials != nil && auth.SMTPSCRAMCredentials != nil {
+		srv.SCRAMCredentials = auth.SMTPSCRAMCredentials
+		for _, mech := range auth.SCRAMMechanisms(true) {
+			srv.AuthMechs[mech] = true
+		}
+		logger.Log().Debugf("[smtpd] enabling SCRAM authentication for %d user(s)", len(auth.SMTPSCRAMCredentials))
+	}
+
+	if auth.SMTPOAuthEnabled() {
+		if srv.AuthMechs == nil {
+			// disable password mechanisms unless credentials are configured
+			srv.AuthMechs = map[string]bool{
+				"CRAM-MD5": false,
+				"PLAIN":    false,
+				"LOGIN":    false,
+			}
+		}
+		srv.AuthMechs["XOAUTH2"] = true
+		srv.AuthMechs["OAUTHBEARER"] = true
+
+		if srv.AuthHandler == nil {
+			srv.AuthHandler = authHandler
+			srv.AuthRequired = !auth.SMTPOAuthAcceptAny
+		} else if config.SMTPAuthAcceptAny {
+			// any password is accepted, but bearer tokens are still validated
+			srv.AuthHandler = func(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error) {
+				if mechanism == "XOAUTH2" || mechanism == "OAUTHBEARER" {
+					return authHandler(remoteAddr, mechanism, username, password, shared)
+				}
+				return authHandlerAny(remoteAddr, mechanism, username, password, shared)
+			}
+		}
+	}
+
 	if config.SMTPTLSCert != "" {
 		srv.TLSRequired = config.SMTPRequireSTARTTLS
 		srv.TLSListener = config.SMTPRequireTLS // if true overrules srv.TLSRequired
 		if err := srv.ConfigureTLS(config.SMTPTLSCert, config.SMTPTLSKey); err != nil {
 			return err
 		}
+
+		if config.SMTPTLSClientCA != "" {
+			if err := srv.ConfigureClientTLS(config.SMTPTLSClientCA, config.SMTPRequireClientCert); err != nil {
+				return err
+			}
+
+			if srv.AuthMechs == nil {
+				// disable password mechanisms unless credentials are configured
+				srv.AuthMechs = map[string]bool{
+					"CRAM-MD5": false,
+					"PLAIN":    false,
+					"LOGIN":    false,
+				}
+			}
+			srv.AuthMechs["EXTERNAL"] = true
+
+			if srv.AuthHandler == nil {
+				srv.AuthHandler = authHandler
+			}
+		}
 	}
 
 	if isSocket {
@@ -330,28 +438,31 @@ func cleanIP(i net.Addr) string {
 	return parts[0]
 }
 
-// Returns a list of all lowercased emails found in To, Cc and Bcc,
-// as well as whether there is a Bcc field
+// Returns a list of all lowercased emails found in To, Cc and Bcc, normalised to their
+// Unicode form, as well as whether there is a Bcc field
 func scanAddressesInHeader(h mail.Header) (map[string]bool, bool) {
 	emails := make(map[string]bool)
 	hasBccHeader := false
 
 	if recipients, err := h.AddressList("To"); err == nil {
 		for _, r := range recipients {
-			emails[strings.ToLower(r.Address)] = true
+			u, _ := tools.NormalizeAddress(r.Address)
+			emails[strings.ToLower(u)] = true
 		}
 	}
 
 	if recipients, err := h.AddressList("Cc"); err == nil {
 		for _, r := range recipients {
-			emails[strings.ToLower(r.Address)] = true
+			u, _ := tools.NormalizeAddress(r.Address)
+			emails[strings.ToLower(u)] = true
 		}
 	}
 
 	recipients, err := h.AddressList("Bcc")
 	if err == nil {
 		for _, r := range recipients {
-			emails[strings.ToLower(r.Address)] = true
+			u, _ := tools.NormalizeAddress(r.Address)
+			emails[strings.ToLower(u)] = true
 		}
 
 		hasBccHeader = true
@@ -0,0 +1,326 @@
+package smtpd
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/storage"
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+const (
+	// QueueTypeRelease is a queued manual message release
+	QueueTypeRelease = "release"
+	// QueueTypeRelay is a queued message relay
+	QueueTypeRelay = "relay"
+	// QueueTypeForward is a queued message forward
+	QueueTypeForward = "forward"
+
+	queueWorkers    = 4
+	queueInterval   = 10 * time.Second
+	queueMinBackoff = time.Minute
+	queueMaxBackoff = time.Hour
+)
+
+var (
+	queueWake      = make(chan struct{}, 1)
+	queueJobs      = make(chan storage.QueueJob, queueWorkers)
+	queueBacklog   atomic.Bool
+	queueInFlight  = map[string]bool{}
+	queueMu        sync.Mutex
+	queueDelivered atomic.Uint64
+	queueFailures  atomic.Uint64
+)
+
+// QueueStatus is the outbound delivery queue
+//
+// swagger:model QueueResponse
+type QueueStatus struct {
+	// Number of queued deliveries
+	Queued uint64
+	// Number of scheduled releases
+	Scheduled uint64
+	// Number of failed deliveries
+	Failed uint64
+	// Total number of deliveries since startup
+	Delivered uint64
+	// Total number of failed delivery attempts since startup
+	FailedAttempts uint64
+	// Queued, scheduled & failed deliveries, oldest first
+	Jobs []storage.QueueJob
+}
+
+// Enqueue adds a release, relay or forward delivery to the outbound queue for immediate delivery.
+// The message database ID of the job is optional, and is used to record the delivery attempts of stored messages.
+func Enqueue(job storage.QueueJob, msg []byte) error {
+	id, err := storage.QueueAdd(job, msg)
+	if err != nil {
+		return err
+	}
+
+	logger.Log().Debugf("[queue] queued %s %s from %s to %s", job.Type, id, job.From, strings.Join(job.To, ", "))
+
+	wakeQueue()
+
+	return nil
+}
+
+// scheduleRelease adds a release to the outbound queue for delivery at the job NextAttempt
+func scheduleRelease(job storage.QueueJob, msg []byte) error {
+	id, err := storage.QueueAdd(job, msg)
+	if err != nil {
+		return err
+	}
+
+	logger.Log().Debugf("[queue] scheduled %s %s from %s to %s at %s", job.Type, id, job.From, strings.Join(job.To, ", "), job.NextAttempt.Format(time.RFC3339))
+
+	return nil
+}
+
+// CancelScheduledRelease deletes a scheduled release from the outbound queue
+func CancelScheduledRelease(id string) error {
+	if err := storage.QueueCancelScheduled(id); err != nil {
+		return err
+	}
+
+	logger.Log().Debugf("[queue] cancelled scheduled release %s", id)
+
+	return nil
+}
+
+// RelayOrQueue releases a stored message immediately via the relay profile (empty for the default relay
+// configuration), queueing it for further attempts if the relay server is temporarily unavailable.
+// Permanent errors (5xx responses) are returned.
+func RelayOrQueue(profile, messageID, from string, to []string, msg []byte) error {
+	p, err := config.RelayProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	code, text, err := relay(p, from, to, msg)
+	recordRelayLog(messageID, newRelayLog(QueueTypeRelease, relayHost(p), to, start, code, text, err))
+	if err == nil || isPermanentError(err) {
+		return err
+	}
+
+	logger.Log().Warnf("[queue] relay failed, queueing for retry: %s", err.Error())
+
+	return Enqueue(storage.QueueJob{Type: QueueTypeRelease, Profile: profile, MessageID: messageID, From: from, To: to}, msg)
+}
+
+// RetryQueued schedules a queued or failed delivery for an immediate attempt
+func RetryQueued(id string) error {
+	if err := storage.QueueRetry(id); err != nil {
+		return err
+	}
+
+	wakeQueue()
+
+	return nil
+}
+
+// GetQueueStatus returns the queue counters & deliveries
+func GetQueueStatus() (QueueStatus, error) {
+	jobs, err := storage.QueueList()
+	if err != nil {
+		return QueueStatus{}, err
+	}
+
+	stats := storage.QueueGetStats()
+
+	return QueueStatus{
+		Queued:         stats.Queued,
+		Scheduled:      stats.Scheduled,
+		Failed:         stats.Failed,
+		Delivered:      queueDelivered.Load(),
+		FailedAttempts: queueFailures.Load(),
+		Jobs:           jobs,
+	}, nil
+}
+
+// processQueue delivers due queued deliveries, polling the queue & on demand
+func processQueue() {
+	for range queueWorkers {
+		go queueWorker()
+	}
+
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+
+	for {
+		deliverQueue()
+
+		select {
+		case <-ticker.C:
+		case <-queueWake:
+		}
+	}
+}
+
+// wakeQueue triggers the queue worker without blocking
+func wakeQueue() {
+	select {
+	case queueWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverQueue passes due deliveries to the queue workers without waiting for them to be delivered.
+// Deliveries already in flight are skipped, as are those the workers have no capacity for, which
+// are picked up again on the next run.
+func deliverQueue() {
+	jobs, err := storage.QueueDue(100)
+	if err != nil {
+		logger.Log().Errorf("[queue] %s", err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		queueMu.Lock()
+		if queueInFlight[job.ID] {
+			queueMu.Unlock()
+			continue
+		}
+		queueInFlight[job.ID] = true
+		queueMu.Unlock()
+
+		select {
+		case queueJobs <- job:
+		default:
+			queueMu.Lock()
+			delete(queueInFlight, job.ID)
+			queueMu.Unlock()
+			queueBacklog.Store(true)
+			return
+		}
+	}
+}
+
+// queueWorker delivers queued deliveries passed by deliverQueue
+func queueWorker() {
+	for job := range queueJobs {
+		deliverQueued(job)
+
+		queueMu.Lock()
+		delete(queueInFlight, job.ID)
+		queueMu.Unlock()
+
+		// check for due deliveries skipped while the workers were busy
+		if queueBacklog.CompareAndSwap(true, false) {
+			wakeQueue()
+		}
+	}
+}
+
+// deliverQueued attempts a queued delivery, removing it from the queue once delivered,
+// else scheduling the next attempt with an exponential backoff
+func deliverQueued(job storage.QueueJob) {
+	_, msg, err := storage.QueueGet(job.ID)
+	if err != nil {
+		logger.Log().Errorf("[queue] %s", err.Error())
+		return
+	}
+
+	// the Date header of a release is set to when it is actually sent, as releases may be scheduled
+	if job.Type == QueueTypeRelease {
+		if msg, err = tools.SetMessageHeader(msg, "Date", time.Now().Format(time.RFC1123Z)); err != nil {
+			logger.Log().Errorf("[queue] %s", err.Error())
+			return
+		}
+	}
+
+	start := time.Now()
+	host := ""
+	permanent := false
+	var code int
+	var text string
+
+	switch job.Type {
+	case QueueTypeForward:
+		// the forward rule may have been removed since the delivery was queued
+		c, ruleErr := config.ForwardRule(job.Profile)
+		if ruleErr != nil {
+			err = ruleErr
+			permanent = true
+			break
+		}
+
+		host = forwardHost(c)
+		code, text, err = forward(c, job.From, msg)
+	default:
+		// the relay profile may have been removed since the delivery was queued
+		p, profileErr := config.RelayProfile(job.Profile)
+		if profileErr != nil {
+			err = profileErr
+			permanent = true
+			break
+		}
+
+		host = relayHost(p)
+		code, text, err = relay(p, job.From, job.To, msg)
+	}
+
+	recordRelayLog(job.MessageID, newRelayLog(job.Type, host, job.To, start, code, text, err))
+
+	if err == nil {
+		queueDelivered.Add(1)
+		logger.Log().Debugf("[queue] delivered %s %s to %s", job.Type, job.ID, strings.Join(job.To, ", "))
+		if err := storage.QueueDelete(job.ID); err != nil {
+			logger.Log().Errorf("[queue] %s", err.Error())
+		}
+		return
+	}
+
+	queueFailures.Add(1)
+
+	attempts := job.Attempts + 1
+	next := time.Now().Add(queueBackoff(attempts))
+	status := storage.QueueStatusQueued
+
+	if permanent || isPermanentError(err) || next.Sub(job.Created) > config.SMTPRelayQueueMaxAgeDuration {
+		status = storage.QueueStatusFailed
+		logger.Log().Errorf("[queue] %s %s failed after %d attempt(s): %s", job.Type, job.ID, attempts, err.Error())
+	} else {
+		logger.Log().Warnf("[queue] %s %s attempt %d failed, retrying at %s: %s", job.Type, job.ID, attempts, next.Format(time.RFC3339), err.Error())
+	}
+
+	if err := storage.QueueUpdate(job.ID, attempts, next, err.Error(), status); err != nil {
+		logger.Log().Errorf("[queue] %s", err.Error())
+	}
+}
+
+// queueBackoff returns the delay before the next attempt, doubling with each attempt
+func queueBackoff(attempts int) time.Duration {
+	d := queueMinBackoff
+	for i := 1; i < attempts && d < queueMaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, queueMaxBackoff)
+}
+
+// isPermanentError returns whether a delivery error is a permanent (5xx) SMTP error
+func isPermanentError(err error) bool {
+	var e *textproto.Error
+
+	return errors.As(err, &e) && e.Code >= 500
+}
+
+// forwardRecipients returns the recipients of a forwarding configuration
+func forwardRecipients(c *config.SMTPForwardConfigStruct) []string {
+	to := []string{}
+	for addr := range strings.SplitSeq(c.To, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+
+	return to
+}
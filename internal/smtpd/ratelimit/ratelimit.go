@@ -0,0 +1,296 @@
+// Package ratelimit enforces SMTP connection, message rate & data quota limits
+// per client IP and authenticated user
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// TypeIP identifies a client IP address
+	TypeIP = "ip"
+	// TypeUser identifies an authenticated SMTP user
+	TypeUser = "user"
+
+	messageWindow = time.Minute
+	sizeWindow    = time.Hour
+)
+
+var (
+	// MaxConnections is the maximum number of concurrent connections per client IP, 0 = unlimited
+	MaxConnections int
+
+	// MaxMessages is the maximum number of messages per minute per client IP, 0 = unlimited
+	MaxMessages int
+
+	// MaxSize is the maximum message data in bytes per hour per client IP, 0 = unlimited
+	MaxSize int
+
+	// MaxUserConnections is the maximum number of concurrent connections per authenticated user, 0 = unlimited
+	MaxUserConnections int
+
+	// MaxUserMessages is the maximum number of messages per minute per authenticated user, 0 = unlimited
+	MaxUserMessages int
+
+	// MaxUserSize is the maximum message data in bytes per hour per authenticated user, 0 = unlimited
+	MaxUserSize int
+
+	clients = map[string]*client{}
+	mu      sync.Mutex
+)
+
+// client is the tracked usage of a client IP or authenticated user
+type client struct {
+	typ         string
+	name        string
+	connections int
+	messages    []delivery
+}
+
+// delivery is an accepted message
+type delivery struct {
+	time time.Time
+	size int
+}
+
+// Status is the configured limits & current usage
+//
+// swagger:model RateLimitsResponse
+type Status struct {
+	// Maximum concurrent connections per client IP (0 = unlimited)
+	MaxConnections int
+	// Maximum messages per minute per client IP (0 = unlimited)
+	MaxMessages int
+	// Maximum message data in bytes per hour per client IP (0 = unlimited)
+	MaxSize int
+	// Maximum concurrent connections per authenticated user (0 = unlimited)
+	MaxUserConnections int
+	// Maximum messages per minute per authenticated user (0 = unlimited)
+	MaxUserMessages int
+	// Maximum message data in bytes per hour per authenticated user (0 = unlimited)
+	MaxUserSize int
+	// Current usage per client IP & authenticated user
+	Clients []Usage
+}
+
+// Usage is the current usage of a client IP or authenticated user
+//
+// swagger:model RateLimitUsage
+type Usage struct {
+	// Client type: ip or user
+	// example: ip
+	Type string
+	// Client IP address or username
+	// example: 127.0.0.1
+	Name string
+	// Current number of connections
+	Connections int
+	// Number of messages accepted in the last minute
+	Messages int
+	// Message data accepted in the last hour in bytes
+	Size int
+}
+
+// Enabled returns whether any limits are set
+func Enabled() bool {
+	return MaxConnections > 0 || MaxMessages > 0 || MaxSize > 0 ||
+		MaxUserConnections > 0 || MaxUserMessages > 0 || MaxUserSize > 0
+}
+
+// limits returns the connection, message & data limits of the client type
+func limits(typ string) (int, int, int) {
+	if typ == TypeUser {
+		return MaxUserConnections, MaxUserMessages, MaxUserSize
+	}
+
+	return MaxConnections, MaxMessages, MaxSize
+}
+
+// Connect registers a new connection, returning false if the connection limit is exceeded
+func Connect(typ, name string) bool {
+	if !Enabled() {
+		return true
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	maxConnections, _, _ := limits(typ)
+
+	c := get(typ, name)
+	if maxConnections > 0 && c.connections >= maxConnections {
+		return false
+	}
+
+	c.connections++
+
+	return true
+}
+
+// Disconnect unregisters a connection registered with Connect
+func Disconnect(typ, name string) {
+	if !Enabled() {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c, ok := clients[typ+"|"+name]; ok && c.connections > 0 {
+		c.connections--
+	}
+}
+
+// Check returns an SMTP error response if accepting a message of the size from the client IP
+// & authenticated user (if set) would exceed a limit, else an empty string
+func Check(ip string, user *string, size int) string {
+	if !Enabled() {
+		return ""
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for _, c := range lookup(ip, user) {
+		c.prune(now)
+		_, maxMessages, maxSize := limits(c.typ)
+
+		if maxMessages > 0 && c.messageCount(now) >= maxMessages {
+			return fmt.Sprintf("421 4.7.0 Message rate limit of %d per minute exceeded for %s, try again later", maxMessages, c.name)
+		}
+
+		if maxSize > 0 && c.size()+size > maxSize {
+			return fmt.Sprintf("452 4.3.1 Hourly data quota of %d bytes exceeded for %s, try again later", maxSize, c.name)
+		}
+	}
+
+	return ""
+}
+
+// Record registers an accepted message of the size from the client IP & authenticated user (if set)
+func Record(ip string, user *string, size int) {
+	if !Enabled() {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for _, c := range lookup(ip, user) {
+		c.prune(now)
+		c.messages = append(c.messages, delivery{time: now, size: size})
+	}
+}
+
+// GetStatus returns the configured limits & current usage
+func GetStatus() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := Status{
+		MaxConnections:     MaxConnections,
+		MaxMessages:        MaxMessages,
+		MaxSize:            MaxSize,
+		MaxUserConnections: MaxUserConnections,
+		MaxUserMessages:    MaxUserMessages,
+		MaxUserSize:        MaxUserSize,
+		Clients:            []Usage{},
+	}
+
+	now := time.Now()
+	for key, c := range clients {
+		c.prune(now)
+		if c.connections == 0 && len(c.messages) == 0 {
+			delete(clients, key)
+			continue
+		}
+
+		s.Clients = append(s.Clients, Usage{
+			Type:        c.typ,
+			Name:        c.name,
+			Connections: c.connections,
+			Messages:    c.messageCount(now),
+			Size:        c.size(),
+		})
+	}
+
+	sort.Slice(s.Clients, func(i, j int) bool {
+		if s.Clients[i].Type != s.Clients[j].Type {
+			return s.Clients[i].Type < s.Clients[j].Type
+		}
+		return s.Clients[i].Name < s.Clients[j].Name
+	})
+
+	return s
+}
+
+// Reset deletes the message & data usage, leaving current connections
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for key, c := range clients {
+		c.messages = nil
+		if c.connections == 0 {
+			delete(clients, key)
+		}
+	}
+}
+
+// get returns the tracked client, creating it if required. mu must be held.
+func get(typ, name string) *client {
+	key := typ + "|" + name
+	c, ok := clients[key]
+	if !ok {
+		c = &client{typ: typ, name: name}
+		clients[key] = c
+	}
+
+	return c
+}
+
+// lookup returns the tracked client IP & authenticated user (if set). mu must be held.
+func lookup(ip string, user *string) []*client {
+	c := []*client{get(TypeIP, ip)}
+	if user != nil {
+		c = append(c, get(TypeUser, *user))
+	}
+
+	return c
+}
+
+// prune deletes deliveries outside of the tracked windows
+func (c *client) prune(now time.Time) {
+	i := 0
+	for i < len(c.messages) && now.Sub(c.messages[i].time) >= sizeWindow {
+		i++
+	}
+	c.messages = c.messages[i:]
+}
+
+// messageCount returns the number of messages accepted in the last minute
+func (c *client) messageCount(now time.Time) int {
+	n := 0
+	for _, m := range c.messages {
+		if now.Sub(m.time) < messageWindow {
+			n++
+		}
+	}
+
+	return n
+}
+
+// size returns the message data accepted in the last hour, once pruned
+func (c *client) size() int {
+	n := 0
+	for _, m := range c.messages {
+		n += m.size
+	}
+
+	return n
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	user := "user1"
+
+	// disabled
+	if !Connect(TypeIP, "192.0.2.1") || Check("192.0.2.1", &user, 100) != "" {
+		t.Error("expected no limits")
+	}
+
+	MaxConnections, MaxMessages, MaxSize = 1, 3, 0
+	MaxUserConnections, MaxUserMessages, MaxUserSize = 2, 3, 1000
+	defer func() {
+		MaxConnections, MaxMessages, MaxSize = 0, 0, 0
+		MaxUserConnections, MaxUserMessages, MaxUserSize = 0, 0, 0
+		clients = map[string]*client{}
+	}()
+
+	// client IP connections use the client IP limit
+	if !Connect(TypeIP, "192.0.2.9") || Connect(TypeIP, "192.0.2.9") {
+		t.Error("expected the client IP connection limit to be exceeded")
+	}
+	Disconnect(TypeIP, "192.0.2.9")
+
+	for range 2 {
+		if !Connect(TypeUser, user) {
+			t.Error("expected the connection to be accepted")
+		}
+	}
+	if Connect(TypeUser, user) {
+		t.Error("expected the connection limit to be exceeded")
+	}
+	Disconnect(TypeUser, user)
+	if !Connect(TypeUser, user) {
+		t.Error("expected the connection to be accepted after a disconnect")
+	}
+
+	// data quota
+	Record("192.0.2.1", &user, 600)
+	if resp := Check("192.0.2.2", &user, 500); !strings.HasPrefix(resp, "452 4.3.1") {
+		t.Errorf("expected the user data quota to be exceeded, got %q", resp)
+	}
+	if resp := Check("192.0.2.2", nil, 500); resp != "" {
+		t.Errorf("expected the client IP without a data quota to be accepted, got %q", resp)
+	}
+
+	// message rate
+	Record("192.0.2.1", nil, 100)
+	Record("192.0.2.1", nil, 100)
+	if resp := Check("192.0.2.1", nil, 0); !strings.HasPrefix(resp, "421 4.7.0") {
+		t.Errorf("expected the message rate to be exceeded, got %q", resp)
+	}
+
+	s := GetStatus()
+	if len(s.Clients) != 2 {
+		t.Fatalf("unexpected usage %+v", s)
+	}
+	if u := s.Clients[0]; u.Type != TypeIP || u.Name != "192.0.2.1" || u.Messages != 3 || u.Size != 800 {
+		t.Errorf("unexpected IP usage %+v", u)
+	}
+	if u := s.Clients[1]; u.Type != TypeUser || u.Connections != 2 || u.Messages != 1 || u.Size != 600 {
+		t.Errorf("unexpected user usage %+v", u)
+	}
+
+	Reset()
+	if s := GetStatus(); len(s.Clients) != 1 || s.Clients[0].Messages != 0 {
+		t.Errorf("expected only the connected user after a reset, got %+v", s)
+	}
+}
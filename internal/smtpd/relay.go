@@ -3,66 +3,116 @@ package smtpd
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/smtp"
+	"net/textproto"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/axllent/mailpit/config"
 	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/storage"
 	"github.com/axllent/mailpit/internal/tools"
 	"github.com/pkg/errors"
 )
 
-// Wrapper to auto relay messages if configured
-func autoRelayMessage(from string, to []string, data *[]byte) error {
-	if config.SMTPRelayConfig.BlockedRecipientsRegexp != nil {
-		filteredTo := []string{}
-		for _, address := range to {
-			if config.SMTPRelayConfig.BlockedRecipientsRegexp.MatchString(address) {
-				logger.Log().Debugf("[relay] ignoring auto-relay to %s: found in blocklist", address)
-				continue
-			}
+const (
+	// smtpDialTimeout is the timeout to connect to a relay or forwarding SMTP server
+	smtpDialTimeout = 30 * time.Second
+	// smtpSessionTimeout is the maximum duration of an SMTP session with a relay or forwarding SMTP server
+	smtpSessionTimeout = 5 * time.Minute
+)
 
-			filteredTo = append(filteredTo, address)
+// Wrapper to auto relay messages if configured
+func autoRelayMessage(from string, to []string, data *[]byte, o *outbound) error {
+	for _, route := range autoRelayRoutes(to) {
+		if err := relayOrEnqueue(o, route.profile, from, route.to, *data); err != nil {
+			return errors.WithMessage(err, "[relay] error")
 		}
-		to = filteredTo
-	}
 
-	if len(to) == 0 {
-		return nil
+		p, _ := config.RelayProfile(route.profile)
+		logger.Log().Debugf(
+			"[relay] auto-relay message to %s from %s via %s",
+			strings.Join(route.to, ", "), from, relayHost(p),
+		)
 	}
 
-	if config.SMTPRelayAll {
-		if err := Relay(from, to, *data); err != nil {
-			return errors.WithMessage(err, "[relay] error")
+	return nil
+}
+
+// relayRoute is the recipients to auto-relay via a relay profile
+type relayRoute struct {
+	profile string
+	to      []string
+}
+
+// autoRelayRoutes returns the recipients to auto-relay, grouped by relay profile. Recipients matching
+// a named profile are relayed via the first matching profile (alphabetically), else via the default
+// relay configuration if all messages are relayed or the recipient matches --smtp-relay-matching.
+// Recipients found in the blocklist of the profile are ignored.
+func autoRelayRoutes(to []string) []relayRoute {
+	names := config.RelayProfileNames()
+	grouped := map[string][]string{}
+
+	for _, address := range to {
+		profile, ok := autoRelayProfile(names, address)
+		if !ok {
+			continue
 		}
 
-		logger.Log().Debugf(
-			"[relay] sent message to %s from %s via %s:%d",
-			strings.Join(to, ", "), from, config.SMTPRelayConfig.Host, config.SMTPRelayConfig.Port,
-		)
-	} else if config.SMTPRelayMatchingRegexp != nil {
-		filtered := []string{}
-		for _, t := range to {
-			if config.SMTPRelayMatchingRegexp.MatchString(t) {
-				filtered = append(filtered, t)
-			}
+		p, _ := config.RelayProfile(profile)
+		if p.BlockedRecipientsRegexp != nil && p.BlockedRecipientsRegexp.MatchString(address) {
+			logger.Log().Debugf("[relay] ignoring auto-relay to %s: found in blocklist", address)
+			continue
 		}
 
-		if len(filtered) == 0 {
-			return nil
+		grouped[profile] = append(grouped[profile], address)
+	}
+
+	routes := []relayRoute{}
+	for _, profile := range append([]string{""}, names...) {
+		if len(grouped[profile]) > 0 {
+			routes = append(routes, relayRoute{profile: profile, to: grouped[profile]})
 		}
+	}
 
-		if err := Relay(from, filtered, *data); err != nil {
-			return errors.WithMessage(err, "[relay] error")
+	return routes
+}
+
+// autoRelayProfile returns the relay profile to auto-relay a recipient via, if any
+func autoRelayProfile(names []string, address string) (string, bool) {
+	for _, name := range names {
+		re := config.SMTPRelayConfig.Profiles[name].MatchingRegexp
+		if re != nil && re.MatchString(address) {
+			return name, true
 		}
+	}
 
-		logger.Log().Debugf(
-			"[relay] auto-relay message to %s from %s via %s:%d",
-			strings.Join(filtered, ", "), from, config.SMTPRelayConfig.Host, config.SMTPRelayConfig.Port,
-		)
+	if config.SMTPRelayAll || config.SMTPRelayMatchingRegexp != nil && config.SMTPRelayMatchingRegexp.MatchString(address) {
+		return "", true
+	}
+
+	return "", false
+}
+
+// relayOrEnqueue queues a message for relaying via the relay profile, or relays it immediately
+// if SMTP errors are forwarded to the client
+func relayOrEnqueue(o *outbound, profile, from string, to []string, data []byte) error {
+	p, err := config.RelayProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	if p.ForwardSMTPErrors {
+		start := time.Now()
+		code, text, err := relay(p, from, to, data)
+		o.attempt(newRelayLog(QueueTypeRelay, relayHost(p), to, start, code, text, err))
+		return err
 	}
 
+	o.enqueue(storage.QueueJob{Type: QueueTypeRelay, Profile: profile, From: from, To: to}, data)
+
 	return nil
 }
 
@@ -71,12 +121,12 @@ func createRelaySMTPClient(config config.SMTPRelayConfigStruct, addr string) (*s
 		tlsConf := &tls.Config{ServerName: config.Host} // #nosec
 		tlsConf.InsecureSkipVerify = config.AllowInsecure
 
-		conn, err := tls.Dial("tcp", addr, tlsConf)
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, tlsConf)
 		if err != nil {
 			return nil, fmt.Errorf("TLS dial error: %v", err)
 		}
 
-		client, err := smtp.NewClient(conn, tlsConf.ServerName)
+		client, err := newSMTPClient(conn, tlsConf.ServerName)
 		if err != nil {
 			_ = conn.Close()
 			return nil, fmt.Errorf("SMTP client error: %v", err)
@@ -86,11 +136,18 @@ func createRelaySMTPClient(config config.SMTPRelayConfigStruct, addr string) (*s
 		return client, nil
 	}
 
-	client, err := smtp.Dial(addr)
+	conn, err := net.DialTimeout("tcp", addr, smtpDialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to %s: %v", addr, err)
 	}
 
+	host, _, _ := net.SplitHostPort(addr)
+	client, err := newSMTPClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error connecting to %s: %v", addr, err)
+	}
+
 	// Set the hostname for HELO/EHLO
 	if hostname, err := os.Hostname(); err == nil {
 		if err := client.Hello(hostname); err != nil {
@@ -112,76 +169,191 @@ func createRelaySMTPClient(config config.SMTPRelayConfigStruct, addr string) (*s
 	return client, nil
 }
 
+// newSMTPClient returns an SMTP client for the connection, setting a deadline for the whole
+// SMTP session so an unresponsive server cannot stall a delivery indefinitely
+func newSMTPClient(conn net.Conn, host string) (*smtp.Client, error) {
+	if err := conn.SetDeadline(time.Now().Add(smtpSessionTimeout)); err != nil {
+		return nil, err
+	}
+
+	return smtp.NewClient(conn, host)
+}
+
 // Relay will connect to a pre-configured SMTP server and send a message to one or more recipients.
 func Relay(from string, to []string, msg []byte) error {
-	addr := fmt.Sprintf("%s:%d", config.SMTPRelayConfig.Host, config.SMTPRelayConfig.Port)
+	_, _, err := relay(&config.SMTPRelayConfig, from, to, msg)
 
-	c, err := createRelaySMTPClient(config.SMTPRelayConfig, addr)
+	return err
+}
+
+// relay sends a message via the relay profile SMTP server, returning the final SMTP response code & text,
+// or delivers it via the provider API or locally if the relay profile uses a provider or local delivery backend
+func relay(p *config.SMTPRelayConfigStruct, from string, to []string, msg []byte) (int, string, error) {
+	msg, err := p.Rewrite.Rules.Apply(msg, from, to)
 	if err != nil {
-		return err
+		return 0, "", fmt.Errorf("error rewriting message: %s", err.Error())
+	}
+
+	if p.OverrideFrom != "" {
+		msg, err = tools.OverrideFromHeader(msg, p.OverrideFrom)
+		if err != nil {
+			return 0, "", fmt.Errorf("error overriding From header: %s", err.Error())
+		}
+
+		from = p.OverrideFrom
+	}
+
+	// sign after any header changes
+	if p.DKIM.Signer != nil {
+		msg, err = p.DKIM.Signer.Sign(msg)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if p.Backend != nil {
+		return 0, "", p.Backend.Deliver(from, to, msg)
+	}
+
+	if p.ProviderClient != nil {
+		return p.ProviderClient.Send(from, to, msg)
+	}
+
+	c, err := createRelaySMTPClient(*p, relayHost(p))
+	if err != nil {
+		return 0, "", err
 	}
 	defer func() { _ = c.Close() }()
 
-	auth := relayAuthFromConfig()
+	auth := relayAuthFromConfig(p)
 
 	if auth != nil {
 		if err = c.Auth(auth); err != nil {
-			return fmt.Errorf("error response to AUTH command: %s", err.Error())
+			return 0, "", fmt.Errorf("error response to AUTH command: %w", err)
 		}
 	}
 
-	if config.SMTPRelayConfig.OverrideFrom != "" {
-		msg, err = tools.OverrideFromHeader(msg, config.SMTPRelayConfig.OverrideFrom)
+	// internationalised addresses must be downgraded if the relay server does not support SMTPUTF8
+	if ok, _ := c.Extension("SMTPUTF8"); !ok {
+		from, to, err = downgradeAddresses(p.ForwardSMTPErrors, from, to)
 		if err != nil {
-			return fmt.Errorf("error overriding From header: %s", err.Error())
+			return 0, "", err
 		}
-
-		from = config.SMTPRelayConfig.OverrideFrom
 	}
 
 	if err = c.Mail(from); err != nil {
-		return errors.WithMessage(err, "error sending MAIL command")
+		return 0, "", errors.WithMessage(err, "error sending MAIL command")
 	}
 
 	for _, addr := range to {
 		if err = c.Rcpt(addr); err != nil {
 			logger.Log().Warnf("error response to RCPT command for %s: %s", addr, err.Error())
-			if config.SMTPRelayConfig.ForwardSMTPErrors {
-				return errors.WithMessagef(err, "error response to RCPT command for %s", addr)
+			if p.ForwardSMTPErrors {
+				return 0, "", errors.WithMessagef(err, "error response to RCPT command for %s", addr)
 			}
 		}
 	}
 
-	w, err := c.Data()
+	code, text, err := sendData(c, msg)
 	if err != nil {
-		return errors.WithMessage(err, "error response to DATA command")
+		return 0, "", err
+	}
+
+	return code, text, c.Quit()
+}
+
+// relayHost returns the host & port of the relay profile SMTP server, or the provider API or local delivery URL
+func relayHost(p *config.SMTPRelayConfigStruct) string {
+	if p.ProviderClient != nil {
+		return p.ProviderClient.String()
 	}
 
+	if p.Backend != nil {
+		return p.Backend.String()
+	}
+
+	return fmt.Sprintf("%s:%d", p.Host, p.Port)
+}
+
+// sendData sends the DATA command & message, returning the final SMTP response code & text
+// which the net/smtp DATA writer does not expose
+func sendData(c *smtp.Client, msg []byte) (int, string, error) {
+	id, err := c.Text.Cmd("DATA")
+	if err != nil {
+		return 0, "", errors.WithMessage(err, "error response to DATA command")
+	}
+
+	c.Text.StartResponse(id)
+	_, _, err = c.Text.ReadResponse(354)
+	c.Text.EndResponse(id)
+	if err != nil {
+		return 0, "", errors.WithMessage(err, "error response to DATA command")
+	}
+
+	w := c.Text.DotWriter()
 	if _, err := w.Write(msg); err != nil {
-		return errors.WithMessage(err, "error sending message")
+		return 0, "", errors.WithMessage(err, "error sending message")
 	}
 
 	if err := w.Close(); err != nil {
-		return errors.WithMessage(err, "error closing connection")
+		return 0, "", errors.WithMessage(err, "error sending message")
+	}
+
+	code, text, err := c.Text.ReadResponse(250)
+	if err != nil {
+		return 0, "", errors.WithMessage(err, "error closing connection")
+	}
+
+	return code, text, nil
+}
+
+// downgradeAddresses returns the ASCII (punycode) forms of the sender & recipient addresses for a relay or
+// forwarding server without the SMTPUTF8 extension (RFC 6531). Addresses with a non-ASCII local part cannot be
+// downgraded: the sender returns an error, and recipients are skipped unless SMTP errors are forwarded.
+func downgradeAddresses(forwardSMTPErrors bool, from string, to []string) (string, []string, error) {
+	if from != "" {
+		_, a := tools.NormalizeAddress(from)
+		if a == "" {
+			return "", nil, &textproto.Error{Code: 553, Msg: "5.6.7 sender " + from + " requires SMTPUTF8 which is not supported by the server"}
+		}
+		from = a
+	}
+
+	recipients := []string{}
+	for _, addr := range to {
+		_, a := tools.NormalizeAddress(addr)
+		if a == "" {
+			err := &textproto.Error{Code: 553, Msg: "5.6.7 recipient " + addr + " requires SMTPUTF8 which is not supported by the server"}
+			if forwardSMTPErrors {
+				return "", nil, err
+			}
+			logger.Log().Warnf("error response to RCPT command for %s: %s", addr, err.Error())
+			continue
+		}
+		recipients = append(recipients, a)
+	}
+
+	if len(recipients) == 0 {
+		return "", nil, &textproto.Error{Code: 553, Msg: "5.6.7 no recipients can be delivered without SMTPUTF8"}
 	}
 
-	return c.Quit()
+	return from, recipients, nil
 }
 
-// Return the SMTP relay authentication based on config
-func relayAuthFromConfig() smtp.Auth {
+// Return the SMTP relay authentication based on the relay profile
+func relayAuthFromConfig(p *config.SMTPRelayConfigStruct) smtp.Auth {
 	var a smtp.Auth
 
-	if config.SMTPRelayConfig.Auth == "plain" {
-		a = smtp.PlainAuth("", config.SMTPRelayConfig.Username, config.SMTPRelayConfig.Password, config.SMTPRelayConfig.Host)
+	if p.Auth == "plain" {
+		a = smtp.PlainAuth("", p.Username, p.Password, p.Host)
 	}
 
-	if config.SMTPRelayConfig.Auth == "login" {
-		a = LoginAuth(config.SMTPRelayConfig.Username, config.SMTPRelayConfig.Password)
+	if p.Auth == "login" {
+		a = LoginAuth(p.Username, p.Password)
 	}
 
-	if config.SMTPRelayConfig.Auth == "cram-md5" {
-		a = smtp.CRAMMD5Auth(config.SMTPRelayConfig.Username, config.SMTPRelayConfig.Secret)
+	if p.Auth == "cram-md5" {
+		a = smtp.CRAMMD5Auth(p.Username, p.Secret)
 	}
 
 	return a
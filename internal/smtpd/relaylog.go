@@ -0,0 +1,90 @@
+package smtpd
+
+import (
+	"errors"
+	"net/textproto"
+	"time"
+
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/storage"
+)
+
+// outbound collects the relay & forward deliveries of a received message, which are
+// recorded & queued once the message is stored and its database ID is known
+type outbound struct {
+	attempts []storage.RelayLog
+	queued   []queuedDelivery
+}
+
+// queuedDelivery is a delivery to be queued once the message is stored
+type queuedDelivery struct {
+	job storage.QueueJob
+	msg []byte
+}
+
+// attempt adds a delivery attempt
+func (o *outbound) attempt(l storage.RelayLog) {
+	o.attempts = append(o.attempts, l)
+}
+
+// enqueue adds a delivery to be queued
+func (o *outbound) enqueue(job storage.QueueJob, msg []byte) {
+	o.queued = append(o.queued, queuedDelivery{job: job, msg: msg})
+}
+
+// commit records the delivery attempts against the stored message & queues the deliveries.
+// The message ID is empty if the message was not stored, in which case attempts are not recorded.
+func (o *outbound) commit(id string) {
+	for _, l := range o.attempts {
+		recordRelayLog(id, l)
+	}
+
+	for _, q := range o.queued {
+		q.job.MessageID = id
+		if err := Enqueue(q.job, q.msg); err != nil {
+			logger.Log().Errorf("[%s] error: %s", q.job.Type, err.Error())
+		}
+	}
+
+	o.attempts = nil
+	o.queued = nil
+}
+
+// newRelayLog returns the relay log of a delivery attempt. The response code & text are
+// taken from the SMTP error if the delivery failed.
+func newRelayLog(typ, host string, to []string, start time.Time, code int, text string, err error) storage.RelayLog {
+	l := storage.RelayLog{
+		Created:    start,
+		Type:       typ,
+		Host:       host,
+		Recipients: to,
+		Code:       code,
+		Response:   text,
+		Duration:   time.Since(start).Milliseconds(),
+		Success:    err == nil,
+	}
+
+	if err != nil {
+		var e *textproto.Error
+		if errors.As(err, &e) {
+			l.Code = e.Code
+			l.Response = e.Msg
+		} else {
+			l.Code = 0
+			l.Response = err.Error()
+		}
+	}
+
+	return l
+}
+
+// recordRelayLog records a delivery attempt against a stored message
+func recordRelayLog(id string, l storage.RelayLog) {
+	if id == "" {
+		return
+	}
+
+	if err := storage.AddRelayLog(id, l); err != nil {
+		logger.Log().Errorf("[db] error recording relay log: %s", err.Error())
+	}
+}
@@ -0,0 +1,358 @@
+package smtpd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/shortuuid"
+	"github.com/axllent/mailpit/internal/storage"
+	"github.com/axllent/mailpit/internal/tools"
+	"github.com/axllent/mailpit/server/websockets"
+)
+
+const (
+	// releaseBatchSize is the number of search results released per batch
+	releaseBatchSize = 100
+
+	// releaseJobTTL is how long a completed release job is retained
+	releaseJobTTL = time.Hour
+)
+
+var (
+	releaseJobs = map[string]*ReleaseJob{}
+	releaseMu   sync.Mutex
+)
+
+// ReleaseJob is a bulk release of the messages matching a search
+//
+// swagger:model ReleaseJob
+type ReleaseJob struct {
+	// Unique job ID
+	ID string
+	// Search query
+	// example: tag:newsletter-v2
+	Search string
+	// Named relay profile, empty for the default relay configuration
+	Profile string
+	// Recipients, empty to release each message to its original recipients
+	To []string
+	// Date & time the job was started
+	Created time.Time
+	// Number of messages matching the search
+	Total int
+	// Number of messages released or queued for release
+	Released int
+	// Number of messages skipped as none of the original recipients are permitted by the relay allow & block lists
+	Skipped int
+	// Number of messages which failed to release
+	Failed int
+	// Whether the job has completed
+	Complete bool
+
+	// completed is when the job completed, used to prune completed jobs
+	completed time.Time
+}
+
+// ReleaseMessage releases a stored message via the relay profile (empty for the default relay configuration)
+// to the recipients. The recipients must be permitted by the allow & block lists of the relay profile.
+// The Bcc header is removed, and the Date, Return-Path & Message-ID headers are set as configured.
+// If sendAt is in the future, the release is scheduled in the outbound queue, else it is released immediately.
+// Delivery attempts are recorded in the relay log of the stored message ID, unless the ID is empty.
+func ReleaseMessage(profile, id string, msg []byte, to []string, sendAt time.Time) error {
+	p, err := config.RelayProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	if err := checkReleaseRecipients(p, to); err != nil {
+		return err
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+
+	fromAddresses, err := m.Header.AddressList("From")
+	if err != nil {
+		return errors.New("Failed: unable to parse From header: " + err.Error())
+	}
+
+	if len(fromAddresses) == 0 {
+		return errors.New("No From header found")
+	}
+
+	from := fromAddresses[0].Address
+
+	// if sender is used, then change from to the sender
+	if senders, err := m.Header.AddressList("Sender"); err == nil {
+		from = senders[0].Address
+	}
+
+	msg, err = tools.RemoveMessageHeaders(msg, []string{"Bcc"})
+	if err != nil {
+		return err
+	}
+
+	// set the Return-Path and SMTP from
+	if p.ReturnPath != "" {
+		if m.Header.Get("Return-Path") != "<"+p.ReturnPath+">" {
+			msg, err = tools.RemoveMessageHeaders(msg, []string{"Return-Path"})
+			if err != nil {
+				return err
+			}
+			msg = append([]byte("Return-Path: <"+p.ReturnPath+">\r\n"), msg...)
+		}
+
+		from = p.ReturnPath
+	}
+
+	// update message date
+	msg, err = tools.SetMessageHeader(msg, "Date", time.Now().Format(time.RFC1123Z))
+	if err != nil {
+		return err
+	}
+
+	if !p.PreserveMessageIDs {
+		// replace the Message-ID header with unique ID
+		uid := shortuuid.New() + "@mailpit"
+		msg, err = tools.SetMessageHeader(msg, "Message-ID", "<"+uid+">")
+		if err != nil {
+			return err
+		}
+	}
+
+	if sendAt.After(time.Now()) {
+		return scheduleRelease(storage.QueueJob{Type: QueueTypeRelease, Profile: profile, MessageID: id, From: from, To: to, NextAttempt: sendAt}, msg)
+	}
+
+	if err := RelayOrQueue(profile, id, from, to, msg); err != nil {
+		logger.Log().Errorf("[smtp] error sending message: %s", err.Error())
+		return fmt.Errorf("SMTP error: %w", err)
+	}
+
+	return nil
+}
+
+// checkReleaseRecipients returns an error if any recipient is invalid, or is not permitted
+// by the allow & block lists of the relay profile
+func checkReleaseRecipients(p *config.SMTPRelayConfigStruct, to []string) error {
+	blocked := []string{}
+	notAllowed := []string{}
+
+	for _, addr := range to {
+		address, err := mail.ParseAddress(addr)
+		if err != nil {
+			return errors.New("Invalid email address: " + addr)
+		}
+
+		if p.AllowedRecipientsRegexp != nil && !p.AllowedRecipientsRegexp.MatchString(address.Address) {
+			notAllowed = append(notAllowed, addr)
+			continue
+		}
+
+		if p.BlockedRecipientsRegexp != nil && p.BlockedRecipientsRegexp.MatchString(address.Address) {
+			blocked = append(blocked, addr)
+			continue
+		}
+	}
+
+	if len(notAllowed) > 0 {
+		addr := tools.Plural(len(notAllowed), "Address", "Addresses")
+		return errors.New("Failed: " + addr + " do not match the allowlist: " + strings.Join(notAllowed, ", "))
+	}
+
+	if len(blocked) > 0 {
+		addr := tools.Plural(len(blocked), "Address", "Addresses")
+		return errors.New("Failed: " + addr + " found on blocklist: " + strings.Join(blocked, ", "))
+	}
+
+	if len(to) == 0 {
+		return errors.New("No valid addresses found")
+	}
+
+	return nil
+}
+
+// ReleaseSearch starts a job to release all messages matching a search via the relay profile (empty for the
+// default relay configuration), either to the recipients, or to the original recipients of each message if
+// none are set. Messages received after the job is started are not released. Progress is broadcast to
+// websocket clients with the "release" event.
+func ReleaseSearch(search, timezone, profile string, to []string) (ReleaseJob, error) {
+	p, err := config.RelayProfile(profile)
+	if err != nil {
+		return ReleaseJob{}, err
+	}
+
+	if len(to) > 0 {
+		if err := checkReleaseRecipients(p, to); err != nil {
+			return ReleaseJob{}, err
+		}
+	}
+
+	now := time.Now()
+	before := now.UnixMilli()
+
+	_, total, err := storage.Search(search, timezone, 0, before, 1)
+	if err != nil {
+		return ReleaseJob{}, err
+	}
+
+	job := &ReleaseJob{
+		ID:      shortuuid.New(),
+		Search:  search,
+		Profile: profile,
+		To:      to,
+		Created: now,
+		Total:   total,
+	}
+
+	releaseMu.Lock()
+	pruneReleaseJobs()
+	releaseJobs[job.ID] = job
+	releaseMu.Unlock()
+
+	logger.Log().Infof("[relay] releasing %d messages matching \"%s\" (job %s)", total, search, job.ID)
+
+	go job.run(p, timezone, before)
+
+	return job.snapshot(), nil
+}
+
+// GetReleaseJob returns a bulk release job
+func GetReleaseJob(id string) (ReleaseJob, bool) {
+	releaseMu.Lock()
+	pruneReleaseJobs()
+	job, ok := releaseJobs[id]
+	releaseMu.Unlock()
+
+	if !ok {
+		return ReleaseJob{}, false
+	}
+
+	return job.snapshot(), true
+}
+
+// pruneReleaseJobs removes jobs completed more than releaseJobTTL ago. The caller must hold releaseMu.
+func pruneReleaseJobs() {
+	for id, job := range releaseJobs {
+		if job.Complete && time.Since(job.completed) > releaseJobTTL {
+			delete(releaseJobs, id)
+		}
+	}
+}
+
+// run releases the search results in batches, broadcasting the progress after each batch.
+// The search results are collected before any are released, as releasing messages may change
+// the results of the search (eg: -is:released), which would otherwise skip messages.
+func (job *ReleaseJob) run(p *config.SMTPRelayConfigStruct, timezone string, before int64) {
+	messages := []storage.MessageSummary{}
+
+	for start := 0; ; start += releaseBatchSize {
+		results, _, err := storage.Search(job.Search, timezone, start, before, releaseBatchSize)
+		if err != nil {
+			logger.Log().Errorf("[relay] release job %s: %s", job.ID, err.Error())
+			break
+		}
+
+		if len(results) == 0 {
+			break
+		}
+
+		messages = append(messages, results...)
+	}
+
+	for batch := range slices.Chunk(messages, releaseBatchSize) {
+		for _, m := range batch {
+			job.release(p, m)
+		}
+
+		websockets.Broadcast("release", job.snapshot())
+	}
+
+	releaseMu.Lock()
+	job.Complete = true
+	job.completed = time.Now()
+	releaseMu.Unlock()
+
+	s := job.snapshot()
+	websockets.Broadcast("release", s)
+
+	logger.Log().Infof("[relay] release job %s complete: %d released, %d skipped, %d failed", s.ID, s.Released, s.Skipped, s.Failed)
+}
+
+// release releases a single search result, updating the job counters
+func (job *ReleaseJob) release(p *config.SMTPRelayConfigStruct, m storage.MessageSummary) {
+	to := job.To
+	if len(to) == 0 {
+		to = originalRecipients(p, m)
+	}
+
+	if len(to) == 0 {
+		releaseMu.Lock()
+		job.Skipped++
+		releaseMu.Unlock()
+		return
+	}
+
+	msg, err := storage.GetMessageRaw(m.ID)
+	if err == nil {
+		err = ReleaseMessage(job.Profile, m.ID, msg, to, time.Time{})
+	}
+
+	releaseMu.Lock()
+	defer releaseMu.Unlock()
+
+	if err != nil {
+		logger.Log().Warnf("[relay] release job %s: error releasing %s: %s", job.ID, m.ID, err.Error())
+		job.Failed++
+		return
+	}
+
+	job.Released++
+}
+
+// snapshot returns a copy of the job
+func (job *ReleaseJob) snapshot() ReleaseJob {
+	releaseMu.Lock()
+	defer releaseMu.Unlock()
+
+	return *job
+}
+
+// originalRecipients returns the unique To, Cc & Bcc addresses of a message which are permitted
+// by the allow & block lists of the relay profile
+func originalRecipients(p *config.SMTPRelayConfigStruct, m storage.MessageSummary) []string {
+	to := []string{}
+	seen := map[string]bool{}
+
+	for _, list := range [][]*mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			key := strings.ToLower(a.Address)
+			if a.Address == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if p.AllowedRecipientsRegexp != nil && !p.AllowedRecipientsRegexp.MatchString(a.Address) {
+				continue
+			}
+
+			if p.BlockedRecipientsRegexp != nil && p.BlockedRecipientsRegexp.MatchString(a.Address) {
+				continue
+			}
+
+			to = append(to, a.Address)
+		}
+	}
+
+	return to
+}
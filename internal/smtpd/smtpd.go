@@ -9,11 +9,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"math"
 	"net"
 	"net/mail"
 	"os"
@@ -24,7 +26,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/axllent/mailpit/internal/auth"
+	"github.com/axllent/mailpit/internal/authres"
 	"github.com/axllent/mailpit/internal/smtpd/chaos"
+	"github.com/axllent/mailpit/internal/smtpd/greylist"
+	"github.com/axllent/mailpit/internal/smtpd/ratelimit"
+	"github.com/axllent/mailpit/internal/tools"
 )
 
 var (
@@ -35,20 +42,36 @@ var (
 
 	// extract mail size from 'MAIL FROM' parameter
 	mailFromSizeRE = regexp.MustCompile(`(?U)(^| |,)[Ss][Ii][Zz][Ee]=(.*)($|,| )`)
+
+	// extract the REQUIRETLS 'MAIL FROM' parameter (RFC 8689)
+	mailFromRequireTLSRE = regexp.MustCompile(`(?i)(^| )REQUIRETLS($| )`)
+
+	// authentication mechanisms which are disabled unless explicitly enabled via Server.AuthMechs
+	optionalAuthMechs = map[string]bool{
+		"EXTERNAL":           true,
+		"XOAUTH2":            true,
+		"OAUTHBEARER":        true,
+		"SCRAM-SHA-1":        true,
+		"SCRAM-SHA-256":      true,
+		"SCRAM-SHA-1-PLUS":   true,
+		"SCRAM-SHA-256-PLUS": true,
+	}
 )
 
 // Handler function called upon successful receipt of an email.
 // Results in a "250 2.0.0 Ok: queued" response.
 type Handler func(remoteAddr net.Addr, from string, to []string, data []byte) error
 
-// MsgIDHandler function called upon successful receipt of an email. Returns a message ID.
-// Results in a "250 2.0.0 Ok: queued as <message-id>" response.
-type MsgIDHandler func(remoteAddr net.Addr, from string, to []string, data []byte, username *string) (string, error)
+// MsgIDHandler function called upon successful receipt of an email, with the authenticated username (nil if not
+// authenticated), whether the REQUIRETLS MAIL parameter was set, and the SMTP envelope (client IP, HELO & MAIL FROM).
+// Returns a message ID. Results in a "250 2.0.0 Ok: queued as <message-id>" response.
+type MsgIDHandler func(remoteAddr net.Addr, from string, to []string, data []byte, username *string, requireTLS bool, envelope *authres.Envelope) (string, error)
 
 // HandlerRcpt function called on RCPT. Return accept status.
 type HandlerRcpt func(remoteAddr net.Addr, from string, to string) bool
 
 // AuthHandler function called when a login attempt is performed. Returns true if credentials are correct.
+// For EXTERNAL & SCRAM mechanisms the credentials have already been verified by the server, and the password is nil.
 type AuthHandler func(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error)
 
 // ErrServerClosed is the default message when a server closes a connection
@@ -101,7 +124,7 @@ type Server struct {
 	Addr                     string // TCP address to listen on, defaults to ":25" (all addresses, port 25) if empty
 	AppName                  string
 	AuthHandler              AuthHandler
-	AuthMechs                map[string]bool // Override list of allowed authentication mechanisms. Currently supported: LOGIN, PLAIN, CRAM-MD5. Enabling LOGIN and PLAIN will reduce RFC 4954 compliance.
+	AuthMechs                map[string]bool // Override list of allowed authentication mechanisms. Currently supported: LOGIN, PLAIN, CRAM-MD5, EXTERNAL, XOAUTH2, OAUTHBEARER, SCRAM-SHA-1(-PLUS), SCRAM-SHA-256(-PLUS). Enabling LOGIN and PLAIN will reduce RFC 4954 compliance.
 	AuthRequired             bool            // Require authentication for every command except AUTH, EHLO, HELO, NOOP, RSET or QUIT as per RFC 4954. Ignored if AuthHandler is not configured.
 	DisableReverseDNS        bool            // Disable reverse DNS lookups, enforces "unknown" hostname
 	Handler                  Handler
@@ -115,10 +138,11 @@ type Server struct {
 	IgnoreRejectedRecipients bool // Accept emails to rejected recipients with 2xx response but silently drop them
 	Timeout                  time.Duration
 	TLSConfig                *tls.Config
-	TLSListener              bool        // Listen for incoming TLS connections only (not recommended as it may reduce compatibility). Ignored if TLS is not configured.
-	TLSRequired              bool        // Require TLS for every command except NOOP, EHLO, STARTTLS, or QUIT as per RFC 3207. Ignored if TLS is not configured.
-	Protocol                 string      // Default tcp, supports unix
-	SocketPerm               fs.FileMode // if using Unix socket, socket permissions
+	TLSListener              bool                  // Listen for incoming TLS connections only (not recommended as it may reduce compatibility). Ignored if TLS is not configured.
+	TLSRequired              bool                  // Require TLS for every command except NOOP, EHLO, STARTTLS, or QUIT as per RFC 3207. Ignored if TLS is not configured.
+	Protocol                 string                // Default tcp, supports unix
+	SCRAMCredentials         auth.SCRAMCredentials // Salted credentials for SCRAM authentication mechanisms
+	SocketPerm               fs.FileMode           // if using Unix socket, socket permissions
 
 	inShutdown   int32 // server was closed or shutdown
 	openSessions int32 // count of open sessions
@@ -138,6 +162,51 @@ func (srv *Server) ConfigureTLS(certFile string, keyFile string) error {
 	return nil
 }
 
+// ConfigureClientTLS enables TLS client certificate authentication using a PEM CA bundle.
+// If required is true then clients must present a valid certificate to complete the TLS handshake,
+// otherwise certificates are only verified if presented. ConfigureTLS must be called first.
+func (srv *Server) ConfigureClientTLS(caFile string, required bool) error {
+	if srv.TLSConfig == nil {
+		return errors.New("TLS must be configured before client certificate authentication")
+	}
+
+	b, err := os.ReadFile(caFile) // #nosec
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("no valid certificates found in %s", caFile)
+	}
+
+	srv.TLSConfig.ClientCAs = pool
+	if required {
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return nil
+}
+
+// ClientCertificateUsername returns the username for a TLS client certificate.
+// This is the subject common name, falling back to the first email address or DNS name
+// in the subject alternative names.
+func ClientCertificateUsername(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+
+	return ""
+}
+
 // // ConfigureTLSWithPassphrase creates a TLS configuration from a certificate,
 // // an encrypted key file and the associated passphrase:
 // func (srv *Server) ConfigureTLSWithPassphrase(
@@ -263,6 +332,9 @@ type session struct {
 	tls           bool
 	authenticated bool
 	username      *string // username, nil if not authenticated
+	certUsername  string  // username of a verified TLS client certificate
+	requireTLS    bool    // REQUIRETLS MAIL parameter of the current transaction (RFC 8689)
+	rateLimitUser string  // authenticated user registered with the rate limiter
 }
 
 // Create new session from connection.
@@ -379,6 +451,41 @@ func (s *session) serve() {
 		s.srv.MaxRecipients = 100
 	}
 
+	// Complete the handshake of TLS listener connections to obtain any client certificate.
+	if tlsConn, ok := s.conn.(*tls.Conn); ok {
+		if s.srv.Timeout > 0 {
+			_ = s.conn.SetDeadline(time.Now().Add(s.srv.Timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		s.setClientCertificate(tlsConn)
+	}
+
+	// Mailpit rate limiting
+	if !ratelimit.Connect(ratelimit.TypeIP, s.remoteIP) {
+		s.writef("421 4.7.0 Too many connections from %s, try again later", s.remoteIP)
+		return
+	}
+	defer ratelimit.Disconnect(ratelimit.TypeIP, s.remoteIP)
+
+	defer func() {
+		if s.rateLimitUser != "" {
+			ratelimit.Disconnect(ratelimit.TypeUser, s.rateLimitUser)
+		}
+	}()
+
+	// users authenticated by a TLS client certificate are subject to the user limits
+	if s.certUsername != "" && !s.connectRateLimitUser(s.certUsername) {
+		s.writef("421 4.7.0 Too many connections for %s, try again later", s.certUsername)
+		return
+	}
+
+	// Mailpit Chaos
+	if !s.chaosFault(chaos.StageConnect) {
+		return
+	}
+
 	// Send banner.
 	s.writef("220 %s %s ESMTP Service ready", s.srv.Hostname, s.srv.AppName)
 
@@ -403,6 +510,11 @@ loop:
 
 		switch verb {
 		case "HELO":
+			// Mailpit Chaos
+			if !s.chaosFault(chaos.StageEHLO) {
+				break loop
+			}
+
 			s.remoteName = args
 			s.writef("250 %s greets %s", s.srv.Hostname, s.remoteName)
 
@@ -414,7 +526,19 @@ loop:
 			hasRejectedRecipients = false
 			buffer.Reset()
 		case "EHLO":
+			// Mailpit Chaos
+			if !s.chaosFault(chaos.StageEHLO) {
+				break loop
+			}
+
 			s.remoteName = args
+
+			// Mailpit Chaos
+			if lines := chaos.Truncate(); lines > 0 {
+				s.writeTruncated(s.makeEHLOResponse(), lines)
+				break loop
+			}
+
 			s.writef("%s", s.makeEHLOResponse())
 
 			// RFC 2821 section 4.1.4 specifies that EHLO has the same effect as RSET.
@@ -442,6 +566,11 @@ loop:
 				break
 			}
 
+			// Mailpit Chaos
+			if !s.chaosFault(chaos.StageMail) {
+				break loop
+			}
+
 			match, err := extractAndValidateAddress(mailFromRE, args)
 			if match == nil {
 				if err != nil {
@@ -451,11 +580,41 @@ loop:
 				}
 			} else {
 				// Mailpit Chaos
-				if fail, code := chaos.Config.Sender.Trigger(); fail {
+				if fail, code := chaos.Current().Sender.Trigger(); fail {
 					s.writef("%d Chaos sender error", code)
 					break
 				}
 
+				declaredSize := 0
+				if sizeMatch := mailFromSizeRE.FindStringSubmatch(match[3]); sizeMatch != nil {
+					declaredSize, _ = strconv.Atoi(sizeMatch[2])
+				}
+				if fail, code, text := chaos.MatchRule(chaos.StageMail, chaos.Envelope{Sender: match[1], Size: declaredSize}); fail {
+					s.writef("%d %s", code, text)
+					break
+				}
+
+				// RFC 8689 requires the REQUIRETLS parameter to only be used over TLS
+				requireTLS := mailFromRequireTLSRE.MatchString(match[3])
+				if requireTLS && !s.tls {
+					if s.srv.TLSConfig == nil {
+						s.writef("555 5.5.4 Unsupported MAIL FROM parameter (REQUIRETLS)")
+					} else {
+						s.writef("530 5.7.10 REQUIRETLS requires a TLS connection")
+					}
+					break
+				}
+				s.requireTLS = requireTLS
+
+				// Mailpit rate limiting
+				if resp := ratelimit.Check(s.remoteIP, s.username, declaredSize); resp != "" {
+					s.writef("%s", resp)
+					if strings.HasPrefix(resp, "421") {
+						break loop
+					}
+					break
+				}
+
 				// Validate the SIZE parameter if one was sent.
 				if len(match[2]) > 0 { // A parameter is present
 					sizeMatch := mailFromSizeRE.FindStringSubmatch(match[3])
@@ -502,6 +661,11 @@ loop:
 				break
 			}
 
+			// Mailpit Chaos
+			if !s.chaosFault(chaos.StageRcpt) {
+				break loop
+			}
+
 			match, err := extractAndValidateAddress(rcptToRE, args)
 			if match == nil {
 				if err != nil {
@@ -511,8 +675,14 @@ loop:
 				}
 			} else {
 				// Mailpit Chaos
-				if fail, code := chaos.Config.Recipient.Trigger(); fail {
+				if fail, code := chaos.Current().Recipient.Trigger(); fail {
 					s.writef("%d Chaos recipient error", code)
+					chaosBounce(from, []string{match[1]}, nil, code, "Chaos recipient error")
+					break
+				}
+				if fail, code, text := chaos.MatchRule(chaos.StageRcpt, chaos.Envelope{Sender: from, Recipients: []string{match[1]}}); fail {
+					s.writef("%d %s", code, text)
+					chaosBounce(from, []string{match[1]}, nil, code, text)
 					break
 				}
 
@@ -524,6 +694,12 @@ loop:
 						accept = s.srv.HandlerRcpt(s.conn.RemoteAddr(), from, match[1])
 					}
 					if accept {
+						// Mailpit greylisting
+						if ok, remaining := greylist.Check(s.conn.RemoteAddr(), from, match[1]); !ok {
+							s.writef("451 4.7.1 Greylisted, please try again in %d seconds", int(math.Ceil(remaining.Seconds())))
+							break
+						}
+
 						to = append(to, match[1])
 						s.writef("250 2.1.5 Ok")
 					} else if s.srv.IgnoreRejectedRecipients {
@@ -572,6 +748,35 @@ loop:
 				}
 			}
 
+			// Mailpit Chaos
+			if !s.chaosFault(chaos.StageData) {
+				break loop
+			}
+			if fail, code, text := chaos.MatchRule(chaos.StageData, chaos.Envelope{Sender: from, Recipients: to, Size: len(data), Data: data}); fail {
+				s.writef("%d %s", code, text)
+				chaosBounce(from, to, data, code, text)
+				from = ""
+				gotFROM = false
+				to = nil
+				hasRejectedRecipients = false
+				buffer.Reset()
+				break
+			}
+
+			// Mailpit rate limiting
+			if resp := ratelimit.Check(s.remoteIP, s.username, len(data)); resp != "" {
+				s.writef("%s", resp)
+				if strings.HasPrefix(resp, "421") {
+					break loop
+				}
+				from = ""
+				gotFROM = false
+				to = nil
+				hasRejectedRecipients = false
+				buffer.Reset()
+				break
+			}
+
 			// Create Received header & write message body into buffer.
 			buffer.Reset()
 			if len(to) > 0 {
@@ -593,7 +798,8 @@ loop:
 				}
 				s.writef("250 2.0.0 Ok: queued")
 			} else if len(to) > 0 && s.srv.MsgIDHandler != nil {
-				msgID, err := s.srv.MsgIDHandler(s.conn.RemoteAddr(), from, to, buffer.Bytes(), s.username)
+				envelope := &authres.Envelope{ClientIP: s.remoteIP, HELO: s.remoteName, MailFrom: from}
+				msgID, err := s.srv.MsgIDHandler(s.conn.RemoteAddr(), from, to, buffer.Bytes(), s.username, s.requireTLS, envelope)
 				if err != nil {
 					checkErrFormat := regexp.MustCompile(`^([2-5][0-9]{2})[\s\-](.+)$`)
 					if checkErrFormat.MatchString(err.Error()) {
@@ -620,6 +826,8 @@ loop:
 				s.writef("250 2.0.0 Ok: queued")
 			}
 
+			ratelimit.Record(s.remoteIP, s.username, len(data))
+
 			// Reset for next mail.
 			from = ""
 			gotFROM = false
@@ -712,6 +920,13 @@ loop:
 			s.br = bufio.NewReaderSize(s.conn, 2048)
 			s.bw = bufio.NewWriter(s.conn)
 			s.tls = true
+			s.setClientCertificate(tlsConn)
+
+			// Mailpit rate limiting
+			if s.certUsername != "" && !s.connectRateLimitUser(s.certUsername) {
+				s.writef("421 4.7.0 Too many connections for %s, try again later", s.certUsername)
+				break loop
+			}
 
 			// RFC 3207 specifies that the server must discard any prior knowledge obtained from the client.
 			s.remoteName = ""
@@ -758,7 +973,10 @@ loop:
 			}
 
 			// Mailpit Chaos
-			if fail, code := chaos.Config.Authentication.Trigger(); fail {
+			if !s.chaosFault(chaos.StageAuth) {
+				break loop
+			}
+			if fail, code := chaos.Current().Authentication.Trigger(); fail {
 				s.writef("%d Chaos authentication error", code)
 				break
 			}
@@ -773,6 +991,14 @@ loop:
 				s.authenticated, err = s.handleAuthLogin(authArgs)
 			case "CRAM-MD5":
 				s.authenticated, err = s.handleAuthCramMD5()
+			case "EXTERNAL":
+				s.authenticated, err = s.handleAuthExternal(authArgs)
+			case "XOAUTH2":
+				s.authenticated, err = s.handleAuthXOAuth2(authArgs)
+			case "OAUTHBEARER":
+				s.authenticated, err = s.handleAuthOAuthBearer(authArgs)
+			case "SCRAM-SHA-1", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-256-PLUS":
+				s.authenticated, err = s.handleAuthSCRAM(authType, authArgs)
 			}
 
 			if err != nil {
@@ -785,6 +1011,12 @@ loop:
 				break
 			}
 
+			// Mailpit rate limiting
+			if s.authenticated && s.username != nil && !s.connectRateLimitUser(*s.username) {
+				s.writef("421 4.7.0 Too many connections for %s, try again later", *s.username)
+				break loop
+			}
+
 			if s.authenticated {
 				s.writef("235 2.7.0 Authentication successful")
 			} else {
@@ -817,6 +1049,42 @@ func (s *session) writef(format string, args ...any) {
 	}
 }
 
+// Apply any Mailpit Chaos delay before responding at the SMTP stage.
+// Returns false if the connection should be dropped instead of responding.
+func (s *session) chaosFault(stage string) bool {
+	if d := chaos.Delay(stage); d > 0 {
+		time.Sleep(d)
+	}
+
+	if !chaos.Drop(stage) {
+		return true
+	}
+
+	// discard unsent data when the connection is closed, so the client sees a reset (RST)
+	conn := s.conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+
+	return false
+}
+
+// Write only the first lines of a multiline response (Mailpit Chaos).
+func (s *session) writeTruncated(response string, lines int) {
+	parts := strings.Split(response, "\r\n")
+	if lines >= len(parts) {
+		lines = len(parts) - 1
+	}
+	if lines < 1 {
+		return
+	}
+
+	s.writef("%s", strings.Join(parts[:lines], "\r\n"))
+}
+
 // Read a complete line from the socket.
 // Lines longer than the reader buffer size are drained and rejected with errLineTooLong
 // so that oversized input never allocates an attacker-controlled amount of memory.
@@ -870,6 +1138,11 @@ func (s *session) parseLine(line string) (verb string, args string) {
 // Read the message data following a DATA command.
 func (s *session) readData() ([]byte, error) {
 	var data []byte
+
+	// Mailpit Chaos
+	bandwidth := chaos.Bandwidth()
+	start := time.Now()
+
 	for {
 		if s.srv.Timeout > 0 {
 			_ = s.conn.SetReadDeadline(time.Now().Add(s.srv.Timeout))
@@ -909,6 +1182,14 @@ func (s *session) readData() ([]byte, error) {
 		}
 
 		data = append(data, line...)
+
+		// Mailpit Chaos, throttle reading to the bandwidth limit
+		if bandwidth > 0 {
+			expected := time.Duration(len(data)) * time.Second / time.Duration(bandwidth)
+			if elapsed := time.Since(start); expected > elapsed {
+				time.Sleep(expected - elapsed)
+			}
+		}
 	}
 	return data, nil
 }
@@ -923,7 +1204,12 @@ func (s *session) makeHeaders(to []string) []byte {
 
 	now := time.Now().Format("Mon, 2 Jan 2006 15:04:05 -0700 (MST)")
 	fmt.Fprintf(&buffer, "Received: from %s (%s [%s])\r\n", s.remoteName, s.remoteHost, s.remoteIP)
-	fmt.Fprintf(&buffer, "        by %s (%s) with SMTP\r\n", s.srv.Hostname, s.srv.AppName)
+	if s.requireTLS {
+		// record the REQUIRETLS tag of the message (RFC 8689)
+		fmt.Fprintf(&buffer, "        by %s (%s) with SMTP (REQUIRETLS)\r\n", s.srv.Hostname, s.srv.AppName)
+	} else {
+		fmt.Fprintf(&buffer, "        by %s (%s) with SMTP\r\n", s.srv.Hostname, s.srv.AppName)
+	}
 	fmt.Fprintf(&buffer, "        for <%s>; %s\r\n", to[0], now)
 	return buffer.Bytes()
 }
@@ -931,16 +1217,22 @@ func (s *session) makeHeaders(to []string) []byte {
 // Determine allowed authentication mechanisms.
 // RFC 4954 specifies that plaintext authentication mechanisms such as LOGIN and PLAIN require a TLS connection.
 // This can be explicitly overridden e.g. setting s.srv.AuthMechs["LOGIN"] = true.
+// Optional mechanisms (XOAUTH2, OAUTHBEARER & SCRAM) are only listed when set in s.srv.AuthMechs.
+// SCRAM channel binding (-PLUS) mechanisms are only available over TLS,
+// and EXTERNAL is only available once a verified TLS client certificate has been presented.
 func (s *session) authMechs() (mechs map[string]bool) {
 	mechs = map[string]bool{"LOGIN": s.tls, "PLAIN": s.tls, "CRAM-MD5": true}
 
-	for mech := range mechs {
-		allowed, found := s.srv.AuthMechs[mech]
-		if found {
-			mechs[mech] = allowed
+	for mech, allowed := range s.srv.AuthMechs {
+		if _, found := mechs[mech]; found || optionalAuthMechs[mech] {
+			mechs[mech] = allowed && (s.tls || !strings.HasSuffix(mech, "-PLUS"))
 		}
 	}
 
+	if mechs["EXTERNAL"] && s.certUsername == "" {
+		mechs["EXTERNAL"] = false
+	}
+
 	return
 }
 
@@ -969,6 +1261,11 @@ func (s *session) makeEHLOResponse() (response string) {
 		}
 	}
 
+	// RFC 8689 REQUIRETLS, the MAIL FROM parameter is only accepted over TLS
+	if s.srv.TLSConfig != nil {
+		response += "250-REQUIRETLS\r\n"
+	}
+
 	response += "250-ENHANCEDSTATUSCODES\r\n"
 	// RFC 6531 specifies that the presence of SMTPUTF8 should include 8BITMIME
 	// "Servers offering this extension MUST provide support for, and announce, the 8BITMIME extension"
@@ -978,6 +1275,79 @@ func (s *session) makeEHLOResponse() (response string) {
 	return
 }
 
+// Set the session username from a verified TLS client certificate.
+// The username is stored with received messages, and may be overridden by a subsequent AUTH.
+func (s *session) setClientCertificate(tlsConn *tls.Conn) {
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	s.certUsername = ClientCertificateUsername(state.PeerCertificates[0])
+	if s.certUsername != "" {
+		uname := s.certUsername
+		s.username = &uname
+	}
+}
+
+// Register the connection of an authenticated user with the rate limiter, releasing any previously
+// registered user of the session. Returns false if the user connection limit is exceeded.
+func (s *session) connectRateLimitUser(username string) bool {
+	if s.rateLimitUser == username {
+		return true
+	}
+
+	if !ratelimit.Connect(ratelimit.TypeUser, username) {
+		return false
+	}
+
+	if s.rateLimitUser != "" {
+		ratelimit.Disconnect(ratelimit.TypeUser, s.rateLimitUser)
+	}
+	s.rateLimitUser = username
+
+	return true
+}
+
+// Handle the EXTERNAL mechanism (RFC 4422 appendix A) using the TLS client certificate identity.
+// The client may send an empty authorization identity, or one matching the certificate username.
+func (s *session) handleAuthExternal(arg string) (bool, error) {
+	var err error
+
+	if arg == "" {
+		s.writef("334 ")
+		arg, err = s.readLine()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if arg == "*" {
+		return false, errors.New("501 5.7.0 Authentication cancelled")
+	}
+
+	authzid := []byte{}
+	if arg != "=" {
+		authzid, err = base64.StdEncoding.DecodeString(arg)
+		if err != nil {
+			return false, errors.New("501 5.5.2 Syntax error (unable to decode)")
+		}
+	}
+
+	if len(authzid) > 0 && string(authzid) != s.certUsername {
+		return false, nil
+	}
+
+	// Validate credentials.
+	authenticated, err := s.srv.AuthHandler(s.conn.RemoteAddr(), "EXTERNAL", []byte(s.certUsername), nil, nil)
+	if authenticated {
+		uname := s.certUsername
+		s.username = &uname
+	}
+
+	return authenticated, err
+}
+
 func (s *session) handleAuthLogin(arg string) (bool, error) {
 	var err error
 
@@ -1081,6 +1451,201 @@ func (s *session) handleAuthCramMD5() (bool, error) {
 	return authenticated, err
 }
 
+// XOAUTH2 as used by Gmail & Microsoft 365.
+// The client response is base64("user=" + user + "\x01auth=Bearer " + token + "\x01\x01").
+// @see https://developers.google.com/gmail/imap/xoauth2-protocol
+func (s *session) handleAuthXOAuth2(arg string) (bool, error) {
+	var err error
+
+	if arg == "" {
+		s.writef("334 ")
+		arg, err = s.readLine()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(arg)
+	if err != nil {
+		return false, errors.New("501 5.5.2 Syntax error (unable to decode)")
+	}
+
+	var username, token string
+	for field := range strings.SplitSeq(string(data), "\x01") {
+		if v, ok := strings.CutPrefix(field, "user="); ok {
+			username = v
+		} else if v, ok := cutBearerToken(field); ok {
+			token = v
+		}
+	}
+
+	if token == "" {
+		return false, errors.New("501 5.5.2 Syntax error (unable to parse)")
+	}
+
+	return s.validateOAuthToken("XOAUTH2", username, token, `{"status":"401","schemes":"bearer"}`)
+}
+
+// OAUTHBEARER as per RFC 7628.
+// The client response is base64(gs2-header + "\x01" + key/value pairs separated by "\x01" + "\x01"),
+// where the gs2-header is "n,a=" + user + "," and the auth key contains "Bearer " + token.
+// @see https://datatracker.ietf.org/doc/html/rfc7628#section-3.1
+func (s *session) handleAuthOAuthBearer(arg string) (bool, error) {
+	var err error
+
+	if arg == "" {
+		s.writef("334 ")
+		arg, err = s.readLine()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(arg)
+	if err != nil {
+		return false, errors.New("501 5.5.2 Syntax error (unable to decode)")
+	}
+
+	gs2Header, kvPairs, found := strings.Cut(string(data), "\x01")
+	if !found {
+		return false, errors.New("501 5.5.2 Syntax error (unable to parse)")
+	}
+
+	// gs2-header: gs2-cbind-flag "," [authzid] ","
+	gs2Parts := strings.Split(gs2Header, ",")
+	if len(gs2Parts) < 3 || (gs2Parts[0] != "n" && gs2Parts[0] != "y") {
+		return false, errors.New("501 5.5.2 Syntax error (unable to parse)")
+	}
+
+	username := ""
+	if v, ok := strings.CutPrefix(gs2Parts[1], "a="); ok {
+		// RFC 5801 saslname encoding
+		username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(v)
+	}
+
+	var token string
+	for field := range strings.SplitSeq(kvPairs, "\x01") {
+		if v, ok := cutBearerToken(field); ok {
+			token = v
+		}
+	}
+
+	if token == "" {
+		return false, errors.New("501 5.5.2 Syntax error (unable to parse)")
+	}
+
+	return s.validateOAuthToken("OAUTHBEARER", username, token, `{"status":"invalid_token","schemes":"bearer"}`)
+}
+
+// Validate an OAuth bearer token with the AuthHandler. On failure the server returns
+// the JSON error as a 334 challenge, and the client must send a dummy response
+// before the final 535 response is returned (RFC 7628 section 3.2.3).
+func (s *session) validateOAuthToken(mechanism, username, token, failure string) (bool, error) {
+	authenticated, err := s.srv.AuthHandler(s.conn.RemoteAddr(), mechanism, []byte(username), []byte(token), nil)
+	if err != nil {
+		return false, err
+	}
+
+	if authenticated {
+		s.username = &username
+		return true, nil
+	}
+
+	s.username = nil
+
+	s.writef("334 %s", base64.StdEncoding.EncodeToString([]byte(failure)))
+	if _, err := s.readLine(); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// SCRAM-SHA-1 & SCRAM-SHA-256 as per RFC 5802 & RFC 7677, including channel binding (-PLUS) over TLS.
+// The server-final-message is sent as a 334 challenge, to which the client responds with an empty line.
+func (s *session) handleAuthSCRAM(mechanism string, arg string) (bool, error) {
+	var channelBinding func(cbType string) ([]byte, error)
+	if tlsConn, ok := s.conn.(*tls.Conn); ok {
+		channelBinding = func(cbType string) ([]byte, error) {
+			return auth.TLSChannelBinding(tlsConn.ConnectionState(), cbType)
+		}
+	}
+
+	server, err := auth.NewSCRAMServer(mechanism, s.srv.SCRAMCredentials, channelBinding)
+	if err != nil {
+		return false, errors.New("504 5.5.4 Unrecognized authentication type")
+	}
+
+	if arg == "" {
+		s.writef("334 ")
+		arg, err = s.readLine()
+		if err != nil {
+			return false, err
+		}
+	} else if arg == "=" {
+		// RFC 4954: a zero-length initial response is sent as a single equals sign
+		arg = ""
+	}
+
+	for {
+		if arg == "*" {
+			return false, errors.New("501 5.7.0 Authentication cancelled")
+		}
+
+		data, err := base64.StdEncoding.DecodeString(arg)
+		if err != nil {
+			return false, errors.New("501 5.5.2 Syntax error (unable to decode)")
+		}
+
+		challenge, done, err := server.Next(data)
+		if err != nil {
+			if errors.Is(err, auth.ErrSCRAMAuth) {
+				s.username = nil
+				return false, nil
+			}
+			return false, errors.New("501 5.5.2 Syntax error (unable to parse)")
+		}
+
+		s.writef("334 %s", base64.StdEncoding.EncodeToString(challenge))
+		arg, err = s.readLine()
+		if err != nil {
+			return false, err
+		}
+
+		if done {
+			if arg == "*" {
+				return false, errors.New("501 5.7.0 Authentication cancelled")
+			}
+
+			// the client proof has been verified, the AuthHandler accepts the login
+			username := server.Username()
+			authenticated, err := s.srv.AuthHandler(s.conn.RemoteAddr(), mechanism, []byte(username), nil, nil)
+			if authenticated {
+				s.username = &username
+			} else {
+				s.username = nil
+			}
+
+			return authenticated, err
+		}
+	}
+}
+
+// Extract the bearer token from an "auth=Bearer <token>" key/value pair.
+func cutBearerToken(field string) (string, bool) {
+	v, ok := strings.CutPrefix(field, "auth=")
+	if !ok {
+		return "", false
+	}
+
+	scheme, token, found := strings.Cut(v, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
 // Extract and validate email address from a regex match.
 // This ensures that only RFC 5322 compliant email addresses are accepted (if set).
 func extractAndValidateAddress(re *regexp.Regexp, args string) ([]string, error) {
@@ -1095,6 +1660,11 @@ func extractAndValidateAddress(re *regexp.Regexp, args string) ([]string, error)
 
 	// first argument will be the email address, validate it if not empty
 	if match[1] != "" {
+		// internationalised addresses (RFC 6531) are normalised to NFC with a Unicode domain
+		if !tools.IsASCIIAddress(match[1]) {
+			match[1], _ = tools.NormalizeAddress(match[1])
+		}
+
 		a, err := mail.ParseAddress(match[1])
 		if err != nil {
 			return nil, errors.New("553 5.1.3 The address is not a valid RFC 5321 address")
@@ -6,17 +6,34 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/auth"
+	"github.com/axllent/mailpit/internal/authres"
+	"github.com/axllent/mailpit/internal/smtpd/bounce"
+	"github.com/axllent/mailpit/internal/smtpd/chaos"
+	"github.com/axllent/mailpit/internal/smtpd/ratelimit"
+	"github.com/axllent/mailpit/internal/storage"
 )
 
 var cert = makeCertificate()
@@ -819,6 +836,9 @@ func TestMakeEHLOResponse(t *testing.T) {
 	if _, ok := extensions["STARTTLS"]; !ok {
 		t.Errorf("STARTTLS does not appear in the extension list when TLS is configured")
 	}
+	if _, ok := extensions["REQUIRETLS"]; !ok {
+		t.Errorf("REQUIRETLS does not appear in the extension list when TLS is configured")
+	}
 
 	// If TLS is already used on the connection, STARTTLS should not appear.
 	s.tls = true
@@ -1689,6 +1709,415 @@ func TestCmdAUTHCRAMMD5WithTLS(t *testing.T) {
 	_ = tlsConn.Close()
 }
 
+func TestCmdAUTHXOAUTH2(t *testing.T) {
+	server := &Server{AuthHandler: testAuthHandler}
+	conn := newConn(t, server)
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+
+	// XOAUTH2 is not offered unless explicitly enabled.
+	cmdCode(t, conn, "AUTH XOAUTH2", "504")
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+
+	server = &Server{AuthHandler: testAuthHandler, AuthMechs: map[string]bool{"XOAUTH2": true}}
+	conn = newConn(t, server)
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+
+	// Corrupt credentials must return 501 syntax error.
+	cmdCode(t, conn, "AUTH XOAUTH2 ==", "501")
+
+	// Missing bearer token must return 501 syntax error.
+	noToken := base64.StdEncoding.EncodeToString([]byte("user=valid\x01\x01"))
+	cmdCode(t, conn, "AUTH XOAUTH2 "+noToken, "501")
+
+	// Invalid credentials must return the JSON error challenge, followed by 535 after the client response.
+	invalid := base64.StdEncoding.EncodeToString([]byte("user=invalid\x01auth=Bearer token\x01\x01"))
+	line := cmdCode(t, conn, "AUTH XOAUTH2 "+invalid, "334")
+	challenge, err := base64.StdEncoding.DecodeString(line[4:])
+	if err != nil || !strings.Contains(string(challenge), `"status":"401"`) {
+		t.Errorf("XOAUTH2 error challenge is %q, want JSON status", string(challenge))
+	}
+	cmdCode(t, conn, "", "535")
+
+	// Valid credentials (prompted) must return 235 authentication succeeded.
+	valid := base64.StdEncoding.EncodeToString([]byte("user=valid\x01auth=Bearer token\x01\x01"))
+	cmdCode(t, conn, "AUTH XOAUTH2", "334")
+	cmdCode(t, conn, valid, "235")
+
+	cmdCode(t, conn, "AUTH XOAUTH2 "+valid, "503")
+
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+}
+
+func TestCmdAUTHOAUTHBEARER(t *testing.T) {
+	server := &Server{AuthHandler: testAuthHandler, AuthMechs: map[string]bool{"OAUTHBEARER": true}}
+	conn := newConn(t, server)
+
+	extensions := parseExtensions(t, cmdCodeMultiline(t, conn, "EHLO host.example.com"))
+	if !strings.Contains(extensions["AUTH"], "OAUTHBEARER") {
+		t.Errorf("AUTH extension is %q, want OAUTHBEARER", extensions["AUTH"])
+	}
+
+	// Invalid gs2 header must return 501 syntax error.
+	badHeader := base64.StdEncoding.EncodeToString([]byte("x,a=valid,\x01auth=Bearer token\x01\x01"))
+	cmdCode(t, conn, "AUTH OAUTHBEARER "+badHeader, "501")
+
+	// Invalid credentials must return the JSON error challenge, followed by 535 after the client response.
+	invalid := base64.StdEncoding.EncodeToString([]byte("n,a=invalid,\x01host=example.com\x01port=25\x01auth=Bearer token\x01\x01"))
+	line := cmdCode(t, conn, "AUTH OAUTHBEARER "+invalid, "334")
+	challenge, err := base64.StdEncoding.DecodeString(line[4:])
+	if err != nil || !strings.Contains(string(challenge), `"status":"invalid_token"`) {
+		t.Errorf("OAUTHBEARER error challenge is %q, want JSON status", string(challenge))
+	}
+	cmdCode(t, conn, base64.StdEncoding.EncodeToString([]byte("\x01")), "535")
+
+	// Valid credentials must return 235 authentication succeeded.
+	valid := base64.StdEncoding.EncodeToString([]byte("n,a=valid,\x01host=example.com\x01port=25\x01auth=Bearer token\x01\x01"))
+	cmdCode(t, conn, "AUTH OAUTHBEARER "+valid, "235")
+
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+}
+
+func TestCmdAUTHSCRAM(t *testing.T) {
+	if err := auth.SetSMTPAuth("user:pencil"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.SMTPCredentials, auth.SMTPSCRAMCredentials = nil, nil })
+
+	var handled []string
+	server := &Server{
+		AuthHandler: func(_ net.Addr, mechanism string, username []byte, _ []byte, _ []byte) (bool, error) {
+			handled = append(handled, mechanism+" "+string(username))
+			return true, nil
+		},
+		SCRAMCredentials: auth.SMTPSCRAMCredentials,
+		AuthMechs:        map[string]bool{"SCRAM-SHA-256": true, "SCRAM-SHA-256-PLUS": true},
+	}
+	conn := newConn(t, server)
+
+	// -PLUS mechanisms must not be advertised without TLS.
+	extensions := parseExtensions(t, cmdCodeMultiline(t, conn, "EHLO host.example.com"))
+	if !strings.Contains(extensions["AUTH"], "SCRAM-SHA-256") || strings.Contains(extensions["AUTH"], "SCRAM-SHA-256-PLUS") {
+		t.Errorf("AUTH extension is %q, want SCRAM-SHA-256 without -PLUS", extensions["AUTH"])
+	}
+
+	// Cancelling the exchange must return 501.
+	cmdCode(t, conn, "AUTH SCRAM-SHA-256", "334")
+	cmdCode(t, conn, "*", "501")
+
+	// An invalid client proof must return 535 authentication failed.
+	clientFirst := base64.StdEncoding.EncodeToString([]byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"))
+	line := cmdCode(t, conn, "AUTH SCRAM-SHA-256 "+clientFirst, "334")
+	serverFirst, err := base64.StdEncoding.DecodeString(line[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, _, _ := strings.Cut(strings.TrimPrefix(string(serverFirst), "r="), ",")
+	clientFinal := "c=biws,r=" + nonce + ",p=" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+	cmdCode(t, conn, base64.StdEncoding.EncodeToString([]byte(clientFinal)), "535")
+
+	// A zero-length initial response is parsed as an empty client-first-message.
+	if line := cmdCode(t, conn, "AUTH SCRAM-SHA-256 =", "501"); !strings.Contains(line, "unable to parse") {
+		t.Errorf("AUTH SCRAM-SHA-256 = response is %q, want a parse error", line)
+	}
+
+	if len(handled) != 0 {
+		t.Errorf("AuthHandler was called for failed exchanges: %v", handled)
+	}
+
+	// A valid client proof must be accepted by the AuthHandler.
+	line = cmdCode(t, conn, "AUTH SCRAM-SHA-256 "+clientFirst, "334")
+	serverFirst, err = base64.StdEncoding.DecodeString(line[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientFinal = scramClientFinal(t, "n=user,r=fyko+d2lbbFgONRv9qkxdawL", string(serverFirst), "pencil")
+	cmdCode(t, conn, base64.StdEncoding.EncodeToString([]byte(clientFinal)), "334")
+	cmdCode(t, conn, "", "235")
+
+	if len(handled) != 1 || handled[0] != "SCRAM-SHA-256 user" {
+		t.Errorf("AuthHandler calls are %v, want [SCRAM-SHA-256 user]", handled)
+	}
+
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+}
+
+// scramClientFinal returns the SCRAM-SHA-256 client-final-message for a server-first-message
+func scramClientFinal(t *testing.T, clientFirstBare, serverFirst, password string) string {
+	t.Helper()
+
+	attrs := map[string]string{}
+	for a := range strings.SplitSeq(serverFirst, ",") {
+		attrs[a[:1]] = a[2:]
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saltedPassword, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, saltedPassword)
+	mac.Write([]byte("Client Key"))
+	clientKey := mac.Sum(nil)
+	storedKey := sha256.Sum256(clientKey)
+
+	withoutProof := "c=biws,r=" + attrs["r"]
+	mac = hmac.New(sha256.New, storedKey[:])
+	mac.Write([]byte(clientFirstBare + "," + serverFirst + "," + withoutProof))
+
+	proof := make([]byte, len(clientKey))
+	subtle.XORBytes(proof, clientKey, mac.Sum(nil))
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+}
+
+func TestCmdAUTHEXTERNAL(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var username *string
+	server := &Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		AuthHandler: func(_ net.Addr, mechanism string, _ []byte, _ []byte, _ []byte) (bool, error) {
+			return mechanism == "EXTERNAL", nil
+		},
+		AuthMechs: map[string]bool{"EXTERNAL": true},
+		MsgIDHandler: func(_ net.Addr, _ string, _ []string, _ []byte, u *string, _ bool, _ *authres.Envelope) (string, error) {
+			username = u
+			return "", nil
+		},
+	}
+	if err := server.ConfigureClientTLS(caFile, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a client certificate EXTERNAL must not be available.
+	conn := newConn(t, server)
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "STARTTLS", "220")
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("Failed to perform TLS handshake: %v", err)
+	}
+	extensions := parseExtensions(t, cmdCodeMultiline(t, tlsConn, "EHLO host.example.com"))
+	if strings.Contains(extensions["AUTH"], "EXTERNAL") {
+		t.Errorf("AUTH extension is %q, want no EXTERNAL without a client certificate", extensions["AUTH"])
+	}
+	cmdCode(t, tlsConn, "AUTH EXTERNAL =", "504")
+	cmdCode(t, tlsConn, "QUIT", "221")
+	_ = tlsConn.Close()
+
+	// With a verified client certificate the certificate username is used.
+	conn = newConn(t, server)
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "STARTTLS", "220")
+	tlsConn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("Failed to perform TLS handshake: %v", err)
+	}
+	extensions = parseExtensions(t, cmdCodeMultiline(t, tlsConn, "EHLO host.example.com"))
+	if !strings.Contains(extensions["AUTH"], "EXTERNAL") {
+		t.Errorf("AUTH extension is %q, want EXTERNAL", extensions["AUTH"])
+	}
+
+	// An authorization identity other than the certificate username must fail.
+	cmdCode(t, tlsConn, "AUTH EXTERNAL "+base64.StdEncoding.EncodeToString([]byte("other")), "535")
+
+	// Cancelling the exchange must return 501.
+	cmdCode(t, tlsConn, "AUTH EXTERNAL", "334")
+	cmdCode(t, tlsConn, "*", "501")
+
+	// An empty authorization identity must succeed.
+	cmdCode(t, tlsConn, "AUTH EXTERNAL =", "235")
+
+	cmdCode(t, tlsConn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, tlsConn, "RCPT TO:<recipient@example.com>", "250")
+	cmdCode(t, tlsConn, "DATA", "354")
+	cmdCode(t, tlsConn, "Test message.\r\n.", "250")
+	if username == nil || *username != "localhost" {
+		t.Errorf("message username is %v, want localhost", username)
+	}
+
+	cmdCode(t, tlsConn, "QUIT", "221")
+	_ = tlsConn.Close()
+}
+
+func TestMsgIDHandlerEnvelope(t *testing.T) {
+	var envelope *authres.Envelope
+	server := &Server{
+		MsgIDHandler: func(_ net.Addr, _ string, _ []string, _ []byte, _ *string, _ bool, e *authres.Envelope) (string, error) {
+			envelope = e
+			return "", nil
+		},
+	}
+
+	conn := newConn(t, server)
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, conn, "RCPT TO:<recipient@example.com>", "250")
+	cmdCode(t, conn, "DATA", "354")
+	cmdCode(t, conn, "Return-Path: <forged@example.net>\r\n\r\nTest message.\r\n.", "250")
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+
+	want := authres.Envelope{ClientIP: "127.0.0.1", HELO: "host.example.com", MailFrom: "sender@example.com"}
+	if envelope == nil || *envelope != want {
+		t.Errorf("envelope is %+v, want %+v", envelope, want)
+	}
+}
+
+func TestChaosRules(t *testing.T) {
+	if err := chaos.SetRules([]chaos.Rule{
+		{Stage: "rcpt", Recipient: "^bounce@example\\.com$", ErrorCode: 550, Text: "5.1.1 Mailbox does not exist"},
+		{Stage: "rcpt", Recipient: "^slow@", Attempts: 2, ErrorCode: 451, Text: "4.7.1 Try again later"},
+		{Stage: "data", MinSize: 100, ErrorCode: 552, Text: "5.3.4 Message too big"},
+		{Stage: "data", Header: "(?m)^X-Reject: yes", ErrorCode: 554},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = chaos.SetRules([]chaos.Rule{})
+		chaos.Enabled = false
+	})
+
+	conn := newConn(t, &Server{})
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+
+	line := cmdCode(t, conn, "RCPT TO:<bounce@example.com>", "550")
+	if line != "550 5.1.1 Mailbox does not exist" {
+		t.Errorf("RCPT response is %q, want rule text", line)
+	}
+
+	// The first two attempts are deferred, then accepted.
+	cmdCode(t, conn, "RCPT TO:<slow@example.com>", "451")
+	cmdCode(t, conn, "RCPT TO:<slow@example.com>", "451")
+	cmdCode(t, conn, "RCPT TO:<slow@example.com>", "250")
+
+	cmdCode(t, conn, "DATA", "354")
+	cmdCode(t, conn, "Subject: large\r\n\r\n"+strings.Repeat("x", 100)+"\r\n.", "552")
+
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, conn, "RCPT TO:<recipient@example.com>", "250")
+	cmdCode(t, conn, "DATA", "354")
+	cmdCode(t, conn, "X-Reject: yes\r\n\r\nTest.\r\n.", "554")
+
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, conn, "RCPT TO:<recipient@example.com>", "250")
+	cmdCode(t, conn, "DATA", "354")
+	cmdCode(t, conn, "Subject: small\r\n\r\nTest.\r\n.", "250")
+
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+}
+
+func TestChaosFaults(t *testing.T) {
+	t.Cleanup(func() {
+		_ = chaos.SetFromStruct(chaos.Triggers{})
+		chaos.Enabled = false
+	})
+
+	for _, f := range []struct {
+		key, value  string
+		probability int
+	}{
+		{"delay-mail", "50-60", 100},
+		{"drop-rcpt", "", 100},
+	} {
+		if err := chaos.SetFault(f.key, f.value, f.probability); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := chaos.SetFault("delay-unknown", "10", 100); err == nil {
+		t.Error("delay for an unknown stage was accepted")
+	}
+
+	conn := newConn(t, &Server{})
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+
+	start := time.Now()
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("MAIL response took %s, want at least 50ms", d)
+	}
+
+	// The connection must be closed without a response.
+	_, _ = fmt.Fprintf(conn, "%s\r\n", "RCPT TO:<recipient@example.com>")
+	if resp, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+		t.Errorf("RCPT response is %q, want closed connection", resp)
+	}
+	_ = conn.Close()
+
+	// Truncated EHLO responses must only return the first lines, then close the connection.
+	if err := chaos.SetFromStruct(chaos.Triggers{Truncate: chaos.TruncateTrigger{Lines: 2, Probability: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	conn = newConn(t, &Server{})
+	_, _ = fmt.Fprintf(conn, "%s\r\n", "EHLO host.example.com")
+	reader := bufio.NewReader(conn)
+	for i := range 3 {
+		resp, err := reader.ReadString('\n')
+		if i < 2 && (err != nil || !strings.HasPrefix(resp, "250-")) {
+			t.Errorf("EHLO response line %d is %q (%v), want 250- continuation", i+1, resp, err)
+		}
+		if i == 2 && err == nil {
+			t.Errorf("EHLO response line %d is %q, want closed connection", i+1, resp)
+		}
+	}
+	_ = conn.Close()
+
+	// DATA must be read no faster than the bandwidth limit.
+	if err := chaos.SetFromStruct(chaos.Triggers{Bandwidth: chaos.BandwidthTrigger{BytesPerSecond: 1000, Probability: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	conn = newConn(t, &Server{})
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, conn, "RCPT TO:<recipient@example.com>", "250")
+	cmdCode(t, conn, "DATA", "354")
+	start = time.Now()
+	cmdCode(t, conn, "Subject: test\r\n\r\n"+strings.Repeat("x", 200)+"\r\n.", "250")
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("DATA took %s, want at least 200ms", d)
+	}
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+}
+
+// Send a command and return the full (multiline) response.
+func cmdCodeMultiline(t *testing.T, conn net.Conn, cmd string) string {
+	_, _ = fmt.Fprintf(conn, "%s\r\n", cmd)
+	reader := bufio.NewReader(conn)
+	lines := []string{}
+	for {
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read response from test server: %v", err)
+		}
+		lines = append(lines, strings.TrimSpace(resp))
+		if len(resp) < 4 || resp[3] != '-' {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // Benchmark the mail handling without the network stack introducing latency.
 func BenchmarkReceive(b *testing.B) {
 	server := &Server{} // Default server configuration.
@@ -1777,7 +2206,7 @@ func (m *mockDropRejectedHandler) handler(remoteAddr net.Addr, from string, to [
 	return nil
 }
 
-func (m *mockDropRejectedHandler) msgIDHandler(remoteAddr net.Addr, from string, to []string, data []byte, username *string) (string, error) {
+func (m *mockDropRejectedHandler) msgIDHandler(remoteAddr net.Addr, from string, to []string, data []byte, username *string, requireTLS bool, _ *authres.Envelope) (string, error) {
 	m.msgIDCalled++
 	m.lastMsgIDFrom = from
 	m.lastMsgIDTo = append([]string{}, to...) // copy slice
@@ -1974,3 +2403,334 @@ func TestAuthContinuationLineLengthLimit(t *testing.T) {
 		t.Fatalf("expected errLineTooLong for oversized AUTH continuation, got %v", err)
 	}
 }
+
+func TestRateLimits(t *testing.T) {
+	ratelimit.MaxConnections = 1
+	ratelimit.MaxMessages = 1
+	defer func() {
+		ratelimit.MaxConnections, ratelimit.MaxMessages = 0, 0
+		ratelimit.Reset()
+	}()
+
+	conn := newConn(t, &Server{})
+
+	// a second connection from the same IP
+	clientConn, serverConn := net.Pipe()
+	go (&Server{}).newSession(serverConn).serve()
+	banner, err := bufio.NewReader(clientConn).ReadString('\n')
+	if err != nil || banner[0:3] != "421" {
+		t.Errorf("expected a 421 connection limit response, got %q %v", banner, err)
+	}
+	_ = clientConn.Close()
+
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, conn, "RCPT TO:<recipient@example.com>", "250")
+	cmdCode(t, conn, "DATA", "354")
+	cmdCode(t, conn, "Test message.\r\n.", "250")
+
+	// message rate limit
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "421")
+	_ = conn.Close()
+
+	if s := ratelimit.GetStatus(); len(s.Clients) != 1 || s.Clients[0].Messages != 1 {
+		t.Errorf("unexpected usage %+v", s)
+	}
+}
+
+func TestRateLimitsClientCertificate(t *testing.T) {
+	ratelimit.MaxUserConnections = 1
+	defer func() {
+		ratelimit.MaxUserConnections = 0
+		ratelimit.Reset()
+	}()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	if err := server.ConfigureClientTLS(caFile, false); err != nil {
+		t.Fatal(err)
+	}
+
+	startTLS := func() *tls.Conn {
+		conn := newConn(t, server)
+		cmdCode(t, conn, "EHLO host.example.com", "250")
+		cmdCode(t, conn, "STARTTLS", "220")
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+		if err := tlsConn.Handshake(); err != nil {
+			t.Fatalf("Failed to perform TLS handshake: %v", err)
+		}
+		return tlsConn
+	}
+
+	tlsConn := startTLS()
+	cmdCode(t, tlsConn, "EHLO host.example.com", "250")
+
+	// a second connection with the same client certificate username
+	tlsConn2 := startTLS()
+	resp, err := bufio.NewReader(tlsConn2).ReadString('\n')
+	if err != nil || resp[0:3] != "421" {
+		t.Errorf("expected a 421 user connection limit response, got %q %v", resp, err)
+	}
+	_ = tlsConn2.Close()
+
+	users := 0
+	for _, u := range ratelimit.GetStatus().Clients {
+		if u.Type == ratelimit.TypeUser {
+			users++
+			if u.Name != "localhost" || u.Connections != 1 {
+				t.Errorf("unexpected user usage %+v", u)
+			}
+		}
+	}
+	if users != 1 {
+		t.Errorf("expected the client certificate user to be counted, got %d users", users)
+	}
+
+	cmdCode(t, tlsConn, "QUIT", "221")
+	_ = tlsConn.Close()
+}
+
+func TestCmdMAILRequireTLS(t *testing.T) {
+	// TLS not configured
+	conn := newConn(t, &Server{})
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com> REQUIRETLS", "555")
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+
+	// TLS configured, but not in use
+	conn = newConn(t, &Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}})
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com> REQUIRETLS", "530")
+	cmdCode(t, conn, "MAIL FROM:<sender@example.com>", "250")
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+
+	// the REQUIRETLS tag is recorded in the Received header
+	s := &session{srv: &Server{AppName: "smtpd", Hostname: "serverName"}, requireTLS: true}
+	if headers := string(s.makeHeaders([]string{"recipient@example.com"})); !strings.Contains(headers, "by serverName (smtpd) with SMTP (REQUIRETLS)\r\n") {
+		t.Errorf("expected the REQUIRETLS tag in the Received header, got %q", headers)
+	}
+}
+
+func TestCmdRCPTInternationalised(t *testing.T) {
+	recipients := []string{}
+	conn := newConn(t, &Server{
+		HandlerRcpt: func(_ net.Addr, _ string, to string) bool {
+			recipients = append(recipients, to)
+			return true
+		},
+	})
+	cmdCode(t, conn, "EHLO host.example.com", "250")
+	cmdCode(t, conn, "MAIL FROM:<jöran@bücher.example> SMTPUTF8", "250")
+	cmdCode(t, conn, "RCPT TO:<jöran@Bücher.example>", "250")
+	cmdCode(t, conn, "RCPT TO:<user@xn--bcher-kva.example>", "250")
+	cmdCode(t, conn, "QUIT", "221")
+	_ = conn.Close()
+
+	// non-ASCII addresses are normalised, ASCII addresses are left unchanged
+	expected := []string{"jöran@bücher.example", "user@xn--bcher-kva.example"}
+	if !reflect.DeepEqual(recipients, expected) {
+		t.Errorf("expected recipients %q, got %q", expected, recipients)
+	}
+}
+
+func TestHandlerRcptInternationalised(t *testing.T) {
+	config.SMTPAllowedRecipientsRegexp = regexp.MustCompile(`@xn--bcher-kva\.example$`)
+	defer func() { config.SMTPAllowedRecipientsRegexp = nil }()
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 25}
+	for to, expected := range map[string]bool{
+		"user@bücher.example":         true,
+		"user@xn--bcher-kva.example":  true,
+		"user@buecher.example":        false,
+		"jöran@bücher.example":        false,
+		"user@bücher.example.invalid": false,
+	} {
+		if result := handlerRcpt(addr, "sender@example.com", to); result != expected {
+			t.Errorf("expected %v for %s, got %v", expected, to, result)
+		}
+	}
+}
+
+func TestRelayDowngradeAddresses(t *testing.T) {
+	from, to, err := downgradeAddresses(false, "sender@bücher.example", []string{"user@Bücher.example", "jöran@example.com", "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "sender@xn--bcher-kva.example" || !reflect.DeepEqual(to, []string{"user@xn--bcher-kva.example", "user@example.com"}) {
+		t.Errorf("unexpected downgraded addresses %q %q", from, to)
+	}
+
+	if _, _, err := downgradeAddresses(false, "jöran@example.com", []string{"user@example.com"}); err == nil {
+		t.Error("expected an error for a non-ASCII sender")
+	}
+
+	if _, _, err := downgradeAddresses(false, "", []string{"jöran@example.com"}); err == nil {
+		t.Error("expected an error without any recipients")
+	}
+
+	if _, _, err := downgradeAddresses(true, "", []string{"user@example.com", "jöran@example.com"}); err == nil {
+		t.Error("expected an error for a non-ASCII recipient when forwarding SMTP errors")
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		5:  16 * time.Minute,
+		7:  time.Hour,
+		20: time.Hour,
+	} {
+		if d := queueBackoff(attempts); d != expected {
+			t.Errorf("expected backoff %s after %d attempts, got %s", expected, attempts, d)
+		}
+	}
+
+	permanent := fmt.Errorf("error response to MAIL command: %w", &textproto.Error{Code: 550, Msg: "5.1.1 rejected"})
+	temporary := fmt.Errorf("error response to MAIL command: %w", &textproto.Error{Code: 421, Msg: "4.7.0 try again later"})
+
+	if !isPermanentError(permanent) || isPermanentError(temporary) || isPermanentError(errors.New("connection refused")) {
+		t.Error("unexpected permanent error classification")
+	}
+}
+
+func TestQueueScheduledMaxAge(t *testing.T) {
+	config.Database = ""
+	if err := storage.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	// the relay server is unavailable, resulting in a temporary error
+	config.SMTPRelayConfig = config.SMTPRelayConfigStruct{Host: "127.0.0.1", Port: 1}
+	config.SMTPRelayQueueMaxAgeDuration = time.Hour
+	defer func() {
+		config.SMTPRelayConfig = config.SMTPRelayConfigStruct{}
+		config.SMTPRelayQueueMaxAgeDuration = 0
+	}()
+
+	// the maximum age of a scheduled release applies from when it is due
+	id, err := storage.QueueAdd(storage.QueueJob{Type: QueueTypeRelease, From: "sender@example.com", To: []string{"recipient@example.com"}, NextAttempt: time.Now().Add(2 * time.Hour)}, []byte("Subject: scheduled\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, _, err := storage.QueueGet(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attempt the delivery as if the scheduled release is due
+	due := time.Until(job.NextAttempt)
+	job.Created = job.Created.Add(-due)
+	job.NextAttempt = job.NextAttempt.Add(-due)
+
+	deliverQueued(job)
+
+	job, _, err = storage.QueueGet(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != storage.QueueStatusQueued || job.Attempts != 1 {
+		t.Errorf("expected the scheduled release to be queued for another attempt, got %s after %d attempt(s): %s", job.Status, job.Attempts, job.LastError)
+	}
+}
+
+func TestNewRelayLog(t *testing.T) {
+	to := []string{"user@example.com"}
+
+	l := newRelayLog(QueueTypeRelease, "smtp.example.com:25", to, time.Now(), 250, "2.0.0 Ok", nil)
+	if !l.Success || l.Code != 250 || l.Response != "2.0.0 Ok" || l.Type != QueueTypeRelease {
+		t.Errorf("unexpected relay log for successful delivery: %+v", l)
+	}
+
+	rejected := fmt.Errorf("error response to RCPT command: %w", &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"})
+	l = newRelayLog(QueueTypeRelay, "smtp.example.com:25", to, time.Now(), 0, "", rejected)
+	if l.Success || l.Code != 550 || l.Response != "5.1.1 User unknown" {
+		t.Errorf("unexpected relay log for rejected delivery: %+v", l)
+	}
+
+	l = newRelayLog(QueueTypeForward, "smtp.example.com:25", to, time.Now(), 0, "", errors.New("connection refused"))
+	if l.Success || l.Code != 0 || l.Response != "connection refused" {
+		t.Errorf("unexpected relay log for failed connection: %+v", l)
+	}
+}
+
+func TestAutoRelayRoutes(t *testing.T) {
+	config.SMTPRelayMatchingRegexp = regexp.MustCompile(`@example\.com$`)
+	config.SMTPRelayConfig.BlockedRecipientsRegexp = regexp.MustCompile(`^blocked@`)
+	config.SMTPRelayConfig.Profiles = map[string]*config.SMTPRelayConfigStruct{
+		"seeds":   {MatchingRegexp: regexp.MustCompile(`^seed`)},
+		"staging": {MatchingRegexp: regexp.MustCompile(`@staging\.example\.com$`), BlockedRecipientsRegexp: regexp.MustCompile(`^ignored@`)},
+	}
+	defer func() {
+		config.SMTPRelayMatchingRegexp = nil
+		config.SMTPRelayConfig = config.SMTPRelayConfigStruct{}
+	}()
+
+	routes := autoRelayRoutes([]string{
+		"user@example.com",
+		"blocked@example.com",
+		"seed1@staging.example.com",
+		"user@staging.example.com",
+		"ignored@staging.example.com",
+		"user@example.net",
+	})
+
+	expected := []relayRoute{
+		{profile: "", to: []string{"user@example.com"}},
+		{profile: "seeds", to: []string{"seed1@staging.example.com"}},
+		{profile: "staging", to: []string{"user@staging.example.com"}},
+	}
+
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected routes %+v, got %+v", expected, routes)
+	}
+}
+
+func TestReleaseRecipients(t *testing.T) {
+	p := &config.SMTPRelayConfigStruct{
+		AllowedRecipientsRegexp: regexp.MustCompile(`@example\.com$`),
+		BlockedRecipientsRegexp: regexp.MustCompile(`^blocked@`),
+	}
+
+	m := storage.MessageSummary{
+		To:  []*mail.Address{{Address: "user@example.com"}, {Address: "blocked@example.com"}},
+		Cc:  []*mail.Address{{Address: "USER@example.com"}, {Address: "user@example.net"}},
+		Bcc: []*mail.Address{{Address: "bcc@example.com"}},
+	}
+
+	if to := originalRecipients(p, m); !reflect.DeepEqual(to, []string{"user@example.com", "bcc@example.com"}) {
+		t.Errorf("unexpected original recipients %q", to)
+	}
+
+	if err := checkReleaseRecipients(p, []string{"user@example.com"}); err != nil {
+		t.Error(err)
+	}
+
+	for _, to := range [][]string{{"user@example.net"}, {"blocked@example.com"}, {"invalid"}, {}} {
+		if err := checkReleaseRecipients(p, to); err == nil {
+			t.Errorf("expected an error for recipients %q", to)
+		}
+	}
+}
+
+func TestRelayBounceRecipients(t *testing.T) {
+	config.SMTPRelayConfig.AllowedRecipientsRegexp = regexp.MustCompile(`@example\.com$`)
+	config.SMTPRelayConfig.BlockedRecipientsRegexp = regexp.MustCompile(`^blocked@`)
+	defer func() {
+		config.SMTPRelayConfig = config.SMTPRelayConfigStruct{}
+	}()
+
+	for _, to := range []string{"sender@example.net", "blocked@example.com"} {
+		if err := relayBounce(bounce.Message{To: to, Data: []byte("Subject: bounce\r\n\r\n")}); err == nil {
+			t.Errorf("expected bounce to %s not to be relayed", to)
+		}
+	}
+}
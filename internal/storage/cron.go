@@ -158,6 +158,17 @@ func pruneMessages() {
 		return
 	}
 
+	_, err = tx.Exec(`DELETE FROM `+tenant("relay_log")+` WHERE ID IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, args...) // #nosec
+	if err != nil {
+		logger.Log().Errorf("[db] %s", err.Error())
+		return
+	}
+
+	if err := deleteScheduledReleases(tx, args); err != nil {
+		logger.Log().Errorf("[db] %s", err.Error())
+		return
+	}
+
 	_, err = tx.Exec(`DELETE FROM `+tenant("mailbox")+` WHERE ID IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, args...) // #nosec
 	if err != nil {
 		logger.Log().Errorf("[db] %s", err.Error())
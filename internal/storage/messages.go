@@ -18,8 +18,13 @@ import (
 	"time"
 
 	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/authres"
+	"github.com/axllent/mailpit/internal/dkim"
 	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/pgp"
+	"github.com/axllent/mailpit/internal/resolver"
 	"github.com/axllent/mailpit/internal/shortuuid"
+	"github.com/axllent/mailpit/internal/smime"
 	"github.com/axllent/mailpit/internal/tools"
 	"github.com/axllent/mailpit/server/webhook"
 	"github.com/axllent/mailpit/server/websockets"
@@ -28,9 +33,11 @@ import (
 )
 
 // Store will save an email to the database tables.
-// The username is the authentication username of either the SMTP or HTTP client (blank for none).
+// The username is the authentication username of either the SMTP or HTTP client (blank for none),
+// requireTLS is whether the message was received with the SMTP REQUIRETLS parameter (RFC 8689), and the
+// envelope is the SMTP session used to evaluate SPF (nil if the message was not received via SMTP).
 // Returns the database ID of the saved message.
-func Store(body *[]byte, username *string) (string, error) {
+func Store(body *[]byte, username *string, requireTLS bool, envelope *authres.Envelope) (string, error) {
 	parser := enmime.NewParser(enmime.DisableCharacterDetection(true))
 
 	// Parse message body with enmime
@@ -58,6 +65,20 @@ func Store(body *[]byte, username *string) (string, error) {
 	if username != nil {
 		obj.Username = *username
 	}
+	obj.RequireTLS = requireTLS
+	obj.SMTP = envelope
+
+	// only authenticate messages on receipt when using local DNS records (no network lookups)
+	if r := resolver.Local(); r != nil {
+		results := authres.Evaluate(*body, envelope, r)
+		obj.SPF = results.SPF.Result
+		obj.DKIM = results.DKIM.Status
+		obj.DMARC = results.DMARC.Result
+		obj.ARC = results.ARC.Result
+	} else if env.GetHeader("DKIM-Signature") == "" {
+		// unsigned messages do not require a key lookup
+		obj.DKIM = dkim.StatusNone
+	}
 
 	messageID := strings.Trim(env.GetHeader("Message-ID"), "<>")
 	created := time.Now()
@@ -291,6 +312,13 @@ func List(start int, beforeTS int64, limit int) ([]MessageSummary, error) {
 				results[i].Tags = []string{}
 			}
 		}
+
+		scheduled := getScheduledReleasesForIDs(ids)
+		for i, m := range results {
+			if ts, ok := scheduled[m.ID]; ok {
+				results[i].ScheduledRelease = &ts
+			}
+		}
 	}
 
 	dbLastAction = time.Now()
@@ -310,6 +338,11 @@ func GetMessage(id string) (*Message, error) {
 		return nil, err
 	}
 
+	// the size is of the message as received
+	size := uint64(len(raw))
+
+	raw, unwrapped := Decrypt(raw)
+
 	r := bytes.NewReader(raw)
 
 	parser := enmime.NewParser(enmime.DisableCharacterDetection(true))
@@ -376,9 +409,11 @@ func GetMessage(id string) (*Message, error) {
 		ReturnPath: returnPath,
 		Subject:    env.GetHeader("Subject"),
 		Tags:       getMessageTags(id),
-		Size:       uint64(len(raw)),
+		Size:       size,
+		Unwrapped:  unwrapped,
 		Text:       env.Text,
 		Username:   meta.Username,
+		RequireTLS: meta.RequireTLS,
 	}
 	obj.HTML = env.HTML
 	obj.Inline = []Attachment{}
@@ -426,6 +461,21 @@ func GetMessage(id string) (*Message, error) {
 	return &obj, nil
 }
 
+// Decrypt returns the decrypted content of S/MIME (encrypted or opaque signed) & OpenPGP/MIME encrypted
+// messages so they can be parsed as regular messages, else the raw message. The second return value is
+// the format the content was unwrapped from (UnwrappedSMIME or UnwrappedPGP), or empty if unchanged.
+func Decrypt(raw []byte) ([]byte, string) {
+	if unwrapped, err := smime.Unwrap(raw); err == nil {
+		return unwrapped, UnwrappedSMIME
+	}
+
+	if unwrapped, err := pgp.Unwrap(raw); err == nil {
+		return unwrapped, UnwrappedPGP
+	}
+
+	return raw, ""
+}
+
 // GetMessageRaw returns an []byte of the full message
 func GetMessageRaw(id string) ([]byte, error) {
 	var i, msg string
@@ -444,7 +494,15 @@ func GetMessageRaw(id string) ([]byte, error) {
 		return nil, errors.New("message not found")
 	}
 
+	dbLastAction = time.Now()
+
+	return decodeRaw(msg, compressed)
+}
+
+// decodeRaw returns a raw message as stored in the database, decompressing it if compressed
+func decodeRaw(msg string, compressed int) ([]byte, error) {
 	var data []byte
+	var err error
 	if sqlDriver == "rqlite" && compressed == 1 {
 		data, err = base64.StdEncoding.DecodeString(msg)
 		if err != nil {
@@ -454,8 +512,6 @@ func GetMessageRaw(id string) ([]byte, error) {
 		data = []byte(msg)
 	}
 
-	dbLastAction = time.Now()
-
 	if compressed == 1 {
 		raw, err := dbDecoder.DecodeAll(data, nil)
 		if err != nil {
@@ -475,6 +531,8 @@ func GetAttachmentPart(id, partID string) (*enmime.Part, error) {
 		return nil, err
 	}
 
+	raw, _ = Decrypt(raw)
+
 	r := bytes.NewReader(raw)
 
 	parser := enmime.NewParser(enmime.DisableCharacterDetection(true))
@@ -772,7 +830,7 @@ func DeleteMessages(ids []string) error {
 		args[i] = id
 	}
 
-	tables := []string{"mailbox", "mailbox_data", "message_tags"}
+	tables := []string{"mailbox", "mailbox_data", "message_tags", "relay_log"}
 
 	for _, t := range tables {
 		sql = fmt.Sprintf(`DELETE FROM %s WHERE ID IN (?%s)`, tenant(t), strings.Repeat(",?", len(ids)-1))
@@ -783,6 +841,10 @@ func DeleteMessages(ids []string) error {
 		}
 	}
 
+	if err := deleteScheduledReleases(tx, args); err != nil {
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
@@ -838,7 +900,7 @@ func DeleteAllMessages() error {
 	// roll back if it fails
 	defer func() { _ = tx.Rollback() }()
 
-	tables := []string{"mailbox", "mailbox_data", "tags", "message_tags"}
+	tables := []string{"mailbox", "mailbox_data", "tags", "message_tags", "relay_log"}
 
 	for _, t := range tables {
 		sql := fmt.Sprintf(`DELETE FROM %s`, tenant(t)) // #nosec
@@ -848,6 +910,11 @@ func DeleteAllMessages() error {
 		}
 	}
 
+	// scheduled releases of the deleted messages
+	if _, err := tx.Exec(`DELETE FROM `+tenant("queue")+` WHERE Status = ? AND MessageID != ''`, QueueStatusScheduled); err != nil { // #nosec
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
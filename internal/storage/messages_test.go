@@ -2,10 +2,13 @@ package storage
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/pgp"
+	"github.com/axllent/mailpit/internal/smime"
 )
 
 func TestTextEmailInserts(t *testing.T) {
@@ -17,7 +20,7 @@ func TestTextEmailInserts(t *testing.T) {
 	start := time.Now()
 
 	for range testRuns {
-		if _, err := Store(&testTextEmail, nil); err != nil {
+		if _, err := Store(&testTextEmail, nil, false, nil); err != nil {
 			t.Log("error ", err)
 			t.Fail()
 		}
@@ -55,7 +58,7 @@ func TestMimeEmailInserts(t *testing.T) {
 		start := time.Now()
 
 		for range testRuns {
-			if _, err := Store(&testMimeEmail, nil); err != nil {
+			if _, err := Store(&testMimeEmail, nil, false, nil); err != nil {
 				t.Log("error ", err)
 				t.Fail()
 			}
@@ -95,7 +98,7 @@ func TestRetrieveMimeEmail(t *testing.T) {
 				t.Logf("Testing mime email retrieval (tenant %s)", tenantID)
 			}
 
-			id, err := Store(&testMimeEmail, nil)
+			id, err := Store(&testMimeEmail, nil, false, nil)
 			if err != nil {
 				t.Log("error ", err)
 				t.Fail()
@@ -152,7 +155,7 @@ func TestMessageSummary(t *testing.T) {
 			t.Logf("Testing message summary (tenant %s)", tenantID)
 		}
 
-		if _, err := Store(&testMimeEmail, nil); err != nil {
+		if _, err := Store(&testMimeEmail, nil, false, nil); err != nil {
 			t.Log("error ", err)
 			t.Fail()
 		}
@@ -186,7 +189,7 @@ func BenchmarkImportText(b *testing.B) {
 	defer Close()
 
 	for i := 0; i < b.N; i++ {
-		if _, err := Store(&testTextEmail, nil); err != nil {
+		if _, err := Store(&testTextEmail, nil, false, nil); err != nil {
 			b.Log("error ", err)
 			b.Fail()
 		}
@@ -198,7 +201,7 @@ func BenchmarkImportMime(b *testing.B) {
 	defer Close()
 
 	for i := 0; i < b.N; i++ {
-		if _, err := Store(&testMimeEmail, nil); err != nil {
+		if _, err := Store(&testMimeEmail, nil, false, nil); err != nil {
 			b.Log("error ", err)
 			b.Fail()
 		}
@@ -215,7 +218,7 @@ func TestInlineImageContentIdHandling(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to read test email: %v", err)
 	}
-	storedMessage, err := Store(&inlineAttachment, nil)
+	storedMessage, err := Store(&inlineAttachment, nil, false, nil)
 	if err != nil {
 		t.Fatal("Failed to store test case 1:", err)
 	}
@@ -245,7 +248,7 @@ func TestRegularAttachmentHandling(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to read test email: %v", err)
 	}
-	storedMessage, err := Store(&regularAttachment, nil)
+	storedMessage, err := Store(&regularAttachment, nil, false, nil)
 	if err != nil {
 		t.Fatal("Failed to store test case 3:", err)
 	}
@@ -279,7 +282,7 @@ func TestMixedAttachmentHandling(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to read test email: %v", err)
 	}
-	storedMessage, err := Store(&mixedAttachment, nil)
+	storedMessage, err := Store(&mixedAttachment, nil, false, nil)
 	if err != nil {
 		t.Fatal("Failed to store test case 4:", err)
 	}
@@ -301,3 +304,113 @@ func TestMixedAttachmentHandling(t *testing.T) {
 		t.Errorf("Test case 4: Expected attachment ContentID to be empty, got '%s'", msg.Attachments[0].ContentID)
 	}
 }
+
+func TestSMIMEEncryptedMessage(t *testing.T) {
+	setup("")
+	defer Close()
+
+	if err := smime.Load("", "../smime/testdata/recipient.pem"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = smime.Load("", "") }()
+
+	encrypted, err := os.ReadFile("../smime/testdata/encrypted.eml")
+	if err != nil {
+		t.Fatalf("Failed to read test email: %v", err)
+	}
+	size := uint64(len(encrypted))
+
+	id, err := Store(&encrypted, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := GetMessage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the decrypted message is parsed, with the signature as an attachment
+	assertEqual(t, msg.Subject, "Encrypted statement", "subject does not match")
+	assertEqual(t, strings.TrimSpace(msg.Text), "Your statement is attached.", "decrypted text does not match")
+	assertEqual(t, len(msg.Attachments), 1, "incorrect number of attachments")
+	assertEqual(t, msg.Attachments[0].FileName, "smime.p7s", "attachment filename does not match")
+
+	// the content is flagged as unwrapped, and the size is of the message as received
+	assertEqual(t, msg.Unwrapped, UnwrappedSMIME, "unwrapped format does not match")
+	assertEqual(t, msg.Size, size, "size does not match the received message")
+
+	if _, err := GetAttachmentPart(id, msg.Attachments[0].PartID); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPGPEncryptedMessage(t *testing.T) {
+	setup("")
+	defer Close()
+
+	if err := pgp.Load("", "../pgp/testdata/recipient.asc"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pgp.Load("", "") }()
+
+	encrypted, err := os.ReadFile("../pgp/testdata/combined.eml")
+	if err != nil {
+		t.Fatalf("Failed to read test email: %v", err)
+	}
+	size := uint64(len(encrypted))
+
+	id, err := Store(&encrypted, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := GetMessage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, msg.Subject, "Signed and encrypted statement", "subject does not match")
+	assertEqual(t, strings.TrimSpace(msg.Text), "View your statement at https://example.com/statement", "decrypted text does not match")
+	assertEqual(t, strings.Contains(msg.HTML, `href="https://example.com/statement"`), true, "decrypted HTML does not match")
+	assertEqual(t, len(msg.Attachments), 0, "incorrect number of attachments")
+	assertEqual(t, msg.Unwrapped, UnwrappedPGP, "unwrapped format does not match")
+	assertEqual(t, msg.Size, size, "size does not match the received message")
+
+	// messages which are not encrypted are as received
+	plain, err := Store(&testTextEmail, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err = GetMessage(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, msg.Unwrapped, "", "unexpected unwrapped format")
+}
+
+func TestRequireTLSMessage(t *testing.T) {
+	setup("")
+	defer Close()
+
+	// the REQUIRETLS tag in a client-supplied Received header is ignored
+	raw := []byte("Received: from client (unknown [127.0.0.1])\r\n        by mailpit (Mailpit) with SMTP (REQUIRETLS)\r\n" +
+		"        for <recipient@example.com>; Mon, 2 Jan 2006 15:04:05 -0700 (MST)\r\n" +
+		"From: sender@example.com\r\nTo: recipient@example.com\r\nSubject: REQUIRETLS\r\n\r\nHello\r\n")
+
+	for _, requireTLS := range []bool{true, false} {
+		id, err := Store(&raw, nil, requireTLS, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := GetMessage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEqual(t, msg.RequireTLS, requireTLS, "REQUIRETLS does not match")
+	}
+}
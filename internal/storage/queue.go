@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/logger"
+	"github.com/axllent/mailpit/internal/shortuuid"
+	"github.com/leporo/sqlf"
+)
+
+const (
+	// QueueStatusQueued is a queued delivery awaiting its next attempt
+	QueueStatusQueued = "queued"
+	// QueueStatusScheduled is a delivery scheduled for a later first attempt
+	QueueStatusScheduled = "scheduled"
+	// QueueStatusFailed is a delivery which permanently failed or exceeded the maximum age
+	QueueStatusFailed = "failed"
+)
+
+// ErrQueueJobNotFound is returned when a queued delivery does not exist
+var ErrQueueJobNotFound = errors.New("queued delivery not found")
+
+// QueueJob is a queued outbound delivery
+//
+// swagger:model QueueJob
+type QueueJob struct {
+	// Unique queue ID
+	ID string
+	// Date & time the delivery was queued, or the date & time a scheduled delivery is due
+	Created time.Time
+	// Delivery type: release (manual release), relay (auto-relay) or forward
+	// example: relay
+	Type string
+	// Named relay profile or forward rule, empty for the default configuration
+	Profile string
+	// Message database ID, if the delivery is of a stored message
+	MessageID string
+	// SMTP sender address
+	// example: sender@example.com
+	From string
+	// SMTP recipient addresses
+	To []string
+	// Message size in bytes
+	Size uint64
+	// Number of delivery attempts
+	Attempts int
+	// Date & time of the next delivery attempt
+	NextAttempt time.Time
+	// Error of the last delivery attempt
+	LastError string
+	// Delivery status: queued, scheduled or failed
+	// example: queued
+	Status string
+}
+
+// QueueStats is the number of queued, scheduled & failed deliveries
+type QueueStats struct {
+	Queued    uint64
+	Scheduled uint64
+	Failed    uint64
+}
+
+// QueueAdd adds an outbound delivery of the job type, profile, message database ID (optional), sender
+// & recipients to the queue, returning the queue ID. The delivery is scheduled if the job NextAttempt
+// is in the future, else it is queued for immediate delivery. The maximum queue age of a scheduled
+// delivery applies from the date & time it is due.
+func QueueAdd(job QueueJob, data []byte) (string, error) {
+	recipients, err := json.Marshal(job.To)
+	if err != nil {
+		return "", err
+	}
+
+	id := shortuuid.New()
+	created := time.Now().UnixMilli()
+	nextAttempt := created
+	status := QueueStatusQueued
+
+	if job.NextAttempt.UnixMilli() > created {
+		created = job.NextAttempt.UnixMilli()
+		nextAttempt = created
+		status = QueueStatusScheduled
+	}
+
+	sql := fmt.Sprintf(`INSERT INTO %s
+		(ID, Created, Type, Profile, MessageID, Sender, Recipients, Size, Attempts, NextAttempt, LastError, Status, Compressed, Data)
+		VALUES(?,?,?,?,?,?,?,?,0,?,'',?,`, tenant("queue")) // #nosec
+	args := []any{id, created, job.Type, job.Profile, job.MessageID, job.From, string(recipients), len(data), nextAttempt, status}
+
+	if config.Compression > 0 {
+		// insert compressed raw message
+		compressed := dbEncoder.EncodeAll(data, make([]byte, 0, len(data)))
+
+		if sqlDriver == "rqlite" {
+			// rqlite does not support binary data in query, so the compressed message is encoded into hexadecimal
+			_, err = db.Exec(sql+fmt.Sprintf(`1,x'%s')`, hex.EncodeToString(compressed)), args...) // #nosec
+		} else {
+			_, err = db.Exec(sql+`1,?)`, append(args, compressed)...)
+		}
+	} else {
+		// insert uncompressed raw message
+		_, err = db.Exec(sql+`0,?)`, append(args, string(data))...)
+	}
+
+	return id, err
+}
+
+// QueueList returns all queued & failed deliveries, ordered by creation date
+func QueueList() ([]QueueJob, error) {
+	return queueSelect(sqlf.From(tenant("queue")).OrderBy("Created ASC"))
+}
+
+// QueueScheduled returns the scheduled deliveries, ordered by their scheduled date
+func QueueScheduled() ([]QueueJob, error) {
+	return queueSelect(sqlf.From(tenant("queue")).
+		Where("Status = ?", QueueStatusScheduled).
+		OrderBy("NextAttempt ASC"))
+}
+
+// QueueDue returns queued & scheduled deliveries which are due for an attempt, oldest first
+func QueueDue(limit int) ([]QueueJob, error) {
+	return queueSelect(sqlf.From(tenant("queue")).
+		Where("Status IN (?, ?)", QueueStatusQueued, QueueStatusScheduled).
+		Where("NextAttempt <= ?", time.Now().UnixMilli()).
+		OrderBy("NextAttempt ASC").
+		Limit(limit))
+}
+
+// QueueGet returns a queued delivery & its raw message
+func QueueGet(id string) (QueueJob, []byte, error) {
+	jobs, err := queueSelect(sqlf.From(tenant("queue")).Where("ID = ?", id))
+	if err != nil {
+		return QueueJob{}, nil, err
+	}
+
+	if len(jobs) == 0 {
+		return QueueJob{}, nil, ErrQueueJobNotFound
+	}
+
+	var data string
+	var compressed int
+	if err := sqlf.From(tenant("queue")).
+		Select("Data").To(&data).
+		Select("Compressed").To(&compressed).
+		Where("ID = ?", id).
+		QueryRowAndClose(context.TODO(), db); err != nil {
+		return QueueJob{}, nil, err
+	}
+
+	raw, err := decodeRaw(data, compressed)
+	if err != nil {
+		return QueueJob{}, nil, err
+	}
+
+	return jobs[0], raw, nil
+}
+
+// QueueUpdate records a failed delivery attempt, setting the next attempt & status
+func QueueUpdate(id string, attempts int, nextAttempt time.Time, lastError, status string) error {
+	_, err := sqlf.Update(tenant("queue")).
+		Set("Attempts", attempts).
+		Set("NextAttempt", nextAttempt.UnixMilli()).
+		Set("LastError", lastError).
+		Set("Status", status).
+		Where("ID = ?", id).
+		ExecAndClose(context.TODO(), db)
+
+	return err
+}
+
+// QueueRetry schedules a queued or failed delivery for an immediate attempt.
+// Scheduled deliveries are not affected.
+func QueueRetry(id string) error {
+	res, err := sqlf.Update(tenant("queue")).
+		Set("NextAttempt", time.Now().UnixMilli()).
+		Set("Status", QueueStatusQueued).
+		Where("ID = ?", id).
+		Where("Status != ?", QueueStatusScheduled).
+		ExecAndClose(context.TODO(), db)
+	if err != nil {
+		return err
+	}
+
+	return queueAffected(res)
+}
+
+// QueueDelete deletes a delivery from the queue once delivered
+func QueueDelete(id string) error {
+	res, err := sqlf.DeleteFrom(tenant("queue")).
+		Where("ID = ?", id).
+		ExecAndClose(context.TODO(), db)
+	if err != nil {
+		return err
+	}
+
+	return queueAffected(res)
+}
+
+// QueueCancel deletes a queued or failed delivery from the queue. Scheduled deliveries
+// can only be cancelled with QueueCancelScheduled.
+func QueueCancel(id string) error {
+	res, err := sqlf.DeleteFrom(tenant("queue")).
+		Where("ID = ?", id).
+		Where("Status != ?", QueueStatusScheduled).
+		ExecAndClose(context.TODO(), db)
+	if err != nil {
+		return err
+	}
+
+	return queueAffected(res)
+}
+
+// deleteScheduledReleases deletes the scheduled releases of messages being deleted in the transaction
+func deleteScheduledReleases(tx *sql.Tx, ids []any) error {
+	_, err := tx.Exec(`DELETE FROM `+tenant("queue")+` WHERE Status = ? AND MessageID IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, append([]any{QueueStatusScheduled}, ids...)...) // #nosec
+
+	return err
+}
+
+// QueueCancelScheduled deletes a scheduled delivery from the queue
+func QueueCancelScheduled(id string) error {
+	res, err := sqlf.DeleteFrom(tenant("queue")).
+		Where("ID = ?", id).
+		Where("Status = ?", QueueStatusScheduled).
+		ExecAndClose(context.TODO(), db)
+	if err != nil {
+		return err
+	}
+
+	return queueAffected(res)
+}
+
+// QueueGetStats returns the number of queued, scheduled & failed deliveries
+func QueueGetStats() QueueStats {
+	stats := QueueStats{}
+
+	var status string
+	var total float64 // use float64 for rqlite compatibility
+
+	if err := sqlf.From(tenant("queue")).
+		Select("Status").To(&status).
+		Select("COUNT(*)").To(&total).
+		GroupBy("Status").
+		QueryAndClose(context.TODO(), db, func(_ *sql.Rows) {
+			switch status {
+			case QueueStatusQueued:
+				stats.Queued = uint64(total)
+			case QueueStatusScheduled:
+				stats.Scheduled = uint64(total)
+			case QueueStatusFailed:
+				stats.Failed = uint64(total)
+			}
+		}); err != nil {
+		logger.Log().Errorf("[db] %s", err.Error())
+	}
+
+	return stats
+}
+
+// getScheduledReleasesForIDs returns the earliest scheduled release of each message database ID
+func getScheduledReleasesForIDs(ids []string) map[string]time.Time {
+	results := map[string]time.Time{}
+
+	if len(ids) == 0 {
+		return results
+	}
+
+	var messageID string
+	var nextAttempt float64 // use float64 for rqlite compatibility
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	if err := sqlf.From(tenant("queue")).
+		Select("MessageID").To(&messageID).
+		Select("MIN(NextAttempt)").To(&nextAttempt).
+		Where("Status = ?", QueueStatusScheduled).
+		Where("MessageID IN (?"+strings.Repeat(",?", len(ids)-1)+")", args...).
+		GroupBy("MessageID").
+		QueryAndClose(context.TODO(), db, func(_ *sql.Rows) {
+			results[messageID] = time.UnixMilli(int64(nextAttempt))
+		}); err != nil {
+		logger.Log().Errorf("[db] %s", err.Error())
+	}
+
+	return results
+}
+
+// queueSelect returns the queued deliveries of a query, excluding the raw message
+func queueSelect(q *sqlf.Stmt) ([]QueueJob, error) {
+	jobs := []QueueJob{}
+
+	var id, typ, profile, messageID, from, recipients, lastError, status string
+	var created, nextAttempt, size, attempts float64 // use float64 for rqlite compatibility
+
+	err := q.Select("ID").To(&id).
+		Select("Created").To(&created).
+		Select("Type").To(&typ).
+		Select("Profile").To(&profile).
+		Select("MessageID").To(&messageID).
+		Select("Sender").To(&from).
+		Select("Recipients").To(&recipients).
+		Select("Size").To(&size).
+		Select("Attempts").To(&attempts).
+		Select("NextAttempt").To(&nextAttempt).
+		Select("LastError").To(&lastError).
+		Select("Status").To(&status).
+		QueryAndClose(context.TODO(), db, func(_ *sql.Rows) {
+			to := []string{}
+			_ = json.Unmarshal([]byte(recipients), &to)
+
+			jobs = append(jobs, QueueJob{
+				ID:          id,
+				Created:     time.UnixMilli(int64(created)),
+				Type:        typ,
+				Profile:     profile,
+				MessageID:   messageID,
+				From:        from,
+				To:          to,
+				Size:        uint64(size),
+				Attempts:    int(attempts),
+				NextAttempt: time.UnixMilli(int64(nextAttempt)),
+				LastError:   lastError,
+				Status:      status,
+			})
+		})
+
+	return jobs, err
+}
+
+// queueAffected returns ErrQueueJobNotFound if no rows were affected
+func queueAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrQueueJobNotFound
+	}
+
+	return nil
+}
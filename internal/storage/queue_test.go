@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/axllent/mailpit/config"
+)
+
+func TestQueue(t *testing.T) {
+	setup("")
+	defer Close()
+
+	t.Log("Testing outbound queue")
+
+	msg := []byte("From: sender@example.com\r\nTo: recipient@example.com\r\nSubject: Queued\r\n\r\nHello\r\n")
+
+	id, err := QueueAdd(QueueJob{Type: "relay", Profile: "staging", From: "sender@example.com", To: []string{"recipient@example.com"}}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, data, err := QueueGet(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(data), string(msg), "queued message mismatch")
+	assertEqual(t, job.Type, "relay", "queued type mismatch")
+	assertEqual(t, job.Profile, "staging", "queued profile mismatch")
+	assertEqual(t, job.To[0], "recipient@example.com", "queued recipient mismatch")
+	assertEqual(t, job.Size, uint64(len(msg)), "queued size mismatch")
+	assertEqual(t, job.Status, QueueStatusQueued, "queued status mismatch")
+
+	due, err := QueueDue(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(due), 1, "expected 1 due delivery")
+
+	// a failed attempt is not due until the next attempt
+	if err := QueueUpdate(id, 1, time.Now().Add(time.Minute), "421 try again later", QueueStatusQueued); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = QueueDue(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(due), 0, "expected no due deliveries")
+
+	if err := QueueUpdate(id, 2, time.Now(), "550 rejected", QueueStatusFailed); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := QueueGetStats()
+	assertEqual(t, stats.Queued, uint64(0), "queued count mismatch")
+	assertEqual(t, stats.Failed, uint64(1), "failed count mismatch")
+
+	jobs, err := QueueList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(jobs), 1, "expected 1 delivery")
+	assertEqual(t, jobs[0].Attempts, 2, "attempts mismatch")
+	assertEqual(t, jobs[0].LastError, "550 rejected", "last error mismatch")
+
+	// retry a failed delivery
+	if err := QueueRetry(id); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = QueueDue(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(due), 1, "expected 1 due delivery")
+
+	if err := QueueCancel(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := QueueDelete(id); err != ErrQueueJobNotFound {
+		t.Fatalf("expected ErrQueueJobNotFound, got %v", err)
+	}
+
+	if err := QueueRetry(id); err != ErrQueueJobNotFound {
+		t.Fatalf("expected ErrQueueJobNotFound, got %v", err)
+	}
+}
+
+func TestQueueCompression(t *testing.T) {
+	for _, compressionLevel := range []int{0, 1, 2, 3} {
+		t.Logf("Testing queue compression level: %d", compressionLevel)
+		config.Compression = compressionLevel
+		setup("")
+
+		id, err := QueueAdd(QueueJob{Type: "relay", From: "sender@example.com", To: []string{"recipient@example.com"}}, testMimeEmail)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, data, err := QueueGet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, string(data), string(testMimeEmail), "queued message mismatch")
+
+		Close()
+	}
+}
+
+func TestQueueScheduled(t *testing.T) {
+	setup("")
+	defer Close()
+
+	t.Log("Testing scheduled releases")
+
+	messageID, err := Store(&testTextEmail, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+	id, err := QueueAdd(QueueJob{Type: "release", MessageID: messageID, From: "sender@example.com", To: []string{"recipient@example.com"}, NextAttempt: sendAt}, testTextEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled, err := QueueScheduled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(scheduled), 1, "expected 1 scheduled release")
+	assertEqual(t, scheduled[0].Status, QueueStatusScheduled, "scheduled status mismatch")
+	assertEqual(t, scheduled[0].NextAttempt.Equal(sendAt), true, "scheduled date mismatch")
+	assertEqual(t, scheduled[0].Created.Equal(sendAt), true, "scheduled created date mismatch")
+
+	stats := QueueGetStats()
+	assertEqual(t, stats.Scheduled, uint64(1), "scheduled count mismatch")
+	assertEqual(t, stats.Queued, uint64(0), "queued count mismatch")
+
+	due, err := QueueDue(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(due), 0, "expected no due deliveries")
+
+	messages, err := List(0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(messages), 1, "expected 1 message")
+	assertEqual(t, messages[0].ScheduledRelease != nil && messages[0].ScheduledRelease.Equal(sendAt), true, "message scheduled release mismatch")
+
+	// scheduled releases can only be cancelled as scheduled releases
+	if err := QueueRetry(id); err != ErrQueueJobNotFound {
+		t.Fatalf("expected ErrQueueJobNotFound, got %v", err)
+	}
+
+	if err := QueueCancel(id); err != ErrQueueJobNotFound {
+		t.Fatalf("expected ErrQueueJobNotFound, got %v", err)
+	}
+
+	if err := QueueCancelScheduled(id); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _, err = Search("", "", 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messages[0].ScheduledRelease == nil, true, "expected no scheduled release")
+
+	// deleting a message cancels its scheduled releases, but not its queued deliveries
+	if _, err := QueueAdd(QueueJob{Type: "release", MessageID: messageID, NextAttempt: sendAt}, testTextEmail); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := QueueAdd(QueueJob{Type: "release", MessageID: messageID}, testTextEmail); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteMessages([]string{messageID}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats = QueueGetStats()
+	assertEqual(t, stats.Scheduled, uint64(0), "scheduled count mismatch")
+	assertEqual(t, stats.Queued, uint64(1), "queued count mismatch")
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/leporo/sqlf"
+)
+
+// RelayLog is a relay, release or forward delivery attempt of a message
+//
+// swagger:model RelayLog
+type RelayLog struct {
+	// Date & time of the delivery attempt
+	Created time.Time
+	// Delivery type: release (manual release), relay (auto-relay) or forward
+	// example: release
+	Type string
+	// SMTP server host & port
+	// example: smtp.example.com:587
+	Host string
+	// Recipient addresses
+	Recipients []string
+	// Final SMTP response code, or 0 if no response was received
+	// example: 250
+	Code int
+	// Final SMTP response text, or the error
+	// example: 2.0.0 Ok: queued as 4F2A1C0D3E
+	Response string
+	// Duration of the delivery attempt in milliseconds
+	Duration int64
+	// Whether the message was delivered
+	Success bool
+}
+
+// AddRelayLog records a delivery attempt of a message
+func AddRelayLog(id string, l RelayLog) error {
+	recipients, err := json.Marshal(l.Recipients)
+	if err != nil {
+		return err
+	}
+
+	success := 0
+	if l.Success {
+		success = 1
+	}
+
+	_, err = sqlf.InsertInto(tenant("relay_log")).
+		Set("ID", id).
+		Set("Created", l.Created.UnixMilli()).
+		Set("Type", l.Type).
+		Set("Host", l.Host).
+		Set("Recipients", string(recipients)).
+		Set("Code", l.Code).
+		Set("Response", l.Response).
+		Set("Duration", l.Duration).
+		Set("Success", success).
+		ExecAndClose(context.TODO(), db)
+
+	return err
+}
+
+// GetRelayLog returns the delivery attempts of a message, oldest first
+func GetRelayLog(id string) ([]RelayLog, error) {
+	log := []RelayLog{}
+
+	var typ, host, recipients, response string
+	var created, code, duration, success float64 // use float64 for rqlite compatibility
+
+	err := sqlf.From(tenant("relay_log")).
+		Select("Created").To(&created).
+		Select("Type").To(&typ).
+		Select("Host").To(&host).
+		Select("Recipients").To(&recipients).
+		Select("Code").To(&code).
+		Select("Response").To(&response).
+		Select("Duration").To(&duration).
+		Select("Success").To(&success).
+		Where("ID = ?", id).
+		OrderBy("Key ASC").
+		QueryAndClose(context.TODO(), db, func(_ *sql.Rows) {
+			to := []string{}
+			_ = json.Unmarshal([]byte(recipients), &to)
+
+			log = append(log, RelayLog{
+				Created:    time.UnixMilli(int64(created)),
+				Type:       typ,
+				Host:       host,
+				Recipients: to,
+				Code:       int(code),
+				Response:   response,
+				Duration:   int64(duration),
+				Success:    success == 1,
+			})
+		})
+
+	return log, err
+}
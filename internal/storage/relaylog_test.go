@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRelayLog(t *testing.T) {
+	setup("")
+	defer Close()
+
+	t.Log("Testing relay log")
+
+	released, err := Store(&testTextEmail, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := Store(&testTextEmail, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayed, err := Store(&testTextEmail, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := []RelayLog{
+		{Created: time.Now(), Type: "release", Host: "smtp.example.com:25", Recipients: []string{"user@example.com"}, Code: 421, Response: "4.3.0 Try again later"},
+		{Created: time.Now(), Type: "release", Host: "smtp.example.com:25", Recipients: []string{"user@example.com"}, Code: 250, Response: "2.0.0 Ok", Duration: 12, Success: true},
+	}
+
+	for _, l := range attempts {
+		if err := AddRelayLog(released, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := AddRelayLog(failed, RelayLog{Created: time.Now(), Type: "relay", Host: "smtp.example.com:25", Recipients: []string{"user@example.com"}, Code: 550, Response: "5.1.1 User unknown"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// auto-relayed messages are not released
+	if err := AddRelayLog(relayed, RelayLog{Created: time.Now(), Type: "relay", Host: "smtp.example.com:25", Recipients: []string{"user@example.com"}, Code: 250, Response: "2.0.0 Ok", Success: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := GetRelayLog(released)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(log), 2, "expected 2 relay log entries")
+	assertEqual(t, log[0].Code, 421, "first relay log code mismatch")
+	assertEqual(t, log[1].Success, true, "second relay log success mismatch")
+	assertEqual(t, log[1].Response, "2.0.0 Ok", "second relay log response mismatch")
+	assertEqual(t, log[1].Duration, int64(12), "second relay log duration mismatch")
+	assertEqual(t, log[1].Recipients[0], "user@example.com", "second relay log recipient mismatch")
+
+	searches := map[string]int{
+		"is:released":  1,
+		"-is:released": 2,
+	}
+
+	for search, expected := range searches {
+		_, total, err := Search(search, "", 0, 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEqual(t, total, expected, fmt.Sprintf("%d %s search results expected", expected, search))
+	}
+
+	// relay logs are deleted with the message
+	if err := DeleteMessages([]string{released}); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err = GetRelayLog(released)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(log), 0, "expected relay log to be deleted")
+}
@@ -0,0 +1,15 @@
+-- CREATE QUEUE TABLE for outbound relay & forward deliveries
+CREATE TABLE IF NOT EXISTS {{ tenant "queue" }} (
+	ID TEXT NOT NULL PRIMARY KEY,
+	Created INTEGER NOT NULL,
+	Type TEXT NOT NULL,
+	Sender TEXT NOT NULL,
+	Recipients TEXT NOT NULL,
+	Data TEXT NOT NULL,
+	Size INTEGER NOT NULL,
+	Attempts INTEGER NOT NULL DEFAULT '0',
+	NextAttempt INTEGER NOT NULL,
+	LastError TEXT NOT NULL DEFAULT '',
+	Status TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS {{ tenant "idx_queue_next_attempt" }} ON {{ tenant "queue" }} (Status, NextAttempt);
@@ -0,0 +1,17 @@
+-- CREATE RELAY LOG TABLE for relay, release & forward delivery attempts of messages
+CREATE TABLE IF NOT EXISTS {{ tenant "relay_log" }} (
+	Key INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	ID TEXT NOT NULL,
+	Created INTEGER NOT NULL,
+	Type TEXT NOT NULL,
+	Host TEXT NOT NULL,
+	Recipients TEXT NOT NULL,
+	Code INTEGER NOT NULL,
+	Response TEXT NOT NULL,
+	Duration INTEGER NOT NULL,
+	Success INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS {{ tenant "idx_relay_log_id" }} ON {{ tenant "relay_log" }} (ID);
+
+-- CREATE MessageID COLUMN IN queue
+ALTER TABLE {{ tenant "queue" }} ADD COLUMN MessageID TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- CREATE Profile COLUMN IN queue for named relay profiles
+ALTER TABLE {{ tenant "queue" }} ADD COLUMN Profile TEXT NOT NULL DEFAULT '';
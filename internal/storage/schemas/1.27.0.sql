@@ -0,0 +1,2 @@
+-- CREATE Compressed COLUMN IN queue for compressed queued messages
+ALTER TABLE {{ tenant "queue" }} ADD COLUMN Compressed INTEGER NOT NULL DEFAULT '0';
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -99,6 +100,13 @@ func Search(search, timezone string, start int, beforeTS int64, limit int) ([]Me
 				results[i].Tags = []string{}
 			}
 		}
+
+		scheduled := getScheduledReleasesForIDs(ids)
+		for i, m := range results {
+			if ts, ok := scheduled[m.ID]; ok {
+				results[i].ScheduledRelease = &ts
+			}
+		}
 	}
 
 	elapsed := time.Since(tsStart)
@@ -141,6 +149,21 @@ func SearchUnreadCount(search, timezone string, beforeTS int64) (int64, error) {
 	return int64(unread), err
 }
 
+// MessageMatchesSearch returns whether a message matches a search
+func MessageMatchesSearch(id, search string) (bool, error) {
+	q := searchQueryBuilder(search, "").Where("m.ID = ?", id)
+
+	match := false
+
+	err := q.QueryAndClose(context.TODO(), db, func(_ *sql.Rows) {
+		match = true
+	})
+
+	dbLastAction = time.Now()
+
+	return match, err
+}
+
 // DeleteSearch will delete all messages for search terms.
 // The search is broken up by segments (exact phrases can be quoted), and interprets specific terms such as:
 // is:read, is:unread, has:attachment, to:<term>, from:<term> & subject:<term>
@@ -229,6 +252,17 @@ func DeleteSearch(search, timezone string) error {
 			if err != nil {
 				return err
 			}
+
+			sqlDelete4 := `DELETE FROM ` + tenant("relay_log") + ` WHERE ID IN (?` + strings.Repeat(",?", len(ids)-1) + `)` // #nosec
+
+			_, err = tx.Exec(sqlDelete4, delIDs...)
+			if err != nil {
+				return err
+			}
+
+			if err := deleteScheduledReleases(tx, delIDs); err != nil {
+				return err
+			}
 		}
 
 		if err := tx.Commit(); err != nil {
@@ -355,57 +389,38 @@ func searchQueryBuilder(searchString, timezone string) *sqlf.Stmt {
 		if strings.HasPrefix(lw, "to:") {
 			w = cleanString(w[3:])
 			if w != "" {
-				if exclude {
-					q.Where("ToJSON NOT LIKE ?", "%"+escPercentChar(w)+"%")
-				} else {
-					q.Where("ToJSON LIKE ?", "%"+escPercentChar(w)+"%")
-				}
+				where, whereArgs := addressSearchQuery([]string{"ToJSON"}, w, exclude)
+				q.Where(where, whereArgs...)
 			}
 		} else if strings.HasPrefix(lw, "from:") {
 			w = cleanString(w[5:])
 			if w != "" {
-				if exclude {
-					q.Where("FromJSON NOT LIKE ?", "%"+escPercentChar(w)+"%")
-				} else {
-					q.Where("FromJSON LIKE ?", "%"+escPercentChar(w)+"%")
-				}
+				where, whereArgs := addressSearchQuery([]string{"FromJSON"}, w, exclude)
+				q.Where(where, whereArgs...)
 			}
 		} else if strings.HasPrefix(lw, "cc:") {
 			w = cleanString(w[3:])
 			if w != "" {
-				if exclude {
-					q.Where("CcJSON NOT LIKE ?", "%"+escPercentChar(w)+"%")
-				} else {
-					q.Where("CcJSON LIKE ?", "%"+escPercentChar(w)+"%")
-				}
+				where, whereArgs := addressSearchQuery([]string{"CcJSON"}, w, exclude)
+				q.Where(where, whereArgs...)
 			}
 		} else if strings.HasPrefix(lw, "bcc:") {
 			w = cleanString(w[4:])
 			if w != "" {
-				if exclude {
-					q.Where("BccJSON NOT LIKE ?", "%"+escPercentChar(w)+"%")
-				} else {
-					q.Where("BccJSON LIKE ?", "%"+escPercentChar(w)+"%")
-				}
+				where, whereArgs := addressSearchQuery([]string{"BccJSON"}, w, exclude)
+				q.Where(where, whereArgs...)
 			}
 		} else if strings.HasPrefix(lw, "reply-to:") {
 			w = cleanString(w[9:])
 			if w != "" {
-				if exclude {
-					q.Where("ReplyToJSON NOT LIKE ?", "%"+escPercentChar(w)+"%")
-				} else {
-					q.Where("ReplyToJSON LIKE ?", "%"+escPercentChar(w)+"%")
-				}
+				where, whereArgs := addressSearchQuery([]string{"ReplyToJSON"}, w, exclude)
+				q.Where(where, whereArgs...)
 			}
 		} else if strings.HasPrefix(lw, "addressed:") {
 			w = cleanString(w[10:])
-			arg := "%" + escPercentChar(w) + "%"
 			if w != "" {
-				if exclude {
-					q.Where("(ToJSON NOT LIKE ? AND FromJSON NOT LIKE ? AND CcJSON NOT LIKE ? AND BccJSON NOT LIKE ? AND ReplyToJSON NOT LIKE ?)", arg, arg, arg, arg, arg)
-				} else {
-					q.Where("(ToJSON LIKE ? OR FromJSON LIKE ? OR CcJSON LIKE ? OR BccJSON LIKE ? OR ReplyToJSON LIKE ?)", arg, arg, arg, arg, arg)
-				}
+				where, whereArgs := addressSearchQuery([]string{"ToJSON", "FromJSON", "CcJSON", "BccJSON", "ReplyToJSON"}, w, exclude)
+				q.Where(where, whereArgs...)
 			}
 		} else if strings.HasPrefix(lw, "subject:") {
 			w = w[8:]
@@ -452,6 +467,13 @@ func searchQueryBuilder(searchString, timezone string) *sqlf.Stmt {
 			} else {
 				q.Where(`m.ID IN (SELECT DISTINCT mt.ID FROM ` + tenant("message_tags") + ` mt JOIN ` + tenant("tags") + ` t ON mt.TagID = t.ID)`)
 			}
+		} else if lw == "is:released" {
+			// only manual releases, not messages auto-relayed or forwarded on receipt
+			if exclude {
+				q.Where(`m.ID NOT IN (SELECT DISTINCT ID FROM ` + tenant("relay_log") + ` WHERE Type = 'release' AND Success = 1)`)
+			} else {
+				q.Where(`m.ID IN (SELECT DISTINCT ID FROM ` + tenant("relay_log") + ` WHERE Type = 'release' AND Success = 1)`)
+			}
 		} else if lw == "has:inline" || lw == "has:inlines" {
 			if exclude {
 				q.Where("Inline = 0")
@@ -464,6 +486,17 @@ func searchQueryBuilder(searchString, timezone string) *sqlf.Stmt {
 			} else {
 				q.Where("Attachments > 0")
 			}
+		} else if prefix, result, found := strings.Cut(lw, ":"); found && slices.Contains([]string{"spf", "dkim", "dmarc", "arc"}, prefix) {
+			// authentication results are only set when evaluated on receipt
+			result = cleanString(result)
+			if result != "" {
+				field := "'$." + strings.ToUpper(prefix) + "'"
+				if exclude {
+					q.Where("IFNULL(json_extract(m.Metadata, "+field+"), '') != ?", result)
+				} else {
+					q.Where("json_extract(m.Metadata, "+field+") = ?", result)
+				}
+			}
 		} else if strings.HasPrefix(lw, "after:") {
 			w = strings.ToUpper(cleanString(w[6:]))
 			if w != "" {
@@ -558,3 +591,52 @@ func sizeToBytes(v string) uint64 {
 
 	return 0
 }
+
+// addressSearchQuery returns the SQL condition & arguments to match (or exclude) an address search term
+// in any of the fields. Internationalised addresses & domains match both their Unicode & ASCII (punycode) forms.
+func addressSearchQuery(fields []string, w string, exclude bool) (string, []any) {
+	conditions := []string{}
+	args := []any{}
+
+	for _, term := range addressSearchTerms(w) {
+		for _, f := range fields {
+			if exclude {
+				conditions = append(conditions, f+" NOT LIKE ?")
+			} else {
+				conditions = append(conditions, f+" LIKE ?")
+			}
+			args = append(args, "%"+escPercentChar(term)+"%")
+		}
+	}
+
+	if exclude {
+		return "(" + strings.Join(conditions, " AND ") + ")", args
+	}
+
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// addressSearchTerms returns the search term, as well as its alternate Unicode or ASCII (punycode) form
+// if the term is an internationalised address or domain
+func addressSearchTerms(w string) []string {
+	terms := []string{w}
+	if !tools.IsInternationalAddress(w) {
+		return terms
+	}
+
+	var forms []string
+	if strings.Contains(w, "@") {
+		forms = tools.AddressForms(w)
+	} else {
+		u, a := tools.NormalizeDomain(w)
+		forms = []string{u, a}
+	}
+
+	for _, f := range forms {
+		if f != "" && !slices.Contains(terms, f) {
+			terms = append(terms, f)
+		}
+	}
+
+	return terms
+}
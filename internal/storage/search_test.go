@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand/v2"
+	"os"
 	"testing"
 
 	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/authres"
+	"github.com/axllent/mailpit/internal/resolver"
 	"github.com/jhillyerd/enmime/v2"
 )
 
@@ -48,7 +51,7 @@ func TestSearch(t *testing.T) {
 
 			bufBytes := buf.Bytes()
 
-			if _, err := Store(&bufBytes, nil); err != nil {
+			if _, err := Store(&bufBytes, nil, false, nil); err != nil {
 				t.Log("error ", err)
 				t.Fail()
 			}
@@ -117,11 +120,11 @@ func TestSearchDelete100(t *testing.T) {
 		}
 
 		for range 100 {
-			if _, err := Store(&testTextEmail, nil); err != nil {
+			if _, err := Store(&testTextEmail, nil, false, nil); err != nil {
 				t.Log("error ", err)
 				t.Fail()
 			}
-			if _, err := Store(&testMimeEmail, nil); err != nil {
+			if _, err := Store(&testMimeEmail, nil, false, nil); err != nil {
 				t.Log("error ", err)
 				t.Fail()
 			}
@@ -158,7 +161,7 @@ func TestSearchDelete1100(t *testing.T) {
 
 	t.Log("Testing search delete of 1100 messages")
 	for range 1100 {
-		if _, err := Store(&testTextEmail, nil); err != nil {
+		if _, err := Store(&testTextEmail, nil, false, nil); err != nil {
 			t.Log("error ", err)
 			t.Fail()
 		}
@@ -186,6 +189,179 @@ func TestSearchDelete1100(t *testing.T) {
 	assertEqual(t, total, 0, "0 search results expected")
 }
 
+func TestSearchAuthentication(t *testing.T) {
+	setup("")
+	defer Close()
+
+	if err := resolver.SetLocal("../authres/testdata/example.zone", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resolver.SetLocal("", "") }()
+
+	t.Log("Testing authentication results search")
+
+	signed, err := os.ReadFile("../dkim/testdata/signed.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := &authres.Envelope{ClientIP: "192.0.2.5", HELO: "mail.football.example.com", MailFrom: "joe@football.example.com"}
+	tampered := bytes.Replace(signed, []byte("We lost the game."), []byte("We won the game."), 1)
+	unsigned := []byte("From: sender@example.com\r\nSubject: Unsigned\r\n\r\nHello\r\n")
+
+	// SPF is only evaluated for messages received via SMTP
+	if _, err := Store(&signed, nil, false, envelope); err != nil {
+		t.Log("error ", err)
+		t.Fail()
+	}
+
+	for _, msg := range [][]byte{tampered, unsigned} {
+		if _, err := Store(&msg, nil, false, nil); err != nil {
+			t.Log("error ", err)
+			t.Fail()
+		}
+	}
+
+	searches := map[string]int{
+		"dkim:pass":  1,
+		"dkim:fail":  1,
+		"dkim:none":  1,
+		"-dkim:pass": 2,
+		"spf:pass":   1,
+		"spf:none":   2,
+		"dmarc:pass": 1,
+		"dmarc:fail": 2,
+		"arc:none":   3,
+	}
+
+	for search, expected := range searches {
+		_, total, err := Search(search, "", 0, 0, 100)
+		if err != nil {
+			t.Log("error ", err)
+			t.Fail()
+		}
+
+		assertEqual(t, total, expected, fmt.Sprintf("%d %s search results expected", expected, search))
+	}
+
+	// without local DNS records only unsigned messages have a DKIM status
+	_ = resolver.SetLocal("", "")
+
+	for _, msg := range [][]byte{signed, unsigned} {
+		if _, err := Store(&msg, nil, false, nil); err != nil {
+			t.Log("error ", err)
+			t.Fail()
+		}
+	}
+
+	for search, expected := range map[string]int{"dkim:none": 2, "dkim:pass": 1, "spf:none": 2} {
+		_, total, err := Search(search, "", 0, 0, 100)
+		if err != nil {
+			t.Log("error ", err)
+			t.Fail()
+		}
+
+		assertEqual(t, total, expected, fmt.Sprintf("%d %s search results expected", expected, search))
+	}
+}
+
+func TestMessageMatchesSearch(t *testing.T) {
+	setup("")
+	defer Close()
+
+	t.Log("Testing message search matching")
+
+	id, err := Store(&testTextEmail, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetMessageTags(id, []string{"billing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	searches := map[string]bool{
+		"tag:billing":         true,
+		"-tag:billing":        false,
+		"tag:finance":         false,
+		"tag:billing is:read": false,
+		"":                    true,
+	}
+
+	for search, expected := range searches {
+		match, err := MessageMatchesSearch(id, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEqual(t, match, expected, fmt.Sprintf("\"%s\" match", search))
+	}
+
+	match, err := MessageMatchesSearch("does-not-exist", "tag:billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, match, false, "unknown message match")
+}
+
+func TestSearchInternationalisedAddresses(t *testing.T) {
+	setup("")
+	defer Close()
+
+	t.Log("Testing internationalised address search")
+
+	messages := [][]byte{
+		[]byte("From: sender@xn--bcher-kva.example\r\nTo: jo\u0308ran+eai@example.com\r\nSubject: Punycode sender\r\n\r\nHello\r\n"),
+		[]byte("From: Sender@Example.COM\r\nTo: user@Bücher.example\r\nSubject: Unicode recipient\r\n\r\nHello\r\n"),
+	}
+
+	ids := []string{}
+	for _, msg := range messages {
+		id, err := Store(&msg, nil, false, nil)
+		if err != nil {
+			t.Log("error ", err)
+			t.Fail()
+		}
+		ids = append(ids, id)
+	}
+
+	// internationalised addresses are stored in their normalised Unicode form, other addresses as received
+	for i, expected := range [][2]string{{"sender@bücher.example", "jöran+eai@example.com"}, {"Sender@Example.COM", "user@bücher.example"}} {
+		msg, err := GetMessage(ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEqual(t, msg.From.Address, expected[0], "stored From address does not match")
+		assertEqual(t, msg.To[0].Address, expected[1], "stored To address does not match")
+	}
+
+	searches := map[string]int{
+		"from:sender@bücher.example":        1,
+		"from:sender@xn--bcher-kva.example": 1,
+		"to:user@xn--bcher-kva.example":     1,
+		"to:bücher.example":                 1,
+		"to:xn--bcher":                      1,
+		"from:@xn--bcher":                   1,
+		"to:jöran":                          1,
+		"addressed:bücher.example":          2,
+		"addressed:xn--bcher-kva.example":   2,
+		"-addressed:xn--bcher-kva.example":  0,
+		"xn--bcher-kva.example":             2,
+		"tag:eai":                           1,
+	}
+
+	for search, expected := range searches {
+		_, total, err := Search(search, "", 0, 0, 100)
+		if err != nil {
+			t.Log("error ", err)
+			t.Fail()
+		}
+
+		assertEqual(t, total, expected, fmt.Sprintf("%d %s search results expected", expected, search))
+	}
+}
+
 func TestEscPercentChar(t *testing.T) {
 	tests := map[string]string{}
 	tests["this is a test"] = "this is a test"
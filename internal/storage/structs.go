@@ -3,6 +3,15 @@ package storage
 import (
 	"net/mail"
 	"time"
+
+	"github.com/axllent/mailpit/internal/authres"
+)
+
+const (
+	// UnwrappedSMIME is the Unwrapped value of S/MIME encrypted or opaque signed messages
+	UnwrappedSMIME = "smime"
+	// UnwrappedPGP is the Unwrapped value of OpenPGP/MIME encrypted messages
+	UnwrappedPGP = "pgp"
 )
 
 // Message data excluding physical attachments
@@ -36,12 +45,18 @@ type Message struct {
 	Tags []string
 	// Username used for authentication (if provided) with the SMTP or Send API
 	Username string
+	// Whether the message was received with the SMTP REQUIRETLS option (RFC 8689)
+	RequireTLS bool
 	// Message body text
 	Text string
 	// Message body HTML
 	HTML string
-	// Message size in bytes
+	// Message size in bytes, as received
 	Size uint64
+	// The format the message content was decrypted or extracted from (`smime` or `pgp`) to be displayed, else empty
+	// if the message content is as received. The raw message & size are always as received.
+	// example: smime
+	Unwrapped string
 	// Inline message attachments
 	Inline []Attachment
 	// Message attachments
@@ -107,6 +122,8 @@ type MessageSummary struct {
 	Attachments int
 	// Message snippet includes up to 250 characters
 	Snippet string
+	// Date & time of the next scheduled release, if any
+	ScheduledRelease *time.Time `json:",omitempty"`
 }
 
 // MailboxStats struct for quick mailbox total/read lookups
@@ -118,12 +135,18 @@ type MailboxStats struct {
 
 // Metadata struct for storing message metadata
 type Metadata struct {
-	From     *mail.Address   `json:"From,omitempty"`
-	To       []*mail.Address `json:"To,omitempty"`
-	Cc       []*mail.Address `json:"Cc,omitempty"`
-	Bcc      []*mail.Address `json:"Bcc,omitempty"`
-	ReplyTo  []*mail.Address `json:"ReplyTo,omitempty"`
-	Username string          `json:"Username,omitempty"`
+	From       *mail.Address     `json:"From,omitempty"`
+	To         []*mail.Address   `json:"To,omitempty"`
+	Cc         []*mail.Address   `json:"Cc,omitempty"`
+	Bcc        []*mail.Address   `json:"Bcc,omitempty"`
+	ReplyTo    []*mail.Address   `json:"ReplyTo,omitempty"`
+	Username   string            `json:"Username,omitempty"`
+	RequireTLS bool              `json:"RequireTLS,omitempty"`
+	SPF        string            `json:"SPF,omitempty"`
+	DKIM       string            `json:"DKIM,omitempty"`
+	DMARC      string            `json:"DMARC,omitempty"`
+	ARC        string            `json:"ARC,omitempty"`
+	SMTP       *authres.Envelope `json:"SMTP,omitempty"`
 }
 
 // ListUnsubscribe contains a summary of List-Unsubscribe & List-Unsubscribe-Post headers
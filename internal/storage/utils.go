@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"encoding/json"
 	"net/mail"
 	"os"
 	"regexp"
@@ -41,9 +42,73 @@ func addressToSlice(env *enmime.Envelope, key string) []*mail.Address {
 		return []*mail.Address{}
 	}
 
+	// internationalised addresses are returned in their normalised Unicode form, other addresses as received
+	for _, a := range data {
+		if tools.IsInternationalAddress(a.Address) {
+			a.Address, _ = tools.NormalizeAddress(a.Address)
+		}
+	}
+
 	return data
 }
 
+// storedAddress is a metadata address, including the ASCII (punycode) form of an internationalised address
+type storedAddress struct {
+	Name    string
+	Address string
+	ASCII   string `json:"ASCII,omitempty"`
+}
+
+// toStoredAddresses returns the metadata addresses of an address list, or nil if empty
+func toStoredAddresses(list []*mail.Address) []*storedAddress {
+	if len(list) == 0 {
+		return nil
+	}
+
+	addresses := []*storedAddress{}
+	for _, a := range list {
+		addresses = append(addresses, toStoredAddress(a))
+	}
+
+	return addresses
+}
+
+// toStoredAddress returns the metadata address of an address, or nil if not set
+func toStoredAddress(a *mail.Address) *storedAddress {
+	if a == nil {
+		return nil
+	}
+
+	s := &storedAddress{Name: a.Name, Address: a.Address}
+	if forms := tools.AddressForms(a.Address); len(forms) > 1 {
+		s.ASCII = forms[1]
+	}
+
+	return s
+}
+
+// MarshalJSON stores both the Unicode & ASCII (punycode) forms of internationalised addresses,
+// allowing address searches to match either form
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	type metadata Metadata
+
+	return json.Marshal(struct {
+		metadata
+		From    *storedAddress   `json:"From,omitempty"`
+		To      []*storedAddress `json:"To,omitempty"`
+		Cc      []*storedAddress `json:"Cc,omitempty"`
+		Bcc     []*storedAddress `json:"Bcc,omitempty"`
+		ReplyTo []*storedAddress `json:"ReplyTo,omitempty"`
+	}{
+		metadata: metadata(m),
+		From:     toStoredAddress(m.From),
+		To:       toStoredAddresses(m.To),
+		Cc:       toStoredAddresses(m.Cc),
+		Bcc:      toStoredAddresses(m.Bcc),
+		ReplyTo:  toStoredAddresses(m.ReplyTo),
+	})
+}
+
 // Generate the search text based on some header fields (to, from, subject etc)
 // and either the stripped HTML body (if exists) or text body
 func createSearchText(env *enmime.Envelope) string {
@@ -57,6 +122,15 @@ func createSearchText(env *enmime.Envelope) string {
 	_, _ = b.WriteString(env.GetHeader("Reply-To") + " ")
 	_, _ = b.WriteString(env.GetHeader("Return-Path") + " ")
 
+	// add both the Unicode & ASCII (punycode) forms of internationalised addresses
+	for _, key := range []string{"From", "To", "Cc", "Bcc", "Reply-To"} {
+		for _, a := range addressToSlice(env, key) {
+			if forms := tools.AddressForms(a.Address); len(forms) > 1 || !tools.IsASCIIAddress(a.Address) {
+				_, _ = b.WriteString(strings.Join(forms, " ") + " ")
+			}
+		}
+	}
+
 	h, _ := html2text.Strip(env.HTML, true)
 	if h != "" {
 		_, _ = b.WriteString(h + " ")
@@ -0,0 +1,243 @@
+// Package tlsrpt parses SMTP TLS reports (RFC 8460) delivered by email
+package tlsrpt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/internal/tools"
+)
+
+const (
+	// maxReportSize is the maximum size of a decompressed report
+	maxReportSize = 10 * 1024 * 1024
+)
+
+// ErrNotTLSRPT is returned when a message does not contain a TLS report
+var ErrNotTLSRPT = errors.New("message does not contain a TLS report")
+
+// Report is a parsed SMTP TLS report
+//
+// swagger:model TLSReportResponse
+type Report struct {
+	// Policy domain the report applies to, from the TLS-Report-Domain header
+	// example: example.com
+	Domain string
+	// Reporting domain, from the TLS-Report-Submitter header
+	// example: mail.sender.example
+	Submitter string
+	// Organization name of the reporter
+	// example: Company-X
+	OrganizationName string
+	// Report start date & time
+	StartDate time.Time
+	// Report end date & time
+	EndDate time.Time
+	// Contact information of the reporter
+	// example: sts-reporting@company-x.example
+	ContactInfo string
+	// Unique report ID
+	// example: 5065427c-23d3-47ca-b6e0-946ea0e8c4be
+	ReportID string
+	// Total number of successful sessions of all policies
+	TotalSuccessful int64
+	// Total number of failed sessions of all policies
+	TotalFailed int64
+	// Policies evaluated by the reporter
+	Policies []Policy
+}
+
+// Policy is the result of a single evaluated policy
+//
+// swagger:model TLSReportPolicy
+type Policy struct {
+	// Policy type: tlsa, sts or no-policy-found
+	// example: sts
+	Type string
+	// Policy string (eg: the MTA-STS policy lines)
+	Strings []string
+	// Policy domain
+	// example: example.com
+	Domain string
+	// MX host patterns of the policy
+	MXHosts []string
+	// Number of successful sessions
+	Successful int64
+	// Number of failed sessions
+	Failed int64
+	// Failure details
+	Failures []Failure
+}
+
+// Failure is a failure result of a policy
+//
+// swagger:model TLSReportFailure
+type Failure struct {
+	// Result type, eg: certificate-expired, sts-policy-invalid, starttls-not-supported
+	// example: certificate-expired
+	ResultType string
+	// Sending MTA IP address
+	// example: 2001:db8:abcd:0012::1
+	SendingMTAIP string
+	// Receiving MX host name
+	// example: mx1.example.com
+	ReceivingMXHostname string
+	// Receiving MX HELO
+	ReceivingMXHelo string
+	// Receiving IP address
+	ReceivingIP string
+	// Number of failed sessions
+	FailedSessions int64
+	// Additional information URI
+	AdditionalInformation string
+	// Failure reason code
+	FailureReasonCode string
+}
+
+// report is the RFC 8460 JSON report format
+type report struct {
+	OrganizationName string `json:"organization-name"`
+	DateRange        struct {
+		Start time.Time `json:"start-datetime"`
+		End   time.Time `json:"end-datetime"`
+	} `json:"date-range"`
+	ContactInfo string `json:"contact-info"`
+	ReportID    string `json:"report-id"`
+	Policies    []struct {
+		Policy struct {
+			Type    string   `json:"policy-type"`
+			Strings []string `json:"policy-string"`
+			Domain  string   `json:"policy-domain"`
+			MXHosts []string `json:"mx-host"`
+		} `json:"policy"`
+		Summary struct {
+			Successful int64 `json:"total-successful-session-count"`
+			Failed     int64 `json:"total-failure-session-count"`
+		} `json:"summary"`
+		FailureDetails []struct {
+			ResultType            string `json:"result-type"`
+			SendingMTAIP          string `json:"sending-mta-ip"`
+			ReceivingMXHostname   string `json:"receiving-mx-hostname"`
+			ReceivingMXHelo       string `json:"receiving-mx-helo"`
+			ReceivingIP           string `json:"receiving-ip"`
+			FailedSessions        int64  `json:"failed-session-count"`
+			AdditionalInformation string `json:"additional-information"`
+			FailureReasonCode     string `json:"failure-reason-code"`
+		} `json:"failure-details"`
+	} `json:"policies"`
+}
+
+// Parse returns the TLS report of a raw message. The report may be a multipart/report (report-type=tlsrpt)
+// part, or the message body, using the application/tlsrpt+gzip or application/tlsrpt+json media types.
+func Parse(msg []byte) (Report, error) {
+	e, err := tools.ParseMIMEEntity(msg)
+	if err != nil {
+		return Report{}, err
+	}
+
+	data, err := findReport(e)
+	if err != nil {
+		return Report{}, err
+	}
+
+	r := report{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Report{}, fmt.Errorf("invalid TLS report: %s", err.Error())
+	}
+
+	result := Report{
+		Domain:           strings.TrimSpace(e.Header.Get("TLS-Report-Domain")),
+		Submitter:        strings.TrimSpace(e.Header.Get("TLS-Report-Submitter")),
+		OrganizationName: r.OrganizationName,
+		StartDate:        r.DateRange.Start,
+		EndDate:          r.DateRange.End,
+		ContactInfo:      r.ContactInfo,
+		ReportID:         r.ReportID,
+		Policies:         []Policy{},
+	}
+
+	for _, p := range r.Policies {
+		policy := Policy{
+			Type:       p.Policy.Type,
+			Strings:    p.Policy.Strings,
+			Domain:     p.Policy.Domain,
+			MXHosts:    p.Policy.MXHosts,
+			Successful: p.Summary.Successful,
+			Failed:     p.Summary.Failed,
+			Failures:   []Failure{},
+		}
+		if policy.Strings == nil {
+			policy.Strings = []string{}
+		}
+		if policy.MXHosts == nil {
+			policy.MXHosts = []string{}
+		}
+
+		for _, f := range p.FailureDetails {
+			policy.Failures = append(policy.Failures, Failure(f))
+		}
+
+		result.TotalSuccessful += policy.Successful
+		result.TotalFailed += policy.Failed
+		result.Policies = append(result.Policies, policy)
+	}
+
+	return result, nil
+}
+
+// findReport returns the decoded (and decompressed) JSON report of the entity
+func findReport(e tools.MIMEEntity) ([]byte, error) {
+	mediaType, params, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/tlsrpt+gzip", "application/tlsrpt+json":
+		body, err := e.DecodedBody()
+		if err != nil {
+			return nil, err
+		}
+
+		if mediaType == "application/tlsrpt+json" {
+			return body, nil
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS report: %s", err.Error())
+		}
+
+		data, err := io.ReadAll(io.LimitReader(zr, maxReportSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS report: %s", err.Error())
+		}
+		if len(data) > maxReportSize {
+			return nil, errors.New("TLS report exceeds the maximum size")
+		}
+
+		return data, nil
+	case "multipart/report", "multipart/mixed":
+		if mediaType == "multipart/report" && !strings.EqualFold(params["report-type"], "tlsrpt") {
+			return nil, ErrNotTLSRPT
+		}
+
+		for _, part := range tools.SplitMultipart(e.Body, params["boundary"]) {
+			p, err := tools.ParseMIMEEntity(part)
+			if err != nil {
+				continue
+			}
+			if data, err := findReport(p); err == nil {
+				return data, nil
+			} else if err != ErrNotTLSRPT {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, ErrNotTLSRPT
+}
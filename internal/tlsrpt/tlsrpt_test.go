@@ -0,0 +1,49 @@
+package tlsrpt
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	msg, err := os.ReadFile("testdata/report.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Parse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Domain != "company-y.example" || r.Submitter != "mail.sender.example" || r.OrganizationName != "Company-X" ||
+		r.ReportID != "5065427c-23d3-47ca-b6e0-946ea0e8c4be" || r.StartDate.Format("2006-01-02") != "2016-04-01" {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	if r.TotalSuccessful != 5326 || r.TotalFailed != 303 || len(r.Policies) != 1 {
+		t.Fatalf("unexpected report totals %+v", r)
+	}
+
+	p := r.Policies[0]
+	if p.Type != "sts" || p.Domain != "company-y.example" || len(p.Strings) != 4 || len(p.Failures) != 3 {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+
+	f := p.Failures[2]
+	if f.ResultType != "validation-failure" || f.FailedSessions != 3 || f.FailureReasonCode != "X509_V_ERR_PROXY_PATH_LENGTH_EXCEEDED" {
+		t.Errorf("unexpected failure %+v", f)
+	}
+
+	// uncompressed report as the message body
+	body := []byte("Content-Type: application/tlsrpt+json\r\n\r\n" +
+		`{"organization-name":"Company-X","report-id":"1","policies":[{"policy":{"policy-type":"no-policy-found","policy-domain":"example.com"},` +
+		`"summary":{"total-successful-session-count":1,"total-failure-session-count":0}}]}`)
+	if r, err := Parse(body); err != nil || r.ReportID != "1" || r.Policies[0].Type != "no-policy-found" || len(r.Policies[0].Failures) != 0 {
+		t.Errorf("unexpected report %+v %v", r, err)
+	}
+
+	if _, err := Parse([]byte("From: sender@example.com\r\nSubject: test\r\n\r\nHello\r\n")); err != ErrNotTLSRPT {
+		t.Errorf("expected ErrNotTLSRPT, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// idnaProfile converts internationalised domain names, lowercasing & mapping as per UTS #46.
+// Unlike idna.Lookup it does not enforce strict domain names, allowing eg: underscores.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false))
+
+// NormalizeAddress returns the Unicode & ASCII (punycode) forms of an email address (RFC 6531).
+// The local part is normalised to Unicode NFC, and the domain is lowercased and converted with IDNA.
+// The ASCII form is empty if the local part contains non-ASCII characters, as it cannot be downgraded.
+// Domains which are not valid IDNs are lowercased only.
+func NormalizeAddress(address string) (string, string) {
+	i := strings.LastIndex(address, "@")
+	if i < 1 {
+		return address, asciiOrEmpty(address)
+	}
+
+	local := norm.NFC.String(address[:i])
+	uDomain, aDomain := NormalizeDomain(address[i+1:])
+
+	unicode := local + "@" + uDomain
+	if !isASCII(local) {
+		return unicode, ""
+	}
+
+	return unicode, local + "@" + aDomain
+}
+
+// NormalizeDomain returns the lowercased Unicode & ASCII (punycode) forms of a domain name.
+// The domain is returned lowercased in both forms if it cannot be converted.
+func NormalizeDomain(domain string) (string, string) {
+	u, err := idnaProfile.ToUnicode(domain)
+	if err != nil {
+		d := strings.ToLower(domain)
+		return d, d
+	}
+
+	a, err := idnaProfile.ToASCII(u)
+	if err != nil {
+		return u, strings.ToLower(domain)
+	}
+
+	return u, a
+}
+
+// AddressForms returns the unique Unicode & ASCII forms of an email address, for matching either form
+func AddressForms(address string) []string {
+	u, a := NormalizeAddress(address)
+	if a == "" || a == u {
+		return []string{u}
+	}
+
+	return []string{u, a}
+}
+
+// IsASCIIAddress returns whether an email address consists of ASCII characters only,
+// ie: it can be sent without the SMTPUTF8 extension
+func IsASCIIAddress(address string) bool {
+	return isASCII(address)
+}
+
+// IsInternationalAddress returns whether an email address (or domain) is internationalised, ie: it contains
+// non-ASCII characters or an ASCII (punycode) encoded domain label
+func IsInternationalAddress(address string) bool {
+	return !isASCII(address) || strings.Contains(strings.ToLower(address), "xn--")
+}
+
+// asciiOrEmpty returns the string if it only contains ASCII characters, else an empty string
+func asciiOrEmpty(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	return ""
+}
+
+// isASCII returns whether the string only contains ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 127 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// MIMEEntity is a raw MIME entity (message or body part) with CRLF line endings
+type MIMEEntity struct {
+	// Header is the parsed header
+	Header mail.Header
+	// RawHeader is the raw header fields, including the trailing empty line
+	RawHeader []byte
+	// Body is the raw (encoded) body
+	Body []byte
+}
+
+// ParseMIMEEntity parses a raw MIME entity, converting line endings to CRLF
+func ParseMIMEEntity(b []byte) (MIMEEntity, error) {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	b = bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return MIMEEntity{}, err
+	}
+
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return MIMEEntity{}, err
+	}
+
+	return MIMEEntity{Header: m.Header, RawHeader: b[:len(b)-len(body)], Body: body}, nil
+}
+
+// DecodedBody returns the body decoded using the Content-Transfer-Encoding
+func (e MIMEEntity) DecodedBody() ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(e.Header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		clean := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, string(e.Body))
+		return base64.StdEncoding.DecodeString(clean)
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(e.Body)))
+	}
+
+	return e.Body, nil
+}
+
+// HeaderFields returns the raw (folded) header fields, each including the trailing CRLF
+func (e MIMEEntity) HeaderFields() []string {
+	fields := []string{}
+	for line := range strings.Lines(string(e.RawHeader)) {
+		if line == "\r\n" {
+			break
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+
+	return fields
+}
+
+// ReplaceContent returns the raw message of the entity with its Content-* headers & body replaced by
+// the inner entity, eg: the decrypted content of an encrypted message
+func (e MIMEEntity) ReplaceContent(inner MIMEEntity) []byte {
+	var b bytes.Buffer
+	for _, h := range e.HeaderFields() {
+		name, _, _ := strings.Cut(h, ":")
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(name)), "content-") {
+			b.WriteString(h)
+		}
+	}
+	b.Write(inner.RawHeader)
+	b.Write(inner.Body)
+
+	return b.Bytes()
+}
+
+// SplitMultipart returns the raw body parts of a multipart body with CRLF line endings,
+// excluding the CRLF preceding each delimiter
+func SplitMultipart(body []byte, boundary string) [][]byte {
+	delimiter := []byte("\r\n--" + boundary)
+	// the first delimiter may be at the start of the body
+	body = append([]byte("\r\n"), body...)
+
+	parts := [][]byte{}
+	i := bytes.Index(body, delimiter)
+	for i >= 0 {
+		rest := body[i+len(delimiter):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			break
+		}
+
+		// skip transport padding to the end of the delimiter line
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			break
+		}
+		rest = rest[eol+2:]
+
+		next := bytes.Index(rest, delimiter)
+		if next < 0 {
+			break
+		}
+
+		parts = append(parts, rest[:next])
+		body = rest[next:]
+		i = 0
+	}
+
+	return parts
+}
+
+// MultipartEpilogue returns the raw bytes following the close delimiter of a multipart body,
+// ie: the rest of the close delimiter line & the epilogue, and whether the close delimiter was found
+func MultipartEpilogue(body []byte, boundary string) ([]byte, bool) {
+	delimiter := []byte("\r\n--" + boundary + "--")
+	// the close delimiter may be at the start of the body
+	body = append([]byte("\r\n"), body...)
+
+	i := bytes.Index(body, delimiter)
+	if i < 0 {
+		return nil, false
+	}
+
+	return body[i+len(delimiter):], true
+}
@@ -122,6 +122,42 @@ func TestCleanTag(t *testing.T) {
 	}
 }
 
+func TestNormalizeAddress(t *testing.T) {
+	tests := map[string][2]string{}
+	tests["user@example.com"] = [2]string{"user@example.com", "user@example.com"}
+	tests["User@EXAMPLE.com"] = [2]string{"User@example.com", "User@example.com"}
+	tests["user@Bücher.example"] = [2]string{"user@bücher.example", "user@xn--bcher-kva.example"}
+	tests["user@xn--bcher-kva.example"] = [2]string{"user@bücher.example", "user@xn--bcher-kva.example"}
+	tests["jöran@bücher.example"] = [2]string{"jöran@bücher.example", ""}
+	tests["jo\u0308ran@example.com"] = [2]string{"jöran@example.com", ""}
+	tests["user@under_score.example"] = [2]string{"user@under_score.example", "user@under_score.example"}
+
+	for address, expected := range tests {
+		u, a := NormalizeAddress(address)
+		if u != expected[0] || a != expected[1] {
+			t.Logf("NormalizeAddress error for %s: %q, %q != %q, %q", address, u, a, expected[0], expected[1])
+			t.Fail()
+		}
+	}
+}
+
+func TestIsInternationalAddress(t *testing.T) {
+	tests := map[string]bool{
+		"User@EXAMPLE.com":           false,
+		"example.com":                false,
+		"user@bücher.example":        true,
+		"user@XN--bcher-kva.example": true,
+		"xn--bcher-kva.example":      true,
+		"jöran@example.com":          true,
+	}
+
+	for address, expected := range tests {
+		if IsInternationalAddress(address) != expected {
+			t.Errorf("IsInternationalAddress(%q) != %v", address, expected)
+		}
+	}
+}
+
 func TestSnippets(t *testing.T) {
 	tests := map[string]string{}
 	tests["this is a  test"] = "this is a test"
@@ -57,11 +57,18 @@ func WebUIConfig(w http.ResponseWriter, _ *http.Request) {
 	conf.Body.MessageRelay.Enabled = config.ReleaseEnabled
 	if config.ReleaseEnabled {
 		conf.Body.MessageRelay.SMTPServer = fmt.Sprintf("%s:%d", config.SMTPRelayConfig.Host, config.SMTPRelayConfig.Port)
+		if config.SMTPRelayConfig.Backend != nil {
+			conf.Body.MessageRelay.SMTPServer = config.SMTPRelayConfig.Backend.String()
+		}
+		if config.SMTPRelayConfig.ProviderClient != nil {
+			conf.Body.MessageRelay.SMTPServer = config.SMTPRelayConfig.ProviderClient.String()
+		}
 		conf.Body.MessageRelay.ReturnPath = config.SMTPRelayConfig.ReturnPath
 		conf.Body.MessageRelay.AllowedRecipients = config.SMTPRelayConfig.AllowedRecipients
 		conf.Body.MessageRelay.BlockedRecipients = config.SMTPRelayConfig.BlockedRecipients
 		conf.Body.MessageRelay.OverrideFrom = config.SMTPRelayConfig.OverrideFrom
 		conf.Body.MessageRelay.PreserveMessageIDs = config.SMTPRelayConfig.PreserveMessageIDs
+		conf.Body.MessageRelay.Profiles = config.RelayProfileNames()
 
 		// DEPRECATED 2024/03/12
 		conf.Body.MessageRelay.RecipientAllowlist = config.SMTPRelayConfig.AllowedRecipients
@@ -30,7 +30,7 @@ func GetChaos(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 
-	conf := chaos.Config
+	conf := chaos.Current()
 
 	w.Header().Add("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(conf); err != nil {
@@ -83,10 +83,194 @@ func SetChaos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conf := chaos.Config
+	conf := chaos.Current()
 
 	w.Header().Add("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(conf); err != nil {
 		httpError(w, err.Error())
 	}
 }
+
+// GetChaosRules returns the current Chaos SMTP response rules
+func GetChaosRules(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route GET /api/v1/chaos/rules testing getChaosRules
+	//
+	// # Get Chaos rules
+	//
+	// Returns the deterministic SMTP response rules, in order of evaluation.
+	// This API route will return an error if Chaos is not enabled at runtime.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ChaosRulesResponse
+	//	  400: ErrorResponse
+
+	if !chaos.Enabled {
+		httpError(w, "Chaos is not enabled")
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(chaos.Rules()); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// AddChaosRule adds a Chaos SMTP response rule
+func AddChaosRule(w http.ResponseWriter, r *http.Request) {
+	// swagger:route POST /api/v1/chaos/rules testing addChaosRuleParams
+	//
+	// # Add a Chaos rule
+	//
+	// Appends a deterministic SMTP response rule and returns the rule including its ID.
+	// Rules are evaluated in order, and the first matching rule is triggered.
+	// This API route will return an error if Chaos is not enabled at runtime.
+	//
+	//	Consumes:
+	//	  - application/json
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ChaosRuleResponse
+	//	  400: ErrorResponse
+
+	if !chaos.Enabled {
+		httpError(w, "Chaos is not enabled")
+		return
+	}
+
+	data := chaos.Rule{}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	rule, err := chaos.AddRule(data)
+	if err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rule); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// UpdateChaosRule updates a Chaos SMTP response rule
+func UpdateChaosRule(w http.ResponseWriter, r *http.Request) {
+	// swagger:route PUT /api/v1/chaos/rules/{ID} testing updateChaosRuleParams
+	//
+	// # Update a Chaos rule
+	//
+	// Replaces an existing SMTP response rule, resetting its attempt counters.
+	// This API route will return an error if Chaos is not enabled at runtime.
+	//
+	//	Consumes:
+	//	  - application/json
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ChaosRuleResponse
+	//	  400: ErrorResponse
+
+	if !chaos.Enabled {
+		httpError(w, "Chaos is not enabled")
+		return
+	}
+
+	data := chaos.Rule{}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	rule, err := chaos.UpdateRule(r.PathValue("id"), data)
+	if err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rule); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// DeleteChaosRule deletes a Chaos SMTP response rule
+func DeleteChaosRule(w http.ResponseWriter, r *http.Request) {
+	// swagger:route DELETE /api/v1/chaos/rules/{ID} testing deleteChaosRuleParams
+	//
+	// # Delete a Chaos rule
+	//
+	// Deletes an SMTP response rule.
+	// This API route will return an error if Chaos is not enabled at runtime.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+
+	if !chaos.Enabled {
+		httpError(w, "Chaos is not enabled")
+		return
+	}
+
+	if err := chaos.DeleteRule(r.PathValue("id")); err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// DeleteChaosRules deletes all Chaos SMTP response rules
+func DeleteChaosRules(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route DELETE /api/v1/chaos/rules testing deleteChaosRules
+	//
+	// # Delete all Chaos rules
+	//
+	// Deletes all SMTP response rules and resets the attempt counters.
+	// This API route will return an error if Chaos is not enabled at runtime.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+
+	if !chaos.Enabled {
+		httpError(w, "Chaos is not enabled")
+		return
+	}
+
+	if err := chaos.SetRules([]chaos.Rule{}); err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
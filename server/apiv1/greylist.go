@@ -0,0 +1,57 @@
+package apiv1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/axllent/mailpit/internal/smtpd/greylist"
+)
+
+// GetGreylist returns the greylisting status & counters
+func GetGreylist(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route GET /api/v1/greylist testing getGreylist
+	//
+	// # Get greylisting status
+	//
+	// Returns the greylisting status, counters and tracked client IP, sender & recipient triplets,
+	// including the number of attempts and the time each triplet was first accepted.
+	// Deferred triplets expire if not retried within 48 hours after the delay, and accepted triplets
+	// expire 35 days after their latest delivery attempt.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: GreylistResponse
+	//	  400: ErrorResponse
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(greylist.GetStatus()); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// ResetGreylist deletes all tracked greylisting triplets & resets the counters
+func ResetGreylist(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route DELETE /api/v1/greylist testing resetGreylist
+	//
+	// # Reset greylisting
+	//
+	// Deletes all tracked triplets and resets the counters, so new delivery attempts are greylisted again.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+
+	greylist.Reset()
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
@@ -7,10 +7,15 @@ import (
 	"net/http"
 
 	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/authres"
+	"github.com/axllent/mailpit/internal/dkim"
 	"github.com/axllent/mailpit/internal/htmlcheck"
 	"github.com/axllent/mailpit/internal/linkcheck"
+	"github.com/axllent/mailpit/internal/pgp"
+	"github.com/axllent/mailpit/internal/smime"
 	"github.com/axllent/mailpit/internal/spamassassin"
 	"github.com/axllent/mailpit/internal/storage"
+	"github.com/axllent/mailpit/internal/tlsrpt"
 	"github.com/jhillyerd/enmime/v2"
 )
 
@@ -51,6 +56,7 @@ func HTMLCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	raw, _ = storage.Decrypt(raw)
 	e := bytes.NewReader(raw)
 
 	parser := enmime.NewParser(enmime.DisableCharacterDetection(true))
@@ -184,3 +190,245 @@ func SpamAssassinCheck(w http.ResponseWriter, r *http.Request) {
 		httpError(w, err.Error())
 	}
 }
+
+// DKIMCheck returns the DKIM signature verification report of a message
+func DKIMCheck(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/message/{ID}/dkim other DKIMCheckParams
+	//
+	// # DKIM check
+	//
+	// Verifies every DKIM-Signature header of the message, returning the result of each signature.
+	// Public keys are resolved from the configured zone file or key map, else via DNS.
+	//
+	// The ID can be set to `latest` to return the latest message.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: DKIMResponse
+	//    400: ErrorResponse
+	//    404: NotFoundResponse
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+
+	msg, err := storage.GetMessageRaw(id)
+	if err != nil {
+		fourOFour(w)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dkim.Check(msg)); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// AuthenticationResults returns the SPF, DKIM, DMARC & ARC authentication results of a message
+func AuthenticationResults(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/message/{ID}/auth-results other AuthenticationResultsParams
+	//
+	// # Authentication results
+	//
+	// Evaluates SPF (using the SMTP client IP, HELO & MAIL FROM of the SMTP session), DKIM, DMARC alignment of the
+	// From domain and the ARC chain of the message, returning an Authentication-Results style report.
+	// SPF is not evaluated for messages which were not received via SMTP, such as via the send API.
+	// DNS records are resolved from the configured zone file, else via DNS.
+	//
+	// The ID can be set to `latest` to return the latest message.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: AuthenticationResultsResponse
+	//    400: ErrorResponse
+	//    404: NotFoundResponse
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+
+	msg, err := storage.GetMessageRaw(id)
+	if err != nil {
+		fourOFour(w)
+		return
+	}
+
+	metadata, err := storage.GetMetadata(id)
+	if err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(authres.Check(msg, metadata.SMTP)); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// SMIMECheck verifies the S/MIME signatures of a message, decrypting it first if encrypted
+func SMIMECheck(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/message/{ID}/smime other SMIMECheckParams
+	//
+	// # S/MIME check
+	//
+	// Verifies the S/MIME signatures (multipart/signed or opaque signed) of the message against the
+	// configured CA bundle (else the system roots), returning the signer certificate details.
+	// Encrypted messages are decrypted using the configured private keys before verification.
+	//
+	// The ID can be set to `latest` to return the latest message.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: SMIMEResponse
+	//    400: ErrorResponse
+	//    404: NotFoundResponse
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+
+	msg, err := storage.GetMessageRaw(id)
+	if err != nil {
+		fourOFour(w)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(smime.Check(msg)); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// PGPCheck verifies the OpenPGP/MIME signatures of a message, decrypting it first if encrypted
+func PGPCheck(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/message/{ID}/pgp other PGPCheckParams
+	//
+	// # PGP check
+	//
+	// Verifies the OpenPGP/MIME signatures (multipart/signed) of the message against the configured
+	// public keyring, returning the signer key details.
+	// Encrypted (multipart/encrypted) messages are decrypted using the configured private keys before verification.
+	//
+	// The ID can be set to `latest` to return the latest message.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: PGPResponse
+	//    400: ErrorResponse
+	//    404: NotFoundResponse
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+
+	msg, err := storage.GetMessageRaw(id)
+	if err != nil {
+		fourOFour(w)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pgp.Check(msg)); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// TLSReport returns the parsed SMTP TLS report (TLS-RPT) of a message
+func TLSReport(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/message/{ID}/tls-report other TLSReportParams
+	//
+	// # TLS report
+	//
+	// Parses the SMTP TLS report (RFC 8460) delivered by the message, either as a multipart/report
+	// (report-type=tlsrpt) part or as the message body, using the application/tlsrpt+gzip or
+	// application/tlsrpt+json media types. Returns an error if the message does not contain a TLS report.
+	//
+	// The ID can be set to `latest` to return the latest message.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: TLSReportResponse
+	//    400: ErrorResponse
+	//    404: NotFoundResponse
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+
+	msg, err := storage.GetMessageRaw(id)
+	if err != nil {
+		fourOFour(w)
+		return
+	}
+
+	report, err := tlsrpt.Parse(msg)
+	if err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		httpError(w, err.Error())
+	}
+}
@@ -0,0 +1,115 @@
+package apiv1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/smtpd"
+	"github.com/axllent/mailpit/internal/storage"
+)
+
+// GetQueue returns the outbound relay & forward queue
+func GetQueue(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route GET /api/v1/queue other getQueue
+	//
+	// # Get outbound queue
+	//
+	// Returns the queued and failed relay & forward deliveries, including the number of attempts, the next attempt
+	// and the last error, as well as the number of deliveries and failed attempts since startup.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: QueueResponse
+	//	  400: ErrorResponse
+
+	status, err := smtpd.GetQueueStatus()
+	if err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// RetryQueued schedules a queued or failed delivery for an immediate attempt
+func RetryQueued(w http.ResponseWriter, r *http.Request) {
+	// swagger:route POST /api/v1/queue/{ID}/retry other retryQueueParams
+	//
+	// # Retry a queued delivery
+	//
+	// Schedules a queued or failed delivery for an immediate delivery attempt.
+	// Scheduled releases cannot be retried.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+	//	  404: NotFoundResponse
+
+	if config.DemoMode {
+		httpError(w, "this functionality has been disabled for demonstration purposes")
+		return
+	}
+
+	if err := smtpd.RetryQueued(r.PathValue("id")); err != nil {
+		if errors.Is(err, storage.ErrQueueJobNotFound) {
+			fourOFour(w)
+			return
+		}
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// CancelQueued deletes a queued or failed delivery
+func CancelQueued(w http.ResponseWriter, r *http.Request) {
+	// swagger:route DELETE /api/v1/queue/{ID} other cancelQueueParams
+	//
+	// # Cancel a queued delivery
+	//
+	// Deletes a queued or failed delivery from the queue.
+	// Scheduled releases can only be cancelled with the scheduled release endpoint.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+	//	  404: NotFoundResponse
+
+	if config.DemoMode {
+		httpError(w, "this functionality has been disabled for demonstration purposes")
+		return
+	}
+
+	if err := storage.QueueCancel(r.PathValue("id")); err != nil {
+		if errors.Is(err, storage.ErrQueueJobNotFound) {
+			fourOFour(w)
+			return
+		}
+		httpError(w, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
@@ -0,0 +1,56 @@
+package apiv1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/axllent/mailpit/internal/smtpd/ratelimit"
+)
+
+// GetRateLimits returns the SMTP rate limits & current usage
+func GetRateLimits(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route GET /api/v1/rate-limits testing getRateLimits
+	//
+	// # Get SMTP rate limits
+	//
+	// Returns the configured SMTP rate limits and the current usage of each client IP and authenticated user:
+	// concurrent connections, messages accepted in the last minute and message data accepted in the last hour.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: RateLimitsResponse
+	//	  400: ErrorResponse
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ratelimit.GetStatus()); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// ResetRateLimits resets the SMTP message & data usage
+func ResetRateLimits(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route DELETE /api/v1/rate-limits testing resetRateLimits
+	//
+	// # Reset SMTP rate limits usage
+	//
+	// Resets the message and data usage of all client IPs and authenticated users.
+	// Current connections are not affected.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+
+	ratelimit.Reset()
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
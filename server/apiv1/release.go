@@ -1,19 +1,16 @@
 package apiv1
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/mail"
 	"strings"
 	"time"
 
 	"github.com/axllent/mailpit/config"
-	"github.com/axllent/mailpit/internal/logger"
-	"github.com/axllent/mailpit/internal/shortuuid"
 	"github.com/axllent/mailpit/internal/smtpd"
 	"github.com/axllent/mailpit/internal/storage"
-	"github.com/axllent/mailpit/internal/tools"
 )
 
 // ReleaseMessage (method: POST) will release a message via a pre-configured external SMTP server.
@@ -23,6 +20,11 @@ func ReleaseMessage(w http.ResponseWriter, r *http.Request) {
 	// # Release message
 	//
 	// Release a message via a pre-configured external SMTP server. This is only enabled if message relaying has been configured.
+	// An optional named relay profile can be set to release the message via a different SMTP server.
+	// If the SMTP server is temporarily unavailable, the message is added to the outbound queue for further attempts.
+	//
+	// The release can be scheduled for a later date & time with either `SendAt` or `Delay`. Scheduled releases
+	// are persisted in the outbound queue, and can be listed & cancelled via the scheduled releases endpoints.
 	//
 	// The ID can be set to `latest` to reference the latest message.
 	//
@@ -55,7 +57,10 @@ func ReleaseMessage(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 
 	var data struct {
-		To []string
+		To      []string
+		Profile string
+		SendAt  time.Time
+		Delay   string
 	}
 
 	if err := decoder.Decode(&data); err != nil {
@@ -63,110 +68,235 @@ func ReleaseMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	blocked := []string{}
-	notAllowed := []string{}
-
-	for _, to := range data.To {
-		address, err := mail.ParseAddress(to)
-
-		if err != nil {
-			httpError(w, "Invalid email address: "+to)
+	sendAt := data.SendAt
+	if data.Delay != "" {
+		if !sendAt.IsZero() {
+			httpError(w, "SendAt and Delay cannot be set together")
 			return
 		}
 
-		if config.SMTPRelayConfig.AllowedRecipientsRegexp != nil && !config.SMTPRelayConfig.AllowedRecipientsRegexp.MatchString(address.Address) {
-			notAllowed = append(notAllowed, to)
-			continue
+		delay, err := time.ParseDuration(data.Delay)
+		if err != nil || delay <= 0 {
+			httpError(w, "invalid Delay: "+data.Delay)
+			return
 		}
 
-		if config.SMTPRelayConfig.BlockedRecipientsRegexp != nil && config.SMTPRelayConfig.BlockedRecipientsRegexp.MatchString(address.Address) {
-			blocked = append(blocked, to)
-			continue
-		}
+		sendAt = time.Now().Add(delay)
 	}
 
-	if len(notAllowed) > 0 {
-		addr := tools.Plural(len(notAllowed), "Address", "Addresses")
-		httpError(w, "Failed: "+addr+" do not match the allowlist: "+strings.Join(notAllowed, ", "))
+	if err := smtpd.ReleaseMessage(data.Profile, id, msg, data.To, sendAt); err != nil {
+		httpError(w, err.Error())
 		return
 	}
 
-	if len(blocked) > 0 {
-		addr := tools.Plural(len(blocked), "Address", "Addresses")
-		httpError(w, "Failed: "+addr+" found on blocklist: "+strings.Join(blocked, ", "))
-		return
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// GetRelayLog returns the relay, release & forward delivery attempts of a message
+func GetRelayLog(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/message/{ID}/relay-log message RelayLogParams
+	//
+	// # Get message relay log
+	//
+	// Returns the relay, release & forward delivery attempts of the message, oldest first,
+	// including the target SMTP server, recipients, final SMTP response and duration of each attempt.
+	//
+	// The ID can be set to `latest` to return the latest message.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: RelayLogResponse
+	//    400: ErrorResponse
+	//    404: NotFoundResponse
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
 	}
 
-	if len(data.To) == 0 {
-		httpError(w, "No valid addresses found")
+	if _, err := storage.GetMetadata(id); err != nil {
+		fourOFour(w)
 		return
 	}
 
-	reader := bytes.NewReader(msg)
-	m, err := mail.ReadMessage(reader)
+	log, err := storage.GetRelayLog(id)
 	if err != nil {
 		httpError(w, err.Error())
 		return
 	}
 
-	fromAddresses, err := m.Header.AddressList("From")
-	if err != nil {
-		httpError(w, "Failed: unable to parse From header: "+err.Error())
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(log); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// ReleaseSearch (method: POST) will release all messages matching a search via a pre-configured external SMTP server.
+func ReleaseSearch(w http.ResponseWriter, r *http.Request) {
+	// swagger:route POST /api/v1/search/release messages ReleaseSearchParams
+	//
+	// # Release messages by search
+	//
+	// Release all messages matching [a search](https://mailpit.axllent.org/docs/usage/search-filters/) via a pre-configured
+	// external SMTP server. This is only enabled if message relaying has been configured.
+	//
+	// Messages are released in the background in batches, either to the specified addresses, or to the original
+	// To, Cc & Bcc recipients of each message if none are set. Original recipients not permitted by the relay
+	// allow & block lists are ignored. Messages received after the release has started are not released.
+	//
+	// The release job is returned, and its progress is broadcast to websocket clients using the `release` event.
+	//
+	//	Consumes:
+	//	  - application/json
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ReleaseJob
+	//    400: ErrorResponse
+
+	if config.DemoMode {
+		httpError(w, "this functionality has been disabled for demonstration purposes")
 		return
 	}
 
-	if len(fromAddresses) == 0 {
-		httpError(w, "No From header found")
+	if !config.ReleaseEnabled {
+		httpError(w, "Message relaying is not enabled")
 		return
 	}
 
-	from := fromAddresses[0].Address
+	search := strings.TrimSpace(r.URL.Query().Get("query"))
+	if search == "" {
+		httpError(w, "Error: no search query")
+		return
+	}
+
+	var data struct {
+		To      []string
+		Profile string
+	}
 
-	// if sender is used, then change from to the sender
-	if senders, err := m.Header.AddressList("Sender"); err == nil {
-		from = senders[0].Address
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			httpError(w, err.Error())
+			return
+		}
 	}
 
-	msg, err = tools.RemoveMessageHeaders(msg, []string{"Bcc"})
+	job, err := smtpd.ReleaseSearch(search, r.URL.Query().Get("tz"), data.Profile, data.To)
 	if err != nil {
 		httpError(w, err.Error())
 		return
 	}
 
-	// set the Return-Path and SMTP from
-	if config.SMTPRelayConfig.ReturnPath != "" {
-		if m.Header.Get("Return-Path") != "<"+config.SMTPRelayConfig.ReturnPath+">" {
-			msg, err = tools.RemoveMessageHeaders(msg, []string{"Return-Path"})
-			if err != nil {
-				httpError(w, err.Error())
-				return
-			}
-			msg = append([]byte("Return-Path: <"+config.SMTPRelayConfig.ReturnPath+">\r\n"), msg...)
-		}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// GetReleaseJob returns the progress of a bulk release
+func GetReleaseJob(w http.ResponseWriter, r *http.Request) {
+	// swagger:route GET /api/v1/search/release/{ID} messages ReleaseJobParams
+	//
+	// # Get bulk release progress
+	//
+	// Returns the progress of a release of messages matching a search. Completed releases are available for one hour.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ReleaseJob
+	//    404: NotFoundResponse
 
-		from = config.SMTPRelayConfig.ReturnPath
+	job, ok := smtpd.GetReleaseJob(r.PathValue("id"))
+	if !ok {
+		fourOFour(w)
+		return
 	}
 
-	// update message date
-	msg, err = tools.SetMessageHeader(msg, "Date", time.Now().Format(time.RFC1123Z))
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// GetScheduledReleases returns the scheduled message releases
+func GetScheduledReleases(w http.ResponseWriter, _ *http.Request) {
+	// swagger:route GET /api/v1/scheduled other getScheduledReleases
+	//
+	// # Get scheduled releases
+	//
+	// Returns the scheduled message releases which have not been sent yet, ordered by their scheduled date.
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ScheduledReleasesResponse
+	//	  400: ErrorResponse
+
+	jobs, err := storage.QueueScheduled()
 	if err != nil {
 		httpError(w, err.Error())
 		return
 	}
 
-	if !config.SMTPRelayConfig.PreserveMessageIDs {
-		// replace the Message-ID header with unique ID
-		uid := shortuuid.New() + "@mailpit"
-		msg, err = tools.SetMessageHeader(msg, "Message-ID", "<"+uid+">")
-		if err != nil {
-			httpError(w, err.Error())
-			return
-		}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		httpError(w, err.Error())
 	}
+}
 
-	if err := smtpd.Relay(from, data.To, msg); err != nil {
-		logger.Log().Errorf("[smtp] error sending message: %s", err.Error())
-		httpError(w, "SMTP error: "+err.Error())
+// CancelScheduledRelease deletes a scheduled message release
+func CancelScheduledRelease(w http.ResponseWriter, r *http.Request) {
+	// swagger:route DELETE /api/v1/scheduled/{ID} other cancelScheduledReleaseParams
+	//
+	// # Cancel a scheduled release
+	//
+	// Cancels a scheduled message release which has not been sent yet.
+	//
+	//	Produces:
+	//	  - text/plain
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: OKResponse
+	//	  400: ErrorResponse
+	//	  404: NotFoundResponse
+
+	if config.DemoMode {
+		httpError(w, "this functionality has been disabled for demonstration purposes")
+		return
+	}
+
+	if err := smtpd.CancelScheduledRelease(r.PathValue("id")); err != nil {
+		if errors.Is(err, storage.ErrQueueJobNotFound) {
+			fourOFour(w)
+			return
+		}
+		httpError(w, err.Error())
 		return
 	}
 
@@ -0,0 +1,321 @@
+package apiv1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/axllent/mailpit/config"
+	"github.com/axllent/mailpit/internal/shortuuid"
+	"github.com/axllent/mailpit/internal/smtpd"
+	"github.com/axllent/mailpit/internal/storage"
+	"github.com/axllent/mailpit/internal/tools"
+	"github.com/jhillyerd/enmime/v2"
+)
+
+// resendHeaderExclude are the original headers which are set by the message builder, as well as
+// the signature & trace headers which are no longer valid once a message is modified & resent
+var resendHeaderExclude = []string{
+	"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-Id", "Return-Path",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Id",
+	"DKIM-Signature", "Authentication-Results", "Received", "Received-SPF",
+}
+
+// ResendMessage (method: POST) will rebuild a message with modifications, and either store or release it
+func ResendMessage(w http.ResponseWriter, r *http.Request) {
+	// swagger:route POST /api/v1/message/{ID}/resend message ResendMessageParams
+	//
+	// # Edit & resend message
+	//
+	// Rebuilds a message with optional header overrides and replacement text & HTML parts, preserving
+	// the attachments & inline parts of the original message. Headers set to an empty value are removed.
+	// A new unique Message-ID is generated unless one is set in the header overrides.
+	//
+	// By default the rebuilt message is stored as a new message, linked to the original message with the
+	// `X-Mailpit-Resent-From` header. If `Release` is set, the message is released via the relay instead
+	// (this is only enabled if message relaying has been configured). A released message is not stored, and
+	// the release is not recorded in the relay log of the original message (or matched by `is:released`).
+	//
+	// The ID can be set to `latest` to reference the latest message.
+	//
+	//	Consumes:
+	//	  - application/json
+	//
+	//	Produces:
+	//	  - application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//	  200: ResendMessageResponse
+	//	  400: ErrorResponse
+	//	  404: NotFoundResponse
+
+	if config.DemoMode {
+		httpError(w, "this functionality has been disabled for demonstration purposes")
+		return
+	}
+
+	id := r.PathValue("id")
+
+	if id == "latest" {
+		var err error
+		id, err = storage.LatestID(r)
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+
+	raw, err := storage.GetMessageRaw(id)
+	if err != nil {
+		fourOFour(w)
+		return
+	}
+
+	data := resendMessageParams{}
+
+	if err := json.NewDecoder(r.Body).Decode(&data.Body); err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	if data.Body.Release && !config.ReleaseEnabled {
+		httpError(w, "message relaying is not enabled")
+		return
+	}
+
+	if !data.Body.Release {
+		// link the stored message to the original message
+		if data.Body.Headers == nil {
+			data.Body.Headers = map[string]string{}
+		}
+		data.Body.Headers["X-Mailpit-Resent-From"] = id
+	}
+
+	from, recipients, msg, err := rebuildMessage(raw, data.Body.Headers, data.Body.Text, data.Body.HTML)
+	if err != nil {
+		httpError(w, err.Error())
+		return
+	}
+
+	newID := ""
+
+	if data.Body.Release {
+		to := data.Body.To
+		if len(to) == 0 {
+			to = recipients
+		}
+
+		// the rebuilt message is not stored, so the release is not recorded in the relay log of the original message
+		if err := smtpd.ReleaseMessage(data.Body.Profile, "", msg, to, time.Time{}); err != nil {
+			httpError(w, err.Error())
+			return
+		}
+	} else {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			httpError(w, fmt.Sprintf("error parsing request RemoteAddr: %s", err.Error()))
+			return
+		}
+
+		var httpAuthUser *string
+		if user, _, ok := r.BasicAuth(); ok {
+			httpAuthUser = &user
+		}
+
+		newID, err = smtpd.SaveToDatabase(&net.IPAddr{IP: net.ParseIP(ip)}, from, recipients, msg, httpAuthUser, false, nil)
+		if err != nil {
+			httpError(w, err.Error())
+			return
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct{ ID string }{ID: newID}); err != nil {
+		httpError(w, err.Error())
+	}
+}
+
+// rebuildMessage rebuilds a raw message with the header overrides (empty values remove the header) and
+// replacement text & HTML parts, preserving attachments, inline & other parts. It returns the sender address,
+// the To, Cc & Bcc recipient addresses, and the rebuilt message.
+func rebuildMessage(raw []byte, headers map[string]string, text, html string) (string, []string, []byte, error) {
+	env, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error parsing message: %s", err.Error())
+	}
+
+	// decode the original headers as the builder encodes them as required, except for
+	// address headers which are decoded when parsed
+	h := textproto.MIMEHeader{}
+	for k, values := range env.Root.Header {
+		for _, v := range values {
+			if !isAddressHeader(k) {
+				v = decodeHeader(v)
+			}
+			h.Add(k, v)
+		}
+	}
+
+	overrides := textproto.MIMEHeader{}
+	for k, v := range headers {
+		if k == "" || strings.ContainsAny(k, " :\r\n") {
+			return "", nil, nil, fmt.Errorf("invalid header name: \"%s\"", k)
+		}
+
+		if strings.ContainsAny(v, "\r\n") {
+			return "", nil, nil, fmt.Errorf("invalid value for header: \"%s\"", k)
+		}
+
+		overrides.Set(k, v)
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+	}
+
+	from, err := mail.ParseAddress(h.Get("From"))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid From address: %s", err.Error())
+	}
+
+	msg := enmime.Builder().
+		From(from.Name, from.Address).
+		Subject(h.Get("Subject"))
+
+	recipients := []string{}
+
+	for _, k := range []string{"To", "Cc", "Bcc", "Reply-To"} {
+		if h.Get(k) == "" {
+			continue
+		}
+
+		addresses, err := mail.ParseAddressList(h.Get(k))
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("invalid %s address: %s", k, err.Error())
+		}
+
+		for _, a := range addresses {
+			switch k {
+			case "To":
+				msg = msg.To(a.Name, a.Address)
+			case "Cc":
+				msg = msg.CC(a.Name, a.Address)
+			case "Bcc":
+				msg = msg.BCC(a.Name, a.Address)
+			default:
+				msg = msg.ReplyTo(a.Name, a.Address)
+				continue
+			}
+
+			recipients = append(recipients, a.Address)
+		}
+	}
+
+	// the Date is set to now unless overridden
+	if d := overrides.Get("Date"); d != "" {
+		date, err := mail.ParseDate(d)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("invalid Date: %s", err.Error())
+		}
+		msg = msg.Date(date)
+	}
+
+	// a new unique Message-ID is generated unless overridden
+	messageID := overrides.Get("Message-Id")
+	if messageID == "" {
+		messageID = "<" + shortuuid.New() + "@mailpit>"
+	}
+	msg = msg.Header("Message-ID", messageID)
+
+	for k, values := range h {
+		if isResendExcludedHeader(k) {
+			continue
+		}
+
+		for _, v := range values {
+			msg = msg.Header(k, v)
+		}
+	}
+
+	// enmime generates the text from the HTML if the original message has no text part
+	if text == "" && hasTextPart(env.Root) {
+		text = env.Text
+	}
+
+	if html == "" {
+		html = env.HTML
+	}
+
+	if text != "" {
+		msg = msg.Text([]byte(text))
+	}
+
+	if html != "" {
+		msg = msg.HTML([]byte(html))
+	}
+
+	for _, p := range env.Inlines {
+		msg = msg.AddInline(p.Content, p.ContentType, p.FileName, p.ContentID)
+	}
+
+	for _, p := range env.OtherParts {
+		msg = msg.AddOtherPart(p.Content, p.ContentType, p.FileName, p.ContentID)
+	}
+
+	for _, p := range env.Attachments {
+		msg = msg.AddAttachment(p.Content, p.ContentType, p.FileName)
+	}
+
+	part, err := msg.Build()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error building message: %s", err.Error())
+	}
+
+	var buff bytes.Buffer
+
+	if err := part.Encode(io.Writer(&buff)); err != nil {
+		return "", nil, nil, fmt.Errorf("error building message: %s", err.Error())
+	}
+
+	return from.Address, recipients, buff.Bytes(), nil
+}
+
+// isResendExcludedHeader returns whether an original header is set by the message builder,
+// or is a signature or trace header, including the ARC-* headers
+func isResendExcludedHeader(k string) bool {
+	return tools.InArray(k, resendHeaderExclude) || strings.HasPrefix(strings.ToUpper(k), "ARC-")
+}
+
+// hasTextPart returns whether a message has a text/plain body part
+func hasTextPart(root *enmime.Part) bool {
+	return root.BreadthMatchFirst(func(p *enmime.Part) bool {
+		return p.ContentType == "text/plain" && p.Disposition != "attachment"
+	}) != nil
+}
+
+// isAddressHeader returns whether a header contains email addresses
+func isAddressHeader(k string) bool {
+	return tools.InArray(k, []string{"From", "To", "Cc", "Bcc", "Reply-To", "Sender"})
+}
+
+// decodeHeader returns the decoded RFC 2047 header value, or the original value if it cannot be decoded
+func decodeHeader(v string) string {
+	dec := new(mime.WordDecoder)
+	if s, err := dec.DecodeHeader(v); err == nil {
+		return s
+	}
+
+	return v
+}
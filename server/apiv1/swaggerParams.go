@@ -6,7 +6,11 @@
 //nolint:unused
 package apiv1
 
-import "github.com/axllent/mailpit/internal/smtpd/chaos"
+import (
+	"time"
+
+	"github.com/axllent/mailpit/internal/smtpd/chaos"
+)
 
 // swagger:parameters setChaosParams
 type setChaosParams struct {
@@ -14,6 +18,33 @@ type setChaosParams struct {
 	Body chaos.Triggers
 }
 
+// swagger:parameters addChaosRuleParams
+type addChaosRuleParams struct {
+	// in: body
+	Body chaos.Rule
+}
+
+// swagger:parameters updateChaosRuleParams
+type updateChaosRuleParams struct {
+	// Chaos rule ID
+	//
+	// in: path
+	// required: true
+	ID string
+
+	// in: body
+	Body chaos.Rule
+}
+
+// swagger:parameters deleteChaosRuleParams
+type deleteChaosRuleParams struct {
+	// Chaos rule ID
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
 // swagger:parameters AttachmentParams
 type attachmentParams struct {
 	// Message database ID or "latest"
@@ -173,6 +204,45 @@ type deleteSearchParams struct {
 	TZ string `json:"tz"`
 }
 
+// swagger:parameters ReleaseSearchParams
+type releaseSearchParams struct {
+	// Search query
+	//
+	// in: query
+	// required: true
+	// type: string
+	Query string `json:"query"`
+
+	// [Timezone identifier](https://en.wikipedia.org/wiki/List_of_tz_database_time_zones) used only for `before:` & `after:` searches (eg: "Pacific/Auckland").
+	//
+	// in: query
+	// required: false
+	// type string
+	TZ string `json:"tz"`
+
+	// in: body
+	Body struct {
+		// Optional array of email addresses to relay the messages to, else each message is relayed to its original recipients
+		//
+		// example: ["user1@example.com", "user2@example.com"]
+		To []string
+
+		// Optional named relay profile, else the default relay configuration is used
+		//
+		// example: staging
+		Profile string
+	}
+}
+
+// swagger:parameters ReleaseJobParams
+type releaseJobParams struct {
+	// Release job ID
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
 // swagger:parameters HTMLCheckParams
 type htmlCheckParams struct {
 	// Message database ID or "latest"
@@ -215,6 +285,64 @@ type releaseMessageParams struct {
 		// required: true
 		// example: ["user1@example.com", "user2@example.com"]
 		To []string
+
+		// Optional named relay profile, else the default relay configuration is used
+		//
+		// example: staging
+		Profile string
+
+		// Optional date & time to schedule the release for, in RFC3339 format
+		//
+		// example: 2026-01-02T09:00:00Z
+		SendAt time.Time
+
+		// Optional delay before the release is sent, as a duration (eg: 90s, 15m, 2h), cannot be used with SendAt
+		//
+		// example: 15m
+		Delay string
+	}
+}
+
+// swagger:parameters ResendMessageParams
+type resendMessageParams struct {
+	// Message database ID
+	//
+	// in: path
+	// description: Message database ID
+	// required: true
+	ID string
+
+	// in: body
+	Body struct {
+		// Optional header overrides, headers set to an empty value are removed
+		//
+		// example: {"Subject": "Updated subject", "To": "Jane Doe <jane@example.com>", "X-Campaign": ""}
+		Headers map[string]string
+
+		// Optional replacement text part
+		//
+		// example: Updated text body
+		Text string
+
+		// Optional replacement HTML part
+		//
+		// example: <p>Updated HTML body</p>
+		HTML string
+
+		// Release the rebuilt message via the relay instead of storing it as a new message
+		//
+		// example: false
+		Release bool
+
+		// Release recipients, else the To, Cc & Bcc addresses of the rebuilt message are used (release only)
+		//
+		// example: ["user1@example.com"]
+		To []string
+
+		// Optional named relay profile, else the default relay configuration is used (release only)
+		//
+		// example: staging
+		Profile string
 	}
 }
 
@@ -400,6 +528,60 @@ type spamAssassinCheckParams struct {
 	ID string
 }
 
+// swagger:parameters DKIMCheckParams
+type dkimCheckParams struct {
+	// Message database ID or "latest"
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters PGPCheckParams
+type pgpCheckParams struct {
+	// Message database ID or "latest"
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters RelayLogParams
+type relayLogParams struct {
+	// Message database ID or "latest"
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters TLSReportParams
+type tlsReportParams struct {
+	// Message database ID or "latest"
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters SMIMECheckParams
+type smimeCheckParams struct {
+	// Message database ID or "latest"
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters AuthenticationResultsParams
+type authenticationResultsParams struct {
+	// Message database ID or "latest"
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
 // swagger:parameters ThumbnailParams
 type thumbnailParams struct {
 	// Message database ID or "latest"
@@ -414,3 +596,30 @@ type thumbnailParams struct {
 	// required: true
 	PartID string
 }
+
+// swagger:parameters retryQueueParams
+type retryQueueParams struct {
+	// Queue ID
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters cancelQueueParams
+type cancelQueueParams struct {
+	// Queue ID
+	//
+	// in: path
+	// required: true
+	ID string
+}
+
+// swagger:parameters cancelScheduledReleaseParams
+type cancelScheduledReleaseParams struct {
+	// Queue ID of the scheduled release
+	//
+	// in: path
+	// required: true
+	ID string
+}
@@ -9,6 +9,7 @@ package apiv1
 import (
 	"github.com/axllent/mailpit/internal/smtpd/chaos"
 	"github.com/axllent/mailpit/internal/stats"
+	"github.com/axllent/mailpit/internal/storage"
 )
 
 // Binary data response which inherits the attachment's content type.
@@ -62,7 +63,7 @@ type WebUIConfiguration struct {
 	MessageRelay struct {
 		// Whether message relaying (release) is enabled
 		Enabled bool
-		// The configured SMTP server address
+		// The configured SMTP server address, provider API (eg: sendgrid+https://api.sendgrid.com/v3/mail/send), or local delivery URL (maildir://, mbox:// or exec://)
 		SMTPServer string
 		// Enforced Return-Path (if set) for relay bounces
 		ReturnPath string
@@ -74,6 +75,8 @@ type WebUIConfiguration struct {
 		OverrideFrom string
 		// Preserve the original Message-IDs when relaying messages
 		PreserveMessageIDs bool
+		// Names of the named relay profiles which can be selected when releasing messages
+		Profiles []string
 
 		// DEPRECATED 2024/03/12
 		// swagger:ignore
@@ -120,10 +123,44 @@ type chaosResponse struct {
 	Body chaos.Triggers
 }
 
+// Response for the Chaos rules
+// swagger:response ChaosRulesResponse
+type chaosRulesResponse struct {
+	// The Chaos SMTP response rules
+	//
+	// in: body
+	Body []chaos.Rule
+}
+
+// Response for a Chaos rule
+// swagger:response ChaosRuleResponse
+type chaosRuleResponse struct {
+	// The Chaos SMTP response rule
+	//
+	// in: body
+	Body chaos.Rule
+}
+
 // Message headers
 // swagger:response MessageHeadersResponse
 type messageHeadersResponse map[string][]string
 
+// Message relay log
+// swagger:response RelayLogResponse
+type relayLogResponse struct {
+	// The delivery attempts of the message
+	// in: body
+	Body []storage.RelayLog
+}
+
+// Scheduled releases
+// swagger:response ScheduledReleasesResponse
+type scheduledReleasesResponse struct {
+	// The scheduled releases, ordered by their scheduled date
+	// in: body
+	Body []storage.QueueJob
+}
+
 // Summary of messages
 // swagger:response MessagesSummaryResponse
 type messagesSummaryResponse struct {
@@ -132,6 +169,19 @@ type messagesSummaryResponse struct {
 	Body MessagesSummary
 }
 
+// Confirmation message for the resend API
+// swagger:response ResendMessageResponse
+type resendMessageResponse struct {
+	// Response for resending a message
+	//
+	// in: body
+	Body struct {
+		// Database ID of the new message, empty if the message was released
+		// example: iAfZVVe2UQfNSG5BAjgYwa
+		ID string
+	}
+}
+
 // Confirmation message for HTTP send API
 // swagger:response SendMessageResponse
 type sendMessageResponse struct {
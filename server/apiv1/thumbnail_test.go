@@ -88,7 +88,7 @@ func storeEmailWithPNG(t *testing.T, subject string, png []byte) (msgID, partID
 			"Content-Transfer-Encoding: base64\r\n\r\n%s\r\n--b--\r\n",
 		subject, wrapBase64(png),
 	))
-	id, err := storage.Store(&raw, nil)
+	id, err := storage.Store(&raw, nil, false, nil)
 	if err != nil {
 		t.Fatalf("Store: %v", err)
 	}
@@ -186,6 +186,8 @@ func apiRoutes() *http.ServeMux {
 	r.HandleFunc("DELETE "+config.Webroot+"api/v1/messages", middleWareFunc(apiv1.DeleteMessages))
 	r.HandleFunc("GET "+config.Webroot+"api/v1/search", middleWareFunc(apiv1.Search))
 	r.HandleFunc("DELETE "+config.Webroot+"api/v1/search", middleWareFunc(apiv1.DeleteSearch))
+	r.HandleFunc("POST "+config.Webroot+"api/v1/search/release", middleWareFunc(apiv1.ReleaseSearch))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/search/release/{id}", middleWareFunc(apiv1.GetReleaseJob))
 	r.HandleFunc("POST "+config.Webroot+"api/v1/send", sendAPIAuthMiddleware(apiv1.SendMessageHandler))
 	r.HandleFunc("GET "+config.Webroot+"api/v1/tags", middleWareFunc(apiv1.GetAllTags))
 	r.HandleFunc("PUT "+config.Webroot+"api/v1/tags", middleWareFunc(apiv1.SetMessageTags))
@@ -196,8 +198,15 @@ func apiRoutes() *http.ServeMux {
 	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/headers", middleWareFunc(apiv1.GetHeaders))
 	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/raw", middleWareFunc(apiv1.DownloadRaw))
 	r.HandleFunc("POST "+config.Webroot+"api/v1/message/{id}/release", middleWareFunc(apiv1.ReleaseMessage))
+	r.HandleFunc("POST "+config.Webroot+"api/v1/message/{id}/resend", middleWareFunc(apiv1.ResendMessage))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/relay-log", middleWareFunc(apiv1.GetRelayLog))
 	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/html-check", middleWareFunc(apiv1.HTMLCheck))
 	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/link-check", middleWareFunc(apiv1.LinkCheck))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/dkim", middleWareFunc(apiv1.DKIMCheck))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/auth-results", middleWareFunc(apiv1.AuthenticationResults))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/smime", middleWareFunc(apiv1.SMIMECheck))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/pgp", middleWareFunc(apiv1.PGPCheck))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/tls-report", middleWareFunc(apiv1.TLSReport))
 	if config.EnableSpamAssassin != "" {
 		r.HandleFunc("GET "+config.Webroot+"api/v1/message/{id}/sa-check", middleWareFunc(apiv1.SpamAssassinCheck))
 	}
@@ -209,6 +218,26 @@ func apiRoutes() *http.ServeMux {
 	// Chaos
 	r.HandleFunc("GET "+config.Webroot+"api/v1/chaos", middleWareFunc(apiv1.GetChaos))
 	r.HandleFunc("PUT "+config.Webroot+"api/v1/chaos", middleWareFunc(apiv1.SetChaos))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/chaos/rules", middleWareFunc(apiv1.GetChaosRules))
+	r.HandleFunc("POST "+config.Webroot+"api/v1/chaos/rules", middleWareFunc(apiv1.AddChaosRule))
+	r.HandleFunc("DELETE "+config.Webroot+"api/v1/chaos/rules", middleWareFunc(apiv1.DeleteChaosRules))
+	r.HandleFunc("PUT "+config.Webroot+"api/v1/chaos/rules/{id}", middleWareFunc(apiv1.UpdateChaosRule))
+	r.HandleFunc("DELETE "+config.Webroot+"api/v1/chaos/rules/{id}", middleWareFunc(apiv1.DeleteChaosRule))
+
+	// Greylisting
+	r.HandleFunc("GET "+config.Webroot+"api/v1/greylist", middleWareFunc(apiv1.GetGreylist))
+	r.HandleFunc("DELETE "+config.Webroot+"api/v1/greylist", middleWareFunc(apiv1.ResetGreylist))
+
+	// Rate limits
+	r.HandleFunc("GET "+config.Webroot+"api/v1/rate-limits", middleWareFunc(apiv1.GetRateLimits))
+	r.HandleFunc("DELETE "+config.Webroot+"api/v1/rate-limits", middleWareFunc(apiv1.ResetRateLimits))
+
+	// Outbound queue
+	r.HandleFunc("GET "+config.Webroot+"api/v1/queue", middleWareFunc(apiv1.GetQueue))
+	r.HandleFunc("POST "+config.Webroot+"api/v1/queue/{id}/retry", middleWareFunc(apiv1.RetryQueued))
+	r.HandleFunc("DELETE "+config.Webroot+"api/v1/queue/{id}", middleWareFunc(apiv1.CancelQueued))
+	r.HandleFunc("GET "+config.Webroot+"api/v1/scheduled", middleWareFunc(apiv1.GetScheduledReleases))
+	r.HandleFunc("DELETE "+config.Webroot+"api/v1/scheduled/{id}", middleWareFunc(apiv1.CancelScheduledRelease))
 
 	// Prometheus metrics (if enabled and using existing server)
 	if prometheus.GetMode() == "integrated" {
@@ -9,11 +9,13 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/axllent/mailpit/config"
 	"github.com/axllent/mailpit/internal/auth"
+	"github.com/axllent/mailpit/internal/deliver"
 	"github.com/axllent/mailpit/internal/logger"
 	"github.com/axllent/mailpit/internal/storage"
 	"github.com/axllent/mailpit/server/apiv1"
@@ -328,6 +330,117 @@ func TestAPIv1Send(t *testing.T) {
 	assertEqual(t, `This is a plain text attachment`, string(attachmentBytes), "wrong Attachment content")
 }
 
+func TestAPIv1Resend(t *testing.T) {
+	setup()
+	defer storage.Close()
+
+	r := apiRoutes()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	jsonData := `{
+		"From": {"Email": "john@example.com", "Name": "John Doe"},
+		"To": [{"Email": "jane@example.com"}],
+		"Headers": {"X-Campaign": "spring", "X-Template": "reminder", "DKIM-Signature": "v=1; a=rsa-sha256; d=example.com", "ARC-Seal": "i=1; a=rsa-sha256; cv=none", "Authentication-Results": "mx.example.com; dkim=pass"},
+		"Subject": "Original subject",
+		"Text": "Original text",
+		"HTML": "<p>Original HTML</p>",
+		"Attachments": [{"Content": "VGhpcyBpcyBhIHBsYWluIHRleHQgYXR0YWNobWVudA==", "Filename": "Attached File.txt"}]
+	}`
+
+	b, err := clientPost(ts.URL+"/api/v1/send", jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := struct{ ID string }{}
+	if err := json.Unmarshal(b, &orig); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Resending modified message")
+	b, err = clientPost(ts.URL+"/api/v1/message/"+orig.ID+"/resend", `{
+		"Headers": {"Subject": "Fixed subject ✓", "To": "Bob <bob@example.com>", "X-Campaign": ""},
+		"HTML": "<p>Fixed HTML</p>"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := struct{ ID string }{}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ID == "" || resp.ID == orig.ID {
+		t.Fatalf("expected a new message ID, got %q", resp.ID)
+	}
+
+	msg, err := fetchMessage(ts.URL + "/api/v1/message/" + resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "Fixed subject ✓", msg.Subject, "wrong subject")
+	assertEqual(t, `"John Doe" <john@example.com>`, msg.From.String(), "wrong From")
+	assertEqual(t, 1, len(msg.To), "wrong To count")
+	assertEqual(t, `"Bob" <bob@example.com>`, msg.To[0].String(), "wrong To address")
+	assertEqual(t, "Original text", msg.Text, "wrong text")
+	assertEqual(t, "<p>Fixed HTML</p>", msg.HTML, "wrong HTML")
+	assertEqual(t, 1, len(msg.Attachments), "wrong Attachment count")
+	assertEqual(t, "Attached File.txt", msg.Attachments[0].FileName, "wrong Attachment name")
+
+	b, err = clientGet(ts.URL + "/api/v1/message/" + resp.ID + "/headers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string][]string{}
+	if err := json.Unmarshal(b, &headers); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, orig.ID, strings.Join(headers["X-Mailpit-Resent-From"], ","), "wrong X-Mailpit-Resent-From")
+	assertEqual(t, "reminder", strings.Join(headers["X-Template"], ","), "wrong X-Template")
+	assertEqual(t, 0, len(headers["X-Campaign"]), "expected X-Campaign to be removed")
+
+	for _, k := range []string{"Dkim-Signature", "Arc-Seal", "Authentication-Results"} {
+		assertEqual(t, 0, len(headers[k]), "expected "+k+" to be removed")
+	}
+
+	if _, err := clientPost(ts.URL+"/api/v1/message/"+orig.ID+"/resend", `{"Headers": {"Bad Header": "x"}}`); err == nil {
+		t.Error("expected an error for an invalid header name")
+	}
+
+	if _, err := clientPost(ts.URL+"/api/v1/message/"+orig.ID+"/resend", `{"Release": true}`); err == nil {
+		t.Error("expected an error when relaying is not enabled")
+	}
+
+	t.Log("Releasing modified message")
+	backend, err := deliver.Parse("mbox://" + filepath.Join(t.TempDir(), "mbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ReleaseEnabled = true
+	config.SMTPRelayConfig = config.SMTPRelayConfigStruct{Backend: backend}
+	defer func() {
+		config.ReleaseEnabled = false
+		config.SMTPRelayConfig = config.SMTPRelayConfigStruct{}
+	}()
+
+	if _, err := clientPost(ts.URL+"/api/v1/message/"+orig.ID+"/resend", `{"Release": true, "Headers": {"Subject": "Released"}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// the release of a rebuilt message is not recorded against the original message
+	log, err := storage.GetRelayLog(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 0, len(log), "expected no relay log for the original message")
+}
+
 func TestAPIv1SendMaxMessageSize(t *testing.T) {
 	setup()
 	defer storage.Close()
@@ -614,7 +727,7 @@ func insertEmailData(t *testing.T) {
 
 		bufBytes := buf.Bytes()
 
-		id, err := storage.Store(&bufBytes, nil)
+		id, err := storage.Store(&bufBytes, nil, false, nil)
 		if err != nil {
 			t.Log("error ", err)
 			t.Fail()